@@ -675,7 +675,9 @@ func (a *agentMessageHandler) Call(request motan.Request) motan.Response {
 		return a.httpCall(request, ck, httpCluster.(*cluster.HTTPCluster))
 	}
 	vlog.Warningf("cluster not found. cluster: %s, request id:%d", err.Error(), request.GetRequestID())
-	return getDefaultResponse(request.GetRequestID(), "cluster not found. cluster: "+err.Error())
+	response := getDefaultResponse(request.GetRequestID(), "cluster not found. cluster: "+err.Error())
+	motan.SetErrorCause(response, motan.ErrorCauseNoCluster)
+	return response
 }
 func (a *agentMessageHandler) matchRule(typ, cond, key string, data []serviceMapItem, f func(u *motan.URL) string) (foundClusters []serviceMapItem, err error) {
 	if cond == "" {
@@ -834,6 +836,7 @@ func (sa *serverAgentMessageHandler) Call(request motan.Request) (res motan.Resp
 		p := p.(motan.Provider)
 		res = p.Call(request)
 		res.GetRPCContext(true).GzipSize = int(p.GetURL().GetIntValue(motan.GzipSizeKey, 0))
+		mpro.NegotiateResponseCompress(request, res, p.GetURL().GetParam(motan.CompressKey, ""))
 		return res
 	}
 	vlog.Errorf("not found provider for %s", motan.GetReqInfo(request))
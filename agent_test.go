@@ -17,6 +17,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/weibocom/motan-go/core"
 	mhttp "github.com/weibocom/motan-go/http"
+	"github.com/weibocom/motan-go/metrics"
 	mpro "github.com/weibocom/motan-go/protocol"
 )
 
@@ -77,6 +78,24 @@ func TestHTTPProxyBodySize(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 }
 
+func TestHTTPProxyUpgrade(t *testing.T) {
+	backend := newUpgradeEchoServer()
+	defer backend.Close()
+	conn, _ := assertUpgradeEcho(t, "localhost:9983", backend.Listener.Addr().String())
+	conn.Close()
+
+	// not upgraded by the backend
+	request, _ := http.NewRequest("GET", backend.URL+"/ws", nil)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "other")
+	resp, err := proxyClient.Do(request)
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "not upgraded", string(body))
+}
+
 func TestHTTPProxy(t *testing.T) {
 	wg := &sync.WaitGroup{}
 	wg.Add(goNum)
@@ -109,6 +128,52 @@ func TestHTTPProxy(t *testing.T) {
 	wg.Wait()
 }
 
+func TestHTTPProxyMetricsGroup(t *testing.T) {
+	resp, err := proxyClient.Get("http://test.domain/tst/test")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	// the unknown hosts are proxied directly, their metrics are in the direct group
+	unknownHost := "127.0.0.1:" + strconv.Itoa(10000+rand.Intn(1000))
+	resp, err = proxyClient.Get("http://" + unknownHost + "/unknown")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	hasGroup := func(group string) bool {
+		found := false
+		metrics.RangeAllStatItem(func(k string, v metrics.StatItem) bool {
+			found = v.GetGroup() == group
+			return !found
+		})
+		return found
+	}
+	for i := 0; i < 50 && !(hasGroup(metrics.Escape("test.domain")) && hasGroup("direct")); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.True(t, hasGroup(metrics.Escape("test.domain")))
+	assert.True(t, hasGroup("direct"))
+	assert.False(t, hasGroup(metrics.Escape(unknownHost)))
+}
+
+func TestHTTPProxyLocationRules(t *testing.T) {
+	resp, err := proxyClient.Get("http://test.domain/rules/test")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// the rules are applied by the proxy server only
+	assert.Equal(t, []string{"/rules/test"}, resp.Header["X-Location-Rules"])
+
+	client := &http.Client{
+		Transport: proxyClient.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err = client.Get("http://test.domain/rules/return")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "http://test.domain/rules/redirected", resp.Header.Get("Location"))
+}
+
 func TestRpcToHTTPProxy(t *testing.T) {
 	service := "test.domain"
 	request := meshClient.BuildRequest(service, "/tst/xxxx/111", []interface{}{map[string]string{"a": "a"}})
@@ -83,6 +83,26 @@ func (c *Client) BaseGo(req motan.Request, reply interface{}, done chan *motan.A
 	return result
 }
 
+// NewStream opens a streaming call to an endpoint selected by the load balance.
+// The stream is bound to the endpoint, so the ha strategy and the cluster filters are not applied, the endpoint filters
+// only check the stream when it's opened(see motan.StreamFilter)
+func (c *Client) NewStream(method string, args []interface{}) (motan.ClientStream, error) {
+	req := c.BuildRequest(method, args)
+	req.GetRPCContext(true).ExtFactory = c.extFactory
+	if !c.cluster.IsAvailable() {
+		return nil, errors.New("cluster not available, maybe caused by degrade")
+	}
+	ep := c.cluster.LoadBalance.Select(req)
+	if ep == nil {
+		return nil, errors.New("no available endpoint for " + c.url.Path)
+	}
+	sc, ok := ep.(motan.StreamCaller)
+	if !ok {
+		return nil, motan.ErrStreamNotSupported
+	}
+	return sc.NewStream(req)
+}
+
 func (c *Client) BuildRequest(method string, args []interface{}) motan.Request {
 	req := &motan.MotanRequest{Method: method, ServiceName: c.url.Path, Arguments: args, Attachment: motan.NewStringMap(motan.DefaultAttachmentSize)}
 	version := c.url.GetParam(motan.VersionKey, "")
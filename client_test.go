@@ -1,10 +1,17 @@
 package motan
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	assert2 "github.com/stretchr/testify/assert"
 	"github.com/weibocom/motan-go/config"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/serialize"
+	"io"
+	"net"
 	"testing"
 	"time"
 )
@@ -78,3 +85,273 @@ type HelloService1 struct{}
 func (m *HelloService1) Hello(name string) string {
 	return fmt.Sprintf("Hello %s", name)
 }
+
+func TestClient_NewStream(t *testing.T) {
+	assert := assert2.New(t)
+	serverCfg := `
+motan-server:
+  log_dir: "stdout"
+  application: "app-golang"
+motan-registry:
+  direct:
+    protocol: direct
+motan-service:
+  stream-motan2:
+    path: streamService
+    group: bj
+    protocol: motan2
+    registry: direct
+    serialization: simple
+    ref : "streamServiceID"
+    export: "motan2:64539"
+`
+	conf, err := config.NewConfigFromReader(bytes.NewReader([]byte(serverCfg)))
+	assert.Nil(err)
+	service := &StreamService{canceled: make(chan struct{})}
+	mscontext := NewMotanServerContextFromConfig(conf)
+	assert.Nil(mscontext.RegisterService(service, "streamServiceID"))
+	mscontext.Start(GetDefaultExtFactory())
+	mscontext.ServicesAvailable()
+
+	// a small window makes the server waiting for window updates
+	clientCfg := `
+motan-client:
+  log_dir: "stdout"
+  application: "app-golang"
+motan-registry:
+  direct:
+    protocol: direct
+    host: 127.0.0.1
+    port: 64539
+motan-refer:
+  stream:
+    path: streamService
+    group: bj
+    protocol: motan2
+    registry: direct
+    serialization: simple
+    streamWindow: 2
+`
+	conf, err = config.NewConfigFromReader(bytes.NewReader([]byte(clientCfg)))
+	assert.Nil(err)
+	mccontext := NewClientContextFromConfig(conf)
+	mccontext.Start(GetDefaultExtFactory())
+	time.Sleep(time.Second)
+	client := mccontext.GetClient("stream")
+
+	// server stream
+	stream, err := client.NewStream("subscribe", []interface{}{"news", int64(10)})
+	assert.Nil(err)
+	var reply string
+	for i := 0; i < 10; i++ {
+		assert.Nil(stream.Recv(&reply))
+		assert.Equal(fmt.Sprintf("news-%d", i), reply)
+	}
+	assert.Equal(io.EOF, stream.Recv(&reply))
+
+	// bidi stream
+	stream, err = client.NewStream("echo", nil)
+	assert.Nil(err)
+	for i := 0; i < 5; i++ {
+		assert.Nil(stream.Send(fmt.Sprintf("ping-%d", i)))
+		assert.Nil(stream.Recv(&reply))
+		assert.Equal(fmt.Sprintf("echo ping-%d", i), reply)
+	}
+	assert.Nil(stream.CloseSend())
+	assert.Nil(stream.Recv(&reply))
+	assert.Equal("echo done", reply)
+	assert.Equal(io.EOF, stream.Recv(&reply))
+
+	// exception ends the stream
+	stream, err = client.NewStream("fail", nil)
+	assert.Nil(err)
+	err = stream.Recv(&reply)
+	assert.NotNil(err)
+	assert.Equal("stream failed", err.Error())
+	stream, err = client.NewStream("hello", []interface{}{"Ray"})
+	assert.Nil(err)
+	assert.NotNil(stream.Recv(&reply))
+
+	// cancel notifies the server
+	stream, err = client.NewStream("block", nil)
+	assert.Nil(err)
+	assert.Nil(stream.Recv(&reply))
+	assert.Equal("started", reply)
+	stream.Cancel()
+	select {
+	case <-service.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("stream cancel not received by server")
+	}
+	assert.NotNil(stream.Recv(&reply))
+}
+
+func TestClient_NewStreamFilter(t *testing.T) {
+	assert := assert2.New(t)
+	serverCfg := `
+motan-server:
+  log_dir: "stdout"
+  application: "app-golang"
+motan-registry:
+  direct:
+    protocol: direct
+motan-service:
+  stream-auth:
+    path: streamAuthService
+    group: bj
+    protocol: motan2
+    registry: direct
+    serialization: simple
+    filter: "auth"
+    auth.secret: "stream-secret"
+    ref : "streamAuthServiceID"
+    export: "motan2:64546"
+`
+	conf, err := config.NewConfigFromReader(bytes.NewReader([]byte(serverCfg)))
+	assert.Nil(err)
+	mscontext := NewMotanServerContextFromConfig(conf)
+	assert.Nil(mscontext.RegisterService(&StreamService{canceled: make(chan struct{})}, "streamAuthServiceID"))
+	mscontext.Start(GetDefaultExtFactory())
+	mscontext.ServicesAvailable()
+
+	clientCfg := `
+motan-client:
+  log_dir: "stdout"
+  application: "app-golang"
+motan-registry:
+  direct:
+    protocol: direct
+    host: 127.0.0.1
+    port: 64546
+motan-refer:
+  signed:
+    path: streamAuthService
+    group: bj
+    protocol: motan2
+    registry: direct
+    serialization: simple
+    filter: "auth"
+    auth.secret: "stream-secret"
+  unsigned:
+    path: streamAuthService
+    group: bj
+    protocol: motan2
+    registry: direct
+    serialization: simple
+`
+	conf, err = config.NewConfigFromReader(bytes.NewReader([]byte(clientCfg)))
+	assert.Nil(err)
+	mccontext := NewClientContextFromConfig(conf)
+	mccontext.Start(GetDefaultExtFactory())
+	time.Sleep(time.Second)
+
+	// the stream is signed by the client filter and authenticated by the server filter
+	stream, err := mccontext.GetClient("signed").NewStream("subscribe", []interface{}{"news", int64(1)})
+	assert.Nil(err)
+	var reply string
+	assert.Nil(stream.Recv(&reply))
+	assert.Equal("news-0", reply)
+	assert.Equal(io.EOF, stream.Recv(&reply))
+
+	// the stream without signature is rejected before the provider is called
+	stream, err = mccontext.GetClient("unsigned").NewStream("subscribe", []interface{}{"news", int64(1)})
+	assert.Nil(err)
+	err = stream.Recv(&reply)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "auth fail")
+}
+
+func TestClient_NewStreamConnClosed(t *testing.T) {
+	assert := assert2.New(t)
+	serverCfg := `
+motan-server:
+  log_dir: "stdout"
+  application: "app-golang"
+motan-registry:
+  direct:
+    protocol: direct
+motan-service:
+  stream-closed:
+    path: streamClosedService
+    group: bj
+    protocol: motan2
+    registry: direct
+    serialization: simple
+    ref : "streamClosedServiceID"
+    export: "motan2:64547"
+`
+	conf, err := config.NewConfigFromReader(bytes.NewReader([]byte(serverCfg)))
+	assert.Nil(err)
+	service := &StreamService{canceled: make(chan struct{})}
+	mscontext := NewMotanServerContextFromConfig(conf)
+	assert.Nil(mscontext.RegisterService(service, "streamClosedServiceID"))
+	mscontext.Start(GetDefaultExtFactory())
+	mscontext.ServicesAvailable()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:64547")
+	assert.Nil(err)
+	request := &motan.MotanRequest{ServiceName: "streamClosedService", Method: "block", Attachment: motan.NewStringMap(0)}
+	request.SetAttachment(protocol.MGroup, "bj")
+	msg, err := protocol.ConvertToReqMessage(request, &serialize.SimpleSerialization{})
+	assert.Nil(err)
+	msg.Header.RequestID = 1
+	msg.Header.SetStream(true)
+	_, err = conn.Write(msg.Encode().Bytes())
+	assert.Nil(err)
+	res, err := protocol.Decode(bufio.NewReader(conn))
+	assert.Nil(err)
+	assert.True(res.Header.IsStream())
+
+	// the processing stream is canceled when the connection is closed
+	conn.Close()
+	select {
+	case <-service.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("stream not canceled after the connection closed")
+	}
+}
+
+type StreamService struct {
+	canceled chan struct{}
+}
+
+func (s *StreamService) Hello(name string) string {
+	return "Hello " + name
+}
+
+func (s *StreamService) Subscribe(topic string, count int64, stream motan.ServerStream) error {
+	for i := int64(0); i < count; i++ {
+		if err := stream.Send(fmt.Sprintf("%s-%d", topic, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StreamService) Echo(stream motan.ServerStream) error {
+	for {
+		var msg string
+		err := stream.Recv(&msg)
+		if err == io.EOF {
+			return stream.Send("echo done")
+		}
+		if err != nil {
+			return err
+		}
+		if err = stream.Send("echo " + msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *StreamService) Fail(stream motan.ServerStream) error {
+	return errors.New("stream failed")
+}
+
+func (s *StreamService) Block(stream motan.ServerStream) error {
+	stream.Send("started")
+	<-stream.Done()
+	close(s.canceled)
+	return nil
+}
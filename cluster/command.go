@@ -18,6 +18,7 @@ const (
 	CMDTrafficControl = iota
 	CMDDegrade        //service degrade
 	CMDSwitcher
+	CMDRequestRoute // route requests by the attachments, the rules are in the routeRules
 )
 
 const (
@@ -33,17 +34,19 @@ var oldSwitcherMap = make(map[string]bool) //Save the default value before the s
 
 // CommandRegistryWrapper wrapper registry for every cluster
 type CommandRegistryWrapper struct {
-	cluster            *MotanCluster
-	registry           motan.Registry
-	notifyListener     motan.NotifyListener // e.g. cluster
-	serviceCommandInfo string               // current service command
-	agentCommandInfo   string               // current agent command
-	mux                sync.Mutex
-	ownGroupURLs       []*motan.URL
-	otherGroupListener map[string]*serviceListener
-	tcCommand          *ClientCommand //effective traffic control command
-	degradeCommand     *ClientCommand //effective degrade command
-	switcherCommand    *ClientCommand
+	cluster             *MotanCluster
+	registry            motan.Registry
+	notifyListener      motan.NotifyListener // e.g. cluster
+	serviceCommandInfo  string               // current service command
+	agentCommandInfo    string               // current agent command
+	mux                 sync.Mutex
+	ownGroupURLs        []*motan.URL
+	otherGroupListener  map[string]*serviceListener // the other groups of the tc command and the request route targets
+	routeGroups         []string                    // the groups of the request route targets
+	tcCommand           *ClientCommand              //effective traffic control command
+	degradeCommand      *ClientCommand              //effective degrade command
+	switcherCommand     *ClientCommand
+	requestRouteCommand *ClientCommand
 }
 
 type ClientCommand struct {
@@ -87,6 +90,7 @@ func (s *serviceListener) Notify(registryURL *motan.URL, urls []*motan.URL) {
 	}
 	s.urls = urls
 	s.crw.getResultWithCommand(true)
+	s.crw.notifyRouteGroups()
 }
 
 func (s *serviceListener) unSubscribe(registry motan.Registry) {
@@ -210,6 +214,8 @@ func (c *CommandRegistryWrapper) clear() {
 	defer c.mux.Unlock()
 	c.tcCommand = nil
 	c.degradeCommand = nil
+	c.requestRouteCommand = nil
+	c.routeGroups = nil
 	c.agentCommandInfo = ""
 	c.serviceCommandInfo = ""
 	c.ownGroupURLs = make([]*motan.URL, 0)
@@ -346,12 +352,13 @@ func (c *CommandRegistryWrapper) processCommand(commandType int, commandInfo str
 	var newTcCommand *ClientCommand
 	var newDegradeCommand *ClientCommand
 	var newSwitcherCommand *ClientCommand
+	var newRequestRouteCommand *ClientCommand
 	if c.agentCommandInfo != "" { // agent command first
-		newTcCommand, newDegradeCommand, newSwitcherCommand = mergeCommand(c.agentCommandInfo, c.cluster.GetURL())
+		newTcCommand, newDegradeCommand, newSwitcherCommand, newRequestRouteCommand = mergeCommand(c.agentCommandInfo, c.cluster.GetURL())
 	}
 
 	if c.serviceCommandInfo != "" {
-		tc, dc, sc := mergeCommand(c.serviceCommandInfo, c.cluster.GetURL())
+		tc, dc, sc, rc := mergeCommand(c.serviceCommandInfo, c.cluster.GetURL())
 		if newTcCommand == nil {
 			newTcCommand = tc
 		}
@@ -361,6 +368,9 @@ func (c *CommandRegistryWrapper) processCommand(commandType int, commandInfo str
 		if newSwitcherCommand == nil {
 			newSwitcherCommand = sc
 		}
+		if newRequestRouteCommand == nil {
+			newRequestRouteCommand = rc
+		}
 	}
 	if newTcCommand != nil || (c.tcCommand != nil && newTcCommand == nil) {
 		needNotify = true
@@ -368,40 +378,49 @@ func (c *CommandRegistryWrapper) processCommand(commandType int, commandInfo str
 
 	//process all kinds commands
 	c.tcCommand = newTcCommand
+	otherGroups := make([]string, 0)
 	if c.tcCommand == nil {
 		vlog.Infof("%s process command result : no tc command. ", c.cluster.GetURL().GetIdentity())
-		for _, v := range c.otherGroupListener {
-			v.unSubscribe(c.registry)
-		}
-		c.otherGroupListener = make(map[string]*serviceListener)
 	} else {
 		vlog.Infof("%s process command result : has tc command. tc command will enable.command : %+v", c.cluster.GetURL().GetIdentity(), newTcCommand)
-		newOtherGroupListener := make(map[string]*serviceListener)
 		for _, group := range c.tcCommand.MergeGroups {
-			g := strings.Split(group, ":")
-			if c.cluster.GetURL().Group == g[0] { // own group already subscribe
-				continue
-			}
-			if listener, ok := c.otherGroupListener[g[0]]; ok { // already exist
-				vlog.Infof("commandWrapper %s process tc command. reuse group %s", c.cluster.GetURL().GetIdentity(), g[0])
-				newOtherGroupListener[g[0]] = listener
-				delete(c.otherGroupListener, g[0])
-			} else {
-				vlog.Infof("commandWrapper %s process tc command. subscribe new group %s", c.cluster.GetURL().GetIdentity(), g[0])
-				newGroupURL := c.cluster.GetURL().Copy()
-				newGroupURL.Group = g[0]
-				l := &serviceListener{crw: c, referURL: newGroupURL}
-				l.subscribe(c.registry)
-				newOtherGroupListener[g[0]] = l
-			}
+			otherGroups = append(otherGroups, strings.Split(group, ":")[0])
 		}
-
-		oldOtherGroupListener := c.otherGroupListener
-		c.otherGroupListener = newOtherGroupListener
-		// sync destroy
-		for _, v := range oldOtherGroupListener {
-			v.unSubscribe(c.registry)
+	}
+	// the groups of the request route targets are subscribed like the merge groups, but they are only used by the
+	// request route rules
+	oldRouteGroups := c.routeGroups
+	c.routeGroups = nil
+	if newRequestRouteCommand != nil {
+		c.routeGroups = requestRouteGroups(newRequestRouteCommand.RouteRules)
+		otherGroups = append(otherGroups, c.routeGroups...)
+	}
+	newOtherGroupListener := make(map[string]*serviceListener)
+	for _, g := range otherGroups {
+		if c.cluster.GetURL().Group == g { // own group already subscribe
+			continue
+		}
+		if _, ok := newOtherGroupListener[g]; ok {
+			continue
 		}
+		if listener, ok := c.otherGroupListener[g]; ok { // already exist
+			vlog.Infof("commandWrapper %s process command. reuse group %s", c.cluster.GetURL().GetIdentity(), g)
+			newOtherGroupListener[g] = listener
+			delete(c.otherGroupListener, g)
+		} else {
+			vlog.Infof("commandWrapper %s process command. subscribe new group %s", c.cluster.GetURL().GetIdentity(), g)
+			newGroupURL := c.cluster.GetURL().Copy()
+			newGroupURL.Group = g
+			l := &serviceListener{crw: c, referURL: newGroupURL}
+			l.subscribe(c.registry)
+			newOtherGroupListener[g] = l
+		}
+	}
+	oldOtherGroupListener := c.otherGroupListener
+	c.otherGroupListener = newOtherGroupListener
+	// sync destroy
+	for _, v := range oldOtherGroupListener {
+		v.unSubscribe(c.registry)
 	}
 	c.degradeCommand = newDegradeCommand
 	if c.degradeCommand == nil {
@@ -442,10 +461,62 @@ func (c *CommandRegistryWrapper) processCommand(commandType int, commandInfo str
 	oldSwitcherMap = newSwitcherMap
 	c.switcherCommand = newSwitcherCommand
 
+	//process request route command, the endpoints are not changed so it does not need notify
+	var oldRules, newRules []string
+	if c.requestRouteCommand != nil {
+		oldRules = c.requestRouteCommand.RouteRules
+	}
+	if newRequestRouteCommand != nil {
+		newRules = newRequestRouteCommand.RouteRules
+	}
+	if strings.Join(oldRules, "\n") != strings.Join(newRules, "\n") {
+		c.cluster.setRequestRoutes(newRules)
+	}
+	c.requestRouteCommand = newRequestRouteCommand
+	if len(c.routeGroups) > 0 || len(oldRouteGroups) > 0 {
+		c.cluster.setRouteGroupURLs(c.routeGroupURLs())
+	}
+
 	return needNotify
 }
 
-func mergeCommand(commandInfo string, url *motan.URL) (tcCommand *ClientCommand, degradeCommand *ClientCommand, switcherCommand *ClientCommand) {
+// routeGroupURLs returns the urls of the request route target groups which are not merged by the tc command, the
+// merged groups are already in the endpoints of the cluster. It must be called with the mux
+func (c *CommandRegistryWrapper) routeGroupURLs() []*motan.URL {
+	result := make([]*motan.URL, 0)
+	for _, g := range c.routeGroups {
+		if c.cluster.GetURL().Group == g || c.isMergeGroup(g) {
+			continue
+		}
+		if l, ok := c.otherGroupListener[g]; ok {
+			result = append(result, l.urls...)
+		}
+	}
+	return result
+}
+
+func (c *CommandRegistryWrapper) isMergeGroup(group string) bool {
+	if c.tcCommand == nil {
+		return false
+	}
+	for _, g := range c.tcCommand.MergeGroups {
+		if strings.Split(g, ":")[0] == group {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyRouteGroups refreshes the endpoints of the request route target groups when a group listener is notified
+func (c *CommandRegistryWrapper) notifyRouteGroups() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if len(c.routeGroups) > 0 {
+		c.cluster.setRouteGroupURLs(c.routeGroupURLs())
+	}
+}
+
+func mergeCommand(commandInfo string, url *motan.URL) (tcCommand *ClientCommand, degradeCommand *ClientCommand, switcherCommand *ClientCommand, requestRouteCommand *ClientCommand) {
 	//only one command of a type will enable in same service. depends on the index of command
 	cmd := ParseCommand(commandInfo)
 	if cmd == nil {
@@ -469,11 +540,18 @@ func mergeCommand(commandInfo string, url *motan.URL) (tcCommand *ClientCommand,
 				case CMDSwitcher:
 					temp := c
 					switcherCommand = &temp
+				case CMDRequestRoute:
+					if requestRouteCommand == nil {
+						temp := c
+						requestRouteCommand = &temp
+					} else {
+						vlog.Warningf("request route command will ignore by priority. command : %v", c)
+					}
 				}
 			}
 		}
 	}
-	return tcCommand, degradeCommand, switcherCommand
+	return tcCommand, degradeCommand, switcherCommand, requestRouteCommand
 }
 
 func (c *CommandRegistryWrapper) NotifyCommand(registryURL *motan.URL, commandType int, commandInfo string) {
@@ -1,6 +1,8 @@
 package cluster
 
 import (
+	"errors"
+	"net"
 	"sync"
 
 	"github.com/weibocom/motan-go/core"
@@ -129,6 +131,14 @@ func (c *HTTPCluster) CanServe(uri string) (string, bool) {
 	return "", false
 }
 
+// Locate returns the matched location of the uri, it returns nil if no location matched
+func (c *HTTPCluster) Locate(uri string) *http.ProxyLocation {
+	if matcher, ok := c.uriConverter.(*http.LocationMatcher); ok {
+		return matcher.Locate(uri)
+	}
+	return nil
+}
+
 func (c *HTTPCluster) GetIdentity() string {
 	return c.url.GetIdentity()
 }
@@ -188,6 +198,24 @@ func (c *HTTPCluster) Call(request core.Request) core.Response {
 	return cluster.Call(request)
 }
 
+// SelectUpgradeAddress selects an endpoint of the service for the upgraded connections such as websocket, which can not
+// be proxied by rpc. The address is the host of the endpoint and the port of the proxy address of the provider
+func (c *HTTPCluster) SelectUpgradeAddress(request core.Request) (string, error) {
+	cluster := c.getMotanCluster(request.GetServiceName())
+	if cluster == nil || cluster.LoadBalance == nil {
+		return "", errors.New("no cluster for service " + request.GetServiceName())
+	}
+	ep := cluster.LoadBalance.Select(request)
+	if ep == nil {
+		return "", errors.New("no endpoints for service " + request.GetServiceName())
+	}
+	_, port, err := net.SplitHostPort(ep.GetURL().GetParam(http.ProxyAddressKey, ""))
+	if err != nil {
+		return "", errors.New("no http address of endpoint " + ep.GetURL().GetAddressStr())
+	}
+	return net.JoinHostPort(ep.GetURL().Host, port), nil
+}
+
 func (c *HTTPCluster) Destroy() {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -6,6 +6,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/http"
 )
 
 type HTTPTestRegistry struct {
@@ -40,3 +41,32 @@ func TestHTTPCluster_Call(t *testing.T) {
 	assert.True(t, response != nil)
 	httpCluster.Destroy()
 }
+
+func TestHTTPCluster_SelectUpgradeAddress(t *testing.T) {
+	domain := "test.domain"
+	context := &core.Context{}
+	context.ConfigFile = filepath.Join("testdata", "httpCluster.yaml")
+	context.Initialize()
+	extFactory := getCustomExt()
+	extFactory.RegistExtRegistry("test", func(url *core.URL) core.Registry {
+		registry := &HTTPTestRegistry{}
+		registry.URL = url
+		registry.GroupService = map[string][]string{domain: {"test"}}
+		return registry
+	})
+	request := &core.MotanRequest{ServiceName: "test", Method: "/2/test"}
+	httpCluster := NewHTTPCluster(context.HTTPClientURLs[domain], true, context, extFactory)
+	_, err := httpCluster.SelectUpgradeAddress(request)
+	assert.NotNil(t, err)
+	httpCluster.Destroy()
+
+	// the port of the proxy address of the provider
+	clientURL := context.HTTPClientURLs[domain].Copy()
+	clientURL.Host = "10.0.0.1"
+	clientURL.PutParam(http.ProxyAddressKey, "localhost:8080")
+	httpCluster = NewHTTPCluster(clientURL, true, context, extFactory)
+	addr, err := httpCluster.SelectUpgradeAddress(request)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1:8080", addr)
+	httpCluster.Destroy()
+}
@@ -0,0 +1,249 @@
+package cluster
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+const (
+	// MirrorGroupKey is the shadow group which the sampled requests are mirrored to
+	MirrorGroupKey = "mirrorGroup"
+	// MirrorPercentKey is the percentage in [0, 100] of the mirrored requests
+	MirrorPercentKey = "mirrorPercent"
+	// MirrorConcurrencyKey is the max number of the in-flight shadow calls, the requests exceeded are not mirrored
+	MirrorConcurrencyKey = "mirrorConcurrency"
+	// MirrorCompareKey enables the comparison of the primary and the shadow response values
+	MirrorCompareKey = "mirrorCompare"
+	// MirrorAttachmentKey marks the shadow requests, it's passed to the http upstream as header MOTAN-mirror
+	MirrorAttachmentKey = "M_mirror"
+
+	DefaultMirrorPercent     = 100
+	DefaultMirrorConcurrency = 20
+)
+
+const (
+	mirrorMetricsRole     = "motan-client-mirror"
+	mirrorGroupSuffix     = ".mirror"
+	mirrorTotalCount      = ".total_count"
+	mirrorDroppedCount    = ".dropped_count"
+	mirrorErrorCount      = ".error_count"
+	mirrorSameCount       = ".same_count"
+	mirrorDiffCount       = ".diff_count"
+	mirrorUncomparedCount = ".uncompared_count"
+)
+
+// requestMirror duplicates the sampled requests to the shadow cluster asynchronously, the shadow responses are
+// discarded after they are compared. The shadow calls are bounded by the concurrency, so they can not exhaust the
+// resources of the primary calls
+type requestMirror struct {
+	url     *motan.URL
+	cluster *MotanCluster
+	percent float64
+	compare bool
+	slots   chan struct{}
+	group   string
+	service string
+}
+
+// mirrorCall is a sampled request, the primary result is captured after the primary call finished
+type mirrorCall struct {
+	request    *motan.MotanRequest
+	reply      interface{}
+	async      bool
+	dispatched bool
+}
+
+func newRequestMirror(context *motan.Context, extFactory motan.ExtensionFactory, url *motan.URL, proxy bool) *requestMirror {
+	group := url.GetParam(MirrorGroupKey, "")
+	if group == "" {
+		return nil
+	}
+	percent, err := strconv.ParseFloat(url.GetParam(MirrorPercentKey, strconv.Itoa(DefaultMirrorPercent)), 64)
+	if err != nil || percent <= 0 {
+		vlog.Warningf("Mirror of cluster %s is disabled by %s: %s", url.GetIdentity(), MirrorPercentKey, url.GetParam(MirrorPercentKey, ""))
+		return nil
+	}
+	concurrency := url.GetPositiveIntValue(MirrorConcurrencyKey, DefaultMirrorConcurrency)
+	compare, _ := strconv.ParseBool(url.GetParam(MirrorCompareKey, "false"))
+	shadowURL := url.Copy()
+	shadowURL.Group = group
+	// the shadow cluster should not mirror again
+	delete(shadowURL.Parameters, MirrorGroupKey)
+	delete(shadowURL.Parameters, MirrorPercentKey)
+	delete(shadowURL.Parameters, MirrorConcurrencyKey)
+	delete(shadowURL.Parameters, MirrorCompareKey)
+	vlog.Infof("Mirror %.2f%% requests of cluster %s to group %s", percent, url.GetIdentity(), group)
+	return &requestMirror{
+		url:     shadowURL,
+		cluster: NewCluster(context, extFactory, shadowURL, proxy),
+		percent: percent,
+		compare: compare,
+		slots:   make(chan struct{}, concurrency),
+		group:   metrics.Escape(url.Group) + mirrorGroupSuffix,
+		service: metrics.Escape(url.Path),
+	}
+}
+
+// sample returns a mirror call if the request should be mirrored, it must be called before the primary call
+// because the request may be changed by the primary call. The slot of the call is acquired before the request is
+// copied, the request is dropped if there is no free slot
+func (r *requestMirror) sample(request motan.Request) *mirrorCall {
+	if r.percent < 100 && rand.Float64()*100 >= r.percent {
+		return nil
+	}
+	if request.GetAttachment(MirrorAttachmentKey) != "" {
+		// the shadow requests are not mirrored again
+		return nil
+	}
+	if _, ok := request.(*motan.MotanRequest); !ok {
+		return nil
+	}
+	select {
+	case r.slots <- struct{}{}:
+	default:
+		metrics.AddCounter(r.group, r.service, mirrorMetricsRole+mirrorDroppedCount, 1)
+		return nil
+	}
+	shadowRequest := request.Clone().(*motan.MotanRequest)
+	call := &mirrorCall{request: shadowRequest}
+	if rc := shadowRequest.RPCContext; rc != nil {
+		call.reply = rc.Reply
+		call.async = rc.AsyncCall
+		// the shadow call is a synchronous call which shares nothing with the primary call
+		shadowRequest.RPCContext = &motan.RPCContext{
+			ExtFactory:      rc.ExtFactory,
+			Proxy:           rc.Proxy,
+			GzipSize:        rc.GzipSize,
+			CompressCodec:   rc.CompressCodec,
+			SerializeNum:    rc.SerializeNum,
+			Serialized:      rc.Serialized,
+			OriginalMessage: rc.OriginalMessage,
+		}
+		if rc.Meta != nil {
+			shadowRequest.RPCContext.Meta = rc.Meta.Copy()
+		}
+		if msg, ok := rc.OriginalMessage.(*protocol.Message); ok {
+			// the proxy request is sent by the original message which is changed when it's encoded, so the shadow
+			// request is sent by the cloned one, the primary message and its body may be reused after the primary call
+			shadowRequest.Attachment = msg.Metadata
+		}
+	}
+	shadowRequest.SetAttachment(MirrorAttachmentKey, "true")
+	shadowRequest.SetAttachment(protocol.MGroup, r.url.Group)
+	return call
+}
+
+// dispatch calls the shadow cluster with the sampled request in the acquired slot
+func (r *requestMirror) dispatch(call *mirrorCall, primary motan.Response) {
+	call.dispatched = true
+	var primaryValue interface{}
+	primaryFailed := primary == nil || primary.GetException() != nil
+	// the result of the async call is not ready when the call returned
+	compare := r.compare && !call.async
+	if compare && !primaryFailed {
+		primaryValue = copyMirrorValue(primary.GetValue())
+	}
+	go func() {
+		defer func() {
+			<-r.slots
+		}()
+		defer motan.HandlePanic(func() {
+			vlog.Errorf("Mirror call panic. req:%s", motan.GetReqInfo(call.request))
+		})
+		start := time.Now()
+		shadow := r.cluster.Call(call.request)
+		key := mirrorMetricsRole
+		metrics.AddCounter(r.group, r.service, key+mirrorTotalCount, 1)
+		metrics.AddHistograms(r.group, r.service, key, time.Since(start).Nanoseconds()/1e6)
+		if shadow.GetException() != nil {
+			metrics.AddCounter(r.group, r.service, key+mirrorErrorCount, 1)
+		}
+		if !compare {
+			return
+		}
+		switch compareMirrorResponse(primaryFailed, primaryValue, shadow, call.reply) {
+		case mirrorSame:
+			metrics.AddCounter(r.group, r.service, key+mirrorSameCount, 1)
+		case mirrorDiff:
+			metrics.AddCounter(r.group, r.service, key+mirrorDiffCount, 1)
+			vlog.Infof("Mirror response differs. req:%s", motan.GetReqInfo(call.request))
+		default:
+			metrics.AddCounter(r.group, r.service, key+mirrorUncomparedCount, 1)
+		}
+	}()
+}
+
+// abort releases the slot of the call which is not dispatched, e.g. the primary call panics
+func (r *requestMirror) abort(call *mirrorCall) {
+	if !call.dispatched {
+		<-r.slots
+	}
+}
+
+func (r *requestMirror) destroy() {
+	r.cluster.Destroy()
+}
+
+const (
+	mirrorUncompared = iota
+	mirrorSame
+	mirrorDiff
+)
+
+// compareMirrorResponse compares the values if both calls succeed, the failed calls are the same if both fail
+func compareMirrorResponse(primaryFailed bool, primaryValue interface{}, shadow motan.Response, reply interface{}) int {
+	shadowFailed := shadow.GetException() != nil
+	if primaryFailed || shadowFailed {
+		if primaryFailed == shadowFailed {
+			return mirrorSame
+		}
+		return mirrorDiff
+	}
+	shadowValue := shadow.GetValue()
+	if d, ok := shadowValue.(*motan.DeserializableValue); ok {
+		if reply == nil || reflect.TypeOf(reply).Kind() != reflect.Ptr {
+			shadowValue = d.Body
+		} else {
+			// deserialize as the primary reply
+			v, err := d.Deserialize(reflect.New(reflect.TypeOf(reply).Elem()).Interface())
+			if err != nil {
+				return mirrorUncompared
+			}
+			shadowValue = v
+		}
+	}
+	if primaryBytes, ok := primaryValue.([]byte); ok {
+		if shadowBytes, ok := shadowValue.([]byte); ok {
+			if bytes.Equal(primaryBytes, shadowBytes) {
+				return mirrorSame
+			}
+			return mirrorDiff
+		}
+	}
+	if reflect.TypeOf(primaryValue) != reflect.TypeOf(shadowValue) {
+		return mirrorUncompared
+	}
+	if reflect.DeepEqual(primaryValue, shadowValue) {
+		return mirrorSame
+	}
+	return mirrorDiff
+}
+
+// copyMirrorValue copies the bytes of the primary value, they may be reused after the primary call returned
+func copyMirrorValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return append([]byte(nil), v...)
+	case *motan.DeserializableValue:
+		return append([]byte(nil), v.Body...)
+	}
+	return value
+}
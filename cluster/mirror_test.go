@@ -0,0 +1,165 @@
+package cluster
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+type mirrorTestEndPoint struct {
+	*motan.TestEndPoint
+	lock     sync.Mutex
+	requests []motan.Request
+}
+
+func (e *mirrorTestEndPoint) Call(request motan.Request) motan.Response {
+	e.lock.Lock()
+	e.requests = append(e.requests, request)
+	e.lock.Unlock()
+	if e.ProcessTime != 0 {
+		time.Sleep(time.Duration(e.ProcessTime) * time.Millisecond)
+	}
+	return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: []byte(e.URL.GetParam("value", ""))}
+}
+
+func (e *mirrorTestEndPoint) calls() []motan.Request {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]motan.Request(nil), e.requests...)
+}
+
+func newMirrorTestCluster(params map[string]string) (*MotanCluster, *mirrorTestEndPoint, *mirrorTestEndPoint) {
+	endpoints := make(map[string]*mirrorTestEndPoint)
+	ext := getCustomExt()
+	ext.RegistExtEndpoint("mirror", func(url *motan.URL) motan.EndPoint {
+		ep := &mirrorTestEndPoint{TestEndPoint: &motan.TestEndPoint{URL: url}}
+		ep.ProcessTime, _ = url.GetInt("processTime")
+		endpoints[url.Group] = ep
+		return ep
+	})
+	url := &motan.URL{Protocol: "mirror", Path: "test.service", Group: "primary", Parameters: map[string]string{
+		motan.Hakey: "failover",
+		motan.Lbkey: "random",
+	}}
+	for k, v := range params {
+		url.Parameters[k] = v
+	}
+	cluster := NewCluster(&motan.Context{}, ext, url, false)
+	cluster.Notify(RegistryURL, []*motan.URL{{Protocol: "mirror", Path: "test.service", Host: "127.0.0.1", Port: 8001,
+		Group: "primary", Parameters: map[string]string{"value": "a"}}})
+	if cluster.mirror != nil {
+		cluster.mirror.cluster.Notify(RegistryURL, []*motan.URL{{Protocol: "mirror", Path: "test.service", Host: "127.0.0.1",
+			Port: 8002, Group: "shadow", Parameters: map[string]string{"value": params["value"], "processTime": params["shadowProcessTime"]}}})
+	}
+	return cluster, endpoints["primary"], endpoints["shadow"]
+}
+
+func waitMirrorCalls(ep *mirrorTestEndPoint, n int) {
+	for i := 0; i < 100 && len(ep.calls()) < n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRequestMirror(t *testing.T) {
+	cluster, primary, shadow := newMirrorTestCluster(map[string]string{
+		MirrorGroupKey:   "shadow",
+		MirrorCompareKey: "true",
+		"value":          "a",
+	})
+	assert.NotNil(t, cluster.mirror)
+	assert.True(t, cluster.mirror.compare)
+	_, ok := cluster.mirror.url.Parameters[MirrorGroupKey]
+	assert.False(t, ok)
+	assert.Nil(t, cluster.mirror.cluster.mirror)
+
+	request := &motan.MotanRequest{ServiceName: "test.service", Method: "hello", Arguments: []interface{}{"test"}}
+	request.SetAttachment(protocol.MGroup, "primary")
+	response := cluster.Call(request)
+	assert.Nil(t, response.GetException())
+	assert.Equal(t, []byte("a"), response.GetValue())
+	waitMirrorCalls(shadow, 1)
+	assert.Len(t, primary.calls(), 1)
+	shadowCalls := shadow.calls()
+	assert.Len(t, shadowCalls, 1)
+	assert.Equal(t, "true", shadowCalls[0].GetAttachment(MirrorAttachmentKey))
+	assert.Equal(t, "shadow", shadowCalls[0].GetAttachment(protocol.MGroup))
+	assert.Equal(t, "hello", shadowCalls[0].GetMethod())
+	// the primary request is not changed
+	assert.Equal(t, "", request.GetAttachment(MirrorAttachmentKey))
+	assert.Equal(t, "primary", request.GetAttachment(protocol.MGroup))
+
+	// the shadow requests are not mirrored again
+	cluster.Call(shadowCalls[0])
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, shadow.calls(), 1)
+
+	cluster.Destroy()
+	assert.True(t, cluster.mirror.cluster.closed)
+}
+
+func TestRequestMirrorProxy(t *testing.T) {
+	cluster, primary, shadow := newMirrorTestCluster(map[string]string{MirrorGroupKey: "shadow"})
+	msg := &protocol.Message{Header: protocol.BuildHeader(protocol.Req, true, 0, 1, protocol.Normal),
+		Metadata: motan.NewStringMap(0), Body: []byte("body"), Type: protocol.Req}
+	msg.Metadata.Store(protocol.MGroup, "primary")
+	request := &motan.MotanRequest{ServiceName: "test.service", Method: "hello", Attachment: msg.Metadata,
+		RPCContext: &motan.RPCContext{Proxy: true, OriginalMessage: msg}}
+	response := cluster.Call(request)
+	assert.Nil(t, response.GetException())
+	waitMirrorCalls(shadow, 1)
+	assert.Len(t, primary.calls(), 1)
+	shadowCalls := shadow.calls()
+	assert.Len(t, shadowCalls, 1)
+	// the shadow request is sent by a copy of the proxy message
+	shadowMessage := shadowCalls[0].GetRPCContext(true).OriginalMessage.(*protocol.Message)
+	assert.False(t, shadowMessage == msg)
+	assert.Equal(t, "true", shadowMessage.Metadata.LoadOrEmpty(MirrorAttachmentKey))
+	assert.Equal(t, "shadow", shadowMessage.Metadata.LoadOrEmpty(protocol.MGroup))
+	assert.Equal(t, []byte("body"), shadowMessage.Body)
+	// the primary message is not changed
+	assert.Equal(t, "", msg.Metadata.LoadOrEmpty(MirrorAttachmentKey))
+	assert.Equal(t, "primary", msg.Metadata.LoadOrEmpty(protocol.MGroup))
+	assert.Equal(t, "", request.GetAttachment(MirrorAttachmentKey))
+	assert.Equal(t, "primary", request.GetAttachment(protocol.MGroup))
+}
+
+func TestRequestMirrorBounded(t *testing.T) {
+	cluster, _, shadow := newMirrorTestCluster(map[string]string{
+		MirrorGroupKey:       "shadow",
+		MirrorConcurrencyKey: "1",
+		"shadowProcessTime":  "100",
+	})
+	for i := 0; i < 3; i++ {
+		cluster.Call(&motan.MotanRequest{ServiceName: "test.service", Method: "hello"})
+	}
+	// the request is not copied without free slot
+	assert.Nil(t, cluster.mirror.sample(&motan.MotanRequest{ServiceName: "test.service", Method: "hello"}))
+	time.Sleep(150 * time.Millisecond)
+	assert.Len(t, cluster.mirror.slots, 0)
+	// the first shadow call is in flight when the others are sampled, so they are dropped
+	assert.Len(t, shadow.calls(), 1)
+
+	cluster, _, _ = newMirrorTestCluster(map[string]string{MirrorGroupKey: "shadow", MirrorPercentKey: "0"})
+	assert.Nil(t, cluster.mirror)
+	cluster, _, _ = newMirrorTestCluster(nil)
+	assert.Nil(t, cluster.mirror)
+}
+
+func TestCompareMirrorResponse(t *testing.T) {
+	success := func(value interface{}) motan.Response {
+		return &motan.MotanResponse{Value: value}
+	}
+	failed := &motan.MotanResponse{Exception: &motan.Exception{ErrCode: 500, ErrMsg: "test"}}
+	assert.Equal(t, mirrorSame, compareMirrorResponse(false, []byte("a"), success([]byte("a")), nil))
+	assert.Equal(t, mirrorDiff, compareMirrorResponse(false, []byte("a"), success([]byte("b")), nil))
+	assert.Equal(t, mirrorSame, compareMirrorResponse(false, []byte("a"), success(&motan.DeserializableValue{Body: []byte("a")}), nil))
+	assert.Equal(t, mirrorSame, compareMirrorResponse(true, nil, failed, nil))
+	assert.Equal(t, mirrorDiff, compareMirrorResponse(false, []byte("a"), failed, nil))
+	assert.Equal(t, mirrorDiff, compareMirrorResponse(true, nil, success([]byte("a")), nil))
+	assert.Equal(t, mirrorSame, compareMirrorResponse(false, map[string]string{"a": "b"}, success(map[string]string{"a": "b"}), nil))
+	assert.Equal(t, mirrorUncompared, compareMirrorResponse(false, "a", success(1), nil))
+}
@@ -25,8 +25,12 @@ type MotanCluster struct {
 	Refers         []motan.EndPoint
 	Filters        []motan.Filter
 	clusterFilter  motan.ClusterFilter
+	routeFilter    *requestRouteFilter
+	outlier        *outlierDetector
+	mirror         *requestMirror
 	extFactory     motan.ExtensionFactory
 	registryRefers map[string][]motan.EndPoint
+	routeRefers    []motan.EndPoint // the endpoints of the request route target groups, they are not balanced by LoadBalance
 	notifyLock     sync.Mutex
 	available      bool
 	closed         bool
@@ -75,7 +79,17 @@ func (m *MotanCluster) Call(request motan.Request) (res motan.Response) {
 				request.SetAttachment(protocol.MGroup, m.url.Group)
 			}
 		}
-		return m.clusterFilter.Filter(m.HaStrategy, m.LoadBalance, request)
+		if m.mirror == nil {
+			return m.routeFilter.Filter(m.HaStrategy, m.LoadBalance, request)
+		}
+		mirrorCall := m.mirror.sample(request)
+		if mirrorCall == nil {
+			return m.routeFilter.Filter(m.HaStrategy, m.LoadBalance, request)
+		}
+		defer m.mirror.abort(mirrorCall)
+		res = m.routeFilter.Filter(m.HaStrategy, m.LoadBalance, request)
+		m.mirror.dispatch(mirrorCall, res)
+		return res
 	}
 	vlog.Infoln("cluster:" + m.GetIdentity() + "is not available!")
 	return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "cluster not available, maybe caused by degrade", ErrType: motan.ServiceException})
@@ -95,6 +109,8 @@ func (m *MotanCluster) initCluster() bool {
 	if m.Filters == nil {
 		m.Filters = make([]motan.Filter, 0)
 	}
+	m.routeFilter = newRequestRouteFilter(m.clusterFilter)
+	m.outlier = newOutlierDetector(m)
 	//TODO whether has available refers
 	m.available = true
 	m.closed = false
@@ -102,6 +118,11 @@ func (m *MotanCluster) initCluster() bool {
 	// parse registry and subscribe
 	m.parseRegistry()
 
+	m.mirror = newRequestMirror(m.Context, m.extFactory, m.url, m.proxy)
+	if m.outlier != nil {
+		m.outlier.start()
+	}
+
 	vlog.Infof("init MotanCluster %s", m.GetIdentity())
 
 	return true
@@ -125,7 +146,99 @@ func (m *MotanCluster) refresh() {
 	// shuffle endpoints list avoid to call to determine server nodes when the list is not change.
 	newRefers = m.ShuffleEndpoints(newRefers)
 	m.Refers = newRefers
-	m.LoadBalance.OnRefresh(newRefers)
+	m.refreshLoadBalance()
+}
+
+// availableRefers returns the refers and the request route refers which are not ejected by the outlier detection
+func (m *MotanCluster) availableRefers() ([]motan.EndPoint, []motan.EndPoint) {
+	if m.outlier == nil {
+		return m.Refers, m.routeRefers
+	}
+	return m.outlier.available(m.Refers, m.routeRefers)
+}
+
+// refreshLoadBalance refreshes the load balances with the available refers, it must be called with the notifyLock
+func (m *MotanCluster) refreshLoadBalance() {
+	refers, routeRefers := m.availableRefers()
+	m.LoadBalance.OnRefresh(refers)
+	if router := m.routeFilter.getRouter(); router != nil {
+		router.refresh(withRouteRefers(refers, routeRefers))
+	}
+}
+
+// withRouteRefers appends the endpoints of the request route target groups to the refers
+func withRouteRefers(refers []motan.EndPoint, routeRefers []motan.EndPoint) []motan.EndPoint {
+	if len(routeRefers) == 0 {
+		return refers
+	}
+	endpoints := make([]motan.EndPoint, 0, len(refers)+len(routeRefers))
+	endpoints = append(endpoints, refers...)
+	return append(endpoints, routeRefers...)
+}
+
+// setRouteGroupURLs refreshes the endpoints of the request route target groups which are subscribed by the command
+// registry wrapper, they are only selected by the request route rules
+func (m *MotanCluster) setRouteGroupURLs(urls []*motan.URL) {
+	m.notifyLock.Lock()
+	defer m.notifyLock.Unlock()
+	endpointMap := make(map[string]motan.EndPoint, len(m.routeRefers))
+	for _, ep := range m.routeRefers {
+		endpointMap[ep.GetURL().GetIdentity()] = ep
+	}
+	endpoints := make([]motan.EndPoint, 0, len(urls))
+	for _, u := range urls {
+		if u == nil || !u.CanServe(m.url) {
+			continue
+		}
+		ep, ok := endpointMap[u.GetIdentity()]
+		if ok {
+			delete(endpointMap, u.GetIdentity())
+		} else if ep = m.newEndPoint(u); ep == nil {
+			continue
+		}
+		endpoints = append(endpoints, ep)
+	}
+	vlog.Infof("cluster %s refresh request route group endpoints, size %d", m.GetIdentity(), len(endpoints))
+	m.routeRefers = endpoints
+	if router := m.routeFilter.getRouter(); router != nil {
+		router.refresh(withRouteRefers(m.availableRefers()))
+	}
+	for _, ep := range endpointMap {
+		ep.Destroy()
+	}
+}
+
+// setRequestRoutes replaces the request route rules of the cluster, the rules are removed if it's empty
+func (m *MotanCluster) setRequestRoutes(rules []string) {
+	m.notifyLock.Lock()
+	defer m.notifyLock.Unlock()
+	router := newRequestRouter(rules, func() motan.LoadBalance {
+		return m.extFactory.GetLB(m.url)
+	})
+	if router != nil {
+		router.refresh(withRouteRefers(m.availableRefers()))
+		vlog.Infof("cluster %s enable request route rules: %v", m.GetIdentity(), rules)
+	} else if m.routeFilter.getRouter() != nil {
+		vlog.Infof("cluster %s disable request route rules", m.GetIdentity())
+	}
+	m.routeFilter.router.Store(router)
+}
+
+// GetRequestRoutes returns the status of the request route rules
+func (m *MotanCluster) GetRequestRoutes() []RequestRouteStatus {
+	if router := m.routeFilter.getRouter(); router != nil {
+		return router.status()
+	}
+	return []RequestRouteStatus{}
+}
+
+// GetOutlierStatus returns the ejected endpoints and the recent events of the outlier detection, it returns nil if
+// the outlier detection is disabled
+func (m *MotanCluster) GetOutlierStatus() *OutlierStatus {
+	if m.outlier == nil {
+		return nil
+	}
+	return m.outlier.status()
 }
 func (m *MotanCluster) ShuffleEndpoints(endpoints []motan.EndPoint) []motan.EndPoint {
 	rand.Seed(time.Now().UnixNano())
@@ -165,21 +278,7 @@ func (m *MotanCluster) Notify(registryURL *motan.URL, urls []*motan.URL) {
 			delete(endpointMap, u.GetIdentity())
 		}
 		if ep == nil {
-			newURL := u.Copy()
-			newURL.MergeParams(m.url.Parameters)
-			ep = m.extFactory.GetEndPoint(newURL)
-
-			if ep != nil {
-				ep.SetProxy(m.proxy)
-				serialization := motan.GetSerialization(newURL, m.extFactory)
-				if serialization == nil {
-					vlog.Warningf("MotanCluster can not find Serialization in DefaultExtensionFactory! url:%+v", m.url)
-				} else {
-					ep.SetSerialization(serialization)
-				}
-				motan.Initialize(ep)
-				ep = m.addFilter(ep, m.Filters)
-			}
+			ep = m.newEndPoint(u)
 		}
 
 		if ep != nil {
@@ -215,11 +314,34 @@ func processWeight(m *MotanCluster, urls []*motan.URL) []*motan.URL {
 	return urls
 }
 
+func (m *MotanCluster) newEndPoint(u *motan.URL) motan.EndPoint {
+	newURL := u.Copy()
+	newURL.MergeParams(m.url.Parameters)
+	ep := m.extFactory.GetEndPoint(newURL)
+	if ep == nil {
+		return nil
+	}
+	ep.SetProxy(m.proxy)
+	serialization := motan.GetSerialization(newURL, m.extFactory)
+	if serialization == nil {
+		vlog.Warningf("MotanCluster can not find Serialization in DefaultExtensionFactory! url:%+v", m.url)
+	} else {
+		ep.SetSerialization(serialization)
+	}
+	motan.Initialize(ep)
+	return m.addFilter(ep, m.Filters)
+}
+
 func (m *MotanCluster) addFilter(ep motan.EndPoint, filters []motan.Filter) motan.EndPoint {
 	fep := &motan.FilterEndPoint{URL: ep.GetURL(), Caller: ep}
 	statusFilters := make([]motan.Status, 0, len(filters))
 	var lastf motan.EndPointFilter
 	lastf = motan.GetLastEndPointFilter()
+	if m.outlier != nil {
+		of := m.outlier.newFilter(ep.GetURL())
+		of.SetNext(lastf)
+		lastf = of
+	}
 	for _, f := range filters {
 		if filter := f.NewFilter(ep.GetURL()); filter != nil {
 			if ef, ok := filter.(motan.EndPointFilter); ok {
@@ -257,10 +379,16 @@ func (m *MotanCluster) Destroy() {
 			vlog.Infof("unsubscribe from registry %s .", r.GetURL().GetIdentity())
 			r.Unsubscribe(m.url, m)
 		}
-		for _, e := range m.Refers {
+		for _, e := range withRouteRefers(m.Refers, m.routeRefers) {
 			vlog.Infof("destroy endpoint %s .", e.GetURL().GetIdentity())
 			e.Destroy()
 		}
+		if m.mirror != nil {
+			m.mirror.destroy()
+		}
+		if m.outlier != nil {
+			m.outlier.destroy()
+		}
 		m.closed = true
 	}
 }
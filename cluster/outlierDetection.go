@@ -0,0 +1,388 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+)
+
+const (
+	// OutlierDetectionKey enables the outlier detection of the cluster
+	OutlierDetectionKey = "outlierDetection"
+	// OutlierIntervalKey is the interval(ms) of the detection, the statistics are reset in every interval
+	OutlierIntervalKey = "outlierInterval"
+	// OutlierBaseEjectionTimeKey is the ejection time(ms) of the first offense, it grows with the repeated offenses
+	OutlierBaseEjectionTimeKey = "outlierBaseEjectionTime"
+	// OutlierMaxEjectionTimeKey is the max ejection time(ms)
+	OutlierMaxEjectionTimeKey = "outlierMaxEjectionTime"
+	// OutlierMaxEjectionPercentKey is the max percentage of the ejected endpoints, at least one endpoint can be ejected
+	OutlierMaxEjectionPercentKey = "outlierMaxEjectionPercent"
+	// OutlierMinRequestsKey is the min requests of an endpoint in an interval to be detected
+	OutlierMinRequestsKey = "outlierMinRequests"
+	// OutlierMinEndpointsKey is the min number of the detected endpoints to calculate the medians
+	OutlierMinEndpointsKey = "outlierMinEndpoints"
+	// OutlierErrorRateDeviationKey is the percentage that the error rate of an outlier exceeds the median
+	OutlierErrorRateDeviationKey = "outlierErrorRateDeviation"
+	// OutlierLatencyFactorKey is the times that the average latency of an outlier exceeds the median, 0 disables it
+	OutlierLatencyFactorKey = "outlierLatencyFactor"
+	// OutlierLatencyThresholdKey is the min difference(ms) between the average latency of an outlier and the median
+	OutlierLatencyThresholdKey = "outlierLatencyThreshold"
+
+	DefaultOutlierInterval           = 10 * time.Second
+	DefaultOutlierBaseEjectionTime   = 30 * time.Second
+	DefaultOutlierMaxEjectionTime    = 300 * time.Second
+	DefaultOutlierMaxEjectionPercent = 10
+	DefaultOutlierMinRequests        = 20
+	DefaultOutlierMinEndpoints       = 3
+	DefaultOutlierErrorRateDeviation = 20
+	DefaultOutlierLatencyFactor      = 3
+	DefaultOutlierLatencyThreshold   = 50 * time.Millisecond
+
+	OutlierActionEject  = "eject"
+	OutlierActionReturn = "return"
+)
+
+const (
+	outlierFilterName    = "outlierDetection"
+	outlierMetricsRole   = "motan-client-outlier"
+	outlierGroupSuffix   = ".outlier"
+	outlierEjectedCount  = ".ejected_count"
+	outlierReturnedCount = ".returned_count"
+	outlierMaxEvents     = 100
+)
+
+// outlierHost is the statistics and the ejection state of an endpoint
+type outlierHost struct {
+	address   string
+	requests  int64
+	errors    int64
+	latency   int64 // the total latency in nanoseconds
+	ejected   bool
+	until     time.Time
+	ejections int // the multiplier of the ejection time, it decreases when the endpoint keeps healthy
+}
+
+// OutlierEvent is an ejection or a return of an endpoint
+type OutlierEvent struct {
+	Time         string `json:"time"`
+	Endpoint     string `json:"endpoint"`
+	Action       string `json:"action"`
+	Reason       string `json:"reason,omitempty"`
+	EjectionTime int64  `json:"ejectionTime,omitempty"` // ms
+}
+
+// OutlierEjection is an ejected endpoint
+type OutlierEjection struct {
+	Endpoint  string `json:"endpoint"`
+	Ejections int    `json:"ejections"`
+	Until     string `json:"until"`
+}
+
+// OutlierStatus is the outlier detection status of a cluster which is shown on the manage port
+type OutlierStatus struct {
+	Ejected []OutlierEjection `json:"ejected"`
+	Events  []OutlierEvent    `json:"events"`
+}
+
+// outlierDetector ejects the endpoints whose error rate or latency deviates from the medians of the cluster in an
+// interval. The ejected endpoints are removed from the load balance until the ejection time passed
+type outlierDetector struct {
+	cluster            *MotanCluster
+	interval           time.Duration
+	baseEjectionTime   time.Duration
+	maxEjectionTime    time.Duration
+	maxEjectionPercent int64
+	minRequests        int64
+	minEndpoints       int
+	errorRateDeviation float64
+	latencyFactor      float64
+	latencyThreshold   time.Duration
+	group              string
+	service            string
+
+	lock   sync.Mutex
+	hosts  map[string]*outlierHost // key is the identity of the endpoint url
+	events []OutlierEvent
+	stop   chan struct{}
+}
+
+func newOutlierDetector(cluster *MotanCluster) *outlierDetector {
+	url := cluster.GetURL()
+	if enable, _ := strconv.ParseBool(url.GetParam(OutlierDetectionKey, "false")); !enable {
+		return nil
+	}
+	d := &outlierDetector{
+		cluster:            cluster,
+		interval:           url.GetTimeDuration(OutlierIntervalKey, time.Millisecond, DefaultOutlierInterval),
+		baseEjectionTime:   url.GetTimeDuration(OutlierBaseEjectionTimeKey, time.Millisecond, DefaultOutlierBaseEjectionTime),
+		maxEjectionTime:    url.GetTimeDuration(OutlierMaxEjectionTimeKey, time.Millisecond, DefaultOutlierMaxEjectionTime),
+		maxEjectionPercent: url.GetPositiveIntValue(OutlierMaxEjectionPercentKey, DefaultOutlierMaxEjectionPercent),
+		minRequests:        url.GetPositiveIntValue(OutlierMinRequestsKey, DefaultOutlierMinRequests),
+		minEndpoints:       int(url.GetPositiveIntValue(OutlierMinEndpointsKey, DefaultOutlierMinEndpoints)),
+		errorRateDeviation: getFloatParam(url, OutlierErrorRateDeviationKey, DefaultOutlierErrorRateDeviation) / 100,
+		latencyFactor:      getFloatParam(url, OutlierLatencyFactorKey, DefaultOutlierLatencyFactor),
+		latencyThreshold:   url.GetTimeDuration(OutlierLatencyThresholdKey, time.Millisecond, DefaultOutlierLatencyThreshold),
+		group:              metrics.Escape(url.Group) + outlierGroupSuffix,
+		service:            metrics.Escape(url.Path),
+		hosts:              make(map[string]*outlierHost),
+		stop:               make(chan struct{}),
+	}
+	if d.interval <= 0 {
+		d.interval = DefaultOutlierInterval
+	}
+	if d.maxEjectionTime < d.baseEjectionTime {
+		d.maxEjectionTime = d.baseEjectionTime
+	}
+	vlog.Infof("Outlier detection of cluster %s is enabled, interval: %s", url.GetIdentity(), d.interval)
+	return d
+}
+
+func getFloatParam(url *motan.URL, key string, defaultValue float64) float64 {
+	if v, err := strconv.ParseFloat(url.GetParam(key, ""), 64); err == nil && v >= 0 {
+		return v
+	}
+	return defaultValue
+}
+
+func (d *outlierDetector) start() {
+	go func() {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if d.check() {
+					d.cluster.notifyLock.Lock()
+					d.cluster.refreshLoadBalance()
+					d.cluster.notifyLock.Unlock()
+				}
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (d *outlierDetector) destroy() {
+	close(d.stop)
+}
+
+// newFilter returns the endpoint filter which collects the statistics of the endpoint
+func (d *outlierDetector) newFilter(url *motan.URL) motan.EndPointFilter {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	host, ok := d.hosts[url.GetIdentity()]
+	if !ok {
+		host = &outlierHost{address: url.GetAddressStr()}
+		d.hosts[url.GetIdentity()] = host
+	}
+	return &outlierFilter{host: host}
+}
+
+// available returns the refers and the request route refers which are not ejected, and removes the hosts of the
+// endpoints not in the cluster
+func (d *outlierDetector) available(refers []motan.EndPoint, routeRefers []motan.EndPoint) ([]motan.EndPoint, []motan.EndPoint) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	hosts := make(map[string]*outlierHost, len(refers)+len(routeRefers))
+	filter := func(endpoints []motan.EndPoint) []motan.EndPoint {
+		result := make([]motan.EndPoint, 0, len(endpoints))
+		for _, ep := range endpoints {
+			id := ep.GetURL().GetIdentity()
+			host := d.hosts[id]
+			if host != nil {
+				hosts[id] = host
+			}
+			if host == nil || !host.ejected {
+				result = append(result, ep)
+			}
+		}
+		return result
+	}
+	refers, routeRefers = filter(refers), filter(routeRefers)
+	d.hosts = hosts
+	return refers, routeRefers
+}
+
+// outlierCandidate is the statistics of a detected endpoint in an interval
+type outlierCandidate struct {
+	host      *outlierHost
+	errorRate float64
+	latency   float64 // the average latency in nanoseconds
+}
+
+// check ejects the outliers and returns the endpoints whose ejection time passed, it returns true if the
+// ejected endpoints changed
+func (d *outlierDetector) check() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Now()
+	changed := false
+	ejected := 0
+	candidates := make([]*outlierCandidate, 0, len(d.hosts))
+	for _, host := range d.hosts {
+		requests := atomic.SwapInt64(&host.requests, 0)
+		errors := atomic.SwapInt64(&host.errors, 0)
+		latency := atomic.SwapInt64(&host.latency, 0)
+		if host.ejected {
+			if now.Before(host.until) {
+				ejected++
+				continue
+			}
+			host.ejected = false
+			changed = true
+			d.addEvent(OutlierEvent{Time: now.Format(time.RFC3339), Endpoint: host.address, Action: OutlierActionReturn})
+			metrics.AddCounter(d.group, d.service, outlierMetricsRole+outlierReturnedCount, 1)
+			vlog.Infof("Outlier endpoint %s of cluster %s is returned", host.address, d.cluster.GetIdentity())
+			continue
+		}
+		if requests < d.minRequests {
+			continue
+		}
+		candidates = append(candidates, &outlierCandidate{
+			host:      host,
+			errorRate: float64(errors) / float64(requests),
+			latency:   float64(latency) / float64(requests),
+		})
+	}
+	if len(candidates) < d.minEndpoints {
+		return changed
+	}
+	errorRates := make([]float64, len(candidates))
+	latencies := make([]float64, len(candidates))
+	for i, c := range candidates {
+		errorRates[i] = c.errorRate
+		latencies[i] = c.latency
+	}
+	medianErrorRate := median(errorRates)
+	medianLatency := median(latencies)
+	maxEjected := int(int64(len(d.hosts)) * d.maxEjectionPercent / 100)
+	if maxEjected < 1 {
+		maxEjected = 1
+	}
+	// the worst endpoints are ejected first
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].errorRate != candidates[j].errorRate {
+			return candidates[i].errorRate > candidates[j].errorRate
+		}
+		return candidates[i].latency > candidates[j].latency
+	})
+	for _, c := range candidates {
+		host := c.host
+		reason := ""
+		if c.errorRate > 0 && c.errorRate-medianErrorRate >= d.errorRateDeviation {
+			reason = fmt.Sprintf("error rate %.2f%% exceeds median %.2f%%", c.errorRate*100, medianErrorRate*100)
+		} else if d.latencyFactor > 0 && c.latency > medianLatency*d.latencyFactor && c.latency-medianLatency >= float64(d.latencyThreshold) {
+			reason = fmt.Sprintf("latency %s exceeds median %s", time.Duration(c.latency), time.Duration(medianLatency))
+		}
+		if reason == "" {
+			if host.ejections > 0 {
+				host.ejections--
+			}
+			continue
+		}
+		if ejected >= maxEjected {
+			vlog.Warningf("Outlier endpoint %s of cluster %s is not ejected by max ejection percent: %s", host.address, d.cluster.GetIdentity(), reason)
+			continue
+		}
+		ejected++
+		host.ejections++
+		ejectionTime := d.baseEjectionTime * time.Duration(host.ejections)
+		if ejectionTime > d.maxEjectionTime || ejectionTime <= 0 {
+			ejectionTime = d.maxEjectionTime
+		}
+		host.ejected = true
+		host.until = now.Add(ejectionTime)
+		changed = true
+		d.addEvent(OutlierEvent{Time: now.Format(time.RFC3339), Endpoint: host.address, Action: OutlierActionEject, Reason: reason,
+			EjectionTime: ejectionTime.Nanoseconds() / 1e6})
+		metrics.AddCounter(d.group, d.service, outlierMetricsRole+outlierEjectedCount, 1)
+		vlog.Warningf("Outlier endpoint %s of cluster %s is ejected for %s: %s", host.address, d.cluster.GetIdentity(), ejectionTime, reason)
+	}
+	return changed
+}
+
+func (d *outlierDetector) addEvent(event OutlierEvent) {
+	if len(d.events) >= outlierMaxEvents {
+		d.events = append(d.events[:0], d.events[1:]...)
+	}
+	d.events = append(d.events, event)
+}
+
+func (d *outlierDetector) status() *OutlierStatus {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	status := &OutlierStatus{Ejected: []OutlierEjection{}, Events: append([]OutlierEvent{}, d.events...)}
+	for _, host := range d.hosts {
+		if host.ejected {
+			status.Ejected = append(status.Ejected, OutlierEjection{Endpoint: host.address, Ejections: host.ejections, Until: host.until.Format(time.RFC3339)})
+		}
+	}
+	sort.Slice(status.Ejected, func(i, j int) bool {
+		return status.Ejected[i].Endpoint < status.Ejected[j].Endpoint
+	})
+	return status
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// outlierFilter is the innermost endpoint filter which collects the results of the endpoint calls, the business
+// exceptions are not counted as errors
+type outlierFilter struct {
+	host *outlierHost
+	next motan.EndPointFilter
+}
+
+func (f *outlierFilter) GetName() string {
+	return outlierFilterName
+}
+
+func (f *outlierFilter) NewFilter(url *motan.URL) motan.Filter {
+	return &outlierFilter{host: f.host}
+}
+
+func (f *outlierFilter) Filter(caller motan.Caller, request motan.Request) motan.Response {
+	start := time.Now()
+	response := f.GetNext().Filter(caller, request)
+	atomic.AddInt64(&f.host.latency, int64(time.Since(start)))
+	atomic.AddInt64(&f.host.requests, 1)
+	if response == nil || (response.GetException() != nil && response.GetException().ErrType != motan.BizException) {
+		atomic.AddInt64(&f.host.errors, 1)
+	}
+	return response
+}
+
+func (f *outlierFilter) HasNext() bool {
+	return f.next != nil
+}
+
+func (f *outlierFilter) GetIndex() int {
+	return 0
+}
+
+func (f *outlierFilter) GetType() int32 {
+	return motan.EndPointFilterType
+}
+
+func (f *outlierFilter) SetNext(next motan.EndPointFilter) {
+	f.next = next
+}
+
+func (f *outlierFilter) GetNext() motan.EndPointFilter {
+	return f.next
+}
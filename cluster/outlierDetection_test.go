@@ -0,0 +1,144 @@
+package cluster
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+type outlierTestEndPoint struct {
+	*motan.TestEndPoint
+}
+
+func (e *outlierTestEndPoint) Call(request motan.Request) motan.Response {
+	if e.ProcessTime != 0 {
+		time.Sleep(time.Duration(e.ProcessTime) * time.Millisecond)
+	}
+	if e.URL.GetParam("error", "") == "true" {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "test", ErrType: motan.ServiceException})
+	}
+	return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: []byte(e.URL.Host)}
+}
+
+func newOutlierTestCluster(params map[string]string) *MotanCluster {
+	ext := getCustomExt()
+	ext.RegistExtEndpoint("outlier", func(url *motan.URL) motan.EndPoint {
+		ep := &outlierTestEndPoint{TestEndPoint: &motan.TestEndPoint{URL: url}}
+		ep.ProcessTime, _ = url.GetInt("processTime")
+		return ep
+	})
+	url := &motan.URL{Protocol: "outlier", Path: "test.service", Group: "test", Parameters: map[string]string{
+		motan.Hakey:                "failover",
+		motan.Lbkey:                "roundrobin",
+		OutlierDetectionKey:        "true",
+		OutlierIntervalKey:         "3600000",
+		OutlierMinRequestsKey:      "10",
+		OutlierLatencyThresholdKey: "5",
+		OutlierBaseEjectionTimeKey: "1000",
+		OutlierMaxEjectionTimeKey:  "3000",
+	}}
+	for k, v := range params {
+		url.Parameters[k] = v
+	}
+	cluster := NewCluster(&motan.Context{}, ext, url, false)
+	urls := make([]*motan.URL, 0, 4)
+	for i := 1; i <= 4; i++ {
+		urls = append(urls, &motan.URL{Protocol: "outlier", Path: "test.service", Host: "10.0.0." + strconv.Itoa(i), Port: 8001,
+			Group: "test", Parameters: map[string]string{}})
+	}
+	urls[0].Parameters["error"] = "true"
+	urls[1].Parameters["processTime"] = "10"
+	cluster.Notify(RegistryURL, urls)
+	return cluster
+}
+
+func checkOutliers(cluster *MotanCluster, calls int) map[string]int {
+	for i := 0; i < calls; i++ {
+		cluster.Call(&motan.MotanRequest{ServiceName: "test.service", Method: "hello"})
+	}
+	if cluster.outlier.check() {
+		cluster.notifyLock.Lock()
+		cluster.refreshLoadBalance()
+		cluster.notifyLock.Unlock()
+	}
+	hosts := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		response := cluster.Call(&motan.MotanRequest{ServiceName: "test.service", Method: "hello"})
+		if value, ok := response.GetValue().([]byte); ok {
+			hosts[string(value)]++
+		}
+	}
+	return hosts
+}
+
+func TestOutlierDetection(t *testing.T) {
+	cluster := newOutlierTestCluster(map[string]string{OutlierMaxEjectionPercentKey: "50"})
+	defer cluster.Destroy()
+	hosts := checkOutliers(cluster, 100)
+	assert.Len(t, hosts, 2)
+	assert.Equal(t, 0, hosts["10.0.0.2"])
+	status := cluster.GetOutlierStatus()
+	assert.Len(t, status.Ejected, 2)
+	assert.Equal(t, "10.0.0.1:8001", status.Ejected[0].Endpoint)
+	assert.Equal(t, "10.0.0.2:8001", status.Ejected[1].Endpoint)
+	assert.Len(t, status.Events, 2)
+	assert.Equal(t, OutlierActionEject, status.Events[0].Action)
+	assert.Contains(t, status.Events[0].Reason, "error rate")
+	assert.Contains(t, status.Events[1].Reason, "latency")
+	assert.Equal(t, int64(1000), status.Events[0].EjectionTime)
+
+	// the ejection time grows with the repeated offenses
+	cluster.outlier.lock.Lock()
+	for _, host := range cluster.outlier.hosts {
+		host.until = time.Now().Add(-time.Second)
+	}
+	cluster.outlier.lock.Unlock()
+	hosts = checkOutliers(cluster, 0)
+	assert.True(t, hosts["10.0.0.2"] > 0)
+	assert.Empty(t, cluster.GetOutlierStatus().Ejected)
+	checkOutliers(cluster, 100)
+	status = cluster.GetOutlierStatus()
+	assert.Len(t, status.Ejected, 2)
+	assert.Equal(t, 2, status.Ejected[0].Ejections)
+	assert.Len(t, status.Events, 6)
+	assert.Equal(t, OutlierActionReturn, status.Events[2].Action)
+	assert.Equal(t, int64(2000), status.Events[4].EjectionTime)
+}
+
+func TestOutlierDetectionRouteRefers(t *testing.T) {
+	cluster := newOutlierTestCluster(nil)
+	defer cluster.Destroy()
+	cluster.setRouteGroupURLs([]*motan.URL{{Protocol: "outlier", Path: "test.service", Host: "10.0.1.1", Port: 8001,
+		Group: "canary", Parameters: map[string]string{}}})
+	// the hosts of the request route target groups are kept after the load balance is refreshed
+	cluster.notifyLock.Lock()
+	cluster.refreshLoadBalance()
+	cluster.notifyLock.Unlock()
+	cluster.outlier.lock.Lock()
+	defer cluster.outlier.lock.Unlock()
+	assert.Len(t, cluster.outlier.hosts, 5)
+	assert.NotNil(t, cluster.outlier.hosts[cluster.routeRefers[0].GetURL().GetIdentity()])
+}
+
+func TestOutlierDetectionGuard(t *testing.T) {
+	// at least one endpoint can be ejected, the worst one is ejected first
+	cluster := newOutlierTestCluster(nil)
+	defer cluster.Destroy()
+	hosts := checkOutliers(cluster, 100)
+	assert.Len(t, hosts, 3)
+	status := cluster.GetOutlierStatus()
+	assert.Len(t, status.Ejected, 1)
+	assert.Equal(t, "10.0.0.1:8001", status.Ejected[0].Endpoint)
+
+	// the endpoints are not detected without enough requests
+	cluster = newOutlierTestCluster(map[string]string{OutlierMinRequestsKey: "1000"})
+	defer cluster.Destroy()
+	checkOutliers(cluster, 100)
+	assert.Empty(t, cluster.GetOutlierStatus().Events)
+
+	cluster = newOutlierTestCluster(map[string]string{OutlierDetectionKey: "false"})
+	assert.Nil(t, cluster.GetOutlierStatus())
+}
@@ -0,0 +1,327 @@
+package cluster
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+const (
+	// RouteTagsKey is the comma separated tags of the server node, the nodes are selected by the 'tag:' targets
+	RouteTagsKey = "tags"
+
+	RequestRouteFilterName = "requestRoute"
+
+	routeGroupTargetPrefix = "group:"
+	routeTagTargetPrefix   = "tag:"
+)
+
+var routeConditionPattern = regexp.MustCompile(`^([^\s%=!<>]+)\s*(?:%\s*(\d+)\s*)?(==|!=|<=|>=|=|<|>)\s*(.*)$`)
+
+// requestRouteRule routes the requests which match all the conditions to the target endpoints.
+// The format of a rule is '<conditions> to <target>', the conditions are joined by '&&' and every condition
+// compares a request attachment with a value, the attachment value can be taken modulo before the comparison.
+// The target is a group('group:canary'), a tag of the server nodes('tag:canary') or a host pattern like the
+// ip route rules('10.75.*'). e.g.
+//
+//	x-canary == true to tag:canary
+//	uid % 100 < 5 && region = bj to group:canary
+//	* to !10.75.1.*
+type requestRouteRule struct {
+	rule       string
+	conditions []*routeCondition // empty means all the requests
+	target     string
+}
+
+type routeCondition struct {
+	key     string
+	modulo  int64 // 0 means the attachment value is compared directly
+	op      string
+	value   string
+	number  int64
+	numeric bool
+}
+
+func parseRequestRouteRule(rule string) *requestRouteRule {
+	idx := strings.LastIndex(rule, " to ")
+	if idx < 0 {
+		vlog.Warningf("wrong request route rule:%s is ignored!", rule)
+		return nil
+	}
+	r := &requestRouteRule{rule: strings.TrimSpace(rule), target: strings.TrimSpace(rule[idx+4:])}
+	if r.target == "" {
+		vlog.Warningf("wrong request route rule:%s is ignored!", rule)
+		return nil
+	}
+	from := strings.TrimSpace(rule[:idx])
+	if from == "*" {
+		return r
+	}
+	for _, c := range strings.Split(from, "&&") {
+		condition := parseRouteCondition(strings.TrimSpace(c))
+		if condition == nil {
+			vlog.Warningf("wrong condition '%s' of request route rule:%s, the rule is ignored!", c, rule)
+			return nil
+		}
+		r.conditions = append(r.conditions, condition)
+	}
+	return r
+}
+
+func parseRouteCondition(c string) *routeCondition {
+	m := routeConditionPattern.FindStringSubmatch(c)
+	if m == nil {
+		return nil
+	}
+	condition := &routeCondition{key: m[1], op: m[3], value: strings.TrimSpace(m[4])}
+	if condition.op == "=" {
+		condition.op = "=="
+	}
+	if m[2] != "" {
+		condition.modulo, _ = strconv.ParseInt(m[2], 10, 64)
+		if condition.modulo <= 0 {
+			return nil
+		}
+	}
+	number, err := strconv.ParseInt(condition.value, 10, 64)
+	if err == nil {
+		condition.number = number
+		condition.numeric = condition.modulo > 0 || (condition.op != "==" && condition.op != "!=")
+	} else if condition.modulo > 0 || (condition.op != "==" && condition.op != "!=") {
+		// the modulo and the ordering comparisons need a number
+		return nil
+	}
+	return condition
+}
+
+func (c *routeCondition) match(request motan.Request) bool {
+	v := request.GetAttachment(c.key)
+	if v == "" {
+		return c.op == "!="
+	}
+	if !c.numeric {
+		if c.op == "==" {
+			return v == c.value
+		}
+		return v != c.value
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return false
+	}
+	if c.modulo > 0 {
+		n = (n%c.modulo + c.modulo) % c.modulo
+	}
+	switch c.op {
+	case "==":
+		return n == c.number
+	case "!=":
+		return n != c.number
+	case "<":
+		return n < c.number
+	case "<=":
+		return n <= c.number
+	case ">":
+		return n > c.number
+	case ">=":
+		return n >= c.number
+	}
+	return false
+}
+
+func (r *requestRouteRule) match(request motan.Request) bool {
+	for _, c := range r.conditions {
+		if !c.match(request) {
+			return false
+		}
+	}
+	return true
+}
+
+// isRouteTarget checks whether the endpoint url is selected by the target
+func isRouteTarget(target string, url *motan.URL) bool {
+	if strings.HasPrefix(target, routeGroupTargetPrefix) {
+		return url.Group == target[len(routeGroupTargetPrefix):]
+	}
+	if strings.HasPrefix(target, routeTagTargetPrefix) {
+		tag := target[len(routeTagTargetPrefix):]
+		for _, t := range motan.TrimSplit(url.GetParam(RouteTagsKey, ""), ",") {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	}
+	return isMatch(target, url.Host)
+}
+
+// requestRouteGroups returns the groups of the 'group:' targets, the groups which are not merged into the cluster
+// are subscribed by the command registry wrapper
+func requestRouteGroups(rules []string) []string {
+	var groups []string
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		idx := strings.LastIndex(rule, " to ")
+		if idx < 0 {
+			continue
+		}
+		target := strings.TrimSpace(rule[idx+4:])
+		if !strings.HasPrefix(target, routeGroupTargetPrefix) {
+			continue
+		}
+		group := target[len(routeGroupTargetPrefix):]
+		if group != "" && !seen[group] {
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// routeTarget is the endpoints selected by a target, they are balanced by a load balance of the cluster type
+type routeTarget struct {
+	lb        motan.LoadBalance
+	size      int32
+	endpoints atomic.Value // []motan.EndPoint
+}
+
+// isAvailable tells whether the target has an available endpoint
+func (t *routeTarget) isAvailable() bool {
+	endpoints, _ := t.endpoints.Load().([]motan.EndPoint)
+	for _, ep := range endpoints {
+		if ep.IsAvailable() {
+			return true
+		}
+	}
+	return false
+}
+
+// requestRouter holds the request route rules of a cluster
+type requestRouter struct {
+	rules   []*requestRouteRule
+	targets map[string]*routeTarget
+}
+
+func newRequestRouter(rules []string, newLB func() motan.LoadBalance) *requestRouter {
+	router := &requestRouter{targets: make(map[string]*routeTarget)}
+	for _, rule := range rules {
+		r := parseRequestRouteRule(rule)
+		if r == nil {
+			continue
+		}
+		router.rules = append(router.rules, r)
+		if _, ok := router.targets[r.target]; !ok {
+			router.targets[r.target] = &routeTarget{lb: newLB()}
+		}
+	}
+	if len(router.rules) == 0 {
+		return nil
+	}
+	return router
+}
+
+// refresh selects the endpoints of every target, it must be called when the endpoints of the cluster changed
+func (r *requestRouter) refresh(endpoints []motan.EndPoint) {
+	for target, t := range r.targets {
+		selected := make([]motan.EndPoint, 0, len(endpoints))
+		for _, ep := range endpoints {
+			if isRouteTarget(target, ep.GetURL()) {
+				selected = append(selected, ep)
+			}
+		}
+		t.lb.OnRefresh(selected)
+		t.endpoints.Store(selected)
+		atomic.StoreInt32(&t.size, int32(len(selected)))
+	}
+}
+
+// route returns the load balance of the first matched rule, nil means the request is balanced in all endpoints
+// of the cluster. The request is not routed if the matched target has no available endpoint
+func (r *requestRouter) route(request motan.Request) motan.LoadBalance {
+	for _, rule := range r.rules {
+		if rule.match(request) {
+			if t := r.targets[rule.target]; t.isAvailable() {
+				return t.lb
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// RequestRouteStatus is the status of a request route rule which is shown on the manage port
+type RequestRouteStatus struct {
+	Rule      string `json:"rule"`
+	Target    string `json:"target"`
+	Endpoints int    `json:"endpoints"`
+}
+
+func (r *requestRouter) status() []RequestRouteStatus {
+	status := make([]RequestRouteStatus, 0, len(r.rules))
+	for _, rule := range r.rules {
+		status = append(status, RequestRouteStatus{
+			Rule:      rule.rule,
+			Target:    rule.target,
+			Endpoints: int(atomic.LoadInt32(&r.targets[rule.target].size)),
+		})
+	}
+	return status
+}
+
+// requestRouteFilter is the head of the cluster filter chain, it replaces the load balance of the cluster with
+// the one of the route target when the request matches a request route rule
+type requestRouteFilter struct {
+	router atomic.Value // *requestRouter
+	next   motan.ClusterFilter
+}
+
+func newRequestRouteFilter(next motan.ClusterFilter) *requestRouteFilter {
+	f := &requestRouteFilter{next: next}
+	f.router.Store((*requestRouter)(nil))
+	return f
+}
+
+func (f *requestRouteFilter) getRouter() *requestRouter {
+	return f.router.Load().(*requestRouter)
+}
+
+func (f *requestRouteFilter) GetIndex() int {
+	return 0
+}
+
+func (f *requestRouteFilter) GetName() string {
+	return RequestRouteFilterName
+}
+
+func (f *requestRouteFilter) NewFilter(url *motan.URL) motan.Filter {
+	return newRequestRouteFilter(nil)
+}
+
+func (f *requestRouteFilter) Filter(ha motan.HaStrategy, lb motan.LoadBalance, request motan.Request) motan.Response {
+	if router := f.getRouter(); router != nil {
+		if routeLB := router.route(request); routeLB != nil {
+			lb = routeLB
+		}
+	}
+	return f.GetNext().Filter(ha, lb, request)
+}
+
+func (f *requestRouteFilter) HasNext() bool {
+	return f.next != nil
+}
+
+func (f *requestRouteFilter) SetNext(cf motan.ClusterFilter) {
+	f.next = cf
+}
+
+func (f *requestRouteFilter) GetNext() motan.ClusterFilter {
+	return f.next
+}
+
+func (f *requestRouteFilter) GetType() int32 {
+	return motan.ClusterFilterType
+}
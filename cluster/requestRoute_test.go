@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+func newRouteTestRequest(attachments ...string) *motan.MotanRequest {
+	request := &motan.MotanRequest{ServiceName: "test.service", Method: "hello"}
+	for i := 0; i+1 < len(attachments); i += 2 {
+		request.SetAttachment(attachments[i], attachments[i+1])
+	}
+	return request
+}
+
+func TestParseRequestRouteRule(t *testing.T) {
+	rule := parseRequestRouteRule(" uid % 100 < 5 && x-canary=true to group:canary ")
+	assert.NotNil(t, rule)
+	assert.Equal(t, "group:canary", rule.target)
+	assert.Len(t, rule.conditions, 2)
+	assert.Equal(t, int64(100), rule.conditions[0].modulo)
+	assert.Equal(t, "==", rule.conditions[1].op)
+	assert.True(t, rule.match(newRouteTestRequest("uid", "10203", "x-canary", "true")))
+	assert.False(t, rule.match(newRouteTestRequest("uid", "10205", "x-canary", "true")))
+	assert.False(t, rule.match(newRouteTestRequest("uid", "10203")))
+	assert.False(t, rule.match(newRouteTestRequest("uid", "abc", "x-canary", "true")))
+
+	rule = parseRequestRouteRule("* to tag:canary")
+	assert.True(t, rule.match(newRouteTestRequest()))
+	rule = parseRequestRouteRule("region != bj to 10.75.*")
+	assert.True(t, rule.match(newRouteTestRequest()))
+	assert.True(t, rule.match(newRouteTestRequest("region", "sh")))
+	assert.False(t, rule.match(newRouteTestRequest("region", "bj")))
+	rule = parseRequestRouteRule("version >= 10 to tag:new")
+	assert.True(t, rule.match(newRouteTestRequest("version", "10")))
+	assert.False(t, rule.match(newRouteTestRequest("version", "9")))
+
+	for _, r := range []string{"x-canary == true", "uid % 0 < 5 to tag:canary", "uid % 100 < abc to tag:canary",
+		"region > bj to tag:canary", "x-canary to tag:canary", "x-canary == true to "} {
+		assert.Nil(t, parseRequestRouteRule(r), r)
+	}
+}
+
+func TestRequestRouteGroups(t *testing.T) {
+	assert.Equal(t, []string{"canary", "beta"}, requestRouteGroups([]string{"uid % 100 < 5 to group:canary",
+		"x-canary == true to group:canary", "* to tag:canary", "x-beta == true to group:beta", "illegal", "* to group:"}))
+	assert.Empty(t, requestRouteGroups(nil))
+}
+
+type routeTestEndPoint struct {
+	*mirrorTestEndPoint
+}
+
+func (e *routeTestEndPoint) IsAvailable() bool {
+	return e.URL.GetParam("unavailable", "") != "true"
+}
+
+// newRouteTestCluster returns a cluster of the primary group, the canary group is only subscribed by the request
+// route command
+func newRouteTestCluster() (*MotanCluster, *CommandRegistryWrapper) {
+	ext := getCustomExt()
+	ext.RegistExtEndpoint("route", func(url *motan.URL) motan.EndPoint {
+		return &routeTestEndPoint{mirrorTestEndPoint: &mirrorTestEndPoint{TestEndPoint: &motan.TestEndPoint{URL: url}}}
+	})
+	url := &motan.URL{Protocol: "route", Path: "test.service", Group: "primary", Parameters: map[string]string{
+		motan.Hakey: "failover",
+		motan.Lbkey: "random",
+	}}
+	cluster := NewCluster(&motan.Context{}, ext, url, false)
+	crw := GetCommandRegistryWrapper(cluster, ext.GetRegistry(RegistryURL)).(*CommandRegistryWrapper)
+	crw.notifyListener = cluster
+	crw.Notify(RegistryURL, []*motan.URL{
+		{Protocol: "route", Path: "test.service", Host: "10.75.1.1", Port: 8001, Group: "primary",
+			Parameters: map[string]string{"value": "primary"}},
+		{Protocol: "route", Path: "test.service", Host: "10.75.1.2", Port: 8001, Group: "primary",
+			Parameters: map[string]string{"value": "tag", RouteTagsKey: "beta, canary"}},
+	})
+	return cluster, crw
+}
+
+func canaryRouteURLs() []*motan.URL {
+	return []*motan.URL{{Protocol: "route", Path: "test.service", Host: "10.73.1.1", Port: 8001, Group: "canary",
+		Parameters: map[string]string{"value": "group"}}}
+}
+
+func TestRequestRouteCommand(t *testing.T) {
+	cluster, crw := newRouteTestCluster()
+	cmds := []string{buildCmd(1, CMDRequestRoute, "test.service", "",
+		"\"x-canary == true to tag:canary\", \"uid % 100 < 5 to group:canary\", \"x-debug = 1 to tag:none\", \"illegal\"")}
+	crw.processCommand(ServiceCmd, buildCmdList(cmds))
+	assert.NotNil(t, crw.requestRouteCommand)
+	// the target group is subscribed by the command registry wrapper
+	assert.NotNil(t, crw.otherGroupListener["canary"])
+	assert.Equal(t, 0, cluster.GetRequestRoutes()[1].Endpoints)
+	crw.otherGroupListener["canary"].Notify(RegistryURL, canaryRouteURLs())
+	assert.Len(t, cluster.GetRefers(), 2)
+	assert.Equal(t, []RequestRouteStatus{
+		{Rule: "x-canary == true to tag:canary", Target: "tag:canary", Endpoints: 1},
+		{Rule: "uid % 100 < 5 to group:canary", Target: "group:canary", Endpoints: 1},
+		{Rule: "x-debug = 1 to tag:none", Target: "tag:none", Endpoints: 0},
+	}, cluster.GetRequestRoutes())
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, []byte("tag"), cluster.Call(newRouteTestRequest("x-canary", "true")).GetValue())
+		assert.Equal(t, []byte("group"), cluster.Call(newRouteTestRequest("uid", "1203")).GetValue())
+	}
+	// the requests matched the target without endpoint are balanced in all endpoints
+	values := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		values[string(cluster.Call(newRouteTestRequest("x-debug", "1", "uid", "1299")).GetValue().([]byte))] = true
+	}
+	// the requests which are not routed are never balanced to the target group
+	assert.Len(t, values, 2)
+	assert.False(t, values["group"])
+	// the requests matched the target without available endpoint are balanced in all endpoints too
+	cluster.routeRefers[0].GetURL().PutParam("unavailable", "true")
+	values = make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		values[string(cluster.Call(newRouteTestRequest("uid", "1203")).GetValue().([]byte))] = true
+	}
+	assert.Len(t, values, 2)
+	assert.False(t, values["group"])
+	cluster.routeRefers[0].GetURL().PutParam("unavailable", "false")
+	assert.Equal(t, []byte("group"), cluster.Call(newRouteTestRequest("uid", "1203")).GetValue())
+
+	// the targets are refreshed with the endpoints
+	crw.Notify(RegistryURL, []*motan.URL{
+		{Protocol: "route", Path: "test.service", Host: "10.75.1.1", Port: 8001, Group: "primary",
+			Parameters: map[string]string{"value": "primary"}},
+	})
+	assert.Equal(t, 0, cluster.GetRequestRoutes()[0].Endpoints)
+	assert.Equal(t, []byte("group"), cluster.Call(newRouteTestRequest("uid", "1203")).GetValue())
+	crw.otherGroupListener["canary"].Notify(RegistryURL, []*motan.URL{})
+	assert.Equal(t, 0, cluster.GetRequestRoutes()[1].Endpoints)
+	crw.otherGroupListener["canary"].Notify(RegistryURL, canaryRouteURLs())
+	assert.Equal(t, 1, cluster.GetRequestRoutes()[1].Endpoints)
+
+	// the agent command takes precedence, the rules are removed without request route command
+	cmds = []string{buildCmd(1, CMDRequestRoute, "*", "", "\"* to 10.75.*\"")}
+	crw.processCommand(AgentCmd, buildCmdList(cmds))
+	assert.Equal(t, []RequestRouteStatus{{Rule: "* to 10.75.*", Target: "10.75.*", Endpoints: 1}}, cluster.GetRequestRoutes())
+	assert.Equal(t, []byte("primary"), cluster.Call(newRouteTestRequest("uid", "1203")).GetValue())
+	// the target group is unsubscribed with the rules
+	assert.Empty(t, crw.otherGroupListener)
+	assert.Empty(t, cluster.routeRefers)
+	crw.processCommand(AgentCmd, "")
+	crw.processCommand(ServiceCmd, buildCmdList([]string{buildCmd(1, CMDDegrade, "other.service", "", "")}))
+	assert.Nil(t, crw.requestRouteCommand)
+	assert.Empty(t, cluster.GetRequestRoutes())
+}
@@ -44,6 +44,7 @@ const (
 	ProxyKey                = "proxy"
 	AddressKey              = "address"
 	GzipSizeKey             = "mingzSize"
+	CompressKey             = "compress"
 	HostKey                 = "host"
 	RemoteIPKey             = "remoteIP"
 	ProxyRegistryKey        = "proxyRegistry"
@@ -51,12 +52,25 @@ const (
 	ConnectTimeoutKey       = "connectTimeout"
 	ConnectRetryIntervalKey = "connectRetryInterval"
 	ClientConnectionKey     = "clientConnection"
+	MaxClientConnectionKey  = "maxClientConnection"
+	MaxChannelPendingKey    = "maxChannelPending"
+	ChannelIdleTimeoutKey   = "channelIdleTimeout"
 	ErrorCountThresholdKey  = "errorCountThreshold"
 	KeepaliveIntervalKey    = "keepaliveInterval"
 	UnixSockKey             = "unixSock"
 	ManagementUnixSockKey   = "managementUnixSock"
 	ManagementPortRangeKey  = "managementPortRange"
 	HTTPProxyUnixSockKey    = "httpProxyUnixSock"
+	TLSKey                  = "tls"
+	TLSCertFileKey          = "tlsCert"
+	TLSKeyFileKey           = "tlsKey"
+	TLSCAFileKey            = "tlsCA"
+	TLSVerifyKey            = "tlsVerify"
+	TLSServerNameKey        = "tlsServerName"
+	TLSReloadIntervalKey    = "tlsReloadInterval"
+	CancelNotifyKey         = "cancelNotify"
+	StreamWindowKey         = "streamWindow"
+	MessagePoolKey          = "messagePool"
 )
 
 // nodeType
@@ -85,6 +99,16 @@ const (
 // meta keys
 const (
 	MetaUpstreamCode = "upstreamCode"
+	// MetaUpstreamAddress is the address of the endpoint which the request is sent to
+	MetaUpstreamAddress = "upstreamAddress"
+	// MetaErrorCause is the cause of an exception response in process, it's never sent to the peers
+	MetaErrorCause = "errorCause"
+)
+
+// error causes
+const (
+	ErrorCauseTimeout   = "timeout"
+	ErrorCauseNoCluster = "noCluster"
 )
 
 // errorCodes
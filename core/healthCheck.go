@@ -0,0 +1,274 @@
+package core
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weibocom/motan-go/log"
+)
+
+const (
+	// HealthCheckKey enables the active health check with a probe type: heartbeat, method or http
+	HealthCheckKey = "healthCheck"
+	// HealthCheckIntervalKey is the interval(ms) between the probes
+	HealthCheckIntervalKey = "healthCheckInterval"
+	// HealthCheckTimeoutKey is the timeout(ms) of a probe
+	HealthCheckTimeoutKey = "healthCheckTimeout"
+	// HealthCheckJitterKey is the max random time(ms) added to every interval, so the probes of the nodes are spread
+	HealthCheckJitterKey = "healthCheckJitter"
+	// HealthyThresholdKey is the consecutive successful probes to mark an unhealthy node healthy
+	HealthyThresholdKey = "healthyThreshold"
+	// UnhealthyThresholdKey is the consecutive failed probes to mark a healthy node unhealthy
+	UnhealthyThresholdKey = "unhealthyThreshold"
+	// HealthCheckMethodKey is the method called by the 'method' probes
+	HealthCheckMethodKey = "healthCheckMethod"
+	// HealthCheckPathKey is the path requested by the 'http' probes
+	HealthCheckPathKey = "healthCheckPath"
+	// HealthCheckStatusKey is the expected status codes of the 'http' probes, e.g. '200,204', '200-399', '2xx'
+	HealthCheckStatusKey = "healthCheckStatus"
+
+	HealthCheckHeartbeat = "heartbeat"
+	HealthCheckMethod    = "method"
+	HealthCheckHTTP      = "http"
+
+	DefaultHealthCheckInterval    = 5 * time.Second
+	DefaultHealthCheckTimeout     = time.Second
+	DefaultHealthyThreshold       = 2
+	DefaultUnhealthyThreshold     = 3
+	DefaultHealthCheckPath        = "/"
+	DefaultHealthCheckStatus      = "200-399"
+	defaultHealthCheckJitterRatio = 10
+)
+
+// HealthCheckable is implemented by the endpoints and the providers which check the health actively
+type HealthCheckable interface {
+	GetHealthStatus() *HealthStatus
+}
+
+// HealthCheckConfig is the active health check config of a node
+type HealthCheckConfig struct {
+	Type               string
+	Interval           time.Duration
+	Timeout            time.Duration
+	Jitter             time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+	Method             string
+	Path               string
+	statusRanges       [][2]int
+}
+
+// NewHealthCheckConfig returns the health check config of the url, nil means the health check is disabled
+func NewHealthCheckConfig(url *URL, defaultType string) *HealthCheckConfig {
+	checkType := url.GetParam(HealthCheckKey, "")
+	if enable, err := strconv.ParseBool(checkType); err == nil {
+		if !enable {
+			return nil
+		}
+		checkType = defaultType
+	}
+	if checkType == "" {
+		return nil
+	}
+	c := &HealthCheckConfig{
+		Type:               checkType,
+		Interval:           url.GetTimeDuration(HealthCheckIntervalKey, time.Millisecond, DefaultHealthCheckInterval),
+		Timeout:            url.GetTimeDuration(HealthCheckTimeoutKey, time.Millisecond, DefaultHealthCheckTimeout),
+		HealthyThreshold:   int(url.GetPositiveIntValue(HealthyThresholdKey, DefaultHealthyThreshold)),
+		UnhealthyThreshold: int(url.GetPositiveIntValue(UnhealthyThresholdKey, DefaultUnhealthyThreshold)),
+		Method:             url.GetParam(HealthCheckMethodKey, ""),
+		Path:               url.GetParam(HealthCheckPathKey, DefaultHealthCheckPath),
+	}
+	if c.Interval <= 0 {
+		c.Interval = DefaultHealthCheckInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultHealthCheckTimeout
+	}
+	c.Jitter = url.GetTimeDuration(HealthCheckJitterKey, time.Millisecond, c.Interval/defaultHealthCheckJitterRatio)
+	if c.Jitter < 0 {
+		c.Jitter = 0
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		c.Path = "/" + c.Path
+	}
+	c.statusRanges = parseStatusRanges(url.GetParam(HealthCheckStatusKey, DefaultHealthCheckStatus))
+	if len(c.statusRanges) == 0 {
+		c.statusRanges = parseStatusRanges(DefaultHealthCheckStatus)
+	}
+	return c
+}
+
+func parseStatusRanges(rules string) [][2]int {
+	ranges := make([][2]int, 0, 4)
+	for _, rule := range TrimSplit(rules, ",") {
+		rule = strings.ToLower(rule)
+		if len(rule) == 3 && strings.HasSuffix(rule, "xx") {
+			if n, err := strconv.Atoi(rule[:1]); err == nil {
+				ranges = append(ranges, [2]int{n * 100, n*100 + 99})
+				continue
+			}
+		}
+		bounds := TrimSplit(rule, "-")
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil || len(bounds) > 2 {
+			vlog.Warningf("Illegal health check status rule '%s' is ignored", rule)
+			continue
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = strconv.Atoi(bounds[1]); err != nil {
+				vlog.Warningf("Illegal health check status rule '%s' is ignored", rule)
+				continue
+			}
+		}
+		ranges = append(ranges, [2]int{from, to})
+	}
+	return ranges
+}
+
+// IsExpectedStatus checks whether the status code of an http probe is healthy
+func (c *HealthCheckConfig) IsExpectedStatus(code int) bool {
+	for _, r := range c.statusRanges {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckStatus returns an error if the status code of an http probe is not expected
+func (c *HealthCheckConfig) CheckStatus(code int) error {
+	if c.IsExpectedStatus(code) {
+		return nil
+	}
+	return errors.New("unexpected status code " + strconv.Itoa(code))
+}
+
+// HealthStatus is the active health check status of a node
+type HealthStatus struct {
+	Type      string `json:"type"`
+	Healthy   bool   `json:"healthy"`
+	Checks    int64  `json:"checks"`
+	Failures  int64  `json:"failures"`
+	LastCheck string `json:"lastCheck,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// HealthProbe probes a node once, the node is unhealthy if it returns an error
+type HealthProbe func(timeout time.Duration) error
+
+// HealthChecker probes a node periodically, the node is marked unhealthy after the consecutive failures reached the
+// unhealthy threshold, and marked healthy after the consecutive successes reached the healthy threshold.
+// The node is healthy before the first probe
+type HealthChecker struct {
+	name     string
+	config   *HealthCheckConfig
+	probe    HealthProbe
+	onChange func(healthy bool)
+
+	lock      sync.Mutex
+	status    HealthStatus
+	successes int
+	failures  int
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+func NewHealthChecker(name string, config *HealthCheckConfig, probe HealthProbe, onChange func(healthy bool)) *HealthChecker {
+	return &HealthChecker{
+		name:     name,
+		config:   config,
+		probe:    probe,
+		onChange: onChange,
+		status:   HealthStatus{Type: config.Type, Healthy: true},
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start probes the node in a goroutine until the checker is stopped
+func (h *HealthChecker) Start() {
+	go func() {
+		defer HandlePanic(nil)
+		timer := time.NewTimer(h.nextInterval())
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				h.Check()
+				timer.Reset(h.nextInterval())
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (h *HealthChecker) nextInterval() time.Duration {
+	if h.config.Jitter <= 0 {
+		return h.config.Interval
+	}
+	return h.config.Interval + time.Duration(rand.Int63n(int64(h.config.Jitter)+1))
+}
+
+func (h *HealthChecker) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+	})
+}
+
+// Check probes the node once and updates the health status
+func (h *HealthChecker) Check() {
+	err := h.probe(h.config.Timeout)
+	h.lock.Lock()
+	h.status.Checks++
+	h.status.LastCheck = time.Now().Format(time.RFC3339)
+	changed := false
+	if err != nil {
+		h.status.Failures++
+		h.status.LastError = err.Error()
+		h.successes = 0
+		h.failures++
+		if h.status.Healthy && h.failures >= h.config.UnhealthyThreshold {
+			h.status.Healthy = false
+			changed = true
+		}
+	} else {
+		h.status.LastError = ""
+		h.failures = 0
+		h.successes++
+		if !h.status.Healthy && h.successes >= h.config.HealthyThreshold {
+			h.status.Healthy = true
+			changed = true
+		}
+	}
+	healthy := h.status.Healthy
+	h.lock.Unlock()
+	if changed {
+		if healthy {
+			vlog.Infof("[healthCheck] %s is healthy", h.name)
+		} else {
+			vlog.Warningf("[healthCheck] %s is unhealthy, err: %v", h.name, err)
+		}
+		if h.onChange != nil {
+			h.onChange(healthy)
+		}
+	}
+}
+
+func (h *HealthChecker) IsHealthy() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.status.Healthy
+}
+
+func (h *HealthChecker) GetHealthStatus() *HealthStatus {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	status := h.status
+	return &status
+}
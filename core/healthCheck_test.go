@@ -0,0 +1,98 @@
+package core
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHealthCheckConfig(t *testing.T) {
+	url := &URL{Parameters: map[string]string{}}
+	assert.Nil(t, NewHealthCheckConfig(url, HealthCheckHeartbeat))
+	url.PutParam(HealthCheckKey, "false")
+	assert.Nil(t, NewHealthCheckConfig(url, HealthCheckHeartbeat))
+
+	url.PutParam(HealthCheckKey, "true")
+	config := NewHealthCheckConfig(url, HealthCheckHeartbeat)
+	assert.Equal(t, HealthCheckHeartbeat, config.Type)
+	assert.Equal(t, DefaultHealthCheckInterval, config.Interval)
+	assert.Equal(t, DefaultHealthCheckTimeout, config.Timeout)
+	assert.Equal(t, DefaultHealthCheckInterval/10, config.Jitter)
+	assert.Equal(t, DefaultHealthyThreshold, config.HealthyThreshold)
+	assert.Equal(t, DefaultUnhealthyThreshold, config.UnhealthyThreshold)
+	assert.Equal(t, DefaultHealthCheckPath, config.Path)
+	assert.True(t, config.IsExpectedStatus(302))
+	assert.False(t, config.IsExpectedStatus(404))
+
+	url.PutParam(HealthCheckKey, HealthCheckHTTP)
+	url.PutParam(HealthCheckIntervalKey, "100")
+	url.PutParam(HealthCheckJitterKey, "0")
+	url.PutParam(HealthCheckPathKey, "health")
+	url.PutParam(HealthCheckStatusKey, "200, 3xx, 400-404, abc")
+	config = NewHealthCheckConfig(url, HealthCheckHeartbeat)
+	assert.Equal(t, HealthCheckHTTP, config.Type)
+	assert.Equal(t, 100*time.Millisecond, config.Interval)
+	assert.Equal(t, time.Duration(0), config.Jitter)
+	assert.Equal(t, "/health", config.Path)
+	for _, code := range []int{200, 301, 399, 400, 404} {
+		assert.Nil(t, config.CheckStatus(code), code)
+	}
+	for _, code := range []int{201, 405, 500} {
+		assert.NotNil(t, config.CheckStatus(code), code)
+	}
+}
+
+func TestHealthChecker(t *testing.T) {
+	url := &URL{Parameters: map[string]string{HealthCheckKey: "true", HealthyThresholdKey: "2", UnhealthyThresholdKey: "3"}}
+	var fail int32
+	var changes []bool
+	checker := NewHealthChecker("test", NewHealthCheckConfig(url, HealthCheckHeartbeat), func(timeout time.Duration) error {
+		if atomic.LoadInt32(&fail) == 1 {
+			return errors.New("probe failed")
+		}
+		return nil
+	}, func(healthy bool) {
+		changes = append(changes, healthy)
+	})
+	assert.True(t, checker.IsHealthy())
+
+	atomic.StoreInt32(&fail, 1)
+	checker.Check()
+	checker.Check()
+	assert.True(t, checker.IsHealthy())
+	checker.Check()
+	assert.False(t, checker.IsHealthy())
+	status := checker.GetHealthStatus()
+	assert.Equal(t, int64(3), status.Checks)
+	assert.Equal(t, int64(3), status.Failures)
+	assert.Equal(t, "probe failed", status.LastError)
+
+	atomic.StoreInt32(&fail, 0)
+	checker.Check()
+	assert.False(t, checker.IsHealthy())
+	checker.Check()
+	assert.True(t, checker.IsHealthy())
+	assert.Equal(t, "", checker.GetHealthStatus().LastError)
+	assert.Equal(t, []bool{false, true}, changes)
+}
+
+func TestHealthCheckerStart(t *testing.T) {
+	url := &URL{Parameters: map[string]string{HealthCheckKey: "true", HealthCheckIntervalKey: "10", UnhealthyThresholdKey: "1"}}
+	var probes int32
+	checker := NewHealthChecker("test", NewHealthCheckConfig(url, HealthCheckHeartbeat), func(timeout time.Duration) error {
+		atomic.AddInt32(&probes, 1)
+		return errors.New("probe failed")
+	}, nil)
+	checker.Start()
+	time.Sleep(100 * time.Millisecond)
+	checker.Stop()
+	checker.Stop()
+	assert.False(t, checker.IsHealthy())
+	n := atomic.LoadInt32(&probes)
+	assert.True(t, n > 1)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&probes))
+}
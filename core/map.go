@@ -85,6 +85,33 @@ func (m *StringMap) Len() int {
 	return len(m.innerMap)
 }
 
+// Reset removes all entries and keeps the allocated space of the map
+func (m *StringMap) Reset() {
+	m.mu.Lock()
+	for k := range m.innerMap {
+		delete(m.innerMap, k)
+	}
+	m.mu.Unlock()
+}
+
+var stringMapPool = sync.Pool{New: func() interface{} {
+	return NewStringMap(DefaultAttachmentSize)
+}}
+
+// AcquireStringMap gets an empty StringMap from the pool, it should be put back by ReleaseStringMap
+func AcquireStringMap() *StringMap {
+	return stringMapPool.Get().(*StringMap)
+}
+
+// ReleaseStringMap resets the map and puts it back to the pool, the map must not be used after released
+func ReleaseStringMap(m *StringMap) {
+	if m == nil {
+		return
+	}
+	m.Reset()
+	stringMapPool.Put(m)
+}
+
 type CopyOnWriteMap struct {
 	mu       sync.Mutex
 	innerMap atomic.Value
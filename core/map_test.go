@@ -66,6 +66,22 @@ func TestStringMap_Load(t *testing.T) {
 	wg.Wait()
 }
 
+func TestStringMapPool(t *testing.T) {
+	stringMap := AcquireStringMap()
+	assert.Equal(t, 0, stringMap.Len())
+	stringMap.Store("key1", "value1")
+	stringMap.Store("key2", "value2")
+	stringMap.Reset()
+	assert.Equal(t, 0, stringMap.Len())
+	assert.Equal(t, "", stringMap.LoadOrEmpty("key1"))
+	stringMap.Store("key3", "value3")
+	ReleaseStringMap(stringMap)
+	ReleaseStringMap(nil)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, 0, AcquireStringMap().Len())
+	}
+}
+
 func BenchmarkStringMap(b *testing.B) {
 	stringMap := NewStringMap(0)
 	for i := 0; i < b.N; i++ {
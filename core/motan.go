@@ -37,6 +37,8 @@ const (
 var (
 	registryGroupInfoMaxCacheTime        = time.Hour
 	registryGroupServiceInfoMaxCacheTime = time.Hour
+
+	ErrStreamNotSupported = errors.New("stream call is not supported")
 )
 
 //-----------interface-------------
@@ -274,6 +276,65 @@ type MessageHandler interface {
 	GetProvider(serviceName string) Provider
 }
 
+// ClientStream : the client side of a streaming call
+type ClientStream interface {
+	// Send sends a message to the server, it blocks until the server grants the flow control window
+	Send(v interface{}) error
+	// CloseSend tells the server no more message will be sent
+	CloseSend() error
+	// Recv receives a message from the server into reply, io.EOF is returned after the server ends the stream normally
+	Recv(reply interface{}) error
+	// Cancel stops the stream and notifies the server
+	Cancel()
+}
+
+// ServerStream : the server side of a streaming call
+type ServerStream interface {
+	Send(v interface{}) error
+	// Recv receives a message from the client into v, io.EOF is returned after the client closes sending
+	Recv(v interface{}) error
+	// Done is closed when the client cancels the stream
+	Done() <-chan struct{}
+}
+
+// StreamCaller : a caller supports streaming calls
+type StreamCaller interface {
+	NewStream(request Request) (ClientStream, error)
+}
+
+// StreamProvider : a provider serves streaming calls, the stream ends when CallStream returns and the error is sent to the client
+type StreamProvider interface {
+	Provider
+	CallStream(request Request, stream ServerStream) error
+}
+
+// StreamFilter : an EndPointFilter checks the streaming calls when the streams are opened, because the messages of a
+// stream do not go through the Filter method. The filters which reject or sign the requests(e.g. auth and the
+// limiters) implement it, the stream is rejected if an exception is returned
+type StreamFilter interface {
+	FilterStream(caller Caller, request Request) *Exception
+}
+
+// FilterStream runs the stream checks of the filter chain in order, the first exception is returned
+func FilterStream(filter EndPointFilter, caller Caller, request Request) *Exception {
+	for f := filter; f != nil; f = f.GetNext() {
+		if sf, ok := f.(StreamFilter); ok {
+			if e := sf.FilterStream(caller, request); e != nil {
+				return e
+			}
+		}
+		if !f.HasNext() {
+			break
+		}
+	}
+	return nil
+}
+
+// StreamMessageHandler : a MessageHandler dispatches streaming calls to StreamProviders
+type StreamMessageHandler interface {
+	CallStream(request Request, stream ServerStream) error
+}
+
 // Serialization : Serialization
 type Serialization interface {
 	GetSerialNum() int
@@ -352,6 +413,7 @@ type RPCContext struct {
 	Oneway          bool
 	Proxy           bool
 	GzipSize        int
+	CompressCodec   string
 	BodySize        int
 	SerializeNum    int
 	Serialized      bool
@@ -372,12 +434,32 @@ type RPCContext struct {
 
 	// trace context
 	Tc *TraceContext
+
+	// PeerIdentity is the identity of the verified peer certificate when the connection uses mTLS
+	PeerIdentity string
+
+	// CancelCh is closed when the response is no longer needed, such as the losing attempt of hedged requests.
+	// the endpoint stops waiting for the response and notifies the server to skip the request
+	CancelCh chan struct{}
 }
 
 func (c *RPCContext) AddFinishHandler(handler FinishHandler) {
 	c.FinishHandlers = append(c.FinishHandlers, handler)
 }
 
+// IsCanceled check whether the request is canceled by the caller
+func (c *RPCContext) IsCanceled() bool {
+	if c.CancelCh == nil {
+		return false
+	}
+	select {
+	case <-c.CancelCh:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *RPCContext) OnFinish() {
 	for _, h := range c.FinishHandlers {
 		h.Handle()
@@ -490,6 +572,8 @@ func (m *MotanRequest) GetRPCContext(canCreate bool) *RPCContext {
 	return m.RPCContext
 }
 
+// Clone copies the request, the bodies of the not deserialized arguments are copied too, because they may be sliced
+// from a pooled buffer which is released after the original request is finished
 func (m *MotanRequest) Clone() interface{} {
 	newRequest := &MotanRequest{
 		RequestID:   m.RequestID,
@@ -498,6 +582,16 @@ func (m *MotanRequest) Clone() interface{} {
 		MethodDesc:  m.MethodDesc,
 		Arguments:   m.Arguments,
 	}
+	copied := false
+	for i, arg := range m.Arguments {
+		if dv, ok := arg.(*DeserializableValue); ok {
+			if !copied {
+				newRequest.Arguments = append([]interface{}(nil), m.Arguments...)
+				copied = true
+			}
+			newRequest.Arguments[i] = &DeserializableValue{Serialization: dv.Serialization, Body: append([]byte(nil), dv.Body...)}
+		}
+	}
 	if m.Attachment != nil {
 		newRequest.Attachment = m.Attachment.Copy()
 	}
@@ -507,6 +601,7 @@ func (m *MotanRequest) Clone() interface{} {
 			Oneway:              m.RPCContext.Oneway,
 			Proxy:               m.RPCContext.Proxy,
 			GzipSize:            m.RPCContext.GzipSize,
+			CompressCodec:       m.RPCContext.CompressCodec,
 			SerializeNum:        m.RPCContext.SerializeNum,
 			Serialized:          m.RPCContext.Serialized,
 			AsyncCall:           m.RPCContext.AsyncCall,
@@ -519,6 +614,8 @@ func (m *MotanRequest) Clone() interface{} {
 			ResponseReceiveTime: m.RPCContext.ResponseReceiveTime,
 			FinishHandlers:      m.RPCContext.FinishHandlers,
 			Tc:                  m.RPCContext.Tc,
+			PeerIdentity:        m.RPCContext.PeerIdentity,
+			CancelCh:            m.RPCContext.CancelCh,
 		}
 		if m.RPCContext.OriginalMessage != nil {
 			if oldMessage, ok := m.RPCContext.OriginalMessage.(Cloneable); ok {
@@ -630,6 +727,24 @@ func BuildExceptionResponse(requestid uint64, e *Exception) *MotanResponse {
 	return &MotanResponse{RequestID: requestid, Exception: e}
 }
 
+// SetErrorCause records the cause of the exception response, such as the timeout, the error code sent to the peers is
+// not changed
+func SetErrorCause(response Response, cause string) {
+	ctx := response.GetRPCContext(true)
+	if ctx.Meta == nil {
+		ctx.Meta = NewStringMap(DefaultRPCContextMetaSize)
+	}
+	ctx.Meta.Store(MetaErrorCause, cause)
+}
+
+// GetErrorCause returns the cause recorded by SetErrorCause
+func GetErrorCause(response Response) string {
+	if ctx := response.GetRPCContext(false); ctx != nil && ctx.Meta != nil {
+		return ctx.Meta.LoadOrEmpty(MetaErrorCause)
+	}
+	return ""
+}
+
 // extensions factory-func
 
 type DefaultFilterFunc func() Filter
@@ -843,7 +958,14 @@ func (l *lastEndPointFilter) Filter(caller Caller, request Request) Response {
 	if request.GetRPCContext(true).Tc != nil {
 		request.GetRPCContext(true).Tc.PutReqSpan(&Span{Name: EpFilterEnd, Addr: caller.GetURL().GetAddressStr(), Time: time.Now()})
 	}
-	return caller.Call(request)
+	response := caller.Call(request)
+	// the address is recorded only in the existing context, the responses of the most calls are not allocated again
+	if url := caller.GetURL(); response != nil && url != nil {
+		if resCtx := response.GetRPCContext(false); resCtx != nil && resCtx.Meta != nil {
+			resCtx.Meta.Store(MetaUpstreamAddress, url.GetAddressStr())
+		}
+	}
+	return response
 }
 
 func (l *lastEndPointFilter) HasNext() bool {
@@ -914,6 +1036,19 @@ func (f *FilterEndPoint) Call(request Request) Response {
 	}
 	return f.Filter.Filter(f.Caller, request)
 }
+
+// NewStream opens a stream from the underlying caller after the stream checks of the filters
+func (f *FilterEndPoint) NewStream(request Request) (ClientStream, error) {
+	sc, ok := f.Caller.(StreamCaller)
+	if !ok {
+		return nil, ErrStreamNotSupported
+	}
+	if e := FilterStream(f.Filter, f.Caller, request); e != nil {
+		return nil, errors.New(e.ErrMsg)
+	}
+	return sc.NewStream(request)
+}
+
 func (f *FilterEndPoint) GetURL() *URL {
 	return f.URL
 }
@@ -27,6 +27,17 @@ func TestExtFactory(t *testing.T) {
 	ext.RegistryExtSerialization("test", 0, newSerial)
 }
 
+func TestMotanRequest_CloneArguments(t *testing.T) {
+	body := []byte("body")
+	request := &MotanRequest{Arguments: []interface{}{"arg", &DeserializableValue{Body: body}}}
+	clone := request.Clone().(*MotanRequest)
+	// the body of the not deserialized argument is copied
+	body[0] = 'x'
+	assert.Equal(t, "arg", clone.Arguments[0])
+	assert.Equal(t, []byte("body"), clone.Arguments[1].(*DeserializableValue).Body)
+	assert.Equal(t, []byte("xody"), request.Arguments[1].(*DeserializableValue).Body)
+}
+
 func TestRegistLocalProvider(t *testing.T) {
 	service := "testService"
 	tp := &TestProvider{}
@@ -190,3 +201,59 @@ func newDiscoverErrorRegistry() *TestRegistry {
 	registry.DiscoverError = true
 	return registry
 }
+
+type contextTestEndPoint struct {
+	TestEndPoint
+}
+
+func (c *contextTestEndPoint) Call(request Request) Response {
+	response := &MotanResponse{RequestID: request.GetRequestID()}
+	response.GetRPCContext(true)
+	return response
+}
+
+func TestLastEndPointFilter(t *testing.T) {
+	ep := &contextTestEndPoint{TestEndPoint{URL: &URL{Host: "127.0.0.1", Port: 8002}}}
+	response := GetLastEndPointFilter().Filter(ep, &MotanRequest{ServiceName: "test", Method: "hello"})
+	// the address of the endpoint is recorded in the meta of the response
+	assert.Equal(t, "127.0.0.1:8002", response.GetRPCContext(false).Meta.LoadOrEmpty(MetaUpstreamAddress))
+	// the context is not created for the address
+	response = GetLastEndPointFilter().Filter(&TestEndPoint{URL: &URL{Host: "127.0.0.1", Port: 8002}}, &MotanRequest{ServiceName: "test", Method: "hello"})
+	assert.Nil(t, response.GetRPCContext(false))
+	// the caller without url is called as usual
+	response = GetLastEndPointFilter().Filter(&contextTestEndPoint{}, &MotanRequest{ServiceName: "test", Method: "hello"})
+	assert.Equal(t, "", response.GetRPCContext(true).Meta.LoadOrEmpty(MetaUpstreamAddress))
+}
+
+type rejectStreamFilter struct {
+	TestEndPointFilter
+	checked bool
+	reject  bool
+}
+
+func (r *rejectStreamFilter) FilterStream(caller Caller, request Request) *Exception {
+	r.checked = true
+	if r.reject {
+		return &Exception{ErrCode: 403, ErrMsg: "stream rejected", ErrType: ServiceException}
+	}
+	return nil
+}
+
+func TestFilterStream(t *testing.T) {
+	first := &rejectStreamFilter{}
+	second := &rejectStreamFilter{}
+	first.SetNext(&TestEndPointFilter{})
+	first.GetNext().SetNext(second)
+	second.SetNext(GetLastEndPointFilter())
+	request := &MotanRequest{ServiceName: "test", Method: "hello"}
+	assert.Nil(t, FilterStream(first, &TestEndPoint{}, request))
+	assert.True(t, first.checked)
+	assert.True(t, second.checked)
+
+	// the stream is rejected by the first exception
+	first.reject = true
+	second.checked = false
+	e := FilterStream(first, &TestEndPoint{}, request)
+	assert.Equal(t, "stream rejected", e.ErrMsg)
+	assert.False(t, second.checked)
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/weibocom/motan-go/log"
+)
+
+// tls verify mode, the value of url parameter TLSVerifyKey
+const (
+	TLSVerifyNone    = "none"    // server: do not request client certificate; client: skip server verification
+	TLSVerifyRequest = "request" // server only: request client certificate but not require it
+	TLSVerifyRequire = "require" // server only: require client certificate but not verify it
+	TLSVerifyVerify  = "verify"  // server: require and verify client certificate(mTLS); client: verify server certificate
+)
+
+var (
+	defaultTLSReloadInterval = 60 * time.Second
+
+	tlsLoaders     = make(map[string]*TLSConfigLoader)
+	tlsLoadersLock sync.Mutex
+
+	ErrTLSNoCertificate = errors.New("tls certificate or key file not configured")
+	ErrTLSInvalidCA     = errors.New("tls ca file contains no valid certificate")
+)
+
+// IsTLSEnabled check whether the url enables tls transport
+func IsTLSEnabled(url *URL) bool {
+	enable, _ := strconv.ParseBool(url.GetParam(TLSKey, "false"))
+	return enable
+}
+
+// TLSConfigLoader loads certificate, private key and ca from disk, the files are checked periodically
+// and reloaded when modified, so the certificates can be renewed without restart.
+// The loader is shared by all urls with the same files.
+type TLSConfigLoader struct {
+	certFile       string
+	keyFile        string
+	caFile         string
+	reloadInterval time.Duration
+
+	lock      sync.RWMutex
+	cert      *tls.Certificate
+	caPool    *x509.CertPool
+	modTimes  [3]time.Time
+	lastCheck int64 // unix nano
+}
+
+// GetTLSConfigLoader get or create the shared loader for the tls files of url
+func GetTLSConfigLoader(url *URL) (*TLSConfigLoader, error) {
+	certFile := url.GetParam(TLSCertFileKey, "")
+	keyFile := url.GetParam(TLSKeyFileKey, "")
+	caFile := url.GetParam(TLSCAFileKey, "")
+	key := certFile + "|" + keyFile + "|" + caFile
+	tlsLoadersLock.Lock()
+	defer tlsLoadersLock.Unlock()
+	if loader, ok := tlsLoaders[key]; ok {
+		return loader, nil
+	}
+	loader := &TLSConfigLoader{
+		certFile:       certFile,
+		keyFile:        keyFile,
+		caFile:         caFile,
+		reloadInterval: url.GetTimeDuration(TLSReloadIntervalKey, time.Second, defaultTLSReloadInterval),
+	}
+	if err := loader.load(); err != nil {
+		return nil, err
+	}
+	tlsLoaders[key] = loader
+	return loader, nil
+}
+
+// GetCertificate returns the current certificate, nil if no certificate configured
+func (l *TLSConfigLoader) GetCertificate() *tls.Certificate {
+	l.checkReload()
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.cert
+}
+
+// GetCAPool returns the current ca pool, nil if no ca configured
+func (l *TLSConfigLoader) GetCAPool() *x509.CertPool {
+	l.checkReload()
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.caPool
+}
+
+func (l *TLSConfigLoader) checkReload() {
+	if l.reloadInterval <= 0 {
+		return
+	}
+	last := atomic.LoadInt64(&l.lastCheck)
+	now := time.Now().UnixNano()
+	if now-last < int64(l.reloadInterval) || !atomic.CompareAndSwapInt64(&l.lastCheck, last, now) {
+		return
+	}
+	modTimes := [3]time.Time{fileModTime(l.certFile), fileModTime(l.keyFile), fileModTime(l.caFile)}
+	l.lock.RLock()
+	changed := modTimes != l.modTimes
+	l.lock.RUnlock()
+	if !changed {
+		return
+	}
+	if err := l.load(); err != nil {
+		vlog.Errorf("[tls] reload certificate fail, the old one will be used. cert:%s, key:%s, ca:%s, err:%v", l.certFile, l.keyFile, l.caFile, err)
+		return
+	}
+	vlog.Infof("[tls] certificate reloaded. cert:%s, key:%s, ca:%s", l.certFile, l.keyFile, l.caFile)
+}
+
+func (l *TLSConfigLoader) load() error {
+	modTimes := [3]time.Time{fileModTime(l.certFile), fileModTime(l.keyFile), fileModTime(l.caFile)}
+	var cert *tls.Certificate
+	if l.certFile != "" || l.keyFile != "" {
+		c, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	var caPool *x509.CertPool
+	if l.caFile != "" {
+		data, err := ioutil.ReadFile(l.caFile)
+		if err != nil {
+			return err
+		}
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(data) {
+			return ErrTLSInvalidCA
+		}
+	}
+	l.lock.Lock()
+	l.cert = cert
+	l.caPool = caPool
+	l.modTimes = modTimes
+	l.lock.Unlock()
+	atomic.StoreInt64(&l.lastCheck, time.Now().UnixNano())
+	return nil
+}
+
+// ServerConfig build a server side tls config, the certificate and client ca are resolved for every handshake
+func (l *TLSConfigLoader) ServerConfig(verify string) (*tls.Config, error) {
+	if l.certFile == "" || l.keyFile == "" {
+		return nil, ErrTLSNoCertificate
+	}
+	clientAuth := tls.NoClientCert
+	switch verify {
+	case TLSVerifyNone:
+	case TLSVerifyRequest:
+		clientAuth = tls.RequestClientCert
+	case TLSVerifyRequire:
+		clientAuth = tls.RequireAnyClientCert
+	case TLSVerifyVerify:
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		// mTLS is enabled by default when the ca is configured
+		if l.caFile != "" {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				Certificates: []tls.Certificate{*l.GetCertificate()},
+				ClientAuth:   clientAuth,
+				ClientCAs:    l.GetCAPool(),
+			}, nil
+		},
+	}, nil
+}
+
+// ClientConfig build a client side tls config with current certificate and ca
+func (l *TLSConfigLoader) ClientConfig(serverName string, verify string) *tls.Config {
+	config := &tls.Config{
+		ServerName:         serverName,
+		RootCAs:            l.GetCAPool(),
+		InsecureSkipVerify: verify == TLSVerifyNone,
+	}
+	if cert := l.GetCertificate(); cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return config
+}
+
+// BuildServerTLSConfig build server side tls config from url parameters
+func BuildServerTLSConfig(url *URL) (*tls.Config, error) {
+	loader, err := GetTLSConfigLoader(url)
+	if err != nil {
+		return nil, err
+	}
+	return loader.ServerConfig(url.GetParam(TLSVerifyKey, ""))
+}
+
+// BuildClientTLSConfig build client side tls config from url parameters.
+// the server name used for verification is url parameter TLSServerNameKey, default is the host of url
+func BuildClientTLSConfig(url *URL) (*tls.Config, error) {
+	loader, err := GetTLSConfigLoader(url)
+	if err != nil {
+		return nil, err
+	}
+	return loader.ClientConfig(url.GetParam(TLSServerNameKey, url.Host), url.GetParam(TLSVerifyKey, "")), nil
+}
+
+// GetPeerIdentity get the identity of peer from the verified certificate.
+// the first URI SAN(e.g. spiffe id) is used, otherwise the first DNS SAN, otherwise the subject common name.
+// the identity is empty if the peer certificate is not verified(e.g. verify mode 'request' or 'require')
+func GetPeerIdentity(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.CommonName
+}
+
+func fileModTime(file string) time.Time {
+	if file == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,234 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "motan-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) writeCA(t *testing.T, file string) {
+	assert.Nil(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0644))
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, cn string, certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+}
+
+func tlsHandshake(t *testing.T, serverConfig *tls.Config, clientConfig *tls.Config) (serverState tls.ConnectionState, clientErr error, serverErr error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	done := make(chan error, 1)
+	var server *tls.Conn
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		server = tls.Server(conn, serverConfig)
+		err = server.Handshake()
+		if err == nil {
+			// read until client closed, make sure the client can finish handshake
+			_, err = server.Read(make([]byte, 1))
+			if err == io.EOF {
+				err = nil
+			}
+		}
+		server.Close()
+		done <- err
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	client := tls.Client(conn, clientConfig)
+	clientErr = client.Handshake()
+	if clientErr == nil {
+		// the server may reject the client certificate after client handshake finished in TLS 1.3
+		client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = client.Read(make([]byte, 1))
+		if err != nil && err != io.EOF {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				clientErr = err
+			}
+		}
+	}
+	client.Close()
+	serverErr = <-done
+	if server != nil {
+		serverState = server.ConnectionState()
+	}
+	return serverState, clientErr, serverErr
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "motan-tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca.writeCA(t, caFile)
+	ca.issue(t, 2, "server", filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	ca.issue(t, 3, "client-app", filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"))
+
+	assert.False(t, IsTLSEnabled(&URL{}))
+	serverURL := &URL{Host: "127.0.0.1", Parameters: map[string]string{
+		TLSKey:         "true",
+		TLSCertFileKey: filepath.Join(dir, "server.pem"),
+		TLSKeyFileKey:  filepath.Join(dir, "server.key"),
+		TLSCAFileKey:   caFile,
+	}}
+	assert.True(t, IsTLSEnabled(serverURL))
+	serverConfig, err := BuildServerTLSConfig(serverURL)
+	assert.Nil(t, err)
+
+	// mTLS
+	clientURL := &URL{Host: "127.0.0.1", Parameters: map[string]string{
+		TLSKey:         "true",
+		TLSCertFileKey: filepath.Join(dir, "client.pem"),
+		TLSKeyFileKey:  filepath.Join(dir, "client.key"),
+		TLSCAFileKey:   caFile,
+	}}
+	clientConfig, err := BuildClientTLSConfig(clientURL)
+	assert.Nil(t, err)
+	state, clientErr, serverErr := tlsHandshake(t, serverConfig, clientConfig)
+	assert.Nil(t, clientErr)
+	assert.Nil(t, serverErr)
+	assert.Equal(t, "client-app", GetPeerIdentity(state))
+
+	// the unverified client certificate has no identity
+	for _, mode := range []string{TLSVerifyRequest, TLSVerifyRequire} {
+		unverifiedServerURL := serverURL.Copy()
+		unverifiedServerURL.PutParam(TLSVerifyKey, mode)
+		unverifiedServerConfig, err := BuildServerTLSConfig(unverifiedServerURL)
+		assert.Nil(t, err)
+		state, clientErr, serverErr = tlsHandshake(t, unverifiedServerConfig, clientConfig)
+		assert.Nil(t, clientErr)
+		assert.Nil(t, serverErr)
+		assert.NotEmpty(t, state.PeerCertificates, mode)
+		assert.Equal(t, "", GetPeerIdentity(state), mode)
+	}
+
+	// client without certificate is rejected when ca is configured on server
+	clientURL = &URL{Host: "127.0.0.1", Parameters: map[string]string{TLSKey: "true", TLSCAFileKey: caFile}}
+	clientConfig, err = BuildClientTLSConfig(clientURL)
+	assert.Nil(t, err)
+	_, _, serverErr = tlsHandshake(t, serverConfig, clientConfig)
+	assert.NotNil(t, serverErr)
+
+	// client verify fail with wrong server name
+	clientURL.PutParam(TLSServerNameKey, "unknown.host")
+	noVerifyServerURL := serverURL.Copy()
+	noVerifyServerURL.PutParam(TLSVerifyKey, TLSVerifyNone)
+	noVerifyServerConfig, err := BuildServerTLSConfig(noVerifyServerURL)
+	assert.Nil(t, err)
+	clientConfig, _ = BuildClientTLSConfig(clientURL)
+	_, clientErr, _ = tlsHandshake(t, noVerifyServerConfig, clientConfig)
+	assert.NotNil(t, clientErr)
+	clientURL.PutParam(TLSVerifyKey, TLSVerifyNone)
+	clientConfig, _ = BuildClientTLSConfig(clientURL)
+	state, clientErr, serverErr = tlsHandshake(t, noVerifyServerConfig, clientConfig)
+	assert.Nil(t, clientErr)
+	assert.Nil(t, serverErr)
+	assert.Equal(t, "", GetPeerIdentity(state))
+
+	// server without certificate
+	_, err = BuildServerTLSConfig(&URL{Parameters: map[string]string{TLSKey: "true", TLSCAFileKey: caFile}})
+	assert.Equal(t, ErrTLSNoCertificate, err)
+	_, err = BuildServerTLSConfig(&URL{Parameters: map[string]string{TLSKey: "true", TLSCertFileKey: filepath.Join(dir, "notExist.pem"), TLSKeyFileKey: filepath.Join(dir, "notExist.key")}})
+	assert.NotNil(t, err)
+}
+
+func TestTLSConfigLoader_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "motan-tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	ca.issue(t, 10, "server-old", certFile, keyFile)
+	url := &URL{Parameters: map[string]string{
+		TLSCertFileKey:       certFile,
+		TLSKeyFileKey:        keyFile,
+		TLSReloadIntervalKey: "1",
+	}}
+	loader, err := GetTLSConfigLoader(url)
+	assert.Nil(t, err)
+	sameLoader, _ := GetTLSConfigLoader(url.Copy())
+	assert.True(t, loader == sameLoader)
+	oldCert := loader.GetCertificate()
+	assert.NotNil(t, oldCert)
+
+	ca.issue(t, 11, "server-new", certFile, keyFile)
+	newTime := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(certFile, newTime, newTime))
+	assert.Nil(t, os.Chtimes(keyFile, newTime, newTime))
+	// not reload before interval
+	assert.True(t, oldCert == loader.GetCertificate())
+	time.Sleep(1100 * time.Millisecond)
+	newCert := loader.GetCertificate()
+	assert.False(t, oldCert == newCert)
+	parsed, err := x509.ParseCertificate(newCert.Certificate[0])
+	assert.Nil(t, err)
+	assert.Equal(t, "server-new", parsed.Subject.CommonName)
+
+	// broken files keep the old certificate
+	assert.Nil(t, ioutil.WriteFile(certFile, []byte("broken"), 0644))
+	newTime = newTime.Add(time.Minute)
+	assert.Nil(t, os.Chtimes(certFile, newTime, newTime))
+	time.Sleep(1100 * time.Millisecond)
+	assert.True(t, newCert == loader.GetCertificate())
+}
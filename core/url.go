@@ -200,7 +200,11 @@ func (u *URL) GetAddressStr() string {
 	if u.address != "" {
 		return u.address
 	}
-	u.address = u.Host + ":" + u.GetPortStr()
+	if strings.HasPrefix(u.Host, UnixSockProtocolFlag) {
+		u.address = u.Host
+	} else {
+		u.address = u.Host + ":" + u.GetPortStr()
+	}
 	return u.address
 }
 
@@ -158,6 +158,21 @@ func TrimSplit(s string, sep string) []string {
 	return a[:i+1]
 }
 
+// UnixSockProtocolFlag is the prefix of unix socket address, such as 'unix:///run/motan/agent.sock'
+const UnixSockProtocolFlag = "unix://"
+
+// GetUnixSockAddress returns the unix socket path to connect, it's from the 'unixSock' parameter or the host with 'unix://' prefix.
+// empty string means the url should be connected by tcp
+func GetUnixSockAddress(url *URL) string {
+	if unixSockAddr := url.GetParam(UnixSockKey, ""); unixSockAddr != "" {
+		return unixSockAddr
+	}
+	if strings.HasPrefix(url.Host, UnixSockProtocolFlag) {
+		return url.Host[len(UnixSockProtocolFlag):]
+	}
+	return ""
+}
+
 // ListenUnixSock try to listen a unix socket address
 // this method using by create motan agent server, management server and http proxy server
 func ListenUnixSock(unixSockAddr string) (net.Listener, error) {
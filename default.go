@@ -45,6 +45,8 @@ func GetDefaultManageHandlers() map[string]http.Handler {
 		info := &InfoHandler{}
 		defaultManageHandlers["/getConfig"] = info
 		defaultManageHandlers["/getReferService"] = info
+		defaultManageHandlers["/getRequestRoutes"] = info
+		defaultManageHandlers["/getOutlierStatus"] = info
 
 		debug := &DebugHandler{}
 		defaultManageHandlers["/debug/pprof/"] = debug
@@ -77,6 +79,7 @@ func GetDefaultManageHandlers() map[string]http.Handler {
 
 		hotReload := &HotReload{}
 		defaultManageHandlers["/reload/clusters"] = hotReload
+		defaultManageHandlers["/reload/auth"] = hotReload
 	})
 	return defaultManageHandlers
 }
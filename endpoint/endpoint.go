@@ -12,6 +12,7 @@ import (
 const (
 	Grpc   = "grpc"
 	Motan2 = "motan2"
+	Motan  = "motan"
 	Local  = "local"
 	Mock   = "mockEndpoint"
 )
@@ -28,6 +29,10 @@ func RegistDefaultEndpoint(extFactory motan.ExtensionFactory) {
 		return &MotanEndpoint{url: url}
 	})
 
+	extFactory.RegistExtEndpoint(Motan, func(url *motan.URL) motan.EndPoint {
+		return &MotanEndpoint{url: url}
+	})
+
 	extFactory.RegistExtEndpoint(Grpc, func(url *motan.URL) motan.EndPoint {
 		return &GrpcEndPoint{url: url}
 	})
@@ -2,27 +2,93 @@ package endpoint
 
 import (
 	"strconv"
+	"strings"
 	"time"
 
 	motan "github.com/weibocom/motan-go/core"
 	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
 	"golang.org/x/net/context"
 	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 	metadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type GrpcEndPoint struct {
 	url      *motan.URL
 	grpcConn *grpc.ClientConn
 	proxy    bool
+	// the exception types of the grpc status codes
+	errorTypes map[codes.Code]int
+	// the default request timeout in milliseconds
+	requestTimeout int64
 }
 
 const (
 	GRPCSerialNum = 1
 )
 
+// grpc url parameter keys
+const (
+	// GrpcErrorTypeKey overrides the exception types of grpc status codes, such as: `NotFound:service,Unavailable:framework`
+	GrpcErrorTypeKey = "grpcErrorType"
+	// GrpcKeepaliveTimeKey is the interval in milliseconds to ping the idle connections, keepalive is disabled if it is not set
+	GrpcKeepaliveTimeKey = "grpcKeepaliveTime"
+	// GrpcKeepaliveTimeoutKey is the timeout in milliseconds to wait for the ping ack before closing the connection
+	GrpcKeepaliveTimeoutKey = "grpcKeepaliveTimeout"
+)
+
+const DefaultGrpcKeepaliveTimeout = 20 * time.Second
+
+// the default exception types of grpc status codes. the unavailable servers and the timeouts are framework exceptions,
+// the errors caused by the callers and the errors returned by the service implements(unknown) are biz exceptions which
+// will not trigger failover or circuit breaking. the codes not in the table are service exceptions
+var defaultGrpcErrorTypes = map[codes.Code]int{
+	codes.Canceled:           motan.FrameworkException,
+	codes.DeadlineExceeded:   motan.FrameworkException,
+	codes.Unavailable:        motan.FrameworkException,
+	codes.Unknown:            motan.BizException,
+	codes.InvalidArgument:    motan.BizException,
+	codes.NotFound:           motan.BizException,
+	codes.AlreadyExists:      motan.BizException,
+	codes.PermissionDenied:   motan.BizException,
+	codes.FailedPrecondition: motan.BizException,
+	codes.Aborted:            motan.BizException,
+	codes.OutOfRange:         motan.BizException,
+	codes.Unauthenticated:    motan.BizException,
+}
+
+var grpcExceptionTypeNames = map[string]int{
+	"framework": motan.FrameworkException,
+	"service":   motan.ServiceException,
+	"biz":       motan.BizException,
+}
+
 func (g *GrpcEndPoint) Initialize() {
-	grpcconn, err := grpc.Dial((g.url.Host + ":" + strconv.Itoa((int)(g.url.Port))), grpc.WithInsecure(), grpc.WithCodec(&agentCodec{}))
+	g.errorTypes = ParseGrpcErrorTypes(g.url.GetParam(GrpcErrorTypeKey, ""))
+	g.requestTimeout = g.url.GetPositiveIntValue(motan.TimeOutKey, int64(defaultRequestTimeout/time.Millisecond))
+	options := []grpc.DialOption{grpc.WithCodec(&agentCodec{})}
+	if motan.IsTLSEnabled(g.url) {
+		tlsConfig, err := motan.BuildClientTLSConfig(g.url)
+		if err != nil {
+			vlog.Errorf("build tls config fail. url:%s, err:%v", g.url.GetIdentity(), err)
+			return
+		}
+		options = append(options, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		options = append(options, grpc.WithInsecure())
+	}
+	if keepaliveTime := g.url.GetTimeDuration(GrpcKeepaliveTimeKey, time.Millisecond, 0); keepaliveTime > 0 {
+		options = append(options, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveTime,
+			Timeout:             g.url.GetTimeDuration(GrpcKeepaliveTimeoutKey, time.Millisecond, DefaultGrpcKeepaliveTimeout),
+			PermitWithoutStream: true,
+		}))
+	}
+	grpcconn, err := grpc.Dial((g.url.Host + ":" + strconv.Itoa((int)(g.url.Port))), options...)
 	if err != nil {
 		vlog.Errorf("connect to grpc fail! url:%s, err:%s", g.url.GetIdentity(), err.Error())
 	}
@@ -53,13 +119,17 @@ func (g *GrpcEndPoint) Call(request motan.Request) motan.Response {
 		vlog.Errorf("can not process argument in grpc endpoint. argument:%v", request.GetArguments()[0])
 		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "grpc argument must be []byte", ErrType: motan.ServiceException})
 	}
+	if g.grpcConn == nil {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "grpc connection is not available", ErrType: motan.FrameworkException})
+	}
 	out := new(OutMsg)
 
 	var header, trailer metadata.MD
 	md := metadata.New(request.GetAttachments().RawMap())
-	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	timeout := g.url.GetMethodPositiveIntValue(request.GetMethod(), request.GetMethodDesc(), motan.TimeOutKey, g.requestTimeout)
+	ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), md), time.Duration(timeout)*time.Millisecond)
+	defer cancel()
 	err := grpc.Invoke(ctx, "/"+request.GetServiceName()+"/"+request.GetMethod(), in, out, g.grpcConn, grpc.Header(&header), grpc.Trailer(&trailer))
-	// type MD map[string][]string
 	resp := &motan.MotanResponse{Attachment: motan.NewStringMap(motan.DefaultAttachmentSize)}
 	resp.RequestID = request.GetRequestID()
 	resp.ProcessTime = int64((time.Now().UnixNano() - t) / 1000000)
@@ -67,17 +137,97 @@ func (g *GrpcEndPoint) Call(request motan.Request) motan.Response {
 	rc.Serialized = true
 	rc.SerializeNum = GRPCSerialNum
 
-	// @TODO add receiving header and trailers into attachment
+	// the trailers are sent after the headers, they override the headers with the same keys
+	for _, m := range []metadata.MD{header, trailer} {
+		for k, v := range m {
+			if len(v) > 0 && !IsGrpcReservedHeader(k) {
+				resp.Attachment.Store(GrpcMetadataKey(k), v[len(v)-1])
+			}
+		}
+	}
 	if err != nil {
-		errcode := int(grpc.Code(err))
-		//@TODO ErrTYpe
-		resp.Exception = &motan.Exception{ErrCode: errcode, ErrMsg: grpc.ErrorDesc(err), ErrType: errcode}
+		st := status.Convert(err)
+		errType, ok := g.errorTypes[st.Code()]
+		if !ok {
+			errType = motan.ServiceException
+		}
+		resp.Exception = &motan.Exception{ErrCode: int(st.Code()), ErrMsg: st.Message(), ErrType: errType}
 		return resp
 	}
 	resp.Value = (*out).buf
 	return resp
 }
 
+// ParseGrpcErrorTypes builds the exception types of the grpc status codes from the default table and the config.
+// The config is a comma separated list of `code:type`, the code is the name or the number of a grpc status code,
+// the type is one of framework, service and biz
+func ParseGrpcErrorTypes(config string) map[codes.Code]int {
+	errorTypes := make(map[codes.Code]int, len(defaultGrpcErrorTypes))
+	for code, errType := range defaultGrpcErrorTypes {
+		errorTypes[code] = errType
+	}
+	if config == "" {
+		return errorTypes
+	}
+	codeNames := make(map[string]codes.Code, 17)
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		codeNames[strings.ToLower(c.String())] = c
+	}
+	for _, item := range motan.TrimSplit(config, ",") {
+		kv := motan.TrimSplit(item, ":")
+		if len(kv) != 2 {
+			vlog.Warningf("illegal grpc error type config: %s", item)
+			continue
+		}
+		errType, ok := grpcExceptionTypeNames[strings.ToLower(kv[1])]
+		if !ok {
+			vlog.Warningf("illegal grpc error type config: %s", item)
+			continue
+		}
+		name := strings.ToLower(strings.Replace(kv[0], "_", "", -1))
+		if code, ok := codeNames[name]; ok {
+			errorTypes[code] = errType
+		} else if code, err := strconv.Atoi(kv[0]); err == nil {
+			errorTypes[codes.Code(code)] = errType
+		} else {
+			vlog.Warningf("illegal grpc error type config: %s", item)
+		}
+	}
+	return errorTypes
+}
+
+// grpcMotanMetadataKeys are the lowercased motan reserved keys, the other keys are kept as they are
+var grpcMotanMetadataKeys = func() map[string]string {
+	keys := make(map[string]string)
+	for _, key := range []string{mpro.MPath, mpro.MMethod, mpro.MExceptionn, mpro.MProcessTime, mpro.MMethodDesc,
+		mpro.MGroup, mpro.MProxyProtocol, mpro.MVersion, mpro.MModule, mpro.MSource, mpro.MRequestID, mpro.MTimeout,
+		mpro.MAuthTimestamp, mpro.MAuthSignature, mpro.MStreamWindow, mpro.MCompress, mpro.MAcceptCompress} {
+		keys[strings.ToLower(key)] = key
+	}
+	return keys
+}()
+
+// GrpcMetadataKey returns the attachment key of a grpc metadata key. The metadata keys are lowercased by grpc, so the
+// motan reserved keys(e.g. 'm_g') are mapped back to their motan names(e.g. 'M_g')
+func GrpcMetadataKey(key string) string {
+	if motanKey, ok := grpcMotanMetadataKeys[key]; ok {
+		return motanKey
+	}
+	return key
+}
+
+// IsGrpcReservedHeader checks whether the header is used by the http2 transport of grpc, they are not attachments
+func IsGrpcReservedHeader(key string) bool {
+	if strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") {
+		return true
+	}
+	switch key {
+	case "content-type", "user-agent", "te":
+		return true
+	}
+	return false
+}
+
 func (g *GrpcEndPoint) GetName() string {
 	return "grpcEndpoint"
 }
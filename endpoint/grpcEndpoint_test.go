@@ -3,7 +3,10 @@ package endpoint
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	motan "github.com/weibocom/motan-go/core"
+	mpro "github.com/weibocom/motan-go/protocol"
+	"google.golang.org/grpc/codes"
 )
 
 func TestGetURL(t *testing.T) {
@@ -14,3 +17,38 @@ func TestGetURL(t *testing.T) {
 		t.Error("GetName Error")
 	}
 }
+
+func TestParseGrpcErrorTypes(t *testing.T) {
+	errorTypes := ParseGrpcErrorTypes("")
+	assert.Equal(t, motan.FrameworkException, errorTypes[codes.Unavailable])
+	assert.Equal(t, motan.BizException, errorTypes[codes.NotFound])
+	_, ok := errorTypes[codes.Internal]
+	assert.False(t, ok)
+
+	errorTypes = ParseGrpcErrorTypes("NOT_FOUND:service, unavailable:biz,13:framework,Internal,OK:unknown,bad:biz")
+	assert.Equal(t, motan.ServiceException, errorTypes[codes.NotFound])
+	assert.Equal(t, motan.BizException, errorTypes[codes.Unavailable])
+	assert.Equal(t, motan.FrameworkException, errorTypes[codes.Internal])
+	assert.Equal(t, motan.FrameworkException, errorTypes[codes.DeadlineExceeded])
+	_, ok = errorTypes[codes.OK]
+	assert.False(t, ok)
+	// the default table is not changed
+	assert.Equal(t, motan.BizException, defaultGrpcErrorTypes[codes.NotFound])
+}
+
+func TestIsGrpcReservedHeader(t *testing.T) {
+	for _, k := range []string{":authority", "content-type", "grpc-status", "user-agent", "te"} {
+		assert.True(t, IsGrpcReservedHeader(k))
+	}
+	assert.False(t, IsGrpcReservedHeader("m_pt"))
+	assert.False(t, IsGrpcReservedHeader("trace-id"))
+}
+
+func TestGrpcMetadataKey(t *testing.T) {
+	assert.Equal(t, mpro.MGroup, GrpcMetadataKey("m_g"))
+	assert.Equal(t, mpro.MProcessTime, GrpcMetadataKey("m_pt"))
+	assert.Equal(t, "trace-id", GrpcMetadataKey("trace-id"))
+	assert.Equal(t, "mx_g", GrpcMetadataKey("mx_g"))
+	// only the motan reserved keys are mapped
+	assert.Equal(t, "m_custom", GrpcMetadataKey("m_custom"))
+}
@@ -0,0 +1,158 @@
+package endpoint
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	motan "github.com/weibocom/motan-go/core"
+	mhttp "github.com/weibocom/motan-go/http"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+var (
+	errNoHealthCheckMethod  = errors.New("health check method is not configured")
+	errIllegalHealthCheck   = errors.New("illegal health check response")
+	errHealthCheckNoChannel = errors.New("channels is null")
+)
+
+// initHealthCheck creates the active health check of the endpoint if it's configured, the heartbeat is the default
+// probe. The endpoint is unavailable when it's unhealthy, and the passive keepalive can not make it available.
+// the checker is created before the channels, it's started by startHealthCheck after the channels are initialized
+func (m *MotanEndpoint) initHealthCheck() {
+	config := motan.NewHealthCheckConfig(m.url, motan.HealthCheckHeartbeat)
+	if config == nil {
+		return
+	}
+	var probe motan.HealthProbe
+	switch config.Type {
+	case motan.HealthCheckHeartbeat:
+		probe = m.heartbeatProbe
+	case motan.HealthCheckMethod:
+		probe = func(timeout time.Duration) error {
+			return m.methodProbe(config, timeout)
+		}
+	case motan.HealthCheckHTTP:
+		probe = func(timeout time.Duration) error {
+			return m.httpProbe(config, timeout)
+		}
+	default:
+		vlog.Warningf("Unknown health check type %s of endpoint %s, health check is disabled", config.Type, m.url.GetIdentity())
+		return
+	}
+	m.healthChecker = motan.NewHealthChecker(m.url.GetIdentity(), config, probe, m.onHealthChange)
+}
+
+func (m *MotanEndpoint) startHealthCheck() {
+	if m.healthChecker != nil {
+		m.healthChecker.Start()
+	}
+}
+
+func (m *MotanEndpoint) onHealthChange(healthy bool) {
+	if healthy {
+		m.resetErr()
+		if m.channels != nil {
+			m.setAvailable(true)
+		}
+	} else {
+		m.setAvailable(false)
+	}
+}
+
+// isHealthy returns false only if the active health check marks the endpoint unhealthy
+func (m *MotanEndpoint) isHealthy() bool {
+	return m.healthChecker == nil || m.healthChecker.IsHealthy()
+}
+
+// GetHealthStatus returns the active health check status, it's nil if the health check is disabled
+func (m *MotanEndpoint) GetHealthStatus() *motan.HealthStatus {
+	if m.healthChecker == nil {
+		return nil
+	}
+	return m.healthChecker.GetHealthStatus()
+}
+
+func (m *MotanEndpoint) probeCall(msg *mpro.Message, timeout time.Duration) (*mpro.Message, error) {
+	channels := m.channels
+	if channels == nil {
+		return nil, errHealthCheckNoChannel
+	}
+	channel, err := channels.Get()
+	if err != nil {
+		return nil, err
+	}
+	return channel.Call(msg, timeout, nil)
+}
+
+func (m *MotanEndpoint) heartbeatProbe(timeout time.Duration) error {
+	_, err := m.probeCall(mpro.BuildHeartbeat(GenerateRequestID(), mpro.Req), timeout)
+	return err
+}
+
+// callProbe sends the probe request and returns the response, the exceptions of the response are errors
+func (m *MotanEndpoint) callProbe(request *motan.MotanRequest, timeout time.Duration) (motan.Response, error) {
+	request.RequestID = GenerateRequestID()
+	request.SetAttachment(mpro.MGroup, m.url.Group)
+	request.GetRPCContext(true).Proxy = m.proxy
+	msg, err := mpro.ConvertToReqMessage(request, m.serialization)
+	if err != nil {
+		return nil, err
+	}
+	recvMsg, err := m.probeCall(msg, timeout)
+	if err != nil {
+		return nil, err
+	}
+	response, err := mpro.ConvertToResponse(recvMsg, m.serialization)
+	if err != nil {
+		return nil, err
+	}
+	if e := response.GetException(); e != nil {
+		return nil, errors.New(e.ErrMsg)
+	}
+	return response, nil
+}
+
+// methodProbe calls the configured method of the service without arguments
+func (m *MotanEndpoint) methodProbe(config *motan.HealthCheckConfig, timeout time.Duration) error {
+	if config.Method == "" {
+		return errNoHealthCheckMethod
+	}
+	_, err := m.callProbe(&motan.MotanRequest{ServiceName: m.url.Path, Method: config.Method, Arguments: []interface{}{}}, timeout)
+	return err
+}
+
+// httpProbe sends a GET request to the http service behind the endpoint like the http rpc proxy, the endpoint is
+// healthy if the status code is expected
+func (m *MotanEndpoint) httpProbe(config *motan.HealthCheckConfig, timeout time.Duration) error {
+	header := &fasthttp.RequestHeader{}
+	header.SetMethod("GET")
+	header.SetRequestURI(config.Path)
+	header.SetHost(m.url.GetParam(mhttp.DomainKey, m.url.Host))
+	request := &motan.MotanRequest{ServiceName: m.url.Path, Method: config.Path, Arguments: []interface{}{header.Header(), []byte{}}}
+	request.SetAttachment(mhttp.Proxy, "true")
+	request.SetAttachment(mpro.MPath, m.url.Path)
+	response, err := m.callProbe(request, timeout)
+	if err != nil {
+		return err
+	}
+	var reply []interface{}
+	if err = response.ProcessDeserializable(&reply); err != nil {
+		return err
+	}
+	if len(reply) == 0 {
+		return errIllegalHealthCheck
+	}
+	headerBytes, ok := reply[0].([]byte)
+	if !ok {
+		return errIllegalHealthCheck
+	}
+	resHeader := &fasthttp.ResponseHeader{}
+	if err = resHeader.Read(bufio.NewReader(bytes.NewReader(headerBytes))); err != nil {
+		return err
+	}
+	return config.CheckStatus(resHeader.StatusCode())
+}
@@ -0,0 +1,129 @@
+package endpoint
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+// serveHealthCheck ignores the heartbeats if down is set, and responds the http status to the normal requests
+func serveHealthCheck(lis net.Listener, down *int32, status *int32) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			buf := bufio.NewReader(conn)
+			for {
+				msg, err := protocol.Decode(buf)
+				if err != nil {
+					return
+				}
+				var res *protocol.Message
+				if msg.Header.IsHeartbeat() {
+					if atomic.LoadInt32(down) == 1 {
+						continue
+					}
+					res = protocol.BuildHeartbeat(msg.Header.RequestID, protocol.Res)
+				} else {
+					header := "HTTP/1.1 " + strconv.Itoa(int(atomic.LoadInt32(status))) + " OK\r\nContent-Length: 0\r\n\r\n"
+					body, _ := (&serialize.SimpleSerialization{}).Serialize([]interface{}{[]byte(header), []byte{}})
+					res = &protocol.Message{Header: protocol.BuildResponseHeader(msg.Header.RequestID, protocol.Normal),
+						Metadata: motan.NewStringMap(0), Body: body, Type: protocol.Res}
+					res.Header.SetSerialize(serialize.SimpleNumber)
+				}
+				conn.Write(res.Encode().Bytes())
+			}
+		}(conn)
+	}
+}
+
+func newHealthCheckEndpoint(t *testing.T, params map[string]string) (*MotanEndpoint, *int32, *int32, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	var down int32
+	status := int32(200)
+	go serveHealthCheck(lis, &down, &status)
+	url := &motan.URL{Host: "127.0.0.1", Port: lis.Addr().(*net.TCPAddr).Port, Protocol: "motan2", Path: "test.service"}
+	url.PutParam(motan.TimeOutKey, "100")
+	url.PutParam(motan.HealthCheckIntervalKey, "3600000")
+	url.PutParam(motan.HealthCheckTimeoutKey, "50")
+	url.PutParam(motan.UnhealthyThresholdKey, "1")
+	url.PutParam(motan.HealthyThresholdKey, "1")
+	for k, v := range params {
+		url.PutParam(k, v)
+	}
+	ep := &MotanEndpoint{}
+	ep.SetURL(url)
+	ep.SetProxy(true)
+	ep.SetSerialization(&serialize.SimpleSerialization{})
+	ep.Initialize()
+	return ep, &down, &status, func() {
+		ep.Destroy()
+		lis.Close()
+	}
+}
+
+func TestHeartbeatHealthCheck(t *testing.T) {
+	ep, down, _, closer := newHealthCheckEndpoint(t, map[string]string{motan.HealthCheckKey: "true"})
+	defer closer()
+	assert.NotNil(t, ep.healthChecker)
+	ep.healthChecker.Check()
+	assert.True(t, ep.IsAvailable())
+	assert.True(t, ep.GetHealthStatus().Healthy)
+	assert.Equal(t, int64(1), ep.GetHealthStatus().Checks)
+
+	atomic.StoreInt32(down, 1)
+	ep.healthChecker.Check()
+	assert.False(t, ep.IsAvailable())
+	status := ep.GetHealthStatus()
+	assert.False(t, status.Healthy)
+	assert.NotEmpty(t, status.LastError)
+
+	atomic.StoreInt32(down, 0)
+	ep.healthChecker.Check()
+	assert.True(t, ep.IsAvailable())
+}
+
+func TestHTTPHealthCheck(t *testing.T) {
+	ep, _, status, closer := newHealthCheckEndpoint(t, map[string]string{motan.HealthCheckKey: motan.HealthCheckHTTP,
+		motan.HealthCheckPathKey: "/health"})
+	defer closer()
+	ep.healthChecker.Check()
+	assert.True(t, ep.IsAvailable())
+	atomic.StoreInt32(status, 503)
+	ep.healthChecker.Check()
+	assert.False(t, ep.IsAvailable())
+	assert.Equal(t, "unexpected status code 503", ep.GetHealthStatus().LastError)
+	atomic.StoreInt32(status, 204)
+	ep.healthChecker.Check()
+	assert.True(t, ep.IsAvailable())
+}
+
+func TestMethodHealthCheck(t *testing.T) {
+	ep, _, _, closer := newHealthCheckEndpoint(t, map[string]string{motan.HealthCheckKey: motan.HealthCheckMethod})
+	defer closer()
+	ep.healthChecker.Check()
+	assert.False(t, ep.IsAvailable())
+	assert.Equal(t, errNoHealthCheckMethod.Error(), ep.GetHealthStatus().LastError)
+
+	ep, _, _, closer = newHealthCheckEndpoint(t, map[string]string{motan.HealthCheckKey: motan.HealthCheckMethod,
+		motan.HealthCheckMethodKey: "ping"})
+	defer closer()
+	ep.healthChecker.Check()
+	assert.True(t, ep.IsAvailable())
+
+	ep, _, _, closer = newHealthCheckEndpoint(t, nil)
+	defer closer()
+	assert.Nil(t, ep.healthChecker)
+	assert.Nil(t, ep.GetHealthStatus())
+}
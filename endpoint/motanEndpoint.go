@@ -2,8 +2,10 @@ package endpoint
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"strconv"
 	"sync"
@@ -12,6 +14,7 @@ import (
 
 	motan "github.com/weibocom/motan-go/core"
 	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
 	mpro "github.com/weibocom/motan-go/protocol"
 )
 
@@ -22,9 +25,25 @@ var (
 	defaultKeepaliveInterval    = 1000 * time.Millisecond
 	defaultConnectRetryInterval = 60 * time.Second
 	defaultErrorCountThreshold  = 10
+	defaultMaxChannelPending    = 100
+	defaultChannelIdleTimeout   = 60 * time.Second
+	// the first reconnect interval, it doubles after each failure until reaching the connectRetryInterval
+	defaultReconnectBaseInterval = 500 * time.Millisecond
+	// a channel is unhealthy after so many consecutive timeouts without any response
+	channelUnhealthyTimeouts    = int32(10)
+	channelPoolMaintainInterval = time.Second
 	ErrChannelShutdown          = fmt.Errorf("The channel has been shutdown")
 	ErrSendRequestTimeout       = fmt.Errorf("Timeout err: send request timeout")
 	ErrRecvRequestTimeout       = fmt.Errorf("Timeout err: receive request timeout")
+	ErrRequestCanceled          = fmt.Errorf("The request has been canceled")
+	ErrRequestCanceledNotSent   = fmt.Errorf("The request has been canceled before sending")
+	ErrChannelPoolClosed        = fmt.Errorf("The channel pool has been closed")
+	ErrNoAvailableChannel       = fmt.Errorf("No available channel in the channel pool")
+
+	// channelPools are all the alive pools, used for the pool status metrics
+	channelPools      sync.Map
+	channelPoolOnce   sync.Once
+	channelReconnects int64
 
 	defaultAsyncResponse = &motan.MotanResponse{Attachment: motan.NewStringMap(motan.DefaultAttachmentSize), RPCContext: &motan.RPCContext{AsyncCall: true}}
 
@@ -46,11 +65,19 @@ type MotanEndpoint struct {
 	minRequestTimeoutMillisecond int64
 	maxRequestTimeoutMillisecond int64
 	clientConnection             int
+	cancelNotify                 bool
+	// the preferred compressor, it's used after the server accepts it
+	compressCodec   string
+	peerCompressors atomic.Value // string
+	// the codec of the protocol, motan2 or the legacy motan v1
+	codec mpro.Codec
 
 	// for heartbeat requestID
 	keepaliveID      uint64
 	keepaliveRunning bool
 	serialization    motan.Serialization
+	// the active health check, it's nil if the health check is disabled
+	healthChecker *motan.HealthChecker
 }
 
 func (m *MotanEndpoint) setAvailable(available bool) {
@@ -75,25 +102,62 @@ func (m *MotanEndpoint) Initialize() {
 	m.minRequestTimeoutMillisecond, _ = m.url.GetInt(motan.MinTimeOutKey)
 	m.maxRequestTimeoutMillisecond, _ = m.url.GetInt(motan.MaxTimeOutKey)
 	m.clientConnection = int(m.url.GetPositiveIntValue(motan.ClientConnectionKey, int64(defaultChannelPoolSize)))
+	poolConfig := ChannelPoolConfig{
+		MinSize:               m.clientConnection,
+		MaxSize:               int(m.url.GetPositiveIntValue(motan.MaxClientConnectionKey, int64(m.clientConnection))),
+		MaxPending:            int(m.url.GetPositiveIntValue(motan.MaxChannelPendingKey, int64(defaultMaxChannelPending))),
+		IdleTimeout:           m.url.GetTimeDuration(motan.ChannelIdleTimeoutKey, time.Millisecond, defaultChannelIdleTimeout),
+		ReconnectBaseInterval: defaultReconnectBaseInterval,
+		ReconnectMaxInterval:  connectRetryInterval,
+	}
+	m.compressCodec = m.url.GetParam(motan.CompressKey, "")
+	m.peerCompressors.Store("")
+	m.codec = mpro.NewCodec(m.url.Protocol, m.serialization)
+	config := DefaultConfig()
+	config.Codec = m.codec
+	// the cancel messages are sent only if it's enabled explicitly, the servers which don't know the cancel messages
+	// (e.g. the old go servers and the java servers) take them as the normal requests. only motan2 supports it
+	if m.codec.GetName() == mpro.CodecMotan2 {
+		m.cancelNotify, _ = strconv.ParseBool(m.url.GetParam(motan.CancelNotifyKey, "false"))
+	}
+	network, address := "tcp", m.url.GetAddressStr()
+	if unixSockAddr := motan.GetUnixSockAddress(m.url); unixSockAddr != "" {
+		network, address = "unix", unixSockAddr
+	}
 	factory := func() (net.Conn, error) {
-		return net.DialTimeout("tcp", m.url.GetAddressStr(), connectTimeout)
+		return net.DialTimeout(network, address, connectTimeout)
+	}
+	if motan.IsTLSEnabled(m.url) {
+		loader, err := motan.GetTLSConfigLoader(m.url)
+		if err != nil {
+			vlog.Errorf("Load tls config failed, endpoint will be unavailable. url: %v, err:%s", m.url, err.Error())
+			return
+		}
+		factory = newTLSConnFactory(factory, loader, m.url.GetParam(motan.TLSServerNameKey, m.url.Host), m.url.GetParam(motan.TLSVerifyKey, ""), connectTimeout)
+	}
+	if m.compressCodec != "" {
+		// the peer may be restarted with other compressors, they are learned again after reconnecting
+		dial := factory
+		factory = func() (net.Conn, error) {
+			m.peerCompressors.Store("")
+			return dial()
+		}
 	}
-	channels, err := NewChannelPool(m.clientConnection, factory, nil, m.serialization)
+	// the retrying goroutine reads the health checker, so it's created before
+	m.initHealthCheck()
+	channels, err := NewChannelPoolWithConfig(poolConfig, factory, config, m.serialization)
 	if err != nil {
 		vlog.Errorf("Channel pool init failed. url: %v, err:%s", m.url, err.Error())
-		// retry connect
+		// retry connect with jittered exponential backoff
 		go func() {
 			defer motan.HandlePanic(nil)
-			// TODO: retry after 2^n * timeUnit
-			ticker := time.NewTicker(connectRetryInterval)
-			defer ticker.Stop()
-			for {
+			for retries := 1; ; retries++ {
 				select {
-				case <-ticker.C:
-					channels, err := NewChannelPool(m.clientConnection, factory, nil, m.serialization)
+				case <-time.After(reconnectBackoff(retries, poolConfig.ReconnectBaseInterval, connectRetryInterval)):
+					channels, err := NewChannelPoolWithConfig(poolConfig, factory, config, m.serialization)
 					if err == nil {
 						m.channels = channels
-						m.setAvailable(true)
+						m.setAvailable(m.isHealthy())
 						vlog.Infof("Channel pool init success. url:%s", m.url.GetAddressStr())
 						return
 					}
@@ -107,6 +171,7 @@ func (m *MotanEndpoint) Initialize() {
 		m.setAvailable(true)
 		vlog.Infof("Channel pool init success. url:%s", m.url.GetAddressStr())
 	}
+	m.startHealthCheck()
 }
 
 func (m *MotanEndpoint) Destroy() {
@@ -118,6 +183,9 @@ func (m *MotanEndpoint) Destroy() {
 	m.setAvailable(false)
 	m.destroyCh <- struct{}{}
 	m.destroyed = true
+	if m.healthChecker != nil {
+		m.healthChecker.Stop()
+	}
 	if m.channels != nil {
 		vlog.Infof("motan2 endpoint %s will destroyed", m.url.GetAddressStr())
 		m.channels.Close()
@@ -145,6 +213,14 @@ func (m *MotanEndpoint) Call(request motan.Request) motan.Response {
 	rc := request.GetRPCContext(true)
 	rc.Proxy = m.proxy
 	rc.GzipSize = int(m.url.GetIntValue(motan.GzipSizeKey, 0))
+	if m.compressCodec != "" && !m.proxy {
+		peerCompressors := m.peerCompressors.Load().(string)
+		rc.CompressCodec = mpro.NegotiateCompressor(m.compressCodec, peerCompressors)
+		// the compressors are advertised until the peer's ones are learned, the peer echoes its ones only to them
+		if peerCompressors == "" {
+			request.SetAttachment(mpro.MAcceptCompress, mpro.AcceptCompressors())
+		}
+	}
 
 	if m.channels == nil {
 		vlog.Errorf("motanEndpoint %s error: channels is null", m.url.GetAddressStr())
@@ -184,16 +260,31 @@ func (m *MotanEndpoint) Call(request motan.Request) motan.Response {
 		rc.Tc.PutReqSpan(&motan.Span{Name: motan.Convert, Addr: m.GetURL().GetAddressStr(), Time: time.Now()})
 	}
 	recvMsg, err := channel.Call(msg, deadline, rc)
+	if err == ErrRequestCanceled || err == ErrRequestCanceledNotSent {
+		// the caller does not need the response any more, it's not an error of the endpoint.
+		// the server is notified only if it has received the request
+		if m.cancelNotify && err == ErrRequestCanceled {
+			channel.Cancel(msg.Header.RequestID)
+		}
+		return m.defaultErrMotanResponse(request, "channel call error:"+err.Error())
+	}
 	if err != nil {
 		vlog.Errorf("motanEndpoint call fail. ep:%s, req:%s, msgid:%d, error: %s", m.url.GetAddressStr(), motan.GetReqInfo(request), msg.Header.RequestID, err.Error())
 		m.recordErrAndKeepalive()
-		return m.defaultErrMotanResponse(request, "channel call error:"+err.Error())
+		response := m.defaultErrMotanResponse(request, "channel call error:"+err.Error())
+		if err == ErrSendRequestTimeout || err == ErrRecvRequestTimeout {
+			motan.SetErrorCause(response, motan.ErrorCauseTimeout)
+		}
+		return response
 	}
 	if rc.AsyncCall {
 		return defaultAsyncResponse
 	}
 	recvMsg.Header.SetProxy(m.proxy)
 	recvMsg.Header.RequestID = request.GetRequestID()
+	if accepts := recvMsg.Metadata.LoadOrEmpty(mpro.MAcceptCompress); accepts != "" && m.compressCodec != "" {
+		m.peerCompressors.Store(accepts)
+	}
 	response, err := mpro.ConvertToResponse(recvMsg, m.serialization)
 	if rc.Tc != nil {
 		rc.Tc.PutResSpan(&motan.Span{Name: motan.Convert, Time: time.Now()})
@@ -264,7 +355,8 @@ func (m *MotanEndpoint) keepalive() {
 			} else {
 				_, err = channel.Call(mpro.BuildHeartbeat(m.keepaliveID, mpro.Req), defaultRequestTimeout, nil)
 				if err == nil {
-					m.setAvailable(true)
+					// the endpoint keeps unavailable until the active health check succeeds
+					m.setAvailable(m.isHealthy())
 					m.resetErr()
 					vlog.Infof("[keepalive] heartbeat success. url: %s", m.url.GetIdentity())
 					return
@@ -309,6 +401,8 @@ func (m *MotanEndpoint) IsAvailable() bool {
 // Config : Config
 type Config struct {
 	RequestTimeout time.Duration
+	// Codec is the codec of the messages on the channels, motan2 is used if it is nil
+	Codec mpro.Codec
 }
 
 func DefaultConfig() *Config {
@@ -328,6 +422,7 @@ type Channel struct {
 	// config
 	config        *Config
 	serialization motan.Serialization
+	codec         mpro.Codec
 	address       string
 
 	// connection
@@ -343,6 +438,13 @@ type Channel struct {
 	// heartbeat
 	heartbeats    map[uint64]*Stream
 	heartbeatLock sync.Mutex
+	// streaming calls
+	callStreams    map[uint64]*clientStream
+	callStreamLock sync.Mutex
+
+	// health
+	lastActive int64 // unix nano of the last stream created
+	timeouts   int32 // consecutive timeouts since the last response
 
 	// shutdown
 	shutdown     bool
@@ -369,11 +471,14 @@ func (s *Stream) Send() error {
 	timer := time.NewTimer(s.deadline.Sub(time.Now()))
 	defer timer.Stop()
 
-	buf := s.sendMsg.Encode()
+	data, err := s.channel.codec.Encode(s.sendMsg)
+	if err != nil {
+		return err
+	}
 	if s.rc != nil && s.rc.Tc != nil {
 		s.rc.Tc.PutReqSpan(&motan.Span{Name: motan.Encode, Addr: s.channel.address, Time: time.Now()})
 	}
-	ready := sendReady{data: buf.Bytes()}
+	ready := sendReady{data: data}
 	select {
 	case s.channel.sendCh <- ready:
 		if s.rc != nil {
@@ -398,6 +503,10 @@ func (s *Stream) Recv() (*mpro.Message, error) {
 	}()
 	timer := time.NewTimer(s.deadline.Sub(time.Now()))
 	defer timer.Stop()
+	var cancelCh chan struct{}
+	if s.rc != nil {
+		cancelCh = s.rc.CancelCh
+	}
 	select {
 	case <-s.recvNotifyCh:
 		msg := s.recvMsg
@@ -406,7 +515,10 @@ func (s *Stream) Recv() (*mpro.Message, error) {
 		}
 		return msg, nil
 	case <-timer.C:
+		atomic.AddInt32(&s.channel.timeouts, 1)
 		return nil, ErrRecvRequestTimeout
+	case <-cancelCh:
+		return nil, ErrRequestCanceled
 	case <-s.channel.shutdownCh:
 		return nil, ErrChannelShutdown
 	}
@@ -416,6 +528,7 @@ func (s *Stream) notify(msg *mpro.Message, t time.Time) {
 	defer func() {
 		s.Close()
 	}()
+	atomic.StoreInt32(&s.channel.timeouts, 0)
 	if s.rc != nil {
 		s.rc.ResponseReceiveTime = t
 		if s.rc.Tc != nil {
@@ -467,6 +580,7 @@ func (c *Channel) NewStream(msg *mpro.Message, rc *motan.RPCContext) (*Stream, e
 		rc:           rc,
 	}
 	s.isClose.Store(false)
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
 	// RequestID is communication identifier, it is own by channel
 	msg.Header.RequestID = GenerateRequestID()
 	if msg.Header.IsHeartbeat() {
@@ -502,6 +616,9 @@ type sendReady struct {
 }
 
 func (c *Channel) Call(msg *mpro.Message, deadline time.Duration, rc *motan.RPCContext) (*mpro.Message, error) {
+	if rc != nil && rc.IsCanceled() {
+		return nil, ErrRequestCanceledNotSent
+	}
 	stream, err := c.NewStream(msg, rc)
 	if err != nil {
 		return nil, err
@@ -516,10 +633,42 @@ func (c *Channel) Call(msg *mpro.Message, deadline time.Duration, rc *motan.RPCC
 	return stream.Recv()
 }
 
+// Cancel notifies the server to skip the request, the notification is dropped if the send queue is full
+func (c *Channel) Cancel(requestID uint64) {
+	ready := sendReady{data: mpro.BuildCancel(requestID).Encode().Bytes()}
+	select {
+	case c.sendCh <- ready:
+	default:
+		vlog.Warningf("send cancel message fail, send queue is full. ep:%s, requestid:%d", c.address, requestID)
+	}
+}
+
 func (c *Channel) IsClosed() bool {
 	return c.shutdown
 }
 
+// IsHealthy tells whether the channel keeps receiving responses, a channel with too many consecutive timeouts is replaced by the pool
+func (c *Channel) IsHealthy() bool {
+	return atomic.LoadInt32(&c.timeouts) < channelUnhealthyTimeouts
+}
+
+// PendingCount returns the number of streams waiting for response
+func (c *Channel) PendingCount() int {
+	c.streamLock.Lock()
+	defer c.streamLock.Unlock()
+	return len(c.streams)
+}
+
+// isIdle tells whether the channel has neither pending calls nor open streaming calls
+func (c *Channel) isIdle() bool {
+	if c.PendingCount() > 0 {
+		return false
+	}
+	c.callStreamLock.Lock()
+	defer c.callStreamLock.Unlock()
+	return len(c.callStreams) == 0
+}
+
 func (c *Channel) recv() {
 	defer motan.HandlePanic(func() {
 		c.closeOnErr(errPanic)
@@ -531,7 +680,7 @@ func (c *Channel) recv() {
 
 func (c *Channel) recvLoop() error {
 	for {
-		res, t, err := mpro.DecodeWithTime(c.bufRead)
+		res, t, err := c.codec.Decode(c.bufRead)
 		if err != nil {
 			return err
 		}
@@ -588,9 +737,19 @@ func (c *Channel) handleHeartbeat(msg *mpro.Message, t time.Time) error {
 }
 
 func (c *Channel) handleMessage(msg *mpro.Message, t time.Time) error {
+	if msg.Header.IsStream() {
+		c.handleStreamFrame(msg)
+		return nil
+	}
 	c.streamLock.Lock()
 	stream := c.streams[msg.Header.RequestID]
 	c.streamLock.Unlock()
+	if stream == nil {
+		// the heartbeat responses of motan v1 have no heartbeat flag
+		c.heartbeatLock.Lock()
+		stream = c.heartbeats[msg.Header.RequestID]
+		c.heartbeatLock.Unlock()
+	}
 	if stream == nil {
 		vlog.Warningf("handle recv message, missing stream: %d, ep:%s", msg.Header.RequestID, c.address)
 	} else {
@@ -626,92 +785,344 @@ func (c *Channel) Close() error {
 
 type ConnFactory func() (net.Conn, error)
 
+// newTLSConnFactory wraps the raw connections created by factory with tls client.
+// the tls config is built for every connection, so the reloaded certificates take effect on reconnecting
+func newTLSConnFactory(factory ConnFactory, loader *motan.TLSConfigLoader, serverName string, verify string, handshakeTimeout time.Duration) ConnFactory {
+	return func() (net.Conn, error) {
+		conn, err := factory()
+		if err != nil {
+			return nil, err
+		}
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			_ = tcpConn.SetNoDelay(true)
+		}
+		tlsConn := tls.Client(conn, loader.ClientConfig(serverName, verify))
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
+}
+
+// ChannelPoolConfig configures the size, growth and reconnection of a ChannelPool
+type ChannelPoolConfig struct {
+	// MinSize channels are kept connected, broken ones are rebuilt in background
+	MinSize int
+	// MaxSize is the upper limit the pool can grow to when channels are busy
+	MaxSize int
+	// MaxPending is the pending stream count of the least busy channel which triggers the pool growth
+	MaxPending int
+	// IdleTimeout is the idle duration after which channels above MinSize are closed
+	IdleTimeout time.Duration
+	// ReconnectBaseInterval and ReconnectMaxInterval bound the exponential reconnect backoff
+	ReconnectBaseInterval time.Duration
+	ReconnectMaxInterval  time.Duration
+}
+
+// ChannelPoolStats is a snapshot of the pool status
+type ChannelPoolStats struct {
+	Active     int
+	Pending    int
+	Reconnects int64
+}
+
 type ChannelPool struct {
-	channels      chan *Channel
+	channels      []*Channel
 	channelsLock  sync.Mutex
+	closed        bool
 	factory       ConnFactory
 	config        *Config
+	poolConfig    ChannelPoolConfig
 	serialization motan.Serialization
+	reconnects    int64
+	notifyCh      chan struct{}
+	closeCh       chan struct{}
+	// draining channels are unhealthy ones not handed out any more, they are closed after the in-flight calls finished
+	draining []*Channel
 }
 
-func (c *ChannelPool) getChannels() chan *Channel {
-	channels := c.channels
-	return channels
-}
-
+// Get returns the healthy channel with the fewest pending streams.
+// Broken channels are skipped and rebuilt by the background maintainer instead of dialing inline.
 func (c *ChannelPool) Get() (*Channel, error) {
-	channels := c.getChannels()
-	if channels == nil {
-		return nil, errors.New("channels is nil")
+	c.channelsLock.Lock()
+	if c.closed {
+		c.channelsLock.Unlock()
+		return nil, ErrChannelPoolClosed
 	}
-	channel, ok := <-channels
-	if ok && (channel == nil || channel.IsClosed()) {
-		conn, err := c.factory()
-		if err != nil {
-			vlog.Errorf("create channel failed. err:%s", err.Error())
+	var selected *Channel
+	minPending := 0
+	broken := false
+	for _, channel := range c.channels {
+		if channel.IsClosed() || !channel.IsHealthy() {
+			broken = true
+			continue
+		}
+		pending := channel.PendingCount()
+		if selected == nil || pending < minPending {
+			selected, minPending = channel, pending
 		}
-		channel = buildChannel(conn, c.config, c.serialization)
 	}
-	if err := retChannelPool(channels, channel); err != nil && channel != nil {
-		channel.closeOnErr(err)
+	grow := selected != nil && minPending >= c.poolConfig.MaxPending && len(c.channels) < c.poolConfig.MaxSize
+	c.channelsLock.Unlock()
+	if selected == nil || broken || grow {
+		c.notifyMaintain()
 	}
-	if channel == nil {
-		return nil, errors.New("channel is nil")
+	if selected == nil {
+		return nil, ErrNoAvailableChannel
 	}
-	return channel, nil
+	return selected, nil
 }
 
-func retChannelPool(channels chan *Channel, channel *Channel) (error error) {
-	defer func() {
-		if err := recover(); err != nil {
-			error = errors.New("ChannelPool has been closed")
+// Stats returns the active channel count, pending stream count and total reconnects of the pool
+func (c *ChannelPool) Stats() ChannelPoolStats {
+	stats := ChannelPoolStats{Reconnects: atomic.LoadInt64(&c.reconnects)}
+	c.channelsLock.Lock()
+	defer c.channelsLock.Unlock()
+	for _, channel := range c.channels {
+		if !channel.IsClosed() {
+			stats.Active++
+			stats.Pending += channel.PendingCount()
 		}
-	}()
-	if channels == nil {
-		return errors.New("channels is nil")
 	}
-	channels <- channel
-	return nil
+	return stats
 }
 
 func (c *ChannelPool) Close() error {
 	c.channelsLock.Lock() // to prevent channels closed many times
-	channels := c.channels
+	if c.closed {
+		c.channelsLock.Unlock()
+		return nil
+	}
+	c.closed = true
+	channels := append(c.channels, c.draining...)
 	c.channels = nil
-	c.factory = nil
-	c.config = nil
+	c.draining = nil
 	c.channelsLock.Unlock()
-	if channels == nil {
-		return nil
+	close(c.closeCh)
+	channelPools.Delete(c)
+	for _, channel := range channels {
+		channel.Close()
+	}
+	return nil
+}
+
+func (c *ChannelPool) notifyMaintain() {
+	select {
+	case c.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// maintain rebuilds broken channels with jittered exponential backoff, grows the pool when channels are busy
+// and reaps idle channels above the min size
+func (c *ChannelPool) maintain() {
+	defer motan.HandlePanic(nil)
+	ticker := time.NewTicker(channelPoolMaintainInterval)
+	defer ticker.Stop()
+	retries := 0
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-ticker.C:
+		case <-c.notifyCh:
+		}
+		for {
+			need, reconnect := c.needChannel()
+			if !need {
+				break
+			}
+			if err := c.addChannel(); err != nil {
+				retries++
+				backoff := reconnectBackoff(retries, c.poolConfig.ReconnectBaseInterval, c.poolConfig.ReconnectMaxInterval)
+				vlog.Warningf("[channelPool] create channel failed, retry after %v. err:%s", backoff, err.Error())
+				select {
+				case <-time.After(backoff):
+					continue
+				case <-c.closeCh:
+					return
+				}
+			}
+			retries = 0
+			if reconnect {
+				atomic.AddInt64(&c.reconnects, 1)
+				atomic.AddInt64(&channelReconnects, 1)
+			}
+		}
+	}
+}
+
+// needChannel removes broken and idle channels, then tells whether a new channel should be created
+// and whether it is a reconnection for keeping the min size.
+// the unhealthy channels are drained, they are closed when they have no in-flight calls
+func (c *ChannelPool) needChannel() (need bool, reconnect bool) {
+	c.channelsLock.Lock()
+	if c.closed {
+		c.channelsLock.Unlock()
+		return false, false
+	}
+	var removed []*Channel
+	draining := c.draining[:0]
+	for _, channel := range c.draining {
+		if channel.IsClosed() || channel.isIdle() {
+			removed = append(removed, channel)
+		} else {
+			draining = append(draining, channel)
+		}
 	}
-	close(channels)
-	for channel := range channels {
-		if channel != nil {
-			channel.Close()
+	channels := make([]*Channel, 0, len(c.channels))
+	for _, channel := range c.channels {
+		if channel.IsClosed() {
+			removed = append(removed, channel)
+		} else if !channel.IsHealthy() {
+			if channel.isIdle() {
+				removed = append(removed, channel)
+			} else {
+				draining = append(draining, channel)
+			}
+		} else {
+			channels = append(channels, channel)
 		}
 	}
+	c.draining = draining
+	minPending := -1
+	idleDeadline := time.Now().Add(-c.poolConfig.IdleTimeout).UnixNano()
+	kept := channels[:0]
+	for i, channel := range channels {
+		pending := channel.PendingCount()
+		if len(kept)+len(channels)-i > c.poolConfig.MinSize && channel.isIdle() && atomic.LoadInt64(&channel.lastActive) < idleDeadline {
+			removed = append(removed, channel)
+			continue
+		}
+		kept = append(kept, channel)
+		if minPending < 0 || pending < minPending {
+			minPending = pending
+		}
+	}
+	c.channels = kept
+	reconnect = len(kept) < c.poolConfig.MinSize
+	need = reconnect || (len(kept) < c.poolConfig.MaxSize && minPending >= c.poolConfig.MaxPending)
+	c.channelsLock.Unlock()
+	for _, channel := range removed {
+		channel.Close()
+	}
+	return need, reconnect
+}
+
+func (c *ChannelPool) addChannel() error {
+	channel, err := c.newChannel()
+	if err != nil {
+		return err
+	}
+	c.channelsLock.Lock()
+	defer c.channelsLock.Unlock()
+	if c.closed {
+		channel.Close()
+		return ErrChannelPoolClosed
+	}
+	c.channels = append(c.channels, channel)
 	return nil
 }
 
+func (c *ChannelPool) newChannel() (*Channel, error) {
+	conn, err := c.factory()
+	if err != nil {
+		return nil, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetNoDelay(true)
+	}
+	channel := buildChannel(conn, c.config, c.serialization)
+	if channel == nil {
+		conn.Close()
+		return nil, errors.New("build channel failed")
+	}
+	return channel, nil
+}
+
+// reconnectBackoff returns a random duration in [d/2, d], d is base * 2^(retries-1) and not greater than max
+func reconnectBackoff(retries int, base time.Duration, max time.Duration) time.Duration {
+	d := max
+	if retries <= 30 && base<<uint(retries-1) < max {
+		d = base << uint(retries-1)
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func registerChannelPoolSamplers() {
+	channelPoolOnce.Do(func() {
+		metrics.RegisterStatusSampleFunc("motan_client_channel_count", func() int64 {
+			return sumChannelPoolStats(func(stats ChannelPoolStats) int { return stats.Active })
+		})
+		metrics.RegisterStatusSampleFunc("motan_client_pending_stream_count", func() int64 {
+			return sumChannelPoolStats(func(stats ChannelPoolStats) int { return stats.Pending })
+		})
+		metrics.RegisterStatusSampleFunc("motan_client_channel_reconnect_count", func() int64 {
+			return atomic.SwapInt64(&channelReconnects, 0)
+		})
+	})
+}
+
+func sumChannelPoolStats(value func(stats ChannelPoolStats) int) int64 {
+	var sum int64
+	channelPools.Range(func(k, v interface{}) bool {
+		sum += int64(value(k.(*ChannelPool).Stats()))
+		return true
+	})
+	return sum
+}
+
+// NewChannelPool creates a fixed size channel pool
 func NewChannelPool(poolCap int, factory ConnFactory, config *Config, serialization motan.Serialization) (*ChannelPool, error) {
-	if poolCap <= 0 {
+	return NewChannelPoolWithConfig(ChannelPoolConfig{MinSize: poolCap, MaxSize: poolCap}, factory, config, serialization)
+}
+
+// NewChannelPoolWithConfig creates a channel pool with MinSize channels connected, it fails if any of them can not be connected
+func NewChannelPoolWithConfig(poolConfig ChannelPoolConfig, factory ConnFactory, config *Config, serialization motan.Serialization) (*ChannelPool, error) {
+	if poolConfig.MinSize <= 0 {
 		return nil, errors.New("invalid capacity settings")
 	}
+	if poolConfig.MaxSize < poolConfig.MinSize {
+		poolConfig.MaxSize = poolConfig.MinSize
+	}
+	if poolConfig.MaxPending <= 0 {
+		poolConfig.MaxPending = defaultMaxChannelPending
+	}
+	if poolConfig.IdleTimeout <= 0 {
+		poolConfig.IdleTimeout = defaultChannelIdleTimeout
+	}
+	if poolConfig.ReconnectBaseInterval <= 0 {
+		poolConfig.ReconnectBaseInterval = defaultReconnectBaseInterval
+	}
+	if poolConfig.ReconnectMaxInterval < poolConfig.ReconnectBaseInterval {
+		poolConfig.ReconnectMaxInterval = defaultConnectRetryInterval
+	}
 	channelPool := &ChannelPool{
-		channels:      make(chan *Channel, poolCap),
+		channels:      make([]*Channel, 0, poolConfig.MaxSize),
 		factory:       factory,
 		config:        config,
+		poolConfig:    poolConfig,
 		serialization: serialization,
+		notifyCh:      make(chan struct{}, 1),
+		closeCh:       make(chan struct{}),
 	}
-	for i := 0; i < poolCap; i++ {
-		conn, err := factory()
+	for i := 0; i < poolConfig.MinSize; i++ {
+		channel, err := channelPool.newChannel()
 		if err != nil {
 			channelPool.Close()
 			return nil, err
 		}
-		_ = conn.(*net.TCPConn).SetNoDelay(true)
-		channelPool.channels <- buildChannel(conn, config, serialization)
+		channelPool.channels = append(channelPool.channels, channel)
 	}
+	registerChannelPoolSamplers()
+	channelPools.Store(channelPool, true)
+	go channelPool.maintain()
 	return channelPool, nil
 }
 
@@ -732,9 +1143,15 @@ func buildChannel(conn net.Conn, config *Config, serialization motan.Serializati
 		sendCh:        make(chan sendReady, 256),
 		streams:       make(map[uint64]*Stream, 64),
 		heartbeats:    make(map[uint64]*Stream),
+		callStreams:   make(map[uint64]*clientStream),
 		shutdownCh:    make(chan struct{}),
 		serialization: serialization,
+		codec:         config.Codec,
 		address:       conn.RemoteAddr().String(),
+		lastActive:    time.Now().UnixNano(),
+	}
+	if channel.codec == nil {
+		channel.codec = mpro.NewCodec(mpro.CodecMotan2, serialization)
 	}
 
 	go channel.recv()
@@ -1,11 +1,16 @@
 package endpoint
 
 import (
+	"bufio"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"github.com/weibocom/motan-go/protocol"
 	"net"
 	"runtime"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -87,6 +92,254 @@ func TestMotanEndpoint_RequestTimeout(t *testing.T) {
 	ep.Destroy()
 }
 
+func TestMotanEndpoint_Cancel(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer lis.Close()
+	// the server never responds, and records the request id of requests and cancel messages
+	requestCh := make(chan uint64, 1)
+	cancelCh := make(chan uint64, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := bufio.NewReader(conn)
+		for {
+			msg, err := protocol.Decode(buf)
+			if err != nil {
+				return
+			}
+			if msg.Header.IsCancel() {
+				cancelCh <- msg.Header.RequestID
+			} else if !msg.Header.IsHeartbeat() {
+				requestCh <- msg.Header.RequestID
+			}
+		}
+	}()
+	url := &motan.URL{Host: "127.0.0.1", Port: lis.Addr().(*net.TCPAddr).Port, Protocol: "motan2"}
+	url.PutParam(motan.TimeOutKey, "1000")
+	url.PutParam(motan.ErrorCountThresholdKey, "1")
+	url.PutParam(motan.ClientConnectionKey, "1")
+	// the cancel notify is disabled by default
+	defaultURL := url.Copy()
+	defaultURL.Port = 8989
+	ep := &MotanEndpoint{}
+	ep.SetURL(defaultURL)
+	ep.SetSerialization(&serialize.SimpleSerialization{})
+	ep.Initialize()
+	assert.False(t, ep.cancelNotify)
+	ep.Destroy()
+
+	url.PutParam(motan.CancelNotifyKey, "true")
+	ep = &MotanEndpoint{}
+	ep.SetURL(url)
+	ep.SetProxy(true)
+	ep.SetSerialization(&serialize.SimpleSerialization{})
+	ep.Initialize()
+	defer ep.Destroy()
+	assert.True(t, ep.cancelNotify)
+
+	request := &motan.MotanRequest{ServiceName: "test", Method: "test"}
+	request.Attachment = motan.NewStringMap(0)
+	request.GetRPCContext(true).CancelCh = make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(request.GetRPCContext(true).CancelCh)
+	}()
+	start := time.Now()
+	res := ep.Call(request)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	assert.Contains(t, res.GetException().ErrMsg, ErrRequestCanceled.Error())
+	// canceled request is not an error of the endpoint
+	assert.True(t, ep.IsAvailable())
+	requestID := <-requestCh
+	select {
+	case id := <-cancelCh:
+		assert.Equal(t, requestID, id)
+	case <-time.After(time.Second):
+		t.Errorf("cancel message not received")
+	}
+
+	// request canceled before sending, the server is not notified
+	res = ep.Call(request)
+	assert.Contains(t, res.GetException().ErrMsg, ErrRequestCanceled.Error())
+	select {
+	case id := <-cancelCh:
+		t.Errorf("unexpected cancel message of an unsent request: %d", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMotanEndpoint_UnixSock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "motan-uds")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	sockFile := filepath.Join(dir, "agent.sock")
+	lis, err := motan.ListenUnixSock(sockFile)
+	assert.Nil(t, err)
+	defer lis.Close()
+	go serveEcho(lis)
+
+	for _, url := range []*motan.URL{
+		{Host: "127.0.0.1", Port: 9982, Protocol: "motan2", Parameters: map[string]string{motan.UnixSockKey: sockFile}},
+		{Host: motan.UnixSockProtocolFlag + sockFile, Protocol: "motan2", Parameters: map[string]string{}},
+	} {
+		url.PutParam(motan.TimeOutKey, "1000")
+		url.PutParam(motan.KeepaliveIntervalKey, "10")
+		url.PutParam(motan.ErrorCountThresholdKey, "1")
+		ep := &MotanEndpoint{}
+		ep.SetURL(url)
+		ep.SetSerialization(&serialize.SimpleSerialization{})
+		ep.Initialize()
+		assert.True(t, ep.IsAvailable())
+		request := &motan.MotanRequest{ServiceName: "test", Method: "test", Arguments: []interface{}{"uds"}}
+		request.Attachment = motan.NewStringMap(0)
+		var reply string
+		request.GetRPCContext(true).Reply = &reply
+		res := ep.Call(request)
+		assert.Nil(t, res.GetException())
+		assert.Equal(t, "uds", reply)
+
+		// keepalive by heartbeat after the endpoint is unavailable
+		ep.setAvailable(false)
+		ep.recordErrAndKeepalive()
+		time.Sleep(100 * time.Millisecond)
+		assert.True(t, ep.IsAvailable())
+		ep.Destroy()
+	}
+	assert.Equal(t, motan.UnixSockProtocolFlag+sockFile, (&motan.URL{Host: motan.UnixSockProtocolFlag + sockFile}).GetAddressStr())
+}
+
+// serveEcho responds heartbeats and echoes the request body
+func TestChannelPool(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer lis.Close()
+	go serveEcho(lis)
+	oldInterval := channelPoolMaintainInterval
+	channelPoolMaintainInterval = 20 * time.Millisecond
+	defer func() { channelPoolMaintainInterval = oldInterval }()
+
+	factory := func() (net.Conn, error) {
+		return net.DialTimeout("tcp", lis.Addr().String(), time.Second)
+	}
+	poolConfig := ChannelPoolConfig{MinSize: 1, MaxSize: 2, MaxPending: 1, IdleTimeout: 200 * time.Millisecond, ReconnectBaseInterval: 10 * time.Millisecond, ReconnectMaxInterval: 50 * time.Millisecond}
+	pool, err := NewChannelPoolWithConfig(poolConfig, factory, nil, nil)
+	assert.Nil(t, err)
+	defer pool.Close()
+	assert.Equal(t, ChannelPoolStats{Active: 1}, pool.Stats())
+
+	// grow when the least busy channel has too many pending streams
+	first, err := pool.Get()
+	assert.Nil(t, err)
+	stream, err := first.NewStream(&protocol.Message{Header: protocol.BuildRequestHeader(0), Metadata: motan.NewStringMap(0)}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, first.PendingCount())
+	pool.Get()
+	waitFor(t, func() bool { return pool.Stats().Active == 2 })
+	assert.Equal(t, 1, pool.Stats().Pending)
+	second, err := pool.Get()
+	assert.Nil(t, err)
+	assert.True(t, first != second)
+
+	// idle channels above the min size are reaped
+	stream.Close()
+	waitFor(t, func() bool { return pool.Stats().Active == 1 })
+
+	// unhealthy channels are skipped and replaced, they are drained before closing
+	channel, _ := pool.Get()
+	stream, err = channel.NewStream(&protocol.Message{Header: protocol.BuildRequestHeader(0), Metadata: motan.NewStringMap(0)}, nil)
+	assert.Nil(t, err)
+	atomic.StoreInt32(&channel.timeouts, channelUnhealthyTimeouts)
+	assert.False(t, channel.IsHealthy())
+	_, err = pool.Get()
+	assert.Equal(t, ErrNoAvailableChannel, err)
+	waitFor(t, func() bool {
+		c, err := pool.Get()
+		return err == nil && c != channel
+	})
+	select {
+	case <-channel.shutdownCh:
+		t.Errorf("the unhealthy channel is closed with pending streams")
+	default:
+	}
+	stream.Close()
+	select {
+	case <-channel.shutdownCh:
+	case <-time.After(time.Second):
+		t.Errorf("the drained channel is not closed")
+	}
+
+	// broken channels are rebuilt in background
+	channel, _ = pool.Get()
+	channel.Close()
+	waitFor(t, func() bool {
+		c, err := pool.Get()
+		return err == nil && c != channel
+	})
+	res, err := pool.Get()
+	assert.Nil(t, err)
+	msg, err := res.Call(protocol.BuildHeartbeat(0, protocol.Req), time.Second, nil)
+	assert.Nil(t, err)
+	assert.True(t, msg.Header.IsHeartbeat())
+	assert.Equal(t, int64(2), pool.Stats().Reconnects)
+
+	pool.Close()
+	_, err = pool.Get()
+	assert.Equal(t, ErrChannelPoolClosed, err)
+	assert.True(t, res.IsClosed())
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	base, max := 100*time.Millisecond, 2*time.Second
+	for retries := 1; retries < 100; retries++ {
+		expect := max
+		if retries <= 5 {
+			expect = base << uint(retries-1)
+		}
+		backoff := reconnectBackoff(retries, base, max)
+		assert.True(t, backoff >= expect/2 && backoff <= expect, "retries: %d, backoff: %v", retries, backoff)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("wait for condition timeout")
+}
+
+func serveEcho(lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			buf := bufio.NewReader(conn)
+			for {
+				msg, err := protocol.Decode(buf)
+				if err != nil {
+					return
+				}
+				var res *protocol.Message
+				if msg.Header.IsHeartbeat() {
+					res = protocol.BuildHeartbeat(msg.Header.RequestID, protocol.Res)
+				} else {
+					res = &protocol.Message{Header: protocol.BuildResponseHeader(msg.Header.RequestID, protocol.Normal), Metadata: motan.NewStringMap(0), Body: msg.Body, Type: protocol.Res}
+				}
+				conn.Write(res.Encode().Bytes())
+			}
+		}(conn)
+	}
+}
+
 func StartTestServer(port int) *MockServer {
 	m := &MockServer{Port: port}
 	m.Start()
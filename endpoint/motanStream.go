@@ -0,0 +1,216 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// NewStream opens a streaming call on a channel of the endpoint.
+// The stream frames share the channel with unary calls, they are identified by the request id of the opening frame
+func (m *MotanEndpoint) NewStream(request motan.Request) (motan.ClientStream, error) {
+	if m.codec != nil && m.codec.GetName() != mpro.CodecMotan2 {
+		return nil, motan.ErrStreamNotSupported
+	}
+	if m.channels == nil {
+		m.recordErrAndKeepalive()
+		return nil, errors.New("motanEndpoint error: channels is null")
+	}
+	channel, err := m.channels.Get()
+	if err != nil {
+		m.recordErrAndKeepalive()
+		return nil, err
+	}
+	group := GetRequestGroup(request)
+	if group != m.url.Group && m.url.Group != "" {
+		request.SetAttachment(mpro.MGroup, m.url.Group)
+	}
+	msg, err := mpro.ConvertToReqMessage(request, m.serialization)
+	if err != nil {
+		return nil, err
+	}
+	window := int(m.url.GetPositiveIntValue(motan.StreamWindowKey, mpro.DefaultStreamWindow))
+	return channel.OpenStream(msg, window, m.serialization)
+}
+
+// OpenStream sends the opening frame of a stream, the window is used by both directions of the stream
+func (c *Channel) OpenStream(msg *mpro.Message, window int, serialization motan.Serialization) (motan.ClientStream, error) {
+	if c.IsClosed() {
+		return nil, ErrChannelShutdown
+	}
+	msg.Header.RequestID = GenerateRequestID()
+	msg.Header.SetStream(true)
+	msg.Metadata.Store(mpro.MStreamWindow, strconv.Itoa(window))
+	s := &clientStream{
+		channel:       c,
+		requestID:     msg.Header.RequestID,
+		serialization: serialization,
+		recvCh:        make(chan *mpro.Message, window+1),
+		sendWindow:    mpro.NewSendWindow(window),
+		recvWindow:    mpro.NewRecvWindow(window),
+		done:          make(chan struct{}),
+	}
+	c.callStreamLock.Lock()
+	c.callStreams[s.requestID] = s
+	c.callStreamLock.Unlock()
+	if err := s.write(msg); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *Channel) handleStreamFrame(msg *mpro.Message) {
+	c.callStreamLock.Lock()
+	s := c.callStreams[msg.Header.RequestID]
+	c.callStreamLock.Unlock()
+	if s == nil {
+		vlog.Warningf("handle stream frame, missing stream: %d, ep:%s", msg.Header.RequestID, c.address)
+		return
+	}
+	if increment, ok := mpro.GetWindowUpdate(msg); ok && len(msg.Body) == 0 {
+		s.sendWindow.Grant(increment)
+		return
+	}
+	select {
+	case s.recvCh <- msg:
+	default:
+		// the server sends more frames than the window, the stream can not continue
+		vlog.Warningf("stream receive window overflow, stream: %d, ep:%s", msg.Header.RequestID, c.address)
+		s.setErr(mpro.ErrStreamWindowOverflow)
+		s.Cancel()
+	}
+}
+
+type clientStream struct {
+	channel       *Channel
+	requestID     uint64
+	serialization motan.Serialization
+	recvCh        chan *mpro.Message
+	sendWindow    *mpro.SendWindow
+	recvWindow    *mpro.RecvWindow
+	sendClosed    bool
+	sendLock      sync.Mutex
+	errLock       sync.Mutex
+	err           error
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+func (s *clientStream) Send(v interface{}) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	if s.sendClosed {
+		return mpro.ErrStreamClosed
+	}
+	if s.serialization == nil {
+		return mpro.ErrSerializeNil
+	}
+	body, err := s.serialization.Serialize(v)
+	if err != nil {
+		return err
+	}
+	if err = s.sendWindow.Acquire(s.done); err != nil {
+		return s.closedErr(err)
+	}
+	return s.write(mpro.BuildStreamFrame(s.requestID, mpro.Req, s.serialization.GetSerialNum(), body))
+}
+
+func (s *clientStream) CloseSend() error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	if s.sendClosed {
+		return nil
+	}
+	s.sendClosed = true
+	return s.write(mpro.BuildStreamEnd(s.requestID, mpro.Req, ""))
+}
+
+func (s *clientStream) Recv(reply interface{}) error {
+	select {
+	case msg := <-s.recvCh:
+		if msg.Header.IsEndOfStream() {
+			s.close()
+			if msg.Header.GetStatus() == mpro.Exception {
+				return parseStreamException(msg)
+			}
+			return io.EOF
+		}
+		if s.serialization == nil {
+			return mpro.ErrSerializeNil
+		}
+		if _, err := s.serialization.DeSerialize(msg.Body, reply); err != nil {
+			return err
+		}
+		if increment := s.recvWindow.Consume(); increment > 0 {
+			s.write(mpro.BuildWindowUpdate(s.requestID, mpro.Req, increment))
+		}
+		return nil
+	case <-s.done:
+		return s.closedErr(mpro.ErrStreamClosed)
+	case <-s.channel.shutdownCh:
+		s.close()
+		return ErrChannelShutdown
+	}
+}
+
+func (s *clientStream) Cancel() {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	s.channel.Cancel(s.requestID)
+	s.close()
+}
+
+func (s *clientStream) close() {
+	s.closeOnce.Do(func() {
+		s.channel.callStreamLock.Lock()
+		delete(s.channel.callStreams, s.requestID)
+		s.channel.callStreamLock.Unlock()
+		close(s.done)
+	})
+}
+
+func (s *clientStream) setErr(err error) {
+	s.errLock.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.errLock.Unlock()
+}
+
+func (s *clientStream) closedErr(err error) error {
+	s.errLock.Lock()
+	defer s.errLock.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
+
+func (s *clientStream) write(msg *mpro.Message) error {
+	select {
+	case s.channel.sendCh <- sendReady{data: msg.Encode().Bytes()}:
+		return nil
+	case <-s.done:
+		return s.closedErr(mpro.ErrStreamClosed)
+	case <-s.channel.shutdownCh:
+		return ErrChannelShutdown
+	}
+}
+
+func parseStreamException(msg *mpro.Message) error {
+	var exception *motan.Exception
+	if err := json.Unmarshal([]byte(msg.Metadata.LoadOrEmpty(mpro.MExceptionn)), &exception); err != nil || exception == nil {
+		return errors.New("stream exception: " + msg.Metadata.LoadOrEmpty(mpro.MExceptionn))
+	}
+	return errors.New(exception.ErrMsg)
+}
@@ -0,0 +1,278 @@
+package filter
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+)
+
+const (
+	AdaptiveLimitAlgorithmField = "adaptiveLimit.algorithm" // aimd or gradient
+	AdaptiveLimitInitField      = "adaptiveLimit.initLimit"
+	AdaptiveLimitMinField       = "adaptiveLimit.minLimit"
+	AdaptiveLimitMaxField       = "adaptiveLimit.maxLimit"
+	AdaptiveLimitBackoffField   = "adaptiveLimit.backoffRatio" // limit ratio kept when request dropped
+	AdaptiveLimitTimeoutField   = "adaptiveLimit.timeout"      // ms, aimd: request slower than it is treated as dropped
+	AdaptiveLimitToleranceField = "adaptiveLimit.tolerance"    // gradient: tolerated ratio of short rtt to long rtt
+	AdaptiveLimitSmoothingField = "adaptiveLimit.smoothing"    // gradient: weight of new limit
+
+	AdaptiveLimitAIMD     = "aimd"
+	AdaptiveLimitGradient = "gradient"
+
+	defaultAdaptiveInitLimit    = 20
+	defaultAdaptiveMinLimit     = 1
+	defaultAdaptiveMaxLimit     = 1000
+	defaultAdaptiveBackoff      = 0.9
+	defaultAdaptiveTimeout      = 1000
+	defaultAdaptiveTolerance    = 1.5
+	defaultAdaptiveSmoothing    = 0.2
+	gradientLongRttWindow       = 100
+	adaptiveLimitErrCode        = 503
+	adaptiveLimitMetricsKey     = ":adaptiveLimit"
+	adaptiveLimitSuffix         = ".limit"
+	adaptiveLimitInflightSuffix = ".inflight"
+	adaptiveLimitRejectSuffix   = ".reject_count"
+)
+
+// adaptiveLimitAlgorithm calculates the new limit from a request sample
+type adaptiveLimitAlgorithm interface {
+	update(limit float64, rtt time.Duration, inflight int, dropped bool) float64
+}
+
+// aimdAlgorithm increases the limit by one when the limit is nearly used up, and decreases it by backoff ratio when request dropped or timeout
+type aimdAlgorithm struct {
+	backoffRatio float64
+	timeout      time.Duration
+}
+
+func (a *aimdAlgorithm) update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	if dropped || (a.timeout > 0 && rtt > a.timeout) {
+		return limit * a.backoffRatio
+	}
+	if float64(inflight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// gradientAlgorithm adjusts the limit by the gradient of long-term average rtt to current rtt, like netflix Gradient2Limit.
+// new limit = limit * gradient + sqrt(limit), the sqrt(limit) is the allowed queue size
+type gradientAlgorithm struct {
+	backoffRatio float64
+	tolerance    float64
+	smoothing    float64
+	longRtt      float64 // exponential moving average of rtt in nanoseconds
+}
+
+func (g *gradientAlgorithm) update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	if dropped {
+		return limit * g.backoffRatio
+	}
+	shortRtt := float64(rtt)
+	if shortRtt <= 0 {
+		return limit
+	}
+	if g.longRtt == 0 {
+		g.longRtt = shortRtt
+	} else {
+		g.longRtt += (shortRtt - g.longRtt) / gradientLongRttWindow
+	}
+	// the limit is not fully used, the rtt can not indicate the capacity
+	if float64(inflight) < limit/2 {
+		return limit
+	}
+	gradient := math.Max(0.5, math.Min(1.0, g.tolerance*g.longRtt/shortRtt))
+	newLimit := limit*gradient + math.Sqrt(limit)
+	return limit*(1-g.smoothing) + newLimit*g.smoothing
+}
+
+type adaptiveLimiter struct {
+	lock      sync.Mutex
+	limit     float64
+	minLimit  float64
+	maxLimit  float64
+	inflight  int
+	algorithm adaptiveLimitAlgorithm
+}
+
+// acquire returns the inflight count including current request, false means the limit is exceeded
+func (l *adaptiveLimiter) acquire() (int, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if float64(l.inflight) >= math.Floor(l.limit) {
+		return l.inflight, false
+	}
+	l.inflight++
+	return l.inflight, true
+}
+
+// release returns the new limit, whether the integer limit changed and the inflight count after releasing
+func (l *adaptiveLimiter) release(inflight int, rtt time.Duration, dropped bool) (int, bool, int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.inflight--
+	old := int(l.limit)
+	l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, l.algorithm.update(l.limit, rtt, inflight, dropped)))
+	return int(l.limit), int(l.limit) != old, l.inflight
+}
+
+// exceeded checks the limit without acquiring
+func (l *adaptiveLimiter) exceeded() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return float64(l.inflight) >= math.Floor(l.limit)
+}
+
+func (l *adaptiveLimiter) getLimit() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return int(l.limit)
+}
+
+func (l *adaptiveLimiter) getInflight() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.inflight
+}
+
+// AdaptiveLimitFilter limits the inflight requests of a service(server side) or an endpoint(client side).
+// the concurrency limit is adjusted automatically by observed latency, requests over the limit are rejected immediately.
+type AdaptiveLimitFilter struct {
+	url      *motan.URL
+	switcher *motan.Switcher
+	limiter  *adaptiveLimiter
+	next     motan.EndPointFilter
+}
+
+func (a *AdaptiveLimitFilter) GetIndex() int {
+	return 5
+}
+
+func (a *AdaptiveLimitFilter) GetName() string {
+	return AdaptiveLimit
+}
+
+func (a *AdaptiveLimitFilter) NewFilter(url *motan.URL) motan.Filter {
+	minLimit := float64(url.GetPositiveIntValue(AdaptiveLimitMinField, defaultAdaptiveMinLimit))
+	maxLimit := math.Max(minLimit, float64(url.GetPositiveIntValue(AdaptiveLimitMaxField, defaultAdaptiveMaxLimit)))
+	initLimit := math.Max(minLimit, math.Min(maxLimit, float64(url.GetPositiveIntValue(AdaptiveLimitInitField, defaultAdaptiveInitLimit))))
+	backoff := getFloatParam(url, AdaptiveLimitBackoffField, defaultAdaptiveBackoff)
+	if backoff <= 0 || backoff >= 1 {
+		vlog.Warningf("[%s] illegal %s %v, use default", AdaptiveLimit, AdaptiveLimitBackoffField, backoff)
+		backoff = defaultAdaptiveBackoff
+	}
+	var algorithm adaptiveLimitAlgorithm
+	algorithmName := url.GetParam(AdaptiveLimitAlgorithmField, AdaptiveLimitGradient)
+	switch algorithmName {
+	case AdaptiveLimitAIMD:
+		algorithm = &aimdAlgorithm{
+			backoffRatio: backoff,
+			timeout:      url.GetTimeDuration(AdaptiveLimitTimeoutField, time.Millisecond, defaultAdaptiveTimeout*time.Millisecond),
+		}
+	default:
+		if algorithmName != AdaptiveLimitGradient {
+			vlog.Warningf("[%s] unknown algorithm %s, use %s", AdaptiveLimit, algorithmName, AdaptiveLimitGradient)
+			algorithmName = AdaptiveLimitGradient
+		}
+		smoothing := getFloatParam(url, AdaptiveLimitSmoothingField, defaultAdaptiveSmoothing)
+		if smoothing <= 0 || smoothing > 1 {
+			smoothing = defaultAdaptiveSmoothing
+		}
+		tolerance := getFloatParam(url, AdaptiveLimitToleranceField, defaultAdaptiveTolerance)
+		if tolerance < 1 {
+			tolerance = defaultAdaptiveTolerance
+		}
+		algorithm = &gradientAlgorithm{backoffRatio: backoff, tolerance: tolerance, smoothing: smoothing}
+	}
+
+	switcherName := GetAdaptiveLimitSwitcherName(url)
+	motan.GetSwitcherManager().Register(switcherName, true)
+	vlog.Infof("[%s] new adaptive limiter. url:%s, algorithm:%s, limit:%v, min:%v, max:%v", AdaptiveLimit, url.GetIdentity(), algorithmName, initLimit, minLimit, maxLimit)
+	return &AdaptiveLimitFilter{
+		url:      url,
+		switcher: motan.GetSwitcherManager().GetSwitcher(switcherName),
+		limiter:  &adaptiveLimiter{limit: initLimit, minLimit: minLimit, maxLimit: maxLimit, algorithm: algorithm},
+	}
+}
+
+func (a *AdaptiveLimitFilter) Filter(caller motan.Caller, request motan.Request) motan.Response {
+	if !a.switcher.IsOpen() {
+		return a.GetNext().Filter(caller, request)
+	}
+	metricsKey := "motan-client" + adaptiveLimitMetricsKey
+	if _, ok := caller.(motan.Provider); ok {
+		metricsKey = "motan-server" + adaptiveLimitMetricsKey
+	}
+	group, service := metrics.Escape(a.url.Group), metrics.Escape(a.url.Path)
+	inflight, ok := a.limiter.acquire()
+	if !ok {
+		metrics.AddCounter(group, service, metricsKey+adaptiveLimitRejectSuffix, 1)
+		vlog.Warningf("[%s] request rejected. req:%s, limit:%d, inflight:%d", AdaptiveLimit, motan.GetReqInfo(request), a.limiter.getLimit(), inflight)
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: adaptiveLimitErrCode, ErrMsg: "adaptive concurrency limit exceeded", ErrType: motan.ServiceException})
+	}
+	metrics.AddGauge(group, service, metricsKey+adaptiveLimitInflightSuffix, int64(inflight))
+	start := time.Now()
+	response := a.GetNext().Filter(caller, request)
+	dropped := response.GetException() != nil && response.GetException().ErrType != motan.BizException
+	limit, changed, remaining := a.limiter.release(inflight, time.Since(start), dropped)
+	metrics.AddGauge(group, service, metricsKey+adaptiveLimitInflightSuffix, int64(remaining))
+	if changed {
+		metrics.AddGauge(group, service, metricsKey+adaptiveLimitSuffix, int64(limit))
+	}
+	return response
+}
+
+// FilterStream rejects the stream when the limit is exceeded. The stream does not hold the concurrency, its duration
+// is not a latency of the service
+func (a *AdaptiveLimitFilter) FilterStream(caller motan.Caller, request motan.Request) *motan.Exception {
+	if !a.switcher.IsOpen() || !a.limiter.exceeded() {
+		return nil
+	}
+	metricsKey := "motan-client" + adaptiveLimitMetricsKey
+	if _, ok := caller.(motan.Provider); ok {
+		metricsKey = "motan-server" + adaptiveLimitMetricsKey
+	}
+	metrics.AddCounter(metrics.Escape(a.url.Group), metrics.Escape(a.url.Path), metricsKey+adaptiveLimitRejectSuffix, 1)
+	vlog.Warningf("[%s] stream rejected. req:%s, limit:%d", AdaptiveLimit, motan.GetReqInfo(request), a.limiter.getLimit())
+	return &motan.Exception{ErrCode: adaptiveLimitErrCode, ErrMsg: "adaptive concurrency limit exceeded", ErrType: motan.ServiceException}
+}
+
+// GetLimit returns the current concurrency limit
+func (a *AdaptiveLimitFilter) GetLimit() int {
+	return a.limiter.getLimit()
+}
+
+func GetAdaptiveLimitSwitcherName(url *motan.URL) string {
+	return url.GetParam("conf-id", "") + "_" + AdaptiveLimit
+}
+
+func getFloatParam(url *motan.URL, key string, defaultValue float64) float64 {
+	if v := url.GetParam(key, ""); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+		vlog.Warningf("parse %s config error, use default %v", key, defaultValue)
+	}
+	return defaultValue
+}
+
+func (a *AdaptiveLimitFilter) HasNext() bool {
+	return a.next != nil
+}
+
+func (a *AdaptiveLimitFilter) SetNext(nextFilter motan.EndPointFilter) {
+	a.next = nextFilter
+}
+
+func (a *AdaptiveLimitFilter) GetNext() motan.EndPointFilter {
+	return a.next
+}
+
+func (a *AdaptiveLimitFilter) GetType() int32 {
+	return motan.EndPointFilterType
+}
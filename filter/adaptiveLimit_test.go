@@ -0,0 +1,131 @@
+package filter
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+type blockingFilter struct {
+	release   chan struct{}
+	exception *motan.Exception
+}
+
+func (b *blockingFilter) Filter(caller motan.Caller, request motan.Request) motan.Response {
+	if b.release != nil {
+		<-b.release
+	}
+	if b.exception != nil {
+		return motan.BuildExceptionResponse(request.GetRequestID(), b.exception)
+	}
+	return &motan.MotanResponse{RequestID: request.GetRequestID()}
+}
+
+func (b *blockingFilter) NewFilter(url *motan.URL) motan.Filter   { return b }
+func (b *blockingFilter) GetName() string                         { return "blocking" }
+func (b *blockingFilter) HasNext() bool                           { return false }
+func (b *blockingFilter) GetIndex() int                           { return 100 }
+func (b *blockingFilter) GetType() int32                          { return motan.EndPointFilterType }
+func (b *blockingFilter) SetNext(nextFilter motan.EndPointFilter) {}
+func (b *blockingFilter) GetNext() motan.EndPointFilter           { return nil }
+
+func TestAdaptiveLimitFilter(t *testing.T) {
+	factory := initFactory()
+	url := mockURL()
+	url.PutParam("conf-id", "adaptive")
+	url.PutParam(AdaptiveLimitAlgorithmField, AdaptiveLimitAIMD)
+	url.PutParam(AdaptiveLimitInitField, "2")
+	url.PutParam(AdaptiveLimitMaxField, "3")
+	f := factory.GetFilter(AdaptiveLimit).NewFilter(url).(*AdaptiveLimitFilter)
+	next := &blockingFilter{release: make(chan struct{})}
+	f.SetNext(next)
+	provider := factory.GetProvider(mockURL())
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, f.Filter(provider, defaultRequest()).GetException())
+		}()
+	}
+	for f.limiter.getInflight() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	res := f.Filter(provider, defaultRequest())
+	assert.Equal(t, adaptiveLimitErrCode, res.GetException().ErrCode)
+
+	// limit increased by the full used requests, but not over the max limit
+	close(next.release)
+	wg.Wait()
+	assert.Equal(t, 3, f.GetLimit())
+	assert.Nil(t, f.Filter(provider, defaultRequest()).GetException())
+
+	// backoff on failure
+	next.exception = &motan.Exception{ErrCode: 500, ErrType: motan.ServiceException}
+	f.Filter(provider, defaultRequest())
+	assert.Equal(t, 2, f.GetLimit())
+	next.exception = &motan.Exception{ErrCode: 500, ErrType: motan.BizException}
+	f.Filter(provider, defaultRequest())
+	assert.Equal(t, 2, f.GetLimit())
+
+	// kill switch
+	motan.GetSwitcherManager().GetSwitcher(GetAdaptiveLimitSwitcherName(url)).SetValue(false)
+	next.release = make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.Filter(provider, defaultRequest())
+		}()
+	}
+	close(next.release)
+	wg.Wait()
+	assert.Equal(t, 0, f.limiter.getInflight())
+	assert.Equal(t, 2, f.GetLimit())
+}
+
+func TestGradientAlgorithm(t *testing.T) {
+	limiter := &adaptiveLimiter{limit: 20, minLimit: 1, maxLimit: 100,
+		algorithm: &gradientAlgorithm{backoffRatio: 0.9, tolerance: 1.5, smoothing: 0.2}}
+
+	// stable latency increases the limit
+	for i := 0; i < 10; i++ {
+		inflight, ok := limiter.acquire()
+		assert.True(t, ok)
+		assert.Equal(t, 1, inflight)
+		_, _, remaining := limiter.release(inflight+int(limiter.limit), 10*time.Millisecond, false)
+		assert.Equal(t, 0, remaining)
+	}
+	assert.True(t, limiter.getLimit() > 20)
+	increased := limiter.getLimit()
+
+	// latency is not considered when the limit is not fully used
+	limiter.release(1, time.Second, false)
+	assert.Equal(t, increased, limiter.getLimit())
+
+	// latency rising decreases the limit
+	for i := 0; i < 10; i++ {
+		limiter.release(limiter.getLimit(), 100*time.Millisecond, false)
+	}
+	assert.True(t, limiter.getLimit() < increased)
+
+	// dropped request
+	limit := limiter.limit
+	limiter.release(limiter.getLimit(), time.Millisecond, true)
+	assert.Equal(t, limit*0.9, limiter.limit)
+
+	// limit not less than min limit
+	for i := 0; i < 100; i++ {
+		limiter.release(1, time.Millisecond, true)
+	}
+	assert.Equal(t, 1, limiter.getLimit())
+	limiter.inflight = 0
+	_, ok := limiter.acquire()
+	assert.True(t, ok)
+	_, ok = limiter.acquire()
+	assert.False(t, ok)
+}
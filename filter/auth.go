@@ -0,0 +1,344 @@
+package filter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cfg "github.com/weibocom/motan-go/config"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+const (
+	// AuthSecretKey is the url parameter of the secret used to sign requests on client side,
+	// and it's also the default secret on server side if the caller application has no secret in config
+	AuthSecretKey = "auth.secret"
+
+	authConfigSection    = "auth"
+	authAnyMatch         = "*"
+	defaultTimestampSkew = 300 // seconds
+	authErrCode          = 403
+)
+
+var (
+	authPolicyValue atomic.Value // *authPolicy
+	authInitOnce    sync.Once
+
+	errAuthNoApplication  = errors.New("caller application is empty")
+	errAuthNoSignature    = errors.New("missing auth signature")
+	errAuthTimestamp      = errors.New("auth timestamp expired or invalid")
+	errAuthNoSecret       = errors.New("no auth secret for caller application")
+	errAuthWrongSignature = errors.New("auth signature not correct")
+	errAuthDenied         = errors.New("caller is denied by acl rules")
+)
+
+// AuthConfig is the config of the 'auth' section, e.g.
+//
+//	auth:
+//	  timestampSkew: 300
+//	  secrets:
+//	    app-a: secret-a
+//	  rules:
+//	  - service: com.weibo.HelloService
+//	    method: hello
+//	    allow:
+//	      applications: [app-a]
+//	      ips: [10.0.0.0/8]
+//	    deny:
+//	      ips: [10.1.1.1]
+type AuthConfig struct {
+	TimestampSkew int               `mapstructure:"timestampSkew"`
+	Secrets       map[string]string `mapstructure:"secrets"`
+	Rules         []AuthRule        `mapstructure:"rules"`
+}
+
+// AuthRule is the allow/deny rule for a service or method, empty service or method means all
+type AuthRule struct {
+	Service string      `mapstructure:"service"`
+	Method  string      `mapstructure:"method"`
+	Allow   AuthMatcher `mapstructure:"allow"`
+	Deny    AuthMatcher `mapstructure:"deny"`
+}
+
+// AuthMatcher matches caller by application or remote ip(or CIDR)
+type AuthMatcher struct {
+	Applications []string `mapstructure:"applications"`
+	IPs          []string `mapstructure:"ips"`
+}
+
+type authMatcher struct {
+	applications map[string]bool
+	ips          map[string]bool
+	nets         []*net.IPNet
+}
+
+func newAuthMatcher(m AuthMatcher) *authMatcher {
+	matcher := &authMatcher{applications: make(map[string]bool, len(m.Applications)), ips: make(map[string]bool, len(m.IPs))}
+	for _, app := range m.Applications {
+		matcher.applications[app] = true
+	}
+	for _, ip := range m.IPs {
+		if _, ipNet, err := net.ParseCIDR(ip); err == nil {
+			matcher.nets = append(matcher.nets, ipNet)
+		} else {
+			matcher.ips[ip] = true
+		}
+	}
+	return matcher
+}
+
+func (m *authMatcher) isEmpty() bool {
+	return len(m.applications) == 0 && len(m.ips) == 0 && len(m.nets) == 0
+}
+
+func (m *authMatcher) match(application string, ip string) bool {
+	if m.applications[authAnyMatch] || m.applications[application] || m.ips[authAnyMatch] || m.ips[ip] {
+		return true
+	}
+	if len(m.nets) > 0 {
+		if parsedIP := net.ParseIP(ip); parsedIP != nil {
+			for _, n := range m.nets {
+				if n.Contains(parsedIP) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+type authRule struct {
+	allow *authMatcher
+	deny  *authMatcher
+}
+
+type authPolicy struct {
+	timestampSkew int64
+	secrets       map[string]string
+	rules         map[string]map[string]*authRule // service -> method -> rule
+}
+
+func newAuthPolicy(config *AuthConfig) *authPolicy {
+	p := &authPolicy{
+		timestampSkew: int64(config.TimestampSkew),
+		secrets:       config.Secrets,
+		rules:         make(map[string]map[string]*authRule, len(config.Rules)),
+	}
+	if p.timestampSkew <= 0 {
+		p.timestampSkew = defaultTimestampSkew
+	}
+	if p.secrets == nil {
+		p.secrets = make(map[string]string)
+	}
+	for _, r := range config.Rules {
+		service, method := r.Service, r.Method
+		if service == "" {
+			service = authAnyMatch
+		}
+		if method == "" {
+			method = authAnyMatch
+		}
+		if p.rules[service] == nil {
+			p.rules[service] = make(map[string]*authRule)
+		}
+		p.rules[service][method] = &authRule{allow: newAuthMatcher(r.Allow), deny: newAuthMatcher(r.Deny)}
+	}
+	return p
+}
+
+// check the acl rules from the most specific one: service+method, service, all services.
+// deny rule matched in any level rejects the caller, the first level with allow rule decides the result
+func (p *authPolicy) checkACL(service string, method string, application string, ip string) error {
+	rules := make([]*authRule, 0, 4)
+	for _, s := range [2]string{service, authAnyMatch} {
+		if methodRules := p.rules[s]; methodRules != nil {
+			for _, m := range [2]string{method, authAnyMatch} {
+				if rule := methodRules[m]; rule != nil {
+					rules = append(rules, rule)
+				}
+			}
+		}
+	}
+	for _, rule := range rules {
+		if rule.deny.match(application, ip) {
+			return errAuthDenied
+		}
+	}
+	for _, rule := range rules {
+		if !rule.allow.isEmpty() {
+			if rule.allow.match(application, ip) {
+				return nil
+			}
+			return errAuthDenied
+		}
+	}
+	return nil
+}
+
+func getAuthPolicy() *authPolicy {
+	if p, ok := authPolicyValue.Load().(*authPolicy); ok {
+		return p
+	}
+	return nil
+}
+
+// SetAuthConfig replace current auth secrets and acl rules
+func SetAuthConfig(config *AuthConfig) {
+	if config == nil {
+		config = &AuthConfig{}
+	}
+	authPolicyValue.Store(newAuthPolicy(config))
+	vlog.Infof("[auth] auth config updated. secrets size:%d, rules size:%d", len(config.Secrets), len(config.Rules))
+}
+
+// LoadAuthConfig load auth config from the 'auth' section of config, it can be called again for hot reload
+func LoadAuthConfig(config *cfg.Config) error {
+	authConfig := &AuthConfig{}
+	if config != nil {
+		if _, err := config.GetSection(authConfigSection); err == nil {
+			if err = config.GetStruct(authConfigSection, authConfig); err != nil {
+				vlog.Errorf("[auth] parse auth config fail, the old config will be used. err:%v", err)
+				return err
+			}
+		}
+	}
+	SetAuthConfig(authConfig)
+	return nil
+}
+
+// SignRequest calculate the auth signature: hex(hmac-sha256(secret, application\ntimestamp\nservice\nmethod))
+func SignRequest(secret string, application string, timestamp string, service string, method string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(application))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(service))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(method))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AuthFilter signs requests on client side and authenticates callers on server side.
+// the caller is authenticated by application, timestamp and signature attachments, then the acl rules
+// keyed by caller application and remote ip are checked.
+type AuthFilter struct {
+	url    *motan.URL
+	secret string
+	next   motan.EndPointFilter
+}
+
+func (a *AuthFilter) GetIndex() int {
+	return 4
+}
+
+func (a *AuthFilter) GetName() string {
+	return Auth
+}
+
+func (a *AuthFilter) NewFilter(url *motan.URL) motan.Filter {
+	return &AuthFilter{url: url, secret: url.GetParam(AuthSecretKey, "")}
+}
+
+func (a *AuthFilter) SetContext(context *motan.Context) {
+	authInitOnce.Do(func() {
+		if context != nil {
+			LoadAuthConfig(context.Config)
+		}
+	})
+}
+
+func (a *AuthFilter) Filter(caller motan.Caller, request motan.Request) motan.Response {
+	if _, ok := caller.(motan.Provider); ok {
+		if err := a.authenticate(request); err != nil {
+			vlog.Warningf("[auth] request rejected. req:%s, application:%s, ip:%s, err:%s", motan.GetReqInfo(request), request.GetAttachment(protocol.MSource), request.GetAttachment(motan.HostKey), err.Error())
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: authErrCode, ErrMsg: "auth fail: " + err.Error(), ErrType: motan.ServiceException})
+		}
+	} else if a.secret != "" {
+		a.sign(request)
+	}
+	return a.GetNext().Filter(caller, request)
+}
+
+// FilterStream authenticates the caller of the stream on server side, and signs the stream request on client side
+func (a *AuthFilter) FilterStream(caller motan.Caller, request motan.Request) *motan.Exception {
+	if _, ok := caller.(motan.Provider); ok {
+		if err := a.authenticate(request); err != nil {
+			vlog.Warningf("[auth] stream rejected. req:%s, application:%s, ip:%s, err:%s", motan.GetReqInfo(request), request.GetAttachment(protocol.MSource), request.GetAttachment(motan.HostKey), err.Error())
+			return &motan.Exception{ErrCode: authErrCode, ErrMsg: "auth fail: " + err.Error(), ErrType: motan.ServiceException}
+		}
+	} else if a.secret != "" {
+		a.sign(request)
+	}
+	return nil
+}
+
+func (a *AuthFilter) sign(request motan.Request) {
+	application := request.GetAttachment(protocol.MSource)
+	if application == "" {
+		application = a.url.GetParam(motan.ApplicationKey, "")
+		request.SetAttachment(protocol.MSource, application)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.SetAttachment(protocol.MAuthTimestamp, timestamp)
+	request.SetAttachment(protocol.MAuthSignature, SignRequest(a.secret, application, timestamp, request.GetServiceName(), request.GetMethod()))
+}
+
+func (a *AuthFilter) authenticate(request motan.Request) error {
+	policy := getAuthPolicy()
+	if policy == nil {
+		policy = newAuthPolicy(&AuthConfig{})
+	}
+	application := request.GetAttachment(protocol.MSource)
+	if application == "" {
+		return errAuthNoApplication
+	}
+	signature := request.GetAttachment(protocol.MAuthSignature)
+	if signature == "" {
+		return errAuthNoSignature
+	}
+	timestamp := request.GetAttachment(protocol.MAuthTimestamp)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errAuthTimestamp
+	}
+	if diff := time.Now().Unix() - ts; diff > policy.timestampSkew || diff < -policy.timestampSkew {
+		return errAuthTimestamp
+	}
+	secret := policy.secrets[application]
+	if secret == "" {
+		secret = a.secret
+	}
+	if secret == "" {
+		return errAuthNoSecret
+	}
+	expected := SignRequest(secret, application, timestamp, request.GetServiceName(), request.GetMethod())
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errAuthWrongSignature
+	}
+	return policy.checkACL(request.GetServiceName(), request.GetMethod(), application, request.GetAttachment(motan.HostKey))
+}
+
+func (a *AuthFilter) HasNext() bool {
+	return a.next != nil
+}
+
+func (a *AuthFilter) SetNext(nextFilter motan.EndPointFilter) {
+	a.next = nextFilter
+}
+
+func (a *AuthFilter) GetNext() motan.EndPointFilter {
+	return a.next
+}
+
+func (a *AuthFilter) GetType() int32 {
+	return motan.EndPointFilterType
+}
@@ -0,0 +1,106 @@
+package filter
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/config"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+const authTestConfig = `
+auth:
+  timestampSkew: 60
+  secrets:
+    app-a: secret-a
+    app-b: secret-b
+  rules:
+  - service: com.weibo.testService
+    deny:
+      ips: [10.1.1.1]
+  - service: com.weibo.testService
+    method: testMethod
+    allow:
+      applications: [app-a]
+      ips: [192.168.0.0/16]
+  - service: "*"
+    deny:
+      applications: [app-blocked]
+`
+
+func TestAuthFilter(t *testing.T) {
+	conf, err := config.NewConfigFromReader(bytes.NewReader([]byte(authTestConfig)))
+	assert.Nil(t, err)
+	assert.Nil(t, LoadAuthConfig(conf))
+	factory := initFactory()
+
+	newAuthFilter := func(secret string, application string) motan.EndPointFilter {
+		url := mockURL()
+		url.PutParam(motan.ApplicationKey, application)
+		url.PutParam(AuthSecretKey, secret)
+		f := factory.GetFilter(Auth).NewFilter(url).(motan.EndPointFilter)
+		f.SetNext(motan.GetLastEndPointFilter())
+		return f
+	}
+	clientFilter := func(secret string, application string) *motan.MotanRequest {
+		request := getRequest("com.weibo.testService", testGroup, testMethod)
+		res := newAuthFilter(secret, application).Filter(factory.GetEndPoint(mockURL()), request)
+		assert.Nil(t, res.GetException())
+		return request
+	}
+	serverFilter := func(request *motan.MotanRequest, ip string) motan.Response {
+		request.SetAttachment(motan.HostKey, ip)
+		return newAuthFilter("", "server-app").Filter(factory.GetProvider(mockURL()), request)
+	}
+
+	// signed by client filter
+	request := clientFilter("secret-a", "app-a")
+	assert.Equal(t, "app-a", request.GetAttachment(protocol.MSource))
+	assert.NotEqual(t, "", request.GetAttachment(protocol.MAuthSignature))
+	assert.Nil(t, serverFilter(request, "10.2.2.2").GetException())
+
+	// method rule not allowed application, but ip allowed
+	request = clientFilter("secret-b", "app-b")
+	res := serverFilter(request, "10.2.2.2")
+	assert.Equal(t, authErrCode, res.GetException().ErrCode)
+	request = clientFilter("secret-b", "app-b")
+	assert.Nil(t, serverFilter(request, "192.168.1.1").GetException())
+
+	// service deny rule
+	request = clientFilter("secret-a", "app-a")
+	assert.NotNil(t, serverFilter(request, "10.1.1.1").GetException())
+
+	// wrong secret
+	request = clientFilter("wrong", "app-a")
+	assert.Contains(t, serverFilter(request, "10.2.2.2").GetException().ErrMsg, errAuthWrongSignature.Error())
+
+	// no signature
+	request = getRequest("com.weibo.testService", testGroup, testMethod)
+	request.SetAttachment(protocol.MSource, "app-a")
+	assert.Contains(t, serverFilter(request, "10.2.2.2").GetException().ErrMsg, errAuthNoSignature.Error())
+
+	// expired timestamp
+	request = clientFilter("secret-a", "app-a")
+	timestamp := strconv.FormatInt(time.Now().Unix()-120, 10)
+	request.SetAttachment(protocol.MAuthTimestamp, timestamp)
+	request.SetAttachment(protocol.MAuthSignature, SignRequest("secret-a", "app-a", timestamp, request.GetServiceName(), request.GetMethod()))
+	assert.Contains(t, serverFilter(request, "10.2.2.2").GetException().ErrMsg, errAuthTimestamp.Error())
+
+	// global deny rule, the server url secret is used when application has no secret in config
+	request = clientFilter("secret-c", "app-blocked")
+	request.ServiceName = "com.weibo.otherService"
+	request.SetAttachment(protocol.MAuthSignature, SignRequest("secret-c", "app-blocked", request.GetAttachment(protocol.MAuthTimestamp), request.GetServiceName(), request.GetMethod()))
+	request.SetAttachment(motan.HostKey, "10.2.2.2")
+	serverWithSecret := newAuthFilter("secret-c", "server-app")
+	assert.Contains(t, serverWithSecret.Filter(factory.GetProvider(mockURL()), request).GetException().ErrMsg, errAuthDenied.Error())
+
+	// hot reload config without rules
+	SetAuthConfig(&AuthConfig{Secrets: map[string]string{"app-b": "secret-b"}})
+	request = clientFilter("secret-b", "app-b")
+	assert.Nil(t, serverFilter(request, "10.1.1.1").GetException())
+	SetAuthConfig(nil)
+}
@@ -0,0 +1,537 @@
+package filter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/ratelimit"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+)
+
+const (
+	clusterRateLimitPrefix = ClusterRateLimit + "."
+
+	// ClusterRateLimitBackendField is the name of quota backend, 'redis' or 'memory'
+	ClusterRateLimitBackendField = clusterRateLimitPrefix + "backend"
+	// ClusterRateLimitAddressField is the address of quota backend, such as redis 'host:port'
+	ClusterRateLimitAddressField = clusterRateLimitPrefix + "address"
+	// ClusterRateLimitPasswordField is the password of redis backend
+	ClusterRateLimitPasswordField = clusterRateLimitPrefix + "password"
+	// ClusterRateLimitTimeoutField is the timeout(ms) of backend operations
+	ClusterRateLimitTimeoutField = clusterRateLimitPrefix + "timeout"
+	// ClusterRateLimitLeaseField is the count of tokens leased from backend at once, default 1/10 of the quota
+	ClusterRateLimitLeaseField = clusterRateLimitPrefix + "leaseSize"
+	// ClusterRateLimitFallbackField is the ratio of quota used as local rate limit when the backend is unreachable
+	ClusterRateLimitFallbackField = clusterRateLimitPrefix + "fallbackRatio"
+	// ClusterRateLimitInstancesField is the instance count of the cluster, the fallback ratio is 1/instances by default.
+	// The requests are rejected when the backend is unreachable if neither the fallback ratio nor the instances is set
+	ClusterRateLimitInstancesField = clusterRateLimitPrefix + "instances"
+
+	QuotaBackendMemory = "memory"
+	QuotaBackendRedis  = "redis"
+
+	clusterQuotaServiceKey       = "*"
+	clusterRateLimitErrCode      = 503
+	defaultQuotaBackendTimeout   = 50 // ms
+	quotaBackendRetryInterval    = time.Second
+	redisQuotaMaxIdleConns       = 8
+	clusterRateLimitRemainSuffix = ".cluster_quota_remaining"
+	redisQuotaKeyPrefix          = "motan:quota:"
+	// redisQuotaLeaseScript sets the expiration with the first lease of the window atomically, so the window keys always
+	// expire. The keys are kept a little longer than the window for clock skew
+	redisQuotaLeaseScript = "local used = redis.call('INCRBY', KEYS[1], ARGV[1]) " +
+		"if used == tonumber(ARGV[1]) then redis.call('PEXPIRE', KEYS[1], ARGV[2]) end " +
+		"return used"
+	redisQuotaKeyExpire = "3000" // ms
+)
+
+var (
+	quotaBackendFactories = map[string]func(url *motan.URL) (QuotaBackend, error){
+		QuotaBackendMemory: func(url *motan.URL) (QuotaBackend, error) {
+			return NewMemoryQuotaBackend(), nil
+		},
+		QuotaBackendRedis: func(url *motan.URL) (QuotaBackend, error) {
+			address := url.GetParam(ClusterRateLimitAddressField, "")
+			if address == "" {
+				return nil, errors.New("redis quota backend address is empty")
+			}
+			return NewRedisQuotaBackend(address, url.GetParam(ClusterRateLimitPasswordField, ""),
+				url.GetTimeDuration(ClusterRateLimitTimeoutField, time.Millisecond, defaultQuotaBackendTimeout*time.Millisecond)), nil
+		},
+	}
+	quotaBackends    = make(map[string]QuotaBackend)
+	quotaBackendLock sync.Mutex
+
+	clusterRateLimitReservedKeys = map[string]bool{
+		ClusterRateLimitBackendField:   true,
+		ClusterRateLimitAddressField:   true,
+		ClusterRateLimitPasswordField:  true,
+		ClusterRateLimitTimeoutField:   true,
+		ClusterRateLimitLeaseField:     true,
+		ClusterRateLimitFallbackField:  true,
+		ClusterRateLimitInstancesField: true,
+	}
+)
+
+// QuotaBackend is the shared token store of cluster rate limit.
+// quota of a key is counted in windows of one second, all instances lease tokens from the same window.
+type QuotaBackend interface {
+	// Lease takes at most amount tokens from the quota of the key in the window(unix second),
+	// returns the granted tokens and the remaining quota of the window
+	Lease(key string, window int64, amount int64, limit int64) (granted int64, remaining int64, err error)
+}
+
+// RegistQuotaBackend registers a quota backend factory, the backend is selected by the 'clusterRateLimit.backend' parameter
+func RegistQuotaBackend(name string, factory func(url *motan.URL) (QuotaBackend, error)) {
+	quotaBackendLock.Lock()
+	defer quotaBackendLock.Unlock()
+	quotaBackendFactories[name] = factory
+}
+
+// getQuotaBackend returns the backend shared by filters with the same backend name and address
+func getQuotaBackend(url *motan.URL) (QuotaBackend, error) {
+	name := url.GetParam(ClusterRateLimitBackendField, QuotaBackendRedis)
+	key := name + "|" + url.GetParam(ClusterRateLimitAddressField, "")
+	quotaBackendLock.Lock()
+	defer quotaBackendLock.Unlock()
+	if backend, ok := quotaBackends[key]; ok {
+		return backend, nil
+	}
+	factory, ok := quotaBackendFactories[name]
+	if !ok {
+		return nil, errors.New("unknown quota backend " + name)
+	}
+	backend, err := factory(url)
+	if err != nil {
+		return nil, err
+	}
+	quotaBackends[key] = backend
+	return backend, nil
+}
+
+// calculate the lease result by the used tokens after this lease
+func leaseResult(used int64, amount int64, limit int64) (granted int64, remaining int64) {
+	granted = limit - (used - amount)
+	if granted > amount {
+		granted = amount
+	} else if granted < 0 {
+		granted = 0
+	}
+	remaining = limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return granted, remaining
+}
+
+type memoryQuota struct {
+	window int64
+	used   int64
+}
+
+// MemoryQuotaBackend keeps quota in process memory, it can be used by tests or single instance deployment
+type MemoryQuotaBackend struct {
+	lock   sync.Mutex
+	quotas map[string]*memoryQuota
+}
+
+func NewMemoryQuotaBackend() *MemoryQuotaBackend {
+	return &MemoryQuotaBackend{quotas: make(map[string]*memoryQuota)}
+}
+
+func (m *MemoryQuotaBackend) Lease(key string, window int64, amount int64, limit int64) (int64, int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	quota := m.quotas[key]
+	if quota == nil {
+		quota = &memoryQuota{}
+		m.quotas[key] = quota
+	}
+	if quota.window != window {
+		quota.window = window
+		quota.used = 0
+	}
+	// tokens over limit are not counted, so the failed lease will not affect others
+	granted, _ := leaseResult(quota.used+amount, amount, limit)
+	quota.used += granted
+	return granted, limit - quota.used, nil
+}
+
+// RedisQuotaBackend counts quota by INCRBY in a script on a redis protocol server, the window keys expire automatically.
+// The leases of different keys are sent by the pooled connections concurrently
+type RedisQuotaBackend struct {
+	address  string
+	password string
+	timeout  time.Duration
+	idle     chan *redisConn
+}
+
+type redisConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+func NewRedisQuotaBackend(address string, password string, timeout time.Duration) *RedisQuotaBackend {
+	return &RedisQuotaBackend{address: address, password: password, timeout: timeout, idle: make(chan *redisConn, redisQuotaMaxIdleConns)}
+}
+
+func (r *RedisQuotaBackend) Lease(key string, window int64, amount int64, limit int64) (int64, int64, error) {
+	c, err := r.getConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	redisKey := redisQuotaKeyPrefix + key + ":" + strconv.FormatInt(window, 10)
+	used, err := c.roundTrip("EVAL", redisQuotaLeaseScript, "1", redisKey, strconv.FormatInt(amount, 10), redisQuotaKeyExpire)
+	r.putConn(c, err)
+	if err != nil {
+		return 0, 0, err
+	}
+	granted, remaining := leaseResult(used, amount, limit)
+	return granted, remaining, nil
+}
+
+// getConn returns an idle connection or a new one
+func (r *RedisQuotaBackend) getConn() (*redisConn, error) {
+	select {
+	case c := <-r.idle:
+		return c, nil
+	default:
+	}
+	conn, err := net.DialTimeout("tcp", r.address, r.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn), timeout: r.timeout}
+	if r.password != "" {
+		if _, err = c.roundTrip("AUTH", r.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// putConn puts back the connection, it's closed on any error or if there are enough idle connections
+func (r *RedisQuotaBackend) putConn(c *redisConn, err error) {
+	if err == nil {
+		select {
+		case r.idle <- c:
+			return
+		default:
+		}
+	}
+	c.conn.Close()
+}
+
+// roundTrip sends a redis command and reads the integer or status reply
+func (c *redisConn) roundTrip(args ...string) (int64, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	buf := motan.NewBytesBuffer(64)
+	buf.Write([]byte("*" + strconv.Itoa(len(args)) + "\r\n"))
+	for _, arg := range args {
+		buf.Write([]byte("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"))
+	}
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return 0, errors.New("empty redis reply")
+	}
+	switch line[0] {
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '+':
+		return 0, nil
+	case '-':
+		return 0, errors.New("redis error: " + line[1:])
+	default:
+		return 0, fmt.Errorf("unexpected redis reply: %s", line)
+	}
+}
+
+type clusterQuota struct {
+	key       string
+	limit     int64
+	leaseSize int64
+	fallback  *ratelimit.Bucket
+
+	lock      sync.Mutex
+	leased    *sync.Cond // broadcast when a lease finished
+	leasing   bool
+	window    int64
+	available int64
+	exhausted bool
+	remaining int64
+	failUntil time.Time
+}
+
+func newClusterQuota(key string, limit int64, leaseSize int64, fallbackRatio float64) *clusterQuota {
+	if leaseSize <= 0 {
+		leaseSize = limit / 10
+	}
+	if leaseSize < 1 {
+		leaseSize = 1
+	}
+	if leaseSize > limit {
+		leaseSize = limit
+	}
+	q := &clusterQuota{key: key, limit: limit, leaseSize: leaseSize, remaining: limit}
+	q.leased = sync.NewCond(&q.lock)
+	if fallbackRate := float64(limit) * fallbackRatio; fallbackRate > 0 {
+		q.fallback = ratelimit.NewBucketWithRate(fallbackRate, int64(fallbackRate)+1)
+	}
+	return q
+}
+
+// take one token from the local leased tokens, the window of the leased token is returned for the refund, it's 0 if
+// the token is taken from the fallback bucket. The next tokens are leased ahead asynchronously when the local tokens
+// are running out, the requests only wait for the lease when there is no local token. The lock is not held while
+// leasing. If the backend is unreachable, the local fallback bucket is used until the retry interval passed
+func (q *clusterQuota) take(backend QuotaBackend) (bool, int64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for {
+		now := time.Now()
+		window := now.Unix()
+		if window != q.window {
+			q.window = window
+			q.available = 0
+			q.exhausted = false
+		}
+		if q.available > 0 {
+			q.available--
+			if q.available*2 < q.leaseSize && !q.leasing && !q.exhausted && !now.Before(q.failUntil) {
+				q.leasing = true
+				go q.lease(backend, window)
+			}
+			return true, window
+		}
+		if q.exhausted {
+			return false, 0
+		}
+		if now.Before(q.failUntil) {
+			return q.takeFallback(), 0
+		}
+		if q.leasing {
+			q.leased.Wait()
+			continue
+		}
+		q.leasing = true
+		q.lock.Unlock()
+		q.lease(backend, window)
+		q.lock.Lock()
+	}
+}
+
+// lease leases the tokens of the window from the backend without the lock
+func (q *clusterQuota) lease(backend QuotaBackend, window int64) {
+	granted, remaining, err := backend.Lease(q.key, window, q.leaseSize, q.limit)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.leasing = false
+	q.leased.Broadcast()
+	if err != nil {
+		vlog.Warningf("[%s] lease quota from backend fail, use local fallback limit. key:%s, err:%v", ClusterRateLimit, q.key, err)
+		q.failUntil = time.Now().Add(quotaBackendRetryInterval)
+		return
+	}
+	if window != q.window {
+		// the tokens of the past window are useless
+		return
+	}
+	q.remaining = remaining
+	if granted <= 0 {
+		q.exhausted = true
+		return
+	}
+	q.available += granted
+}
+
+// refund gives back the leased token of the window if the request is rejected by other quotas
+func (q *clusterQuota) refund(window int64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if window != 0 && window == q.window {
+		q.available++
+	}
+}
+
+func (q *clusterQuota) takeFallback() bool {
+	return q.fallback != nil && q.fallback.TakeAvailable(1) == 1
+}
+
+func (q *clusterQuota) getRemaining() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.window != time.Now().Unix() {
+		return q.limit
+	}
+	return q.remaining + q.available
+}
+
+// ClusterRateLimitFilter limits the qps of service and methods in the whole cluster.
+// the quota is shared by all instances through a QuotaBackend, each instance leases tokens in batch from the backend.
+//
+//	clusterRateLimit: 1000             // qps of the service
+//	clusterRateLimit.hello: 100        // qps of the method 'hello'
+//	clusterRateLimit.backend: redis
+//	clusterRateLimit.address: 127.0.0.1:6379
+type ClusterRateLimitFilter struct {
+	url          *motan.URL
+	switcher     *motan.Switcher
+	backend      QuotaBackend
+	serviceQuota *clusterQuota
+	methodQuotas map[string]*clusterQuota
+	next         motan.EndPointFilter
+}
+
+func (c *ClusterRateLimitFilter) GetIndex() int {
+	return 3
+}
+
+func (c *ClusterRateLimitFilter) GetName() string {
+	return ClusterRateLimit
+}
+
+func (c *ClusterRateLimitFilter) NewFilter(url *motan.URL) motan.Filter {
+	ret := &ClusterRateLimitFilter{url: url, methodQuotas: make(map[string]*clusterQuota)}
+	backend, err := getQuotaBackend(url)
+	if err != nil {
+		vlog.Errorf("[%s] init quota backend fail, only local fallback limit will be used. url:%s, err:%v", ClusterRateLimit, url.GetIdentity(), err)
+		backend = unavailableQuotaBackend{err: err}
+	}
+	ret.backend = backend
+	leaseSize := url.GetPositiveIntValue(ClusterRateLimitLeaseField, 0)
+	fallbackRatio := getFloatParam(url, ClusterRateLimitFallbackField, 0)
+	if fallbackRatio <= 0 {
+		if instances := url.GetPositiveIntValue(ClusterRateLimitInstancesField, 0); instances > 0 {
+			fallbackRatio = 1 / float64(instances)
+		} else {
+			vlog.Errorf("[%s] neither %s nor %s is set, the requests will be rejected when the quota backend is unreachable. url:%s", ClusterRateLimit, ClusterRateLimitFallbackField, ClusterRateLimitInstancesField, url.GetIdentity())
+		}
+	}
+	keyPrefix := url.Group + ":" + url.Path + ":"
+
+	if limit, err := strconv.ParseInt(url.GetParam(ClusterRateLimit, ""), 10, 64); err == nil && limit > 0 {
+		ret.serviceQuota = newClusterQuota(keyPrefix+clusterQuotaServiceKey, limit, leaseSize, fallbackRatio)
+	}
+	for key, value := range url.Parameters {
+		if !strings.HasPrefix(key, clusterRateLimitPrefix) || clusterRateLimitReservedKeys[key] {
+			continue
+		}
+		method := key[len(clusterRateLimitPrefix):]
+		if limit, err := strconv.ParseInt(value, 10, 64); err == nil && limit > 0 && method != "" {
+			ret.methodQuotas[method] = newClusterQuota(keyPrefix+method, limit, leaseSize, fallbackRatio)
+		} else {
+			vlog.Warningf("[%s] parse %s config error, value:%s", ClusterRateLimit, key, value)
+		}
+	}
+
+	switcherName := GetClusterRateLimitSwitcherName(url)
+	motan.GetSwitcherManager().Register(switcherName, true)
+	ret.switcher = motan.GetSwitcherManager().GetSwitcher(switcherName)
+	return ret
+}
+
+func (c *ClusterRateLimitFilter) Filter(caller motan.Caller, request motan.Request) motan.Response {
+	if e := c.checkQuota(request); e != nil {
+		return motan.BuildExceptionResponse(request.GetRequestID(), e)
+	}
+	return c.GetNext().Filter(caller, request)
+}
+
+// FilterStream takes the quota when the stream is opened
+func (c *ClusterRateLimitFilter) FilterStream(caller motan.Caller, request motan.Request) *motan.Exception {
+	return c.checkQuota(request)
+}
+
+// checkQuota takes the service quota and the method quota, nil means the request is allowed
+func (c *ClusterRateLimitFilter) checkQuota(request motan.Request) *motan.Exception {
+	if !c.switcher.IsOpen() {
+		return nil
+	}
+	var serviceWindow int64
+	if c.serviceQuota != nil {
+		ok, window := c.take(c.serviceQuota, clusterQuotaServiceKey)
+		if !ok {
+			return c.reject(request)
+		}
+		serviceWindow = window
+	}
+	if methodQuota, ok := c.methodQuotas[request.GetMethod()]; ok {
+		if ok, _ = c.take(methodQuota, request.GetMethod()); !ok {
+			if c.serviceQuota != nil {
+				// the service token is not consumed by the rejected request
+				c.serviceQuota.refund(serviceWindow)
+			}
+			return c.reject(request)
+		}
+	}
+	return nil
+}
+
+func (c *ClusterRateLimitFilter) take(quota *clusterQuota, method string) (bool, int64) {
+	ok, window := quota.take(c.backend)
+	metrics.AddGauge(metrics.Escape(c.url.Group), metrics.Escape(c.url.Path), metrics.Escape(method)+clusterRateLimitRemainSuffix, quota.getRemaining())
+	return ok, window
+}
+
+func (c *ClusterRateLimitFilter) reject(request motan.Request) *motan.Exception {
+	vlog.Warningf("[%s] request rejected. req:%s", ClusterRateLimit, motan.GetReqInfo(request))
+	return &motan.Exception{ErrCode: clusterRateLimitErrCode, ErrMsg: "cluster rate limit exceeded", ErrType: motan.ServiceException}
+}
+
+// GetRemainingQuota returns the remaining cluster quota of current second for the method, empty method means the service quota.
+// -1 is returned if there is no quota for it
+func (c *ClusterRateLimitFilter) GetRemainingQuota(method string) int64 {
+	quota := c.serviceQuota
+	if method != "" {
+		quota = c.methodQuotas[method]
+	}
+	if quota == nil {
+		return -1
+	}
+	return quota.getRemaining()
+}
+
+func GetClusterRateLimitSwitcherName(url *motan.URL) string {
+	return url.GetParam("conf-id", "") + "_" + ClusterRateLimit
+}
+
+func (c *ClusterRateLimitFilter) HasNext() bool {
+	return c.next != nil
+}
+
+func (c *ClusterRateLimitFilter) SetNext(nextFilter motan.EndPointFilter) {
+	c.next = nextFilter
+}
+
+func (c *ClusterRateLimitFilter) GetNext() motan.EndPointFilter {
+	return c.next
+}
+
+func (c *ClusterRateLimitFilter) GetType() int32 {
+	return motan.EndPointFilterType
+}
+
+type unavailableQuotaBackend struct {
+	err error
+}
+
+func (u unavailableQuotaBackend) Lease(key string, window int64, amount int64, limit int64) (int64, int64, error) {
+	return 0, 0, u.err
+}
@@ -0,0 +1,275 @@
+package filter
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// mockRedisServer supports AUTH and EVAL of the lease script
+type mockRedisServer struct {
+	listener net.Listener
+	lock     sync.Mutex
+	values   map[string]int64
+	expires  map[string]string
+}
+
+func newMockRedisServer(t *testing.T) *mockRedisServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := &mockRedisServer{listener: listener, values: make(map[string]int64), expires: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *mockRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			reader.ReadString('\n')
+			arg, _ := reader.ReadString('\n')
+			args = append(args, strings.TrimSpace(arg))
+		}
+		s.lock.Lock()
+		switch args[0] {
+		case "AUTH":
+			if args[1] == "pass" {
+				conn.Write([]byte("+OK\r\n"))
+			} else {
+				conn.Write([]byte("-ERR invalid password\r\n"))
+			}
+		case "EVAL":
+			if args[1] != redisQuotaLeaseScript || args[2] != "1" {
+				conn.Write([]byte("-ERR unknown script\r\n"))
+				break
+			}
+			amount, _ := strconv.ParseInt(args[4], 10, 64)
+			s.values[args[3]] += amount
+			if s.values[args[3]] == amount {
+				s.expires[args[3]] = args[5]
+			}
+			conn.Write([]byte(":" + strconv.FormatInt(s.values[args[3]], 10) + "\r\n"))
+		default:
+			conn.Write([]byte("-ERR unknown command\r\n"))
+		}
+		s.lock.Unlock()
+	}
+}
+
+func waitNextSecond() {
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second + 10*time.Millisecond)))
+}
+
+func newClusterRateLimitFilter(params map[string]string) *ClusterRateLimitFilter {
+	url := mockURL()
+	url.Path = "com.weibo.clusterRateLimitService"
+	url.PutParam("conf-id", "cluster")
+	for k, v := range params {
+		url.PutParam(k, v)
+	}
+	f := initFactory().GetFilter(ClusterRateLimit).NewFilter(url).(*ClusterRateLimitFilter)
+	f.SetNext(motan.GetLastEndPointFilter())
+	return f
+}
+
+func countPassed(f *ClusterRateLimitFilter, method string, times int) int {
+	caller := initFactory().GetEndPoint(mockURL())
+	passed := 0
+	for i := 0; i < times; i++ {
+		if f.Filter(caller, getRequest(testService, testGroup, method)).GetException() == nil {
+			passed++
+		}
+	}
+	return passed
+}
+
+// waitLeased waits for the leases ahead of the quotas
+func waitLeased(f *ClusterRateLimitFilter) {
+	quotas := []*clusterQuota{f.serviceQuota}
+	for _, q := range f.methodQuotas {
+		quotas = append(quotas, q)
+	}
+	for _, q := range quotas {
+		if q == nil {
+			continue
+		}
+		q.lock.Lock()
+		for q.leasing {
+			q.leased.Wait()
+		}
+		q.lock.Unlock()
+	}
+}
+
+func TestClusterRateLimitFilter(t *testing.T) {
+	params := map[string]string{
+		ClusterRateLimit:                    "10",
+		ClusterRateLimit + ".limitedMethod": "4",
+		ClusterRateLimitBackendField:        QuotaBackendMemory,
+		ClusterRateLimitLeaseField:          "3",
+	}
+	// two instances share the quota
+	f1 := newClusterRateLimitFilter(params)
+	f2 := newClusterRateLimitFilter(params)
+	assert.Equal(t, 1, len(f1.methodQuotas))
+	assert.Equal(t, f1.backend, f2.backend)
+	waitNextSecond()
+	assert.Equal(t, 6, countPassed(f1, testMethod, 6))
+	// f1 leases the next 3 tokens ahead when its local tokens are used up
+	waitLeased(f1)
+	assert.Equal(t, int64(4), f1.GetRemainingQuota(""))
+	assert.Equal(t, 1, countPassed(f2, testMethod, 20))
+	assert.Equal(t, int64(0), f2.GetRemainingQuota(""))
+	assert.Equal(t, 3, countPassed(f1, testMethod, 20))
+	assert.Equal(t, int64(-1), f1.GetRemainingQuota(testMethod))
+
+	waitNextSecond()
+	assert.Equal(t, int64(4), f2.GetRemainingQuota("limitedMethod"))
+	passed := countPassed(f1, "limitedMethod", 3)
+	waitLeased(f1)
+	passed += countPassed(f2, "limitedMethod", 3) + countPassed(f1, "limitedMethod", 3)
+	assert.Equal(t, 4, passed)
+	assert.Equal(t, int64(0), f2.GetRemainingQuota("limitedMethod"))
+
+	// kill switch
+	motan.GetSwitcherManager().GetSwitcher(GetClusterRateLimitSwitcherName(f1.url)).SetValue(false)
+	assert.Equal(t, 10, countPassed(f1, "limitedMethod", 10))
+	motan.GetSwitcherManager().GetSwitcher(GetClusterRateLimitSwitcherName(f1.url)).SetValue(true)
+}
+
+func TestClusterRateLimitFilter_Redis(t *testing.T) {
+	server := newMockRedisServer(t)
+	defer server.listener.Close()
+	params := map[string]string{
+		ClusterRateLimit:              "10",
+		ClusterRateLimitBackendField:  QuotaBackendRedis,
+		ClusterRateLimitAddressField:  server.listener.Addr().String(),
+		ClusterRateLimitPasswordField: "pass",
+	}
+	f := newClusterRateLimitFilter(params)
+	waitNextSecond()
+	assert.Equal(t, 10, countPassed(f, testMethod, 15))
+	key := redisQuotaKeyPrefix + ":com.weibo.clusterRateLimitService:*:" + strconv.FormatInt(time.Now().Unix(), 10)
+	server.lock.Lock()
+	assert.True(t, server.values[key] >= 10)
+	assert.Equal(t, "3000", server.expires[key])
+	server.lock.Unlock()
+
+	// wrong password
+	backend := NewRedisQuotaBackend(server.listener.Addr().String(), "wrong", time.Second)
+	_, _, err := backend.Lease("test", 1, 1, 10)
+	assert.NotNil(t, err)
+}
+
+func TestClusterRateLimitFilter_Fallback(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+	params := map[string]string{
+		ClusterRateLimit:              "10",
+		ClusterRateLimitBackendField:  QuotaBackendRedis,
+		ClusterRateLimitAddressField:  address,
+		ClusterRateLimitFallbackField: "0.5",
+	}
+	// local fallback bucket: rate 5, capacity 6
+	passed := countPassed(newClusterRateLimitFilter(params), testMethod, 20)
+	assert.True(t, passed > 0 && passed <= 7, "passed: %d", passed)
+
+	// unknown backend uses fallback
+	params[ClusterRateLimitBackendField] = "unknown"
+	passed = countPassed(newClusterRateLimitFilter(params), testMethod, 20)
+	assert.True(t, passed > 0 && passed <= 7, "passed: %d", passed)
+
+	// the fallback ratio is 1/instances by default: rate 2, capacity 3
+	delete(params, ClusterRateLimitFallbackField)
+	params[ClusterRateLimitInstancesField] = "5"
+	passed = countPassed(newClusterRateLimitFilter(params), testMethod, 20)
+	assert.True(t, passed > 0 && passed <= 4, "passed: %d", passed)
+
+	// no fallback without the fallback ratio and the instances
+	delete(params, ClusterRateLimitInstancesField)
+	assert.Equal(t, 0, countPassed(newClusterRateLimitFilter(params), testMethod, 20))
+}
+
+func TestClusterRateLimitFilter_Refund(t *testing.T) {
+	f := newClusterRateLimitFilter(map[string]string{
+		ClusterRateLimit:                    "10",
+		ClusterRateLimit + ".limitedMethod": "2",
+		ClusterRateLimitBackendField:        QuotaBackendMemory,
+		ClusterRateLimitAddressField:        "refund",
+		ClusterRateLimitLeaseField:          "10",
+	})
+	waitNextSecond()
+	// the service tokens of the requests rejected by the method quota are refunded
+	assert.Equal(t, 2, countPassed(f, "limitedMethod", 5))
+	assert.Equal(t, 8, countPassed(f, testMethod, 20))
+}
+
+// blockingQuotaBackend blocks the leases until it's released
+type blockingQuotaBackend struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingQuotaBackend) Lease(key string, window int64, amount int64, limit int64) (int64, int64, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return amount, limit - amount, nil
+}
+
+func TestClusterQuota_Lease(t *testing.T) {
+	backend := &blockingQuotaBackend{started: make(chan struct{}, 10), release: make(chan struct{})}
+	q := newClusterQuota("test", 10, 4, 0)
+	waitNextSecond()
+	results := make(chan bool, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			ok, _ := q.take(backend)
+			results <- ok
+		}()
+	}
+	<-backend.started
+	// the lock is not held while leasing, and the concurrent requests wait for the same lease
+	assert.Equal(t, int64(10), q.getRemaining())
+	select {
+	case <-backend.started:
+		t.Fatal("concurrent lease")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(backend.release)
+	assert.True(t, <-results)
+	assert.True(t, <-results)
+	// the next tokens are leased ahead when the local tokens are running out
+	ok, _ := q.take(backend)
+	assert.True(t, ok)
+	<-backend.started
+	q.lock.Lock()
+	for q.leasing {
+		q.leased.Wait()
+	}
+	assert.Equal(t, int64(5), q.available)
+	q.lock.Unlock()
+}
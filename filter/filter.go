@@ -7,12 +7,15 @@ import (
 // ext name
 const (
 	// endpoint filter
-	AccessLog      = "accessLog"
-	Metrics        = "metrics"
-	CircuitBreaker = "circuitBreaker"
-	FailFast       = "failfast"
-	Trace          = "trace"
-	RateLimit      = "rateLimit"
+	AccessLog        = "accessLog"
+	Metrics          = "metrics"
+	CircuitBreaker   = "circuitBreaker"
+	FailFast         = "failfast"
+	Trace            = "trace"
+	RateLimit        = "rateLimit"
+	Auth             = "auth"
+	AdaptiveLimit    = "adaptiveLimit"
+	ClusterRateLimit = "clusterRateLimit"
 
 	// cluster filter
 	ClusterAccessLog      = "clusterAccessLog"
@@ -46,6 +49,18 @@ func RegistDefaultFilters(extFactory motan.ExtensionFactory) {
 		return &RateLimitFilter{}
 	})
 
+	extFactory.RegistExtFilter(Auth, func() motan.Filter {
+		return &AuthFilter{}
+	})
+
+	extFactory.RegistExtFilter(AdaptiveLimit, func() motan.Filter {
+		return &AdaptiveLimitFilter{}
+	})
+
+	extFactory.RegistExtFilter(ClusterRateLimit, func() motan.Filter {
+		return &ClusterRateLimitFilter{}
+	})
+
 	// cluster filter
 	extFactory.RegistExtFilter(ClusterAccessLog, func() motan.Filter {
 		return &ClusterAccessLogFilter{}
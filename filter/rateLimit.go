@@ -66,6 +66,19 @@ func (r *RateLimitFilter) Filter(caller core.Caller, request core.Request) core.
 	return r.GetNext().Filter(caller, request)
 }
 
+// FilterStream waits for the tokens when the stream is opened
+func (r *RateLimitFilter) FilterStream(caller core.Caller, request core.Request) *core.Exception {
+	if r.switcher.IsOpen() {
+		if r.bucket != nil {
+			r.bucket.Wait(1)
+		}
+		if methodBucket, ok := r.methodBuckets[request.GetMethod()]; ok {
+			methodBucket.Wait(1)
+		}
+	}
+	return nil
+}
+
 func GetRateLimitSwitcherName(url *core.URL) string {
 	return url.GetParam("conf-id", "") + "_rateLimit"
 }
@@ -68,6 +68,20 @@ func (br *BackupRequestHA) Call(request motan.Request, loadBalance motan.LoadBal
 	deadline := time.NewTimer(time.Duration(requestTimeout) * time.Millisecond)
 	defer deadline.Stop()
 
+	// every attempt has its own cancel channel, the losing attempts are canceled when the call returns.
+	// the first attempt uses the original request, it is waited to finish before restoring the cancel channel of the caller
+	rc := request.GetRPCContext(true)
+	parentCancelCh := rc.CancelCh
+	firstDone := make(chan struct{})
+	cancelChs := make([]chan struct{}, 0, retries+1)
+	defer func() {
+		for _, ch := range cancelChs {
+			close(ch)
+		}
+		<-firstDone
+		rc.CancelCh = parentCancelCh
+	}()
+
 	successCh := make(chan motan.Response, retries+1)
 	if delay <= 0 {
 		// if no delay time configuration, use pXX time as delay time
@@ -88,22 +102,28 @@ func (br *BackupRequestHA) Call(request motan.Request, loadBalance motan.LoadBal
 			vlog.Warningf("The permit is used up, request id: %d", request.GetRequestID())
 			break
 		}
-		// log & clone backup request
+		// log & clone request, the hedged attempts do not share the cancel channel with the original request
 		pr := request
 		if i > 0 {
 			vlog.Infof("[backup request ha] delay %d request id: %d, service: %s, method: %s", delay, request.GetRequestID(), request.GetServiceName(), request.GetMethod())
 			pr = request.Clone().(motan.Request)
 		}
+		cancelCh := make(chan struct{})
+		pr.GetRPCContext(true).CancelCh = cancelCh
+		cancelChs = append(cancelChs, cancelCh)
 		lastErrorCh = make(chan motan.Response, 1)
-		go func(postRequest motan.Request, endpoint motan.EndPoint, errorCh chan motan.Response) {
+		go func(attempt int, postRequest motan.Request, endpoint motan.EndPoint, errorCh chan motan.Response) {
 			defer motan.HandlePanic(nil)
+			if attempt == 0 {
+				defer close(firstDone)
+			}
 			response := br.doCall(postRequest, endpoint)
 			if response != nil && (response.GetException() == nil || response.GetException().ErrType == motan.BizException) {
 				successCh <- response
 			} else {
 				errorCh <- response
 			}
-		}(pr, ep, lastErrorCh)
+		}(i, pr, ep, lastErrorCh)
 
 		timer := time.NewTimer(time.Duration(delay) * time.Millisecond)
 		defer timer.Stop()
@@ -114,6 +134,8 @@ func (br *BackupRequestHA) Call(request motan.Request, loadBalance motan.LoadBal
 		case <-timer.C:
 		case <-deadline.C:
 			goto BREAK
+		case <-parentCancelCh:
+			return getErrorResponse(request.GetRequestID(), fmt.Sprintf("call backup request fail: %s", "canceled"))
 		}
 	}
 	select {
@@ -121,6 +143,8 @@ func (br *BackupRequestHA) Call(request motan.Request, loadBalance motan.LoadBal
 		return resp
 	case resp = <-lastErrorCh:
 	case <-deadline.C:
+	case <-parentCancelCh:
+		return getErrorResponse(request.GetRequestID(), fmt.Sprintf("call backup request fail: %s", "canceled"))
 	}
 BREAK:
 	return getErrorResponse(request.GetRequestID(), fmt.Sprintf("call backup request fail: %s", "timeout"))
@@ -131,6 +155,10 @@ func (br *BackupRequestHA) doCall(request motan.Request, endpoint motan.EndPoint
 	if response.GetException() == nil || response.GetException().ErrType == motan.BizException {
 		return response
 	}
+	if request.GetRPCContext(true).IsCanceled() {
+		// the losing attempt is canceled, it's not a failure
+		return response
+	}
 	vlog.Warningf("BackupRequestHA call fail! url:%s, err:%+v", endpoint.GetURL().GetIdentity(), response.GetException())
 	return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 400, ErrMsg: fmt.Sprintf(
 		"call backup request fail.Exception:%s", response.GetException().ErrMsg), ErrType: motan.ServiceException})
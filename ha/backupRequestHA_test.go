@@ -2,6 +2,7 @@ package ha
 
 import (
 	"bytes"
+	"sync"
 	"testing"
 	"time"
 
@@ -65,3 +66,78 @@ func getEP(processTime int64) motan.EndPoint {
 	fep.Filter = mf
 	return fep
 }
+
+type cancelAwareEndPoint struct {
+	motan.TestEndPoint
+	canceled   chan struct{}
+	cancelOnce sync.Once
+}
+
+func (c *cancelAwareEndPoint) Call(request motan.Request) motan.Response {
+	select {
+	case <-request.GetRPCContext(true).CancelCh:
+		c.cancelOnce.Do(func() {
+			close(c.canceled)
+		})
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Exception: &motan.Exception{ErrCode: 400, ErrMsg: "canceled", ErrType: motan.ServiceException}}
+	case <-time.After(time.Duration(c.ProcessTime) * time.Millisecond):
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), ProcessTime: c.ProcessTime}
+	}
+}
+
+func TestBackupRequestHA_Cancel(t *testing.T) {
+	params := make(map[string]string)
+	params[motan.RetriesKey] = "1"
+	params["backupRequestDelayTime"] = "10"
+	url := &motan.URL{Protocol: "motan2", Path: TestService, Parameters: params}
+	ha := &BackupRequestHA{url: url}
+	ha.Initialize()
+	group := "motan/test.cancel.group"
+	request := &motan.MotanRequest{ServiceName: TestService, Method: TestMethod}
+	request.SetAttachment(protocol.MGroup, group)
+	request.SetAttachment(protocol.MPath, TestService)
+	metrics.GetOrRegisterStatItem(metrics.Escape(group), metrics.Escape(TestService)).SnapshotAndClear()
+
+	slow := &cancelAwareEndPoint{TestEndPoint: motan.TestEndPoint{ProcessTime: 1000}, canceled: make(chan struct{})}
+	fast := &cancelAwareEndPoint{TestEndPoint: motan.TestEndPoint{ProcessTime: 1}, canceled: make(chan struct{})}
+	nlb := &lb.RoundrobinLB{}
+	nlb.OnRefresh([]motan.EndPoint{slow, fast})
+	// make sure the first request selects the slow endpoint
+	for nlb.Select(request) != fast {
+	}
+	start := time.Now()
+	res := ha.Call(request, nlb)
+	if res.GetException() != nil || res.GetProcessTime() != 1 {
+		t.Errorf("backupRequest call fail. res:%+v", res)
+	}
+	select {
+	case <-slow.canceled:
+	case <-time.After(500 * time.Millisecond):
+		t.Errorf("the slow request is not canceled")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("backupRequest call is too slow")
+	}
+	// the original request is not canceled
+	if request.GetRPCContext(true).IsCanceled() {
+		t.Errorf("the original request should not be canceled")
+	}
+	if request.GetRPCContext(true).CancelCh != nil {
+		t.Errorf("the cancel channel of the original request should be restored")
+	}
+
+	// canceled by caller
+	slow = &cancelAwareEndPoint{TestEndPoint: motan.TestEndPoint{ProcessTime: 1000}, canceled: make(chan struct{})}
+	nlb.OnRefresh([]motan.EndPoint{slow})
+	request.GetRPCContext(true).CancelCh = make(chan struct{})
+	close(request.GetRPCContext(true).CancelCh)
+	res = ha.Call(request, nlb)
+	if res.GetException() == nil {
+		t.Errorf("canceled backupRequest should fail. res:%+v", res)
+	}
+	select {
+	case <-slow.canceled:
+	case <-time.After(500 * time.Millisecond):
+		t.Errorf("the request is not canceled")
+	}
+}
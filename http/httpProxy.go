@@ -18,6 +18,8 @@ const (
 	Proxy       = "HTTP_PROXY"
 	Method      = "HTTP_Method"
 	QueryString = "HTTP_QueryString"
+	// LocationRulesApplied means the location rules have been applied by the http proxy server
+	LocationRulesApplied = "HTTP_LocationRulesApplied"
 )
 
 const (
@@ -54,7 +56,7 @@ const (
 var (
 	WhitespaceSplitPattern        = regexp.MustCompile(`\s+`)
 	findRewriteVarPattern         = regexp.MustCompile(`\{[0-9a-zA-Z_-]+\}`)
-	httpProxySpecifiedAttachments = []string{Proxy, Method, QueryString}
+	httpProxySpecifiedAttachments = []string{Proxy, Method, QueryString, LocationRulesApplied}
 	rewriteVarFunc                = func(condType ProxyRewriteType, uri string, queryBytes []byte) string {
 		if condType != proxyRewriteTypeRegexpVar || len(queryBytes) == 0 {
 			return uri
@@ -166,11 +168,21 @@ type ProxyLocation struct {
 	Match        string   `yaml:"match"`
 	Type         string   `yaml:"type"`
 	RewriteRules []string `yaml:"rewriteRules"`
-
-	pattern      *regexp.Regexp
-	locationType ProxyMatchType
-	rewriteRules []*rewriteRule
-	length       int
+	// RequestHeaders and ResponseHeaders are the header rules like: set X-Real-IP $remote_ip
+	RequestHeaders  []string     `yaml:"requestHeaders"`
+	ResponseHeaders []string     `yaml:"responseHeaders"`
+	Return          string       `yaml:"return"`
+	CORS            *CORSConfig  `yaml:"cors"`
+	Cache           *CacheConfig `yaml:"cache"`
+
+	pattern             *regexp.Regexp
+	locationType        ProxyMatchType
+	rewriteRules        []*rewriteRule
+	requestHeaderRules  []*headerRule
+	responseHeaderRules []*headerRule
+	returnRule          *returnRule
+	cache               *ResponseCache
+	length              int
 }
 
 // config like follows
@@ -260,6 +272,7 @@ func (l *ProxyLocation) DeterminePath(path string, query []byte, doRewrite bool)
 }
 
 type LocationMatcher struct {
+	maps            map[string]*httpMap
 	locations       []*ProxyLocation
 	exactLocations  []*ProxyLocation
 	startLocations  []*ProxyLocation
@@ -281,7 +294,10 @@ func NewLocationMatcherFromContext(domain string, context *core.Context) *Locati
 	for _, location := range domainLocationsSlice {
 		locationConfig := location.(map[interface{}]interface{})
 		proxyLocation := ProxyLocation{}
-		proxyLocation.Upstream = locationConfig["upstream"].(string)
+		// the upstream can be omitted by the locations which respond by return
+		if u, ok := locationConfig["upstream"]; ok {
+			proxyLocation.Upstream = u.(string)
+		}
 		proxyLocation.Match = locationConfig["match"].(string)
 		if t, ok := locationConfig["type"]; ok {
 			proxyLocation.Type = t.(string)
@@ -291,17 +307,34 @@ func NewLocationMatcherFromContext(domain string, context *core.Context) *Locati
 				proxyLocation.RewriteRules = append(proxyLocation.RewriteRules, r.(string))
 			}
 		}
+		proxyLocation.RequestHeaders = configStrings(locationConfig["requestHeaders"])
+		proxyLocation.ResponseHeaders = configStrings(locationConfig["responseHeaders"])
+		proxyLocation.Return = configString(locationConfig["return"])
+		if c, ok := locationConfig["cors"].(map[interface{}]interface{}); ok {
+			proxyLocation.CORS = newCORSConfig(c)
+		}
+		if c, ok := locationConfig["cache"].(map[interface{}]interface{}); ok {
+			proxyLocation.Cache = newCacheConfig(c)
+		}
 		locations = append(locations, &proxyLocation)
 	}
-	return NewLocationMatcher(locations)
+	mapsSection, _ := context.Config.GetSection("http-maps")
+	return newLocationMatcherWithMaps(domain, locations, newHTTPMaps(mapsSection))
 }
 
 func NewLocationMatcher(locations []*ProxyLocation) *LocationMatcher {
+	return newLocationMatcherWithMaps("", locations, nil)
+}
+
+// newLocationMatcherWithMaps creates a LocationMatcher, the maps can be used as variables in the location rules
+func newLocationMatcherWithMaps(domain string, locations []*ProxyLocation, maps map[string]*httpMap) *LocationMatcher {
 	matcher := &LocationMatcher{
+		maps:      maps,
 		locations: locations,
 	}
 	for _, l := range locations {
 		l.length = len(l.Match)
+		l.compileRules(domain, maps)
 		if len(l.RewriteRules) != 0 {
 			rewriteRules := make([]*rewriteRule, 0, len(l.RewriteRules))
 			for _, rule := range l.RewriteRules {
@@ -358,20 +391,27 @@ func NewLocationMatcher(locations []*ProxyLocation) *LocationMatcher {
 }
 
 // Pick returns the matched upstream and do url rewrite and etc
-// Now this functions just compatible with nginx location match rules
-// See http://nginx.org/en/docs/http/ngx_http_core_module.html#location
 func (m *LocationMatcher) Pick(path string, query []byte, doRewrite bool) (string, string, bool) {
+	if l := m.Locate(path); l != nil {
+		return l.Upstream, l.DeterminePath(path, query, doRewrite), true
+	}
+	return "", "", false
+}
 
+// Locate returns the matched location of the path
+// Now this functions just compatible with nginx location match rules
+// See http://nginx.org/en/docs/http/ngx_http_core_module.html#location
+func (m *LocationMatcher) Locate(path string) *ProxyLocation {
 	// First do exact location match
 	for _, l := range m.exactLocations {
 		if path == l.Match {
-			return l.Upstream, l.DeterminePath(path, query, doRewrite), true
+			return l
 		}
 	}
 	// Second do regexp match by order
 	for _, l := range m.regexpLocations {
 		if l.pattern.MatchString(path) {
-			return l.Upstream, l.DeterminePath(path, query, doRewrite), true
+			return l
 		}
 	}
 
@@ -388,10 +428,7 @@ func (m *LocationMatcher) Pick(path string, query []byte, doRewrite bool) (strin
 		}
 	}
 
-	if longestLocation != nil {
-		return longestLocation.Upstream, longestLocation.DeterminePath(path, query, doRewrite), true
-	}
-	return "", "", false
+	return longestLocation
 }
 
 func (m *LocationMatcher) URIToServiceName(uri string, queryString []byte) string {
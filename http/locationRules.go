@@ -0,0 +1,465 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+	"github.com/weibocom/motan-go/log"
+)
+
+const (
+	headerRuleSet    = "set"
+	headerRuleAdd    = "add"
+	headerRuleRemove = "remove"
+)
+
+var variablePattern = regexp.MustCompile(`\$(\{[a-zA-Z0-9_]+\}|[a-zA-Z0-9_]+)`)
+
+// RequestVariables provides the values of the variables in the location rules, such as $remote_ip, $request_id,
+// $host, $uri, $request_uri, $scheme, $proxy_add_x_forwarded_for, $query_{name}, $http_{header} and the http-maps
+type RequestVariables struct {
+	Request   *fasthttp.Request
+	RemoteIP  string
+	RequestID string
+}
+
+type variableFunc func(v *RequestVariables) string
+
+var requestVariables = map[string]variableFunc{
+	"remote_ip":  func(v *RequestVariables) string { return v.RemoteIP },
+	"request_id": func(v *RequestVariables) string { return v.RequestID },
+	"host":       func(v *RequestVariables) string { return string(v.Request.Host()) },
+	"uri":        func(v *RequestVariables) string { return string(v.Request.URI().Path()) },
+	"request_uri": func(v *RequestVariables) string {
+		return string(v.Request.URI().RequestURI())
+	},
+	"scheme": func(v *RequestVariables) string {
+		if scheme := v.Request.URI().Scheme(); len(scheme) > 0 {
+			return string(scheme)
+		}
+		return "http"
+	},
+	"proxy_add_x_forwarded_for": func(v *RequestVariables) string {
+		if forwarded := v.Request.Header.Peek("X-Forwarded-For"); len(forwarded) > 0 {
+			return string(forwarded) + ", " + v.RemoteIP
+		}
+		return v.RemoteIP
+	},
+}
+
+// variableTemplate is a string with variables, it's compiled when the locations are loaded
+type variableTemplate struct {
+	segments []templateSegment
+}
+
+type templateSegment struct {
+	literal  string
+	variable variableFunc
+}
+
+func (t *variableTemplate) render(v *RequestVariables) string {
+	if len(t.segments) == 1 && t.segments[0].variable == nil {
+		return t.segments[0].literal
+	}
+	var buf strings.Builder
+	for _, s := range t.segments {
+		if s.variable != nil {
+			buf.WriteString(s.variable(v))
+		} else {
+			buf.WriteString(s.literal)
+		}
+	}
+	return buf.String()
+}
+
+// httpMap is the http-maps item, the value is matched by the entries in order like the nginx map
+type httpMap struct {
+	source       *variableTemplate
+	defaultValue string
+	entries      []*httpMapEntry
+}
+
+type httpMapEntry struct {
+	exact   string
+	pattern *regexp.Regexp
+	value   string
+}
+
+func (m *httpMap) value(v *RequestVariables) string {
+	source := m.source.render(v)
+	for _, e := range m.entries {
+		if e.pattern != nil {
+			if e.pattern.MatchString(source) {
+				return e.value
+			}
+		} else if e.exact == source {
+			return e.value
+		}
+	}
+	return m.defaultValue
+}
+
+// compileTemplate compiles the variables in the string, the unknown variables are kept as they are
+func compileTemplate(s string, maps map[string]*httpMap) *variableTemplate {
+	t := &variableTemplate{}
+	last := 0
+	for _, loc := range variablePattern.FindAllStringIndex(s, -1) {
+		name := strings.Trim(s[loc[0]+1:loc[1]], "{}")
+		variable := lookupVariable(name, maps)
+		if variable == nil {
+			vlog.Warningf("Unknown variable %s in %s", s[loc[0]:loc[1]], s)
+			continue
+		}
+		if loc[0] > last {
+			t.segments = append(t.segments, templateSegment{literal: s[last:loc[0]]})
+		}
+		t.segments = append(t.segments, templateSegment{variable: variable})
+		last = loc[1]
+	}
+	if last < len(s) || len(t.segments) == 0 {
+		t.segments = append(t.segments, templateSegment{literal: s[last:]})
+	}
+	return t
+}
+
+func lookupVariable(name string, maps map[string]*httpMap) variableFunc {
+	if f, ok := requestVariables[name]; ok {
+		return f
+	}
+	if strings.HasPrefix(name, "query_") {
+		key := name[len("query_"):]
+		return func(v *RequestVariables) string {
+			return string(v.Request.URI().QueryArgs().Peek(key))
+		}
+	}
+	if strings.HasPrefix(name, "http_") {
+		header := strings.Replace(name[len("http_"):], "_", "-", -1)
+		return func(v *RequestVariables) string {
+			return string(v.Request.Header.Peek(header))
+		}
+	}
+	if m, ok := maps[name]; ok {
+		return m.value
+	}
+	return nil
+}
+
+func newHTTPMaps(section map[interface{}]interface{}) map[string]*httpMap {
+	maps := make(map[string]*httpMap, len(section))
+	for name, conf := range section {
+		mapConfig, ok := conf.(map[interface{}]interface{})
+		if !ok {
+			vlog.Errorf("Illegal http map %v", name)
+			continue
+		}
+		// the maps can not refer the other maps
+		m := &httpMap{
+			source:       compileTemplate(configString(mapConfig["source"]), nil),
+			defaultValue: configString(mapConfig["default"]),
+		}
+		entries, _ := mapConfig["entries"].([]interface{})
+		for _, e := range entries {
+			entryConfig, ok := e.(map[interface{}]interface{})
+			if !ok {
+				continue
+			}
+			entry := &httpMapEntry{value: configString(entryConfig["value"])}
+			match := configString(entryConfig["match"])
+			switch configString(entryConfig["type"]) {
+			case "regexp":
+				entry.pattern, _ = regexp.Compile(match)
+			case "iregexp":
+				entry.pattern, _ = regexp.Compile("(?i)" + match)
+			default:
+				entry.exact = match
+			}
+			if entry.pattern == nil && entry.exact == "" {
+				vlog.Errorf("Illegal entry %s of http map %v", match, name)
+				continue
+			}
+			m.entries = append(m.entries, entry)
+		}
+		maps[fmt.Sprint(name)] = m
+	}
+	return maps
+}
+
+// config like follows
+// set X-Real-IP $remote_ip
+// remove X-Powered-By
+type headerRule struct {
+	op    string
+	name  string
+	value *variableTemplate
+}
+
+func newHeaderRule(rule string, maps map[string]*httpMap) (*headerRule, error) {
+	args := WhitespaceSplitPattern.Split(strings.TrimSpace(rule), 3)
+	r := &headerRule{op: args[0]}
+	switch {
+	case r.op == headerRuleRemove && len(args) == 2:
+	case (r.op == headerRuleSet || r.op == headerRuleAdd) && len(args) == 3:
+		r.value = compileTemplate(args[2], maps)
+	default:
+		return nil, errors.New("illegal header rule")
+	}
+	r.name = args[1]
+	return r, nil
+}
+
+type headerSetter interface {
+	Set(key, value string)
+	Add(key, value string)
+	Del(key string)
+}
+
+func (r *headerRule) apply(header headerSetter, v *RequestVariables) {
+	switch r.op {
+	case headerRuleSet:
+		header.Set(r.name, r.value.render(v))
+	case headerRuleAdd:
+		header.Add(r.name, r.value.render(v))
+	case headerRuleRemove:
+		header.Del(r.name)
+	}
+}
+
+// config like follows
+// 301 https://$host$request_uri
+// 403
+type returnRule struct {
+	code  int
+	value *variableTemplate
+}
+
+func newReturnRule(rule string, maps map[string]*httpMap) (*returnRule, error) {
+	args := WhitespaceSplitPattern.Split(strings.TrimSpace(rule), 2)
+	code, err := strconv.Atoi(args[0])
+	if err != nil || code < 100 || code > 999 {
+		return nil, errors.New("illegal return code " + args[0])
+	}
+	r := &returnRule{code: code}
+	if len(args) == 2 {
+		r.value = compileTemplate(args[1], maps)
+	}
+	return r, nil
+}
+
+func (r *returnRule) isRedirect() bool {
+	switch r.code {
+	case fasthttp.StatusMovedPermanently, fasthttp.StatusFound, fasthttp.StatusSeeOther,
+		fasthttp.StatusTemporaryRedirect, fasthttp.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// CORSConfig is the cors config of a location, the preflight requests are responded by the proxy
+type CORSConfig struct {
+	AllowOrigins     []string `yaml:"allowOrigins"`
+	AllowMethods     []string `yaml:"allowMethods"`
+	AllowHeaders     []string `yaml:"allowHeaders"`
+	ExposeHeaders    []string `yaml:"exposeHeaders"`
+	AllowCredentials bool     `yaml:"allowCredentials"`
+	MaxAge           int      `yaml:"maxAge"`
+}
+
+func newCORSConfig(conf map[interface{}]interface{}) *CORSConfig {
+	c := &CORSConfig{
+		AllowOrigins:  configStrings(conf["allowOrigins"]),
+		AllowMethods:  configStrings(conf["allowMethods"]),
+		AllowHeaders:  configStrings(conf["allowHeaders"]),
+		ExposeHeaders: configStrings(conf["exposeHeaders"]),
+	}
+	c.AllowCredentials, _ = strconv.ParseBool(configString(conf["allowCredentials"]))
+	c.MaxAge, _ = strconv.Atoi(configString(conf["maxAge"]))
+	return c
+}
+
+// validate rejects the credentials with the wildcard origin, otherwise every site can make the credentialed requests.
+// The credentials need an explicit origin list
+func (c *CORSConfig) validate(match string) {
+	if !c.AllowCredentials {
+		return
+	}
+	for _, o := range c.AllowOrigins {
+		if o == "*" {
+			vlog.Errorf("Illegal cors for location %s: allowCredentials is not allowed with the wildcard origin, the credentials are disabled", match)
+			c.AllowCredentials = false
+			return
+		}
+	}
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin, it's empty if the origin is not allowed
+func (c *CORSConfig) allowOrigin(origin string) string {
+	for _, o := range c.AllowOrigins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+func (c *CORSConfig) setHeaders(header *fasthttp.ResponseHeader, allowOrigin string) {
+	header.Set("Access-Control-Allow-Origin", allowOrigin)
+	if allowOrigin != "*" {
+		header.Add("Vary", "Origin")
+	}
+	if c.AllowCredentials && allowOrigin != "*" {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// Respond handles the requests which are not proxied to the upstream, such as the returns and the cors preflight
+// requests. It returns true if the response is written
+func (l *ProxyLocation) Respond(v *RequestVariables, res *fasthttp.Response) bool {
+	if l.returnRule != nil {
+		res.Reset()
+		res.SetStatusCode(l.returnRule.code)
+		if l.returnRule.value != nil {
+			if l.returnRule.isRedirect() {
+				res.Header.Set("Location", l.returnRule.value.render(v))
+			} else {
+				res.Header.SetContentType("text/plain; charset=utf-8")
+				res.SetBodyString(l.returnRule.value.render(v))
+			}
+		}
+		l.applyCORS(v, res)
+		return true
+	}
+	if l.CORS == nil || !v.Request.Header.IsOptions() {
+		return false
+	}
+	requestMethod := string(v.Request.Header.Peek("Access-Control-Request-Method"))
+	origin := string(v.Request.Header.Peek("Origin"))
+	if requestMethod == "" || origin == "" {
+		return false
+	}
+	res.Reset()
+	allowOrigin := l.CORS.allowOrigin(origin)
+	if allowOrigin == "" {
+		res.SetStatusCode(fasthttp.StatusForbidden)
+		return true
+	}
+	res.SetStatusCode(fasthttp.StatusNoContent)
+	l.CORS.setHeaders(&res.Header, allowOrigin)
+	if len(l.CORS.AllowMethods) > 0 {
+		res.Header.Set("Access-Control-Allow-Methods", strings.Join(l.CORS.AllowMethods, ", "))
+	} else {
+		res.Header.Set("Access-Control-Allow-Methods", requestMethod)
+	}
+	if len(l.CORS.AllowHeaders) > 0 {
+		res.Header.Set("Access-Control-Allow-Headers", strings.Join(l.CORS.AllowHeaders, ", "))
+	} else if requestHeaders := v.Request.Header.Peek("Access-Control-Request-Headers"); len(requestHeaders) > 0 {
+		res.Header.SetBytesV("Access-Control-Allow-Headers", requestHeaders)
+	}
+	if l.CORS.MaxAge > 0 {
+		res.Header.Set("Access-Control-Max-Age", strconv.Itoa(l.CORS.MaxAge))
+	}
+	return true
+}
+
+// RewriteRequest applies the request header rules before the request is proxied
+func (l *ProxyLocation) RewriteRequest(v *RequestVariables) {
+	for _, r := range l.requestHeaderRules {
+		r.apply(&v.Request.Header, v)
+	}
+}
+
+// RewriteResponse applies the response header rules and the cors headers to the response of the upstream
+func (l *ProxyLocation) RewriteResponse(v *RequestVariables, res *fasthttp.Response) {
+	for _, r := range l.responseHeaderRules {
+		r.apply(&res.Header, v)
+	}
+	l.applyCORS(v, res)
+}
+
+func (l *ProxyLocation) applyCORS(v *RequestVariables, res *fasthttp.Response) {
+	if l.CORS == nil {
+		return
+	}
+	origin := string(v.Request.Header.Peek("Origin"))
+	if origin == "" {
+		return
+	}
+	allowOrigin := l.CORS.allowOrigin(origin)
+	if allowOrigin == "" {
+		return
+	}
+	l.CORS.setHeaders(&res.Header, allowOrigin)
+	if len(l.CORS.ExposeHeaders) > 0 {
+		res.Header.Set("Access-Control-Expose-Headers", strings.Join(l.CORS.ExposeHeaders, ", "))
+	}
+}
+
+// HasRules returns true if the location has the header rules, the return, the cors or the cache config
+func (l *ProxyLocation) HasRules() bool {
+	return len(l.requestHeaderRules) > 0 || len(l.responseHeaderRules) > 0 || l.returnRule != nil || l.CORS != nil ||
+		l.cache != nil
+}
+
+// ResponseCache returns the response cache of the location, it's nil if the cache is not configured
+func (l *ProxyLocation) ResponseCache() *ResponseCache {
+	return l.cache
+}
+
+func (l *ProxyLocation) compileRules(domain string, maps map[string]*httpMap) {
+	l.cache = nil
+	if l.Cache != nil {
+		l.cache = newResponseCache(l.Cache, domain, l.Upstream)
+	}
+	if l.CORS != nil {
+		l.CORS.validate(l.Match)
+	}
+	l.requestHeaderRules = compileHeaderRules(l.RequestHeaders, l.Match, maps)
+	l.responseHeaderRules = compileHeaderRules(l.ResponseHeaders, l.Match, maps)
+	l.returnRule = nil
+	if l.Return != "" {
+		r, err := newReturnRule(l.Return, maps)
+		if err != nil {
+			vlog.Errorf("Illegal return %s for location %s: %s", l.Return, l.Match, err.Error())
+			return
+		}
+		l.returnRule = r
+	}
+}
+
+func compileHeaderRules(rules []string, match string, maps map[string]*httpMap) []*headerRule {
+	headerRules := make([]*headerRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule == "" {
+			continue
+		}
+		r, err := newHeaderRule(rule, maps)
+		if err != nil {
+			vlog.Errorf("Illegal header rule %s for location %s: %s", rule, match, err.Error())
+			continue
+		}
+		headerRules = append(headerRules, r)
+	}
+	return headerRules
+}
+
+func configString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func configStrings(v interface{}) []string {
+	values, _ := v.([]interface{})
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		result = append(result, configString(value))
+	}
+	return result
+}
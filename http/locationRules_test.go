@@ -0,0 +1,198 @@
+package http
+
+import (
+	"bytes"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/weibocom/motan-go/config"
+	"github.com/weibocom/motan-go/core"
+)
+
+const locationRulesTestData = `
+http-maps:
+  api_version:
+    source: $http_x_version
+    default: v1
+    entries:
+    - match: '2'
+      value: v2
+    - match: ^3\.
+      type: regexp
+      value: v3
+
+http-locations:
+  test.domain:
+  - match: /api
+    upstream: test-api
+    requestHeaders:
+    - set X-Real-IP $remote_ip
+    - set X-Request-Id $request_id
+    - add X-Query ${query_foo}-$api_version
+    - set X-Forwarded-For $proxy_add_x_forwarded_for
+    - remove Cookie
+    - illegal X-Illegal
+    responseHeaders:
+    - remove X-Powered-By
+    - set X-Served-By $host$uri $unknown
+    cors:
+      allowOrigins:
+      - http://a.domain
+      allowMethods:
+      - GET
+      - POST
+      exposeHeaders:
+      - X-Served-By
+      allowCredentials: true
+      maxAge: 600
+
+  - match: /old
+    type: exact
+    return: 301 https://$host$request_uri
+
+  - match: /deny
+    return: 403 denied $remote_ip
+
+  - match: /public
+    upstream: test-public
+    cors:
+      allowOrigins:
+      - '*'
+
+  - match: /credentials
+    upstream: test-public
+    cors:
+      allowOrigins:
+      - '*'
+      allowCredentials: true
+`
+
+func newLocationRulesTestMatcher() *LocationMatcher {
+	return newLocationRulesTestMatcherFrom(locationRulesTestData)
+}
+
+func newLocationRulesTestMatcherFrom(data string) *LocationMatcher {
+	context := &core.Context{}
+	context.Config, _ = config.NewConfigFromReader(bytes.NewReader([]byte(data)))
+	return NewLocationMatcherFromContext("test.domain", context)
+}
+
+func newTestRequestVariables(method string, uri string, headers map[string]string) *RequestVariables {
+	req := &fasthttp.Request{}
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	req.Header.SetHost("test.domain")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return &RequestVariables{Request: req, RemoteIP: "10.0.0.1", RequestID: "123"}
+}
+
+func (s *APITestSuite) TestLocationHeaderRules() {
+	matcher := newLocationRulesTestMatcher()
+	location := matcher.Locate("/api/test")
+	assert.NotNil(s.T(), location)
+	assert.True(s.T(), location.HasRules())
+	assert.Len(s.T(), location.requestHeaderRules, 5)
+
+	v := newTestRequestVariables("GET", "/api/test?foo=bar", map[string]string{
+		"Cookie":          "a=b",
+		"X-Version":       "3.1",
+		"X-Forwarded-For": "10.0.0.2",
+	})
+	assert.False(s.T(), location.Respond(v, &fasthttp.Response{}))
+	location.RewriteRequest(v)
+	assert.Equal(s.T(), "10.0.0.1", string(v.Request.Header.Peek("X-Real-IP")))
+	assert.Equal(s.T(), "123", string(v.Request.Header.Peek("X-Request-Id")))
+	assert.Equal(s.T(), "bar-v3", string(v.Request.Header.Peek("X-Query")))
+	assert.Equal(s.T(), "10.0.0.2, 10.0.0.1", string(v.Request.Header.Peek("X-Forwarded-For")))
+	assert.Equal(s.T(), "", string(v.Request.Header.Peek("Cookie")))
+
+	res := &fasthttp.Response{}
+	res.Header.Set("X-Powered-By", "test")
+	location.RewriteResponse(v, res)
+	assert.Equal(s.T(), "", string(res.Header.Peek("X-Powered-By")))
+	assert.Equal(s.T(), "test.domain/api/test $unknown", string(res.Header.Peek("X-Served-By")))
+	// no origin no cors headers
+	assert.Equal(s.T(), "", string(res.Header.Peek("Access-Control-Allow-Origin")))
+
+	// the map values
+	v = newTestRequestVariables("GET", "/api/test", map[string]string{"X-Version": "2"})
+	location.RewriteRequest(v)
+	assert.Equal(s.T(), "-v2", string(v.Request.Header.Peek("X-Query")))
+	v = newTestRequestVariables("GET", "/api/test", nil)
+	location.RewriteRequest(v)
+	assert.Equal(s.T(), "-v1", string(v.Request.Header.Peek("X-Query")))
+
+	assert.Nil(s.T(), matcher.Locate("/other"))
+}
+
+func (s *APITestSuite) TestLocationReturn() {
+	matcher := newLocationRulesTestMatcher()
+	upstream, _, ok := matcher.Pick("/old", nil, false)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), "", upstream)
+
+	res := &fasthttp.Response{}
+	v := newTestRequestVariables("GET", "/old?a=b", nil)
+	assert.True(s.T(), matcher.Locate("/old").Respond(v, res))
+	assert.Equal(s.T(), fasthttp.StatusMovedPermanently, res.StatusCode())
+	assert.Equal(s.T(), "https://test.domain/old?a=b", string(res.Header.Peek("Location")))
+
+	res = &fasthttp.Response{}
+	assert.True(s.T(), matcher.Locate("/deny/1").Respond(newTestRequestVariables("POST", "/deny/1", nil), res))
+	assert.Equal(s.T(), fasthttp.StatusForbidden, res.StatusCode())
+	assert.Equal(s.T(), "denied 10.0.0.1", string(res.Body()))
+}
+
+func (s *APITestSuite) TestLocationCORS() {
+	matcher := newLocationRulesTestMatcher()
+	location := matcher.Locate("/api")
+	assert.Equal(s.T(), []string{"GET", "POST"}, location.CORS.AllowMethods)
+	assert.Equal(s.T(), 600, location.CORS.MaxAge)
+
+	// preflight
+	res := &fasthttp.Response{}
+	v := newTestRequestVariables("OPTIONS", "/api", map[string]string{
+		"Origin":                         "http://a.domain",
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "X-Test",
+	})
+	assert.True(s.T(), location.Respond(v, res))
+	assert.Equal(s.T(), fasthttp.StatusNoContent, res.StatusCode())
+	assert.Equal(s.T(), "http://a.domain", string(res.Header.Peek("Access-Control-Allow-Origin")))
+	assert.Equal(s.T(), "true", string(res.Header.Peek("Access-Control-Allow-Credentials")))
+	assert.Equal(s.T(), "GET, POST", string(res.Header.Peek("Access-Control-Allow-Methods")))
+	assert.Equal(s.T(), "X-Test", string(res.Header.Peek("Access-Control-Allow-Headers")))
+	assert.Equal(s.T(), "600", string(res.Header.Peek("Access-Control-Max-Age")))
+
+	// the origin is not allowed
+	res = &fasthttp.Response{}
+	v.Request.Header.Set("Origin", "http://b.domain")
+	assert.True(s.T(), location.Respond(v, res))
+	assert.Equal(s.T(), fasthttp.StatusForbidden, res.StatusCode())
+
+	// the actual request
+	res = &fasthttp.Response{}
+	v = newTestRequestVariables("GET", "/api", map[string]string{"Origin": "http://a.domain"})
+	assert.False(s.T(), location.Respond(v, res))
+	location.RewriteResponse(v, res)
+	assert.Equal(s.T(), "http://a.domain", string(res.Header.Peek("Access-Control-Allow-Origin")))
+	assert.Equal(s.T(), "X-Served-By", string(res.Header.Peek("Access-Control-Expose-Headers")))
+	assert.Equal(s.T(), "Origin", string(res.Header.Peek("Vary")))
+
+	res = &fasthttp.Response{}
+	v = newTestRequestVariables("GET", "/public", map[string]string{"Origin": "http://b.domain"})
+	matcher.Locate("/public").RewriteResponse(v, res)
+	assert.Equal(s.T(), "*", string(res.Header.Peek("Access-Control-Allow-Origin")))
+	assert.Equal(s.T(), "", string(res.Header.Peek("Access-Control-Allow-Credentials")))
+
+	// the credentials are disabled with the wildcard origin, the origin is not reflected
+	location = matcher.Locate("/credentials")
+	assert.False(s.T(), location.CORS.AllowCredentials)
+	res = &fasthttp.Response{}
+	v = newTestRequestVariables("GET", "/credentials", map[string]string{"Origin": "http://evil.domain"})
+	location.RewriteResponse(v, res)
+	assert.Equal(s.T(), "*", string(res.Header.Peek("Access-Control-Allow-Origin")))
+	assert.Equal(s.T(), "", string(res.Header.Peek("Access-Control-Allow-Credentials")))
+}
@@ -0,0 +1,436 @@
+package http
+
+import (
+	"bytes"
+	"container/list"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+)
+
+// the cache status of the requests
+const (
+	CacheStatusBypass = "BYPASS"
+	CacheStatusMiss   = "MISS"
+	CacheStatusHit    = "HIT"
+	CacheStatusStale  = "STALE"
+)
+
+const (
+	DefaultCacheSize        = 1000
+	DefaultCacheMaxBodySize = 1024 * 1024
+
+	cacheMetricsRole = "motan-http-cache"
+	cacheDirectName  = "direct"
+)
+
+// the responses with these status codes are cacheable by default, see RFC 7231 section 6.1
+var cacheableStatusCodes = map[int]bool{
+	fasthttp.StatusOK:                   true,
+	fasthttp.StatusNonAuthoritativeInfo: true,
+	fasthttp.StatusMultipleChoices:      true,
+	fasthttp.StatusMovedPermanently:     true,
+	fasthttp.StatusPermanentRedirect:    true,
+	fasthttp.StatusNotFound:             true,
+	fasthttp.StatusGone:                 true,
+}
+
+// CacheConfig is the response cache config of a location, the responses are cached in memory by the LRU policy
+type CacheConfig struct {
+	// Size is the max number of the cached responses
+	Size int `yaml:"size"`
+	// MaxBodySize is the max body size in bytes of the cached responses
+	MaxBodySize int `yaml:"maxBodySize"`
+	// TTL in seconds overrides the freshness lifetime from the Cache-Control and Expires headers
+	TTL int `yaml:"ttl"`
+	// StaleWhileRevalidate in seconds is used if the response has no stale-while-revalidate directive
+	StaleWhileRevalidate int `yaml:"staleWhileRevalidate"`
+	// KeyHeaders are the request headers in the cache key besides the method, host and uri
+	KeyHeaders []string `yaml:"keyHeaders"`
+	// Bypass rules like: header X-No-Cache, cookie nocache, query nocache
+	Bypass []string `yaml:"bypass"`
+}
+
+func newCacheConfig(conf map[interface{}]interface{}) *CacheConfig {
+	c := &CacheConfig{
+		KeyHeaders: configStrings(conf["keyHeaders"]),
+		Bypass:     configStrings(conf["bypass"]),
+	}
+	c.Size, _ = strconv.Atoi(configString(conf["size"]))
+	c.MaxBodySize, _ = strconv.Atoi(configString(conf["maxBodySize"]))
+	c.TTL, _ = strconv.Atoi(configString(conf["ttl"]))
+	c.StaleWhileRevalidate, _ = strconv.Atoi(configString(conf["staleWhileRevalidate"]))
+	return c
+}
+
+// CacheFetchFunc fetches the response from the upstream when the cache missed
+type CacheFetchFunc func(req *fasthttp.Request, res *fasthttp.Response) error
+
+// ResponseCache caches the responses of a location, the concurrent requests of the same missed key are coalesced
+// and the stale responses are revalidated in background
+type ResponseCache struct {
+	config      *CacheConfig
+	size        int
+	maxBodySize int
+	bypassRules []*cacheBypassRule
+	group       string
+	service     string
+
+	lock    sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	calls   map[string]*cacheCall
+
+	lookups int64
+	hits    int64
+}
+
+type cacheEntry struct {
+	key        string
+	header     *fasthttp.ResponseHeader
+	body       []byte
+	storedAt   time.Time
+	freshUntil time.Time
+	staleUntil time.Time
+}
+
+func (e *cacheEntry) writeTo(res *fasthttp.Response, now time.Time) {
+	res.Reset()
+	e.header.CopyTo(&res.Header)
+	res.SetBody(e.body)
+	if age := int(now.Sub(e.storedAt).Seconds()); age > 0 {
+		res.Header.Set("Age", strconv.Itoa(age))
+	}
+}
+
+// cacheCall is the in-flight fetch of a key
+type cacheCall struct {
+	wg    sync.WaitGroup
+	entry *cacheEntry
+	err   error
+}
+
+// config like follows
+// header X-No-Cache
+// cookie nocache
+// query nocache
+type cacheBypassRule struct {
+	source string
+	name   string
+}
+
+func (r *cacheBypassRule) match(req *fasthttp.Request) bool {
+	var value []byte
+	switch r.source {
+	case "header":
+		value = req.Header.Peek(r.name)
+	case "cookie":
+		value = req.Header.Cookie(r.name)
+	case "query":
+		value = req.URI().QueryArgs().Peek(r.name)
+	}
+	// like nginx the empty value and "0" do not bypass the cache
+	return len(value) > 0 && string(value) != "0"
+}
+
+func newResponseCache(config *CacheConfig, domain string, upstream string) *ResponseCache {
+	c := &ResponseCache{
+		config:      config,
+		size:        config.Size,
+		maxBodySize: config.MaxBodySize,
+		group:       metrics.Escape(domain),
+		service:     metrics.Escape(upstream),
+		lru:         list.New(),
+		entries:     make(map[string]*list.Element),
+		calls:       make(map[string]*cacheCall),
+	}
+	if c.size <= 0 {
+		c.size = DefaultCacheSize
+	}
+	if c.maxBodySize <= 0 {
+		c.maxBodySize = DefaultCacheMaxBodySize
+	}
+	if c.service == "" {
+		c.service = cacheDirectName
+	}
+	for _, rule := range config.Bypass {
+		args := WhitespaceSplitPattern.Split(strings.TrimSpace(rule), 2)
+		if len(args) != 2 || (args[0] != "header" && args[0] != "cookie" && args[0] != "query") {
+			vlog.Errorf("Illegal cache bypass rule %s", rule)
+			continue
+		}
+		c.bypassRules = append(c.bypassRules, &cacheBypassRule{source: args[0], name: args[1]})
+	}
+	return c
+}
+
+// Do writes the cached response to res if the request is cached, otherwise the response is fetched and cached.
+// It returns the cache status of the request
+func (c *ResponseCache) Do(req *fasthttp.Request, res *fasthttp.Response, fetch CacheFetchFunc) (string, error) {
+	key, ok := c.key(req)
+	if !ok {
+		c.addMetrics(CacheStatusBypass)
+		return CacheStatusBypass, fetch(req, res)
+	}
+	now := time.Now()
+	c.lock.Lock()
+	if e := c.get(key, now); e != nil {
+		if now.Before(e.freshUntil) {
+			c.lock.Unlock()
+			e.writeTo(res, now)
+			c.addMetrics(CacheStatusHit)
+			return CacheStatusHit, nil
+		}
+		// the stale response is used while it's revalidating
+		if _, ok := c.calls[key]; !ok {
+			call := c.newCall(key)
+			revalidateReq := fasthttp.AcquireRequest()
+			req.CopyTo(revalidateReq)
+			go func() {
+				defer fasthttp.ReleaseRequest(revalidateReq)
+				revalidateRes := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(revalidateRes)
+				c.fetch(key, call, revalidateReq, revalidateRes, fetch)
+				if call.err != nil {
+					vlog.Warningf("Revalidate cache %s failed: %s", key, call.err.Error())
+				}
+			}()
+		}
+		c.lock.Unlock()
+		e.writeTo(res, now)
+		c.addMetrics(CacheStatusStale)
+		return CacheStatusStale, nil
+	}
+	if call, ok := c.calls[key]; ok {
+		c.lock.Unlock()
+		call.wg.Wait()
+		c.addMetrics(CacheStatusMiss)
+		if call.err != nil {
+			return CacheStatusMiss, call.err
+		}
+		if call.entry.storedAt.IsZero() {
+			// the response is not shared, it may be personalized for the leader request
+			return CacheStatusMiss, fetch(req, res)
+		}
+		call.entry.writeTo(res, time.Now())
+		return CacheStatusMiss, nil
+	}
+	call := c.newCall(key)
+	c.lock.Unlock()
+	c.fetch(key, call, req, res, fetch)
+	c.addMetrics(CacheStatusMiss)
+	return CacheStatusMiss, call.err
+}
+
+// Len returns the number of the cached responses
+func (c *ResponseCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len()
+}
+
+func (c *ResponseCache) newCall(key string) *cacheCall {
+	call := &cacheCall{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	return call
+}
+
+func (c *ResponseCache) fetch(key string, call *cacheCall, req *fasthttp.Request, res *fasthttp.Response, fetch CacheFetchFunc) {
+	defer func() {
+		c.lock.Lock()
+		delete(c.calls, key)
+		c.lock.Unlock()
+		call.wg.Done()
+	}()
+	if call.err = fetch(req, res); call.err != nil {
+		return
+	}
+	call.entry = c.newEntry(key, res, time.Now())
+	if !call.entry.storedAt.IsZero() {
+		c.lock.Lock()
+		c.put(call.entry)
+		c.lock.Unlock()
+	}
+}
+
+// newEntry copies the response, the storedAt is zero if the response is not cacheable
+func (c *ResponseCache) newEntry(key string, res *fasthttp.Response, now time.Time) *cacheEntry {
+	e := &cacheEntry{key: key, header: &fasthttp.ResponseHeader{}}
+	res.Header.CopyTo(e.header)
+	e.body = append([]byte(nil), res.Body()...)
+	lifetime, stale, ok := c.lifetime(res, now)
+	if ok && len(e.body) <= c.maxBodySize {
+		e.storedAt = now
+		e.freshUntil = now.Add(lifetime)
+		e.staleUntil = e.freshUntil.Add(stale)
+	}
+	return e
+}
+
+// lifetime returns the freshness lifetime and the stale-while-revalidate time of the response
+func (c *ResponseCache) lifetime(res *fasthttp.Response, now time.Time) (time.Duration, time.Duration, bool) {
+	if !cacheableStatusCodes[res.StatusCode()] || len(res.Header.Peek("Set-Cookie")) > 0 {
+		return 0, 0, false
+	}
+	if !c.varyByKeyHeaders(string(res.Header.Peek("Vary"))) {
+		return 0, 0, false
+	}
+	directives := parseCacheControl(string(res.Header.Peek("Cache-Control")))
+	if _, ok := directives["no-store"]; ok {
+		return 0, 0, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return 0, 0, false
+	}
+	if _, ok := directives["private"]; ok {
+		return 0, 0, false
+	}
+	stale := time.Duration(c.config.StaleWhileRevalidate) * time.Second
+	if s, err := strconv.Atoi(directives["stale-while-revalidate"]); err == nil && s >= 0 {
+		stale = time.Duration(s) * time.Second
+	}
+	if c.config.TTL > 0 {
+		return time.Duration(c.config.TTL) * time.Second, stale, true
+	}
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[d]; ok {
+			age, err := strconv.Atoi(v)
+			if err != nil || age <= 0 {
+				return 0, 0, false
+			}
+			return time.Duration(age) * time.Second, stale, true
+		}
+	}
+	if expires := res.Header.Peek("Expires"); len(expires) > 0 {
+		t, err := fasthttp.ParseHTTPDate(expires)
+		if err != nil || !t.After(now) {
+			return 0, 0, false
+		}
+		return t.Sub(now), stale, true
+	}
+	return 0, 0, false
+}
+
+// varyByKeyHeaders returns true if all the headers of the Vary are in the cache key
+func (c *ResponseCache) varyByKeyHeaders(vary string) bool {
+	for _, h := range strings.Split(vary, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		found := false
+		for _, k := range c.config.KeyHeaders {
+			if strings.EqualFold(h, k) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// key returns the cache key of the request, it returns false if the request should bypass the cache
+func (c *ResponseCache) key(req *fasthttp.Request) (string, bool) {
+	if !req.Header.IsGet() && !req.Header.IsHead() {
+		return "", false
+	}
+	if len(req.Header.Peek("Authorization")) > 0 {
+		return "", false
+	}
+	directives := parseCacheControl(string(req.Header.Peek("Cache-Control")))
+	if _, ok := directives["no-cache"]; ok {
+		return "", false
+	}
+	if _, ok := directives["no-store"]; ok {
+		return "", false
+	}
+	if bytes.Contains(req.Header.Peek("Pragma"), []byte("no-cache")) {
+		return "", false
+	}
+	for _, r := range c.bypassRules {
+		if r.match(req) {
+			return "", false
+		}
+	}
+	var buf strings.Builder
+	buf.Write(req.Header.Method())
+	buf.WriteByte(' ')
+	buf.Write(req.Host())
+	buf.Write(req.RequestURI())
+	for _, h := range c.config.KeyHeaders {
+		buf.WriteByte('\n')
+		buf.WriteString(h)
+		buf.WriteByte(':')
+		buf.Write(req.Header.Peek(h))
+	}
+	return buf.String(), true
+}
+
+func (c *ResponseCache) get(key string, now time.Time) *cacheEntry {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := element.Value.(*cacheEntry)
+	if !now.Before(e.staleUntil) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return e
+}
+
+func (c *ResponseCache) put(e *cacheEntry) {
+	if element, ok := c.entries[e.key]; ok {
+		element.Value = e
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *ResponseCache) addMetrics(status string) {
+	metrics.AddCounter(c.group, c.service, cacheMetricsRole+":"+strings.ToLower(status)+"_count", 1)
+	if status == CacheStatusBypass {
+		return
+	}
+	lookups := atomic.AddInt64(&c.lookups, 1)
+	hits := atomic.LoadInt64(&c.hits)
+	if status == CacheStatusHit || status == CacheStatusStale {
+		hits = atomic.AddInt64(&c.hits, 1)
+	}
+	// the hit ratio in percent of the cacheable requests
+	metrics.AddGauge(c.group, c.service, cacheMetricsRole+":hit_ratio", hits*100/lookups)
+}
+
+func parseCacheControl(cacheControl string) map[string]string {
+	directives := make(map[string]string)
+	for _, d := range strings.Split(cacheControl, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		if i := strings.IndexByte(d, '='); i >= 0 {
+			directives[strings.ToLower(d[:i])] = strings.Trim(d[i+1:], `"`)
+		} else {
+			directives[strings.ToLower(d)] = ""
+		}
+	}
+	return directives
+}
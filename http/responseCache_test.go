@@ -0,0 +1,228 @@
+package http
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+type cacheTestUpstream struct {
+	count        int32
+	cacheControl string
+	setCookie    bool
+	delay        time.Duration
+	err          error
+}
+
+func (u *cacheTestUpstream) fetch(req *fasthttp.Request, res *fasthttp.Response) error {
+	n := atomic.AddInt32(&u.count, 1)
+	if u.delay > 0 {
+		time.Sleep(u.delay)
+	}
+	if u.err != nil {
+		return u.err
+	}
+	res.SetStatusCode(fasthttp.StatusOK)
+	if u.cacheControl != "" {
+		res.Header.Set("Cache-Control", u.cacheControl)
+	}
+	if u.setCookie {
+		res.Header.Set("Set-Cookie", "session="+strconv.Itoa(int(n)))
+	}
+	res.SetBodyString(string(req.RequestURI()) + " " + strconv.Itoa(int(n)))
+	return nil
+}
+
+func newCacheTestRequest(method string, uri string) *fasthttp.Request {
+	req := &fasthttp.Request{}
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	req.Header.SetHost("test.domain")
+	return req
+}
+
+func (s *APITestSuite) TestResponseCache() {
+	cache := newResponseCache(&CacheConfig{Bypass: []string{"query nocache", "cookie session", "illegal"}}, "test.domain", "test")
+	upstream := &cacheTestUpstream{cacheControl: "max-age=60"}
+	res := &fasthttp.Response{}
+	status, err := cache.Do(newCacheTestRequest("GET", "/a"), res, upstream.fetch)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), CacheStatusMiss, status)
+	assert.Equal(s.T(), "/a 1", string(res.Body()))
+	res = &fasthttp.Response{}
+	status, _ = cache.Do(newCacheTestRequest("GET", "/a"), res, upstream.fetch)
+	assert.Equal(s.T(), CacheStatusHit, status)
+	assert.Equal(s.T(), "/a 1", string(res.Body()))
+	assert.Equal(s.T(), "max-age=60", string(res.Header.Peek("Cache-Control")))
+
+	// bypass
+	for _, req := range []*fasthttp.Request{
+		newCacheTestRequest("POST", "/a"),
+		newCacheTestRequest("GET", "/a?nocache=1"),
+	} {
+		status, _ = cache.Do(req, &fasthttp.Response{}, upstream.fetch)
+		assert.Equal(s.T(), CacheStatusBypass, status)
+	}
+	req := newCacheTestRequest("GET", "/a")
+	req.Header.SetCookie("session", "1")
+	status, _ = cache.Do(req, &fasthttp.Response{}, upstream.fetch)
+	assert.Equal(s.T(), CacheStatusBypass, status)
+	req = newCacheTestRequest("GET", "/a")
+	req.Header.Set("Cache-Control", "no-cache")
+	status, _ = cache.Do(req, &fasthttp.Response{}, upstream.fetch)
+	assert.Equal(s.T(), CacheStatusBypass, status)
+	// the zero value does not bypass
+	status, _ = cache.Do(newCacheTestRequest("GET", "/a?nocache=0"), &fasthttp.Response{}, upstream.fetch)
+	assert.Equal(s.T(), CacheStatusMiss, status)
+
+	// not cacheable responses
+	for _, cacheControl := range []string{"", "no-store", "private, max-age=60", "max-age=0"} {
+		upstream.cacheControl = cacheControl
+		cache.Do(newCacheTestRequest("GET", "/b"), &fasthttp.Response{}, upstream.fetch)
+		status, _ = cache.Do(newCacheTestRequest("GET", "/b"), &fasthttp.Response{}, upstream.fetch)
+		assert.Equal(s.T(), CacheStatusMiss, status, cacheControl)
+	}
+
+	// the errors are not cached
+	upstream.err = errors.New("test error")
+	_, err = cache.Do(newCacheTestRequest("GET", "/c"), &fasthttp.Response{}, upstream.fetch)
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), 2, cache.Len())
+}
+
+func (s *APITestSuite) TestResponseCacheTTLAndEviction() {
+	cache := newResponseCache(&CacheConfig{Size: 2, TTL: 60, KeyHeaders: []string{"X-Version"}}, "", "")
+	upstream := &cacheTestUpstream{}
+	for _, uri := range []string{"/1", "/2", "/1", "/3"} {
+		cache.Do(newCacheTestRequest("GET", uri), &fasthttp.Response{}, upstream.fetch)
+	}
+	assert.Equal(s.T(), 2, cache.Len())
+	// the least recently used /2 is evicted
+	status, _ := cache.Do(newCacheTestRequest("GET", "/1"), &fasthttp.Response{}, upstream.fetch)
+	assert.Equal(s.T(), CacheStatusHit, status)
+	status, _ = cache.Do(newCacheTestRequest("GET", "/2"), &fasthttp.Response{}, upstream.fetch)
+	assert.Equal(s.T(), CacheStatusMiss, status)
+
+	// the key headers
+	req := newCacheTestRequest("GET", "/2")
+	req.Header.Set("X-Version", "2")
+	status, _ = cache.Do(req, &fasthttp.Response{}, upstream.fetch)
+	assert.Equal(s.T(), CacheStatusMiss, status)
+	status, _ = cache.Do(req, &fasthttp.Response{}, upstream.fetch)
+	assert.Equal(s.T(), CacheStatusHit, status)
+}
+
+func (s *APITestSuite) TestResponseCacheCoalescing() {
+	cache := newResponseCache(&CacheConfig{}, "test.domain", "test")
+	upstream := &cacheTestUpstream{cacheControl: "max-age=60", delay: 100 * time.Millisecond}
+	wg := sync.WaitGroup{}
+	bodies := make([]string, 10)
+	for i := 0; i < len(bodies); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res := &fasthttp.Response{}
+			cache.Do(newCacheTestRequest("GET", "/a"), res, upstream.fetch)
+			bodies[i] = string(res.Body())
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(s.T(), int32(1), atomic.LoadInt32(&upstream.count))
+	for _, body := range bodies {
+		assert.Equal(s.T(), "/a 1", body)
+	}
+}
+
+func (s *APITestSuite) TestResponseCacheCoalescingNotCacheable() {
+	cache := newResponseCache(&CacheConfig{}, "test.domain", "test")
+	for _, upstream := range []*cacheTestUpstream{
+		{cacheControl: "private, max-age=60", delay: 100 * time.Millisecond},
+		{cacheControl: "max-age=60", setCookie: true, delay: 100 * time.Millisecond},
+	} {
+		wg := sync.WaitGroup{}
+		responses := make([]*fasthttp.Response, 2)
+		for i := 0; i < len(responses); i++ {
+			wg.Add(1)
+			responses[i] = &fasthttp.Response{}
+			go func(res *fasthttp.Response) {
+				defer wg.Done()
+				cache.Do(newCacheTestRequest("GET", "/private"), res, upstream.fetch)
+			}(responses[i])
+			// the second request waits for the first one in flight
+			time.Sleep(20 * time.Millisecond)
+		}
+		wg.Wait()
+		// the follower fetches its own response instead of sharing the leader's one
+		assert.Equal(s.T(), int32(2), atomic.LoadInt32(&upstream.count))
+		assert.NotEqual(s.T(), string(responses[0].Body()), string(responses[1].Body()))
+		if upstream.setCookie {
+			assert.NotEqual(s.T(), string(responses[0].Header.Peek("Set-Cookie")), string(responses[1].Header.Peek("Set-Cookie")))
+		}
+	}
+	assert.Equal(s.T(), 0, cache.Len())
+}
+
+func (s *APITestSuite) TestResponseCacheStaleWhileRevalidate() {
+	cache := newResponseCache(&CacheConfig{}, "test.domain", "test")
+	upstream := &cacheTestUpstream{cacheControl: "max-age=60, stale-while-revalidate=60"}
+	cache.Do(newCacheTestRequest("GET", "/a"), &fasthttp.Response{}, upstream.fetch)
+	// make the response stale
+	cache.lock.Lock()
+	e := cache.entries["GET test.domain/a"].Value.(*cacheEntry)
+	e.freshUntil = time.Now().Add(-time.Second)
+	cache.lock.Unlock()
+
+	res := &fasthttp.Response{}
+	status, _ := cache.Do(newCacheTestRequest("GET", "/a"), res, upstream.fetch)
+	assert.Equal(s.T(), CacheStatusStale, status)
+	assert.Equal(s.T(), "/a 1", string(res.Body()))
+	for i := 0; i < 100 && atomic.LoadInt32(&upstream.count) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	res = &fasthttp.Response{}
+	status, _ = cache.Do(newCacheTestRequest("GET", "/a"), res, upstream.fetch)
+	assert.Equal(s.T(), CacheStatusHit, status)
+	assert.Equal(s.T(), "/a 2", string(res.Body()))
+
+	// the expired response is removed
+	cache.lock.Lock()
+	e = cache.entries["GET test.domain/a"].Value.(*cacheEntry)
+	e.freshUntil = time.Now().Add(-time.Minute)
+	e.staleUntil = time.Now().Add(-time.Second)
+	cache.lock.Unlock()
+	status, _ = cache.Do(newCacheTestRequest("GET", "/a"), &fasthttp.Response{}, upstream.fetch)
+	assert.Equal(s.T(), CacheStatusMiss, status)
+}
+
+func (s *APITestSuite) TestLocationCacheConfig() {
+	const cacheTestData = `
+http-locations:
+  test.domain:
+  - match: /cache
+    upstream: test
+    cache:
+      size: 10
+      ttl: 30
+      staleWhileRevalidate: 5
+      keyHeaders:
+      - Accept-Language
+      bypass:
+      - header X-No-Cache
+`
+	matcher := newLocationRulesTestMatcherFrom(cacheTestData)
+	location := matcher.Locate("/cache/1")
+	assert.True(s.T(), location.HasRules())
+	cache := location.ResponseCache()
+	assert.NotNil(s.T(), cache)
+	assert.Equal(s.T(), 10, cache.size)
+	assert.Equal(s.T(), 30, location.Cache.TTL)
+	assert.Equal(s.T(), 5, location.Cache.StaleWhileRevalidate)
+	assert.Equal(s.T(), []string{"Accept-Language"}, location.Cache.KeyHeaders)
+	assert.Len(s.T(), cache.bypassRules, 1)
+}
@@ -41,20 +41,31 @@ type WeightedLbWraper struct {
 	weightstring string
 	refers       innerRefers
 	newLb        motan.NewLbFunc
+	slowStart    *slowStart
 }
 
 func NewWeightLbFunc(newLb motan.NewLbFunc) motan.NewLbFunc {
 	return func(url *motan.URL) motan.LoadBalance {
-		return &WeightedLbWraper{url: url, newLb: newLb, refers: &singleGroupRefers{lb: newLb(url)}}
+		w := &WeightedLbWraper{url: url, newLb: newLb, slowStart: newSlowStart(url)}
+		w.refers = &singleGroupRefers{lb: w.newInnerLb()}
+		return w
 	}
 }
 
+// newInnerLb creates the load balance of a group, the slow start is shared by the groups
+func (w *WeightedLbWraper) newInnerLb() motan.LoadBalance {
+	lb := w.newLb(w.url)
+	setSlowStart(lb, w.slowStart)
+	return lb
+}
+
 func (w *WeightedLbWraper) OnRefresh(endpoints []motan.EndPoint) {
+	w.slowStart.refresh(endpoints)
 	if w.weightstring == "" { //not weighted lb
 		if sgr, ok := w.refers.(*singleGroupRefers); ok {
 			sgr.lb.OnRefresh(endpoints)
 		} else {
-			lb := w.newLb(w.url)
+			lb := w.newInnerLb()
 			lb.OnRefresh(endpoints)
 			w.refers = &singleGroupRefers{lb: lb}
 		}
@@ -92,11 +103,13 @@ func (w *WeightedLbWraper) OnRefresh(endpoints []motan.EndPoint) {
 	}
 	weightsArray := make([]int, 0, 16)
 	wr := newWeightRefers()
+	wr.slowStart = w.slowStart
 	for g, e := range groupEp {
 		//build lb
-		lb := w.newLb(w.url)
+		lb := w.newInnerLb()
 		lb.OnRefresh(e)
 		wr.groupLb[g] = lb
+		wr.groupEndpoints[g] = e
 		//build real weight
 		wi := gws[g]
 		if wi < 1 || wi > 100 { //weight normalization
@@ -155,18 +168,30 @@ type weightedRefers struct {
 	ringSize    int
 	groupLb     map[string]motan.LoadBalance
 	index       uint32
+	// groupEndpoints and slowStart reduce the weight of a group by the warmup of its endpoints
+	groupEndpoints map[string][]motan.EndPoint
+	slowStart      *slowStart
 }
 
 func (w *weightedRefers) selectNext(request motan.Request) motan.EndPoint {
-	nextIndex := atomic.AddUint32(&w.index, 1)
-	g := w.weightRing[nextIndex%uint32(w.ringSize)]
-	return w.groupLb[g].Select(request)
+	return w.groupLb[w.selectGroup()].Select(request)
 }
 
 func (w *weightedRefers) selectNextArray(request motan.Request) []motan.EndPoint {
+	return w.groupLb[w.selectGroup()].SelectArray(request)
+}
+
+func (w *weightedRefers) selectGroup() string {
 	nextIndex := atomic.AddUint32(&w.index, 1)
 	g := w.weightRing[nextIndex%uint32(w.ringSize)]
-	return w.groupLb[g].SelectArray(request)
+	if !w.slowStart.isWarming() {
+		return g
+	}
+	for i := 1; i < maxSlowStartSelectTimes && !w.slowStart.accept(w.slowStart.groupWeight(w.groupEndpoints[g])); i++ {
+		nextIndex = atomic.AddUint32(&w.index, 1)
+		g = w.weightRing[nextIndex%uint32(w.ringSize)]
+	}
+	return g
 }
 
 func newWeightRefers() *weightedRefers {
@@ -174,6 +199,7 @@ func newWeightRefers() *weightedRefers {
 	wr.groupWeight = make(map[string]int, 16)
 	wr.weightRing = make([]string, 0, 32)
 	wr.groupLb = make(map[string]motan.LoadBalance, 16)
+	wr.groupEndpoints = make(map[string][]motan.EndPoint, 16)
 	return wr
 }
 
@@ -8,6 +8,7 @@ type RandomLB struct {
 	url       *motan.URL
 	endpoints []motan.EndPoint
 	weight    string
+	slowStart *slowStart
 }
 
 func (r *RandomLB) OnRefresh(endpoints []motan.EndPoint) {
@@ -15,12 +16,16 @@ func (r *RandomLB) OnRefresh(endpoints []motan.EndPoint) {
 }
 func (r *RandomLB) Select(request motan.Request) motan.EndPoint {
 	eps := r.endpoints
-	_, endpoint := SelectOneAtRandom(eps)
+	_, endpoint := r.slowStart.selectEndpoint(func() (int, motan.EndPoint) {
+		return SelectOneAtRandom(eps)
+	})
 	return endpoint
 }
 func (r *RandomLB) SelectArray(request motan.Request) []motan.EndPoint {
 	eps := r.endpoints
-	index, endpoint := SelectOneAtRandom(eps)
+	index, endpoint := r.slowStart.selectEndpoint(func() (int, motan.EndPoint) {
+		return SelectOneAtRandom(eps)
+	})
 	if endpoint == nil {
 		return nil
 	}
@@ -30,3 +35,7 @@ func (r *RandomLB) SelectArray(request motan.Request) []motan.EndPoint {
 func (r *RandomLB) SetWeight(weight string) {
 	r.weight = weight
 }
+
+func (r *RandomLB) setSlowStart(s *slowStart) {
+	r.slowStart = s
+}
@@ -11,6 +11,7 @@ type RoundrobinLB struct {
 	endpoints []motan.EndPoint
 	index     uint32
 	weight    string
+	slowStart *slowStart
 }
 
 func (r *RoundrobinLB) OnRefresh(endpoints []motan.EndPoint) {
@@ -19,13 +20,17 @@ func (r *RoundrobinLB) OnRefresh(endpoints []motan.EndPoint) {
 
 func (r *RoundrobinLB) Select(request motan.Request) motan.EndPoint {
 	eps := r.endpoints
-	_, endpoint := r.roundrobinSelect(eps)
+	_, endpoint := r.slowStart.selectEndpoint(func() (int, motan.EndPoint) {
+		return r.roundrobinSelect(eps)
+	})
 	return endpoint
 }
 
 func (r *RoundrobinLB) SelectArray(request motan.Request) []motan.EndPoint {
 	eps := r.endpoints
-	index, endpoint := r.roundrobinSelect(eps)
+	index, endpoint := r.slowStart.selectEndpoint(func() (int, motan.EndPoint) {
+		return r.roundrobinSelect(eps)
+	})
 	if endpoint == nil {
 		return nil
 	}
@@ -36,6 +41,10 @@ func (r *RoundrobinLB) SetWeight(weight string) {
 	r.weight = weight
 }
 
+func (r *RoundrobinLB) setSlowStart(s *slowStart) {
+	r.slowStart = s
+}
+
 func (r *RoundrobinLB) roundrobinSelect(eps []motan.EndPoint) (int, motan.EndPoint) {
 	epsLen := len(eps)
 	if epsLen == 0 {
@@ -0,0 +1,151 @@
+package lb
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+const (
+	// SlowStartWindowKey is the warmup time(ms) of the newly added endpoints, the slow start is disabled if it's 0
+	SlowStartWindowKey = "slowStartWindow"
+	// SlowStartAggressionKey is the curve of the warmup, the weight is (elapsed/window)^(1/aggression).
+	// 1 means ramping up linearly, the bigger aggression makes the weight ramp up faster at the beginning
+	SlowStartAggressionKey = "slowStartAggression"
+	// SlowStartMinWeightKey is the min weight percent of a warming endpoint
+	SlowStartMinWeightKey = "slowStartMinWeight"
+
+	defaultSlowStartAggression = 1.0
+	defaultSlowStartMinWeight  = 10
+	maxSlowStartSelectTimes    = 5
+)
+
+// slowStartAware is implemented by the load balances which reduce the traffic of the warming endpoints
+type slowStartAware interface {
+	setSlowStart(s *slowStart)
+}
+
+func setSlowStart(lb motan.LoadBalance, s *slowStart) {
+	if ssa, ok := lb.(slowStartAware); ok && s != nil {
+		ssa.setSlowStart(s)
+	}
+}
+
+// slowStart records when the endpoints are added, and ramps up the weights of the new endpoints in the window.
+// The endpoints of the first refresh are warm, an endpoint removed and added again (e.g. returned from the outlier
+// ejection) warms up again
+type slowStart struct {
+	window     time.Duration
+	aggression float64
+	minWeight  float64
+
+	lock        sync.RWMutex
+	initialized bool
+	startTimes  map[string]time.Time
+	warmUntil   time.Time
+}
+
+func newSlowStart(url *motan.URL) *slowStart {
+	window := url.GetTimeDuration(SlowStartWindowKey, time.Millisecond, 0)
+	if window <= 0 {
+		return nil
+	}
+	aggression, err := strconv.ParseFloat(url.GetParam(SlowStartAggressionKey, ""), 64)
+	if err != nil || aggression <= 0 {
+		aggression = defaultSlowStartAggression
+	}
+	minWeight := url.GetIntValue(SlowStartMinWeightKey, defaultSlowStartMinWeight)
+	if minWeight < 1 || minWeight > 100 {
+		minWeight = defaultSlowStartMinWeight
+	}
+	return &slowStart{
+		window:     window,
+		aggression: aggression,
+		minWeight:  float64(minWeight) / 100,
+		startTimes: make(map[string]time.Time),
+	}
+}
+
+func (s *slowStart) refresh(endpoints []motan.EndPoint) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	startTimes := make(map[string]time.Time, len(endpoints))
+	for _, ep := range endpoints {
+		key := ep.GetURL().GetIdentity()
+		if t, ok := s.startTimes[key]; ok {
+			startTimes[key] = t
+		} else if s.initialized {
+			startTimes[key] = now
+			s.warmUntil = now.Add(s.window)
+			vlog.Infof("[slowStart] endpoint %s starts warming up in %v", key, s.window)
+		} else {
+			startTimes[key] = time.Time{}
+		}
+	}
+	s.startTimes = startTimes
+	if len(endpoints) > 0 {
+		s.initialized = true
+	}
+}
+
+func (s *slowStart) isWarming() bool {
+	if s == nil {
+		return false
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return time.Now().Before(s.warmUntil)
+}
+
+// weight returns the effective weight ratio(minWeight ~ 1) of the endpoint
+func (s *slowStart) weight(ep motan.EndPoint) float64 {
+	s.lock.RLock()
+	start, ok := s.startTimes[ep.GetURL().GetIdentity()]
+	s.lock.RUnlock()
+	if !ok || start.IsZero() {
+		return 1
+	}
+	elapsed := time.Since(start)
+	if elapsed >= s.window {
+		return 1
+	}
+	return math.Max(s.minWeight, math.Pow(float64(elapsed)/float64(s.window), 1/s.aggression))
+}
+
+// groupWeight returns the average weight ratio of the endpoints in a group
+func (s *slowStart) groupWeight(endpoints []motan.EndPoint) float64 {
+	if len(endpoints) == 0 {
+		return 1
+	}
+	total := 0.0
+	for _, ep := range endpoints {
+		total += s.weight(ep)
+	}
+	return total / float64(len(endpoints))
+}
+
+func (s *slowStart) accept(weight float64) bool {
+	return weight >= 1 || rand.Float64() < weight
+}
+
+// selectEndpoint reselects when the selected endpoint is warming up and not accepted by its weight, so the traffic
+// of a warming endpoint is reduced by its weight. The last selected endpoint is returned if none is accepted
+func (s *slowStart) selectEndpoint(selectFunc func() (int, motan.EndPoint)) (int, motan.EndPoint) {
+	index, ep := selectFunc()
+	if !s.isWarming() {
+		return index, ep
+	}
+	for i := 1; i < maxSlowStartSelectTimes && ep != nil && !s.accept(s.weight(ep)); i++ {
+		index, ep = selectFunc()
+	}
+	return index, ep
+}
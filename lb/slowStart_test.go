@@ -0,0 +1,122 @@
+package lb
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/endpoint"
+)
+
+func newSlowStartEndpoints(group string, from, to int) []motan.EndPoint {
+	endpoints := make([]motan.EndPoint, 0, to-from)
+	for i := from; i < to; i++ {
+		endpoints = append(endpoints, &endpoint.MockEndpoint{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 8000 + i, Group: group}})
+	}
+	return endpoints
+}
+
+func newSlowStartLb(lbName string, params map[string]string) *WeightedLbWraper {
+	url := &motan.URL{Parameters: map[string]string{motan.Lbkey: lbName, SlowStartWindowKey: "60000"}}
+	for k, v := range params {
+		url.PutParam(k, v)
+	}
+	extFactory := &motan.DefaultExtensionFactory{}
+	extFactory.Initialize()
+	RegistDefaultLb(extFactory)
+	return extFactory.GetLB(url).(*WeightedLbWraper)
+}
+
+// warmFor moves the start time of the warming endpoints to the past
+func warmFor(s *slowStart, elapsed time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for k, t := range s.startTimes {
+		if !t.IsZero() {
+			s.startTimes[k] = time.Now().Add(-elapsed)
+		}
+	}
+	s.warmUntil = time.Now().Add(s.window - elapsed)
+}
+
+func countSelected(lb motan.LoadBalance, times int) map[int]int {
+	counts := make(map[int]int)
+	for i := 0; i < times; i++ {
+		counts[lb.Select(&motan.MotanRequest{}).GetURL().Port]++
+	}
+	return counts
+}
+
+func TestSlowStartWeight(t *testing.T) {
+	assert.Nil(t, newSlowStart(&motan.URL{Parameters: map[string]string{}}))
+	s := newSlowStart(&motan.URL{Parameters: map[string]string{SlowStartWindowKey: "1000", SlowStartMinWeightKey: "0"}})
+	assert.Equal(t, time.Second, s.window)
+	assert.Equal(t, defaultSlowStartAggression, s.aggression)
+	assert.Equal(t, 0.1, s.minWeight)
+
+	// the endpoints of the first refresh are warm
+	endpoints := newSlowStartEndpoints("g", 0, 2)
+	s.refresh(endpoints)
+	assert.False(t, s.isWarming())
+	assert.Equal(t, 1.0, s.weight(endpoints[0]))
+
+	endpoints = append(endpoints, newSlowStartEndpoints("g", 2, 3)...)
+	s.refresh(endpoints)
+	assert.True(t, s.isWarming())
+	assert.Equal(t, 1.0, s.weight(endpoints[0]))
+	assert.Equal(t, 0.1, s.weight(endpoints[2]))
+	warmFor(s, 500*time.Millisecond)
+	assert.InDelta(t, 0.5, s.weight(endpoints[2]), 0.01)
+	assert.InDelta(t, 2.5/3, s.groupWeight(endpoints), 0.01)
+	s.aggression = 2
+	assert.InDelta(t, math.Sqrt(0.5), s.weight(endpoints[2]), 0.01)
+	warmFor(s, time.Second)
+	assert.False(t, s.isWarming())
+	assert.Equal(t, 1.0, s.weight(endpoints[2]))
+
+	// the removed endpoint warms up again when it's added back
+	s.refresh(endpoints[:2])
+	s.refresh(endpoints)
+	assert.Equal(t, 0.1, s.weight(endpoints[2]))
+}
+
+func TestSlowStartLb(t *testing.T) {
+	for _, lbName := range []string{Random, Roundrobin} {
+		lb := newSlowStartLb(lbName, nil)
+		endpoints := newSlowStartEndpoints("g", 0, 4)
+		lb.OnRefresh(endpoints)
+		lb.OnRefresh(append(endpoints, newSlowStartEndpoints("g", 4, 5)...))
+		counts := countSelected(lb, 10000)
+		assert.True(t, counts[8004] > 0 && counts[8004] < 1000, lbName+": "+strconv.Itoa(counts[8004]))
+		assert.Len(t, counts, 5, lbName)
+
+		warmFor(lb.slowStart, time.Minute)
+		counts = countSelected(lb, 10000)
+		assert.True(t, counts[8004] > 1500, lbName+": "+strconv.Itoa(counts[8004]))
+	}
+
+	// the slow start is disabled without the window
+	lb := newSlowStartLb(Random, map[string]string{SlowStartWindowKey: "0"})
+	assert.Nil(t, lb.slowStart)
+	assert.Nil(t, lb.refers.(*singleGroupRefers).lb.(*RandomLB).slowStart)
+}
+
+func TestSlowStartWeightedLb(t *testing.T) {
+	lb := newSlowStartLb(Roundrobin, nil)
+	lb.SetWeight("g0:50,g1:50")
+	endpoints := newSlowStartEndpoints("g0", 0, 2)
+	lb.OnRefresh(endpoints)
+	// the weight of a new group ramps up with its endpoints
+	lb.OnRefresh(append(endpoints, newSlowStartEndpoints("g1", 2, 4)...))
+	counts := countSelected(lb, 10000)
+	g1 := counts[8002] + counts[8003]
+	assert.True(t, g1 > 0 && g1 < 1000, strconv.Itoa(g1))
+
+	warmFor(lb.slowStart, time.Minute)
+	counts = countSelected(lb, 10000)
+	g1 = counts[8002] + counts[8003]
+	assert.True(t, g1 > 4000, strconv.Itoa(g1))
+}
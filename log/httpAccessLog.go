@@ -0,0 +1,126 @@
+package vlog
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// DefaultHTTPAccessLogFormat is the default format of the http proxy access logs
+const DefaultHTTPAccessLogFormat = "$remote_addr $request_method $host $request_uri $status $request_length $bytes_sent $upstream_addr $route $request_time"
+
+// HTTPAccessLogEntity is the http variant of the access log, it's set to AccessLogEntity.HTTP by the http proxy
+type HTTPAccessLogEntity struct {
+	RemoteAddress string `json:"remoteAddress"`
+	Method        string `json:"method"`
+	Host          string `json:"host"`
+	URI           string `json:"uri"`
+	Status        int    `json:"status"`
+	ReqSize       int    `json:"reqSize"`
+	ResSize       int    `json:"resSize"`
+	Upstream      string `json:"upstream"`
+	// Route is how the request is proxied, `rpc` for the requests proxied to the motan services and `direct` for the others
+	Route     string `json:"route"`
+	TotalTime int64  `json:"totalTime"`
+	// Format renders the non-structured log, nil means the DefaultHTTPAccessLogFormat
+	Format *HTTPAccessLogFormat `json:"-"`
+}
+
+// HTTPAccessLogFormat is a parsed nginx style log format, the variables such as `$status` are replaced by the
+// values of the entity, the unknown variables are kept as they are
+type HTTPAccessLogFormat struct {
+	segments []httpLogSegment
+}
+
+type httpLogSegment struct {
+	literal string
+	value   func(e *HTTPAccessLogEntity) string
+}
+
+var httpLogVariables = map[string]func(e *HTTPAccessLogEntity) string{
+	"remote_addr":    func(e *HTTPAccessLogEntity) string { return e.RemoteAddress },
+	"request_method": func(e *HTTPAccessLogEntity) string { return e.Method },
+	"host":           func(e *HTTPAccessLogEntity) string { return e.Host },
+	"request_uri":    func(e *HTTPAccessLogEntity) string { return e.URI },
+	"status":         func(e *HTTPAccessLogEntity) string { return strconv.Itoa(e.Status) },
+	"request_length": func(e *HTTPAccessLogEntity) string { return strconv.Itoa(e.ReqSize) },
+	"bytes_sent":     func(e *HTTPAccessLogEntity) string { return strconv.Itoa(e.ResSize) },
+	"upstream_addr":  func(e *HTTPAccessLogEntity) string { return emptyToDash(e.Upstream) },
+	"route":          func(e *HTTPAccessLogEntity) string { return e.Route },
+	// in seconds with milliseconds resolution like nginx
+	"request_time":    func(e *HTTPAccessLogEntity) string { return strconv.FormatFloat(float64(e.TotalTime)/1000, 'f', 3, 64) },
+	"request_time_ms": func(e *HTTPAccessLogEntity) string { return strconv.FormatInt(e.TotalTime, 10) },
+}
+
+var defaultHTTPAccessLogFormat = ParseHTTPAccessLogFormat(DefaultHTTPAccessLogFormat)
+
+// ParseHTTPAccessLogFormat parses the nginx style format, the variables are `$name` or `${name}`
+func ParseHTTPAccessLogFormat(format string) *HTTPAccessLogFormat {
+	f := &HTTPAccessLogFormat{}
+	literal := &bytes.Buffer{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '$' {
+			literal.WriteByte(format[i])
+			continue
+		}
+		name, end := parseLogVariable(format, i+1)
+		value, ok := httpLogVariables[name]
+		if !ok {
+			literal.WriteString(format[i:end])
+			i = end - 1
+			continue
+		}
+		if literal.Len() > 0 {
+			f.segments = append(f.segments, httpLogSegment{literal: literal.String()})
+			literal.Reset()
+		}
+		f.segments = append(f.segments, httpLogSegment{value: value})
+		i = end - 1
+	}
+	if literal.Len() > 0 {
+		f.segments = append(f.segments, httpLogSegment{literal: literal.String()})
+	}
+	return f
+}
+
+// parseLogVariable returns the variable name starts at the position, and the end position of the variable
+func parseLogVariable(format string, start int) (string, int) {
+	if start < len(format) && format[start] == '{' {
+		if end := strings.IndexByte(format[start:], '}'); end > 0 {
+			return format[start+1 : start+end], start + end + 1
+		}
+		return "", start
+	}
+	end := start
+	for end < len(format) && (format[end] == '_' || (format[end] >= 'a' && format[end] <= 'z') || (format[end] >= '0' && format[end] <= '9')) {
+		end++
+	}
+	return format[start:end], end
+}
+
+// Format renders the entity by the format
+func (f *HTTPAccessLogFormat) Format(e *HTTPAccessLogEntity) string {
+	var buffer bytes.Buffer
+	for _, s := range f.segments {
+		if s.value != nil {
+			buffer.WriteString(s.value(e))
+		} else {
+			buffer.WriteString(s.literal)
+		}
+	}
+	return buffer.String()
+}
+
+func (e *HTTPAccessLogEntity) String() string {
+	if e.Format != nil {
+		return e.Format.Format(e)
+	}
+	return defaultHTTPAccessLogFormat.Format(e)
+}
+
+func emptyToDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,29 @@
+package vlog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPAccessLogFormat(t *testing.T) {
+	entity := &HTTPAccessLogEntity{
+		RemoteAddress: "127.0.0.1:1234",
+		Method:        "GET",
+		Host:          "test.domain",
+		URI:           "/path?a=b",
+		Status:        200,
+		ReqSize:       10,
+		ResSize:       20,
+		Route:         "direct",
+		TotalTime:     1234,
+	}
+	assert.Equal(t, "127.0.0.1:1234 GET test.domain /path?a=b 200 10 20 - direct 1.234", entity.String())
+
+	entity.Upstream = "test-service"
+	entity.Format = ParseHTTPAccessLogFormat(`${host}$request_uri "$status" $upstream_addr|$request_time_ms $unknown $ ${route}`)
+	assert.Equal(t, `test.domain/path?a=b "200" test-service|1234 $unknown $ direct`, entity.String())
+
+	entity.Format = ParseHTTPAccessLogFormat("")
+	assert.Equal(t, "", entity.String())
+}
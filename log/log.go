@@ -51,6 +51,8 @@ type AccessLogEntity struct {
 	Success       bool   `json:"success"`
 	ResponseCode  string `json:"responseCode"`
 	Exception     string `json:"exception"`
+	// HTTP is set by the http proxy, the http access logs are written in the http format
+	HTTP *HTTPAccessLogEntity `json:"http,omitempty"`
 }
 
 type Logger interface {
@@ -348,6 +350,10 @@ func (d *defaultLogger) AccessLog(logObject *AccessLogEntity) {
 }
 
 func (d *defaultLogger) doAccessLog(logObject *AccessLogEntity) {
+	if logObject.HTTP != nil {
+		d.doHTTPAccessLog(logObject.HTTP)
+		return
+	}
 	if d.accessStructured {
 		d.accessLogger.Info("",
 			zap.String("filterName", logObject.FilterName),
@@ -397,6 +403,24 @@ func (d *defaultLogger) doAccessLog(logObject *AccessLogEntity) {
 	}
 }
 
+func (d *defaultLogger) doHTTPAccessLog(logObject *HTTPAccessLogEntity) {
+	if d.accessStructured {
+		d.accessLogger.Info("",
+			zap.String("remoteAddress", logObject.RemoteAddress),
+			zap.String("method", logObject.Method),
+			zap.String("host", logObject.Host),
+			zap.String("uri", logObject.URI),
+			zap.Int("status", logObject.Status),
+			zap.Int("reqSize", logObject.ReqSize),
+			zap.Int("resSize", logObject.ResSize),
+			zap.String("upstream", logObject.Upstream),
+			zap.String("route", logObject.Route),
+			zap.Int64("totalTime", logObject.TotalTime))
+	} else {
+		d.accessLogger.Info(logObject.String())
+	}
+}
+
 func (d *defaultLogger) MetricsLog(msg string) {
 	d.metricsLogger.Info(msg)
 }
@@ -151,6 +151,10 @@ func (i *InfoHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		rw.Write(i.a.getConfigData())
 	case "/getReferService":
 		rw.Write(i.getReferService())
+	case "/getRequestRoutes":
+		rw.Write(i.getRequestRoutes())
+	case "/getOutlierStatus":
+		rw.Write(i.getOutlierStatus())
 	}
 }
 
@@ -159,7 +163,7 @@ func (i *InfoHandler) getReferService() []byte {
 	i.a.clusterMap.Range(func(k, v interface{}) bool {
 		cls := v.(*cluster.MotanCluster)
 		available := cls.IsAvailable()
-		mbody.Service = append(mbody.Service, rpcService{Name: k.(string), Status: available})
+		mbody.Service = append(mbody.Service, rpcService{Name: k.(string), Status: available, Endpoints: getEndpointStatus(cls.GetRefers())})
 		return true
 	})
 	retData := jsonRetData{Code: 200, Body: mbody}
@@ -167,9 +171,59 @@ func (i *InfoHandler) getReferService() []byte {
 	return data
 }
 
+// getRequestRoutes returns the request route rules of the clusters which have rules
+func (i *InfoHandler) getRequestRoutes() []byte {
+	routes := make(map[string][]cluster.RequestRouteStatus)
+	i.a.clusterMap.Range(func(k, v interface{}) bool {
+		if status := v.(*cluster.MotanCluster).GetRequestRoutes(); len(status) > 0 {
+			routes[k.(string)] = status
+		}
+		return true
+	})
+	data, _ := json.Marshal(map[string]interface{}{"code": 200, "body": routes})
+	return data
+}
+
+// getOutlierStatus returns the outlier detection status of the clusters which enable it
+func (i *InfoHandler) getOutlierStatus() []byte {
+	outliers := make(map[string]*cluster.OutlierStatus)
+	i.a.clusterMap.Range(func(k, v interface{}) bool {
+		if status := v.(*cluster.MotanCluster).GetOutlierStatus(); status != nil {
+			outliers[k.(string)] = status
+		}
+		return true
+	})
+	data, _ := json.Marshal(map[string]interface{}{"code": 200, "body": outliers})
+	return data
+}
+
+// getEndpointStatus returns the availability and the active health check status of the endpoints
+func getEndpointStatus(refers []motan.EndPoint) []endpointStatus {
+	endpoints := make([]endpointStatus, 0, len(refers))
+	for _, ep := range refers {
+		status := endpointStatus{Address: ep.GetURL().GetAddressStr(), Available: ep.IsAvailable()}
+		var caller interface{} = ep
+		if fep, ok := ep.(*motan.FilterEndPoint); ok {
+			caller = fep.Caller
+		}
+		if hc, ok := caller.(motan.HealthCheckable); ok {
+			status.Health = hc.GetHealthStatus()
+		}
+		endpoints = append(endpoints, status)
+	}
+	return endpoints
+}
+
 type rpcService struct {
-	Name   string `json:"name"`
-	Status bool   `json:"status"`
+	Name      string           `json:"name"`
+	Status    bool             `json:"status"`
+	Endpoints []endpointStatus `json:"endpoints"`
+}
+
+type endpointStatus struct {
+	Address   string              `json:"address"`
+	Available bool                `json:"available"`
+	Health    *motan.HealthStatus `json:"health,omitempty"`
 }
 
 type body struct {
@@ -678,6 +732,20 @@ func (h *HotReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			Code: 200,
 			Body: string(refersURLs),
 		})
+	case "/reload/auth":
+		ctx := &motan.Context{ConfigFile: h.agent.ConfigFile}
+		ctx.Initialize()
+		code, body := 200, "ok"
+		if ctx.Config == nil {
+			code, body = 500, "load config fail"
+		} else if err := filter.LoadAuthConfig(ctx.Config); err != nil {
+			code, body = 500, err.Error()
+		}
+		jsonEncoder := json.NewEncoder(w)
+		_ = jsonEncoder.Encode(logResponse{
+			Code: code,
+			Body: body,
+		})
 	}
 }
 
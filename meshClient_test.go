@@ -0,0 +1,36 @@
+package motan
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/server"
+)
+
+func TestMeshClient_UnixSock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "motan-mesh")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	sockFile := filepath.Join(dir, "mesh.sock")
+
+	handler := &server.DefaultMessageHandler{}
+	handler.Initialize()
+	handler.AddProvider(&LocalTestServiceProvider{url: &core.URL{Path: "UnixSockTestService"}})
+	motanServer := &server.MotanServer{URL: &core.URL{Parameters: map[string]string{core.UnixSockKey: sockFile}}}
+	assert.Nil(t, motanServer.Open(false, false, handler, GetDefaultExtFactory()))
+	defer motanServer.Destroy()
+
+	client := NewMeshClient()
+	client.SetAddress(core.UnixSockProtocolFlag + sockFile)
+	client.SetRequestTimeout(time.Second)
+	client.Initialize()
+	defer client.Destroy()
+	var reply string
+	assert.Nil(t, client.Call("UnixSockTestService", "hello", []interface{}{"uds"}, &reply))
+	assert.Equal(t, "hello uds", reply)
+}
@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// codec names, they are the protocol names of the urls
+const (
+	CodecMotan2 = "motan2"
+	CodecMotan  = "motan"
+)
+
+// Codec reads and writes the messages of a protocol.
+// The messages are always motan2 messages in memory, so the conversions between the messages and the requests or
+// responses are shared by all protocols
+type Codec interface {
+	GetName() string
+	Encode(msg *Message) ([]byte, error)
+	WriteTo(msg *Message, w io.Writer) (int64, error)
+	Decode(buf *bufio.Reader) (*Message, time.Time, error)
+}
+
+// NewCodecFunc creates a codec, the serialization is used by the protocols which do not carry it in the messages
+type NewCodecFunc func(serialization motan.Serialization) Codec
+
+var (
+	codecs = map[string]NewCodecFunc{
+		CodecMotan2: func(serialization motan.Serialization) Codec {
+			return defaultCodec
+		},
+		CodecMotan: newMotanV1Codec,
+	}
+	codecsLock   sync.RWMutex
+	defaultCodec = &motan2Codec{}
+)
+
+// RegistCodec registers the codec of a protocol
+func RegistCodec(protocol string, newCodec NewCodecFunc) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[protocol] = newCodec
+}
+
+// NewCodec creates the codec of the protocol, the motan2 codec is used if the protocol has no codec registered
+func NewCodec(protocol string, serialization motan.Serialization) Codec {
+	codecsLock.RLock()
+	newCodec := codecs[protocol]
+	codecsLock.RUnlock()
+	if newCodec == nil {
+		return defaultCodec
+	}
+	return newCodec(serialization)
+}
+
+type motan2Codec struct{}
+
+func (c *motan2Codec) GetName() string {
+	return CodecMotan2
+}
+
+func (c *motan2Codec) Encode(msg *Message) ([]byte, error) {
+	return msg.Encode().Bytes(), nil
+}
+
+func (c *motan2Codec) WriteTo(msg *Message, w io.Writer) (int64, error) {
+	return msg.WriteTo(w)
+}
+
+func (c *motan2Codec) Decode(buf *bufio.Reader) (*Message, time.Time, error) {
+	return DecodeWithTime(buf)
+}
@@ -0,0 +1,234 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// compressor names
+const (
+	CompressGzip   = "gzip"
+	CompressSnappy = "snappy"
+	CompressZstd   = "zstd"
+	CompressLz4    = "lz4"
+)
+
+var (
+	ErrCompressorNotFound = errors.New("message compressor not found")
+
+	compressors     = make(map[string]Compressor, 8)
+	compressorsLock sync.RWMutex
+	// the names of all registered compressors, advertised to the peers
+	acceptCompressors string
+)
+
+// Compressor compresses the message body.
+// gzip is identified by the gzip flag in the header for compatibility, others are identified by the MCompress metadata
+type Compressor interface {
+	GetName() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+func init() {
+	RegistCompressor(&gzipCompressor{})
+	RegistCompressor(&snappyCompressor{})
+	RegistCompressor(newZstdCompressor())
+	RegistCompressor(&lz4Compressor{})
+}
+
+// RegistCompressor registers a compressor, it should be called before any message is sent
+func RegistCompressor(c Compressor) {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	compressors[c.GetName()] = c
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	acceptCompressors = strings.Join(names, ",")
+}
+
+func GetCompressor(name string) Compressor {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	return compressors[name]
+}
+
+// AcceptCompressors returns the comma separated names of all registered compressors
+func AcceptCompressors() string {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	return acceptCompressors
+}
+
+// NegotiateCompressor returns the preferred compressor if the peer accepts it, otherwise an empty name which means gzip
+// is used just like the peers without compressor negotiation
+func NegotiateCompressor(preferred string, peerAccepts string) string {
+	if preferred == "" || preferred == CompressGzip || peerAccepts == "" {
+		return ""
+	}
+	for _, name := range strings.Split(peerAccepts, ",") {
+		if name == preferred && GetCompressor(name) != nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// NegotiateResponseCompress chooses the compressor of the response by the compressors the request accepts,
+// and advertises the compressors of this side only to the requester which supports negotiation
+func NegotiateResponseCompress(request motan.Request, response motan.Response, preferred string) {
+	accepts := request.GetAttachment(MAcceptCompress)
+	if accepts == "" {
+		return
+	}
+	response.GetRPCContext(true).CompressCodec = NegotiateCompressor(preferred, accepts)
+	response.SetAttachment(MAcceptCompress, AcceptCompressors())
+}
+
+// EncodeMessageCompress compresses the message body with the named compressor if the body is larger than minSize.
+// An empty or unknown name falls back to gzip
+func EncodeMessageCompress(msg *Message, name string, minSize int) {
+	if name == "" || name == CompressGzip {
+		EncodeMessageGzip(msg, minSize)
+		return
+	}
+	if minSize <= 0 || len(msg.Body) <= minSize || msg.Header.IsGzip() || msg.Metadata.LoadOrEmpty(MCompress) != "" {
+		return
+	}
+	c := GetCompressor(name)
+	if c == nil {
+		vlog.Warningf("compressor %s not found, use gzip instead. request id:%d", name, msg.Header.RequestID)
+		EncodeMessageGzip(msg, minSize)
+		return
+	}
+	data, err := c.Compress(msg.Body)
+	if err != nil {
+		vlog.Warningf("encode %s fail! request id:%d, err:%s", name, msg.Header.RequestID, err.Error())
+		return
+	}
+	msg.Metadata.Store(MCompress, name)
+	msg.Body = data
+}
+
+// DecodeMessageCompress decompresses the message body compressed by gzip or other compressors
+func DecodeMessageCompress(msg *Message) error {
+	if msg.Header.IsGzip() {
+		msg.Body = DecodeGzipBody(msg.Body)
+		msg.Header.SetGzip(false)
+		return nil
+	}
+	name := msg.Metadata.LoadOrEmpty(MCompress)
+	if name == "" {
+		return nil
+	}
+	c := GetCompressor(name)
+	if c == nil {
+		return ErrCompressorNotFound
+	}
+	data, err := c.Decompress(msg.Body)
+	if err != nil {
+		return err
+	}
+	msg.Body = data
+	msg.Metadata.Delete(MCompress)
+	return nil
+}
+
+type gzipCompressor struct{}
+
+func (g *gzipCompressor) GetName() string {
+	return CompressGzip
+}
+
+func (g *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	return EncodeGzip(data)
+}
+
+func (g *gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	return DecodeGzip(data)
+}
+
+type snappyCompressor struct{}
+
+func (s *snappyCompressor) GetName() string {
+	return CompressSnappy
+}
+
+func (s *snappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (s *snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+// zstdCompressor shares the encoder and the decoder, EncodeAll and DecodeAll can be called concurrently
+type zstdCompressor struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCompressor() *zstdCompressor {
+	// the errors are only returned for the illegal options
+	encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+	decoder, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	return &zstdCompressor{encoder: encoder, decoder: decoder}
+}
+
+func (z *zstdCompressor) GetName() string {
+	return CompressZstd
+}
+
+func (z *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	return z.encoder.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+}
+
+func (z *zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	return z.decoder.DecodeAll(data, nil)
+}
+
+var (
+	lz4WriterPool = sync.Pool{New: func() interface{} { return lz4.NewWriter(nil) }}
+	lz4ReaderPool = sync.Pool{New: func() interface{} { return lz4.NewReader(nil) }}
+)
+
+// lz4Compressor uses the lz4 frame format, the writers and readers are pooled because they have large buffers
+type lz4Compressor struct{}
+
+func (l *lz4Compressor) GetName() string {
+	return CompressLz4
+}
+
+func (l *lz4Compressor) Compress(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)/2))
+	w := lz4WriterPool.Get().(*lz4.Writer)
+	defer lz4WriterPool.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (l *lz4Compressor) Decompress(data []byte) ([]byte, error) {
+	r := lz4ReaderPool.Get().(*lz4.Reader)
+	defer lz4ReaderPool.Put(r)
+	r.Reset(bytes.NewReader(data))
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,198 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+type reverseCompressor struct{}
+
+func (r *reverseCompressor) GetName() string {
+	return "reverse"
+}
+
+func (r *reverseCompressor) Compress(data []byte) ([]byte, error) {
+	ret := make([]byte, len(data))
+	for i, b := range data {
+		ret[len(data)-1-i] = b
+	}
+	return ret, nil
+}
+
+func (r *reverseCompressor) Decompress(data []byte) ([]byte, error) {
+	return r.Compress(data)
+}
+
+// buildPayloads generates the compressible payloads like the real bodies, a json feed list and a yaml config
+func buildPayloads() map[string][]byte {
+	r := rand.New(rand.NewSource(1))
+	words := []string{"motan", "agent", "weibo", "feed", "timeline", "comment", "repost", "like", "group", "service"}
+	type status struct {
+		ID       int64    `json:"id"`
+		UID      int64    `json:"uid"`
+		Text     string   `json:"text"`
+		Source   string   `json:"source"`
+		Reposts  int      `json:"reposts_count"`
+		Comments int      `json:"comments_count"`
+		PicIDs   []string `json:"pic_ids"`
+	}
+	statuses := make([]status, 0, 50)
+	for i := 0; i < 50; i++ {
+		text := make([]string, 0, 12)
+		for j := 0; j < 12; j++ {
+			text = append(text, words[r.Intn(len(words))])
+		}
+		statuses = append(statuses, status{ID: 4600000000000000 + int64(i), UID: r.Int63n(10000000), Text: strings.Join(text, " "),
+			Source: "motan-go", Reposts: r.Intn(100), Comments: r.Intn(100), PicIDs: []string{fmt.Sprintf("%032x", r.Int63())}})
+	}
+	feeds, _ := json.MarshalIndent(map[string]interface{}{"statuses": statuses}, "", " ")
+	config := &bytes.Buffer{}
+	config.WriteString("motan-refer:\n")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(config, "  %s-%d:\n    path: com.weibo.%s.%sService\n    group: %s\n    protocol: motan2\n    registry: vintage\n    requestTimeout: %d\n",
+			words[i%len(words)], i, words[r.Intn(len(words))], words[r.Intn(len(words))], words[r.Intn(len(words))], 100*(r.Intn(10)+1))
+	}
+	return map[string][]byte{"feeds.json": feeds, "agent.yaml": config.Bytes()}
+}
+
+func TestCompressors(t *testing.T) {
+	for name, payload := range buildPayloads() {
+		for _, c := range []string{CompressGzip, CompressSnappy, CompressZstd, CompressLz4} {
+			msg := &Message{Header: BuildHeader(Req, false, Simple, 1, Normal), Metadata: motan.NewStringMap(DefaultMetaSize), Body: payload}
+			EncodeMessageCompress(msg, c, 100)
+			if len(msg.Body) >= len(payload) {
+				t.Fatalf("payload not compressed. codec:%s, payload:%s", c, name)
+			}
+			if (c == CompressGzip) != msg.Header.IsGzip() || (c != CompressGzip) != (msg.Metadata.LoadOrEmpty(MCompress) == c) {
+				t.Fatalf("compressor not identified. codec:%s, msg:%+v", c, msg.Header)
+			}
+			decoded, err := Decode(bufio.NewReader(msg.Encode()))
+			if err != nil {
+				t.Fatalf("decode message fail. err:%v", err)
+			}
+			if err = DecodeMessageCompress(decoded); err != nil || !bytes.Equal(payload, decoded.Body) {
+				t.Fatalf("decompress fail. codec:%s, payload:%s, err:%v", c, name, err)
+			}
+			if decoded.Header.IsGzip() || decoded.Metadata.LoadOrEmpty(MCompress) != "" {
+				t.Fatalf("compressor identifier not cleared. codec:%s", c)
+			}
+		}
+	}
+
+	// small body is not compressed
+	msg := &Message{Header: BuildHeader(Req, false, Simple, 1, Normal), Metadata: motan.NewStringMap(DefaultMetaSize), Body: []byte("small")}
+	EncodeMessageCompress(msg, CompressSnappy, 100)
+	if string(msg.Body) != "small" || msg.Metadata.LoadOrEmpty(MCompress) != "" {
+		t.Fatalf("small body should not be compressed")
+	}
+	// unknown compressor falls back to gzip
+	msg.Body = buildBytes(1024)
+	EncodeMessageCompress(msg, "unknown", 100)
+	if !msg.Header.IsGzip() {
+		t.Fatalf("unknown compressor should fall back to gzip")
+	}
+	msg = &Message{Header: BuildHeader(Req, false, Simple, 1, Normal), Metadata: motan.NewStringMap(DefaultMetaSize), Body: []byte("body")}
+	msg.Metadata.Store(MCompress, "unknown")
+	if err := DecodeMessageCompress(msg); err != ErrCompressorNotFound {
+		t.Fatalf("decode unknown compressor should fail. err:%v", err)
+	}
+
+	// custom compressor
+	RegistCompressor(&reverseCompressor{})
+	if !strings.Contains(AcceptCompressors(), "reverse") {
+		t.Fatalf("custom compressor not accepted. accepts:%s", AcceptCompressors())
+	}
+	msg.Metadata.Delete(MCompress)
+	msg.Body = []byte("abcdef")
+	EncodeMessageCompress(msg, "reverse", 1)
+	if string(msg.Body) != "fedcba" || DecodeMessageCompress(msg) != nil || string(msg.Body) != "abcdef" {
+		t.Fatalf("custom compressor not correct. body:%s", msg.Body)
+	}
+}
+
+func TestBuiltinCompressors(t *testing.T) {
+	for _, name := range []string{CompressZstd, CompressLz4} {
+		c := GetCompressor(name)
+		if c == nil {
+			t.Fatalf("%s compressor not registered", name)
+		}
+		for _, data := range [][]byte{{}, []byte("a"), buildBytes(64 * 1024), bytes.Repeat([]byte("motan"), 100*1024)} {
+			compressed, err := c.Compress(data)
+			if err != nil {
+				t.Fatalf("%s compress fail. err:%v", name, err)
+			}
+			decompressed, err := c.Decompress(compressed)
+			if err != nil || !bytes.Equal(data, decompressed) {
+				t.Fatalf("%s round trip fail. size:%d, err:%v", name, len(data), err)
+			}
+		}
+		if _, err := c.Decompress([]byte("not compressed")); err == nil {
+			t.Fatalf("decompress illegal %s data should fail", name)
+		}
+	}
+}
+
+func TestNegotiateCompressor(t *testing.T) {
+	cases := []struct {
+		preferred, accepts, expect string
+	}{
+		{CompressSnappy, "gzip,snappy", CompressSnappy},
+		{CompressSnappy, "", ""},
+		{CompressSnappy, "gzip", ""},
+		{CompressZstd, "gzip,snappy,zstd", CompressZstd},
+		{CompressLz4, "gzip,lz4", CompressLz4},
+		{"unknown", "unknown", ""}, // not registered
+		{CompressGzip, "gzip,snappy", ""},
+		{"", "gzip,snappy", ""},
+	}
+	for _, c := range cases {
+		if ret := NegotiateCompressor(c.preferred, c.accepts); ret != c.expect {
+			t.Errorf("negotiate compressor fail. preferred:%s, accepts:%s, result:%s", c.preferred, c.accepts, ret)
+		}
+	}
+
+	request := &motan.MotanRequest{Attachment: motan.NewStringMap(DefaultMetaSize)}
+	response := &motan.MotanResponse{Attachment: motan.NewStringMap(DefaultMetaSize)}
+	// older peers without negotiation
+	NegotiateResponseCompress(request, response, CompressSnappy)
+	if response.GetRPCContext(true).CompressCodec != "" || response.GetAttachment(MAcceptCompress) != "" {
+		t.Fatalf("should not negotiate with older peers")
+	}
+	request.SetAttachment(MAcceptCompress, "gzip,snappy")
+	NegotiateResponseCompress(request, response, CompressSnappy)
+	if response.GetRPCContext(true).CompressCodec != CompressSnappy || response.GetAttachment(MAcceptCompress) != AcceptCompressors() {
+		t.Fatalf("negotiate response compressor fail")
+	}
+}
+
+func BenchmarkCompressors(b *testing.B) {
+	payloads := buildPayloads()
+	for name, payload := range payloads {
+		for _, c := range []string{CompressGzip, CompressSnappy, CompressZstd, CompressLz4} {
+			compressor := GetCompressor(c)
+			compressed, _ := compressor.Compress(payload)
+			b.Run(c+"/encode/"+name, func(b *testing.B) {
+				b.SetBytes(int64(len(payload)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					compressor.Compress(payload)
+				}
+				b.ReportMetric(float64(len(compressed))/float64(len(payload)), "ratio")
+			})
+			b.Run(c+"/decode/"+name, func(b *testing.B) {
+				b.SetBytes(int64(len(payload)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					compressor.Decompress(compressed)
+				}
+			})
+		}
+	}
+}
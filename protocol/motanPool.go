@@ -0,0 +1,180 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+const (
+	// the buffers larger than this are not put back to the pools, so a few large messages do not hold the memory
+	maxPooledBufferSize = 1024 * 1024
+	minFrameBufferSize  = 1024
+)
+
+var (
+	messagePool = sync.Pool{New: func() interface{} {
+		return &Message{Header: &Header{}}
+	}}
+	encodeBufferPool = sync.Pool{New: func() interface{} {
+		return motan.NewBytesBuffer(512)
+	}}
+	frameBufferPool sync.Pool
+)
+
+// AcquireMessage gets a message with an empty header and metadata from the pool.
+// The message should be put back by ReleaseMessage after it is not used
+func AcquireMessage() *Message {
+	msg := messagePool.Get().(*Message)
+	msg.Metadata = motan.AcquireStringMap()
+	return msg
+}
+
+// ReleaseMessage puts the message back to the pool with its header, metadata and the pooled buffer of the body.
+// None of them can be used after released, including the requests or clones which share the metadata or body
+func ReleaseMessage(msg *Message) {
+	if msg == nil {
+		return
+	}
+	if msg.Metadata != nil {
+		motan.ReleaseStringMap(msg.Metadata)
+	}
+	if msg.frame != nil {
+		releaseFrame(msg.frame)
+	}
+	header := msg.Header
+	if header == nil {
+		header = &Header{}
+	} else {
+		*header = Header{}
+	}
+	*msg = Message{Header: header}
+	messagePool.Put(msg)
+}
+
+// WriteTo writes the encoded message to w without copying the body into an encode buffer.
+// The header and metadata are encoded into a pooled buffer, and they are written with the body by one vectored write
+// if w is a tcp or unix connection. Other writers such as tls connections get the flattened message in one write,
+// so the messages written by multiple goroutines are not interleaved
+func (msg *Message) WriteTo(w io.Writer) (int64, error) {
+	buf := encodeBufferPool.Get().(*motan.BytesBuffer)
+	defer releaseEncodeBuffer(buf)
+	buf.Reset()
+	msg.encodeHead(buf)
+	if len(msg.Body) > 0 {
+		switch w.(type) {
+		case *net.TCPConn, *net.UnixConn:
+			buffers := net.Buffers{buf.Bytes(), msg.Body}
+			return buffers.WriteTo(w)
+		}
+		buf.Write(msg.Body)
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// DecodePooled decodes a message like DecodeWithTime, but the message, header and metadata are got from the pools,
+// and the body is sliced from a pooled read buffer instead of a new allocated one.
+// The message must be released by ReleaseMessage when neither the message nor its body are used
+func DecodePooled(buf *bufio.Reader) (msg *Message, start time.Time, err error) {
+	var temp [HeaderLength]byte
+
+	// decode header
+	_, err = io.ReadFull(buf, temp[:])
+	start = time.Now()
+	if err != nil {
+		return nil, start, err
+	}
+	mn := binary.BigEndian.Uint16(temp[:2])
+	if mn != MotanMagic {
+		vlog.Errorf("wrong magic num:%d, err:%v", mn, err)
+		return nil, start, ErrMagicNum
+	}
+	msg = AcquireMessage()
+	header := msg.Header
+	header.Magic = MotanMagic
+	header.MsgType = temp[2]
+	header.VersionStatus = temp[3]
+	version := header.GetVersion()
+	if version != Version2 {
+		vlog.Errorf("unsupported protocol version number: %d", version)
+		ReleaseMessage(msg)
+		return nil, start, ErrVersion
+	}
+	header.Serialize = temp[4]
+	header.RequestID = binary.BigEndian.Uint64(temp[5:])
+
+	// decode meta, the frame buffer is reused by the body after the metadata is parsed
+	if _, err = io.ReadFull(buf, temp[:4]); err != nil {
+		ReleaseMessage(msg)
+		return nil, start, err
+	}
+	metasize := int(binary.BigEndian.Uint32(temp[:4]))
+	msg.frame = acquireFrame(metasize)
+	if metasize > 0 {
+		metadata := *msg.frame
+		if _, err = io.ReadFull(buf, metadata); err != nil {
+			ReleaseMessage(msg)
+			return nil, start, err
+		}
+		if err = parseMetadata(metadata, msg.Metadata); err != nil {
+			vlog.Errorf("decode message fail, metadata not paired. header:%v, meta:%s", header, metadata)
+			ReleaseMessage(msg)
+			return nil, start, err
+		}
+	}
+
+	//decode body
+	if _, err = io.ReadFull(buf, temp[:4]); err != nil {
+		ReleaseMessage(msg)
+		return nil, start, err
+	}
+	bodysize := int(binary.BigEndian.Uint32(temp[:4]))
+	if cap(*msg.frame) < bodysize {
+		releaseFrame(msg.frame)
+		msg.frame = acquireFrame(bodysize)
+	}
+	msg.Body = (*msg.frame)[:bodysize]
+	if bodysize > 0 {
+		if _, err = io.ReadFull(buf, msg.Body); err != nil {
+			ReleaseMessage(msg)
+			return nil, start, err
+		}
+	}
+	return msg, start, nil
+}
+
+func releaseEncodeBuffer(buf *motan.BytesBuffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		encodeBufferPool.Put(buf)
+	}
+}
+
+func acquireFrame(size int) *[]byte {
+	if v := frameBufferPool.Get(); v != nil {
+		frame := v.(*[]byte)
+		if cap(*frame) >= size {
+			*frame = (*frame)[:size]
+			return frame
+		}
+	}
+	// round up the capacity, so the buffer can be reused by the messages with similar sizes
+	c := minFrameBufferSize
+	for c < size {
+		c <<= 1
+	}
+	frame := make([]byte, size, c)
+	return &frame
+}
+
+func releaseFrame(frame *[]byte) {
+	if cap(*frame) <= maxPooledBufferSize {
+		frameBufferPool.Put(frame)
+	}
+}
@@ -0,0 +1,197 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/core"
+)
+
+func buildPoolTestMessage(bodySize int) *Message {
+	h := BuildHeader(Req, false, JSON, 1234, Normal)
+	meta := core.NewStringMap(DefaultMetaSize)
+	meta.Store(MPath, "com.weibo.test.TestService")
+	meta.Store(MMethod, "hello")
+	meta.Store(MGroup, "test-group")
+	return &Message{Header: h, Metadata: meta, Body: buildBytes(bodySize)}
+}
+
+func assertMessageEqual(t *testing.T, expect *Message, real *Message) {
+	assert.Equal(t, *expect.Header, *real.Header)
+	assert.Equal(t, expect.Metadata.RawMap(), real.Metadata.RawMap())
+	assert.Equal(t, len(expect.Body), len(real.Body))
+	assert.True(t, bytes.Equal(expect.Body, real.Body))
+}
+
+func assertEncodedMessage(t *testing.T, expect *Message, data []byte) {
+	real, err := Decode(bufio.NewReader(bytes.NewReader(data)))
+	assert.Nil(t, err)
+	assertMessageEqual(t, expect, real)
+}
+
+func TestMessageWriteTo(t *testing.T) {
+	for _, size := range []int{0, 100, 64 * 1024} {
+		msg := buildPoolTestMessage(size)
+		encoded := msg.Encode().Bytes()
+
+		buf := &bytes.Buffer{}
+		n, err := msg.WriteTo(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(encoded)), n)
+		assertEncodedMessage(t, msg, buf.Bytes())
+
+		// vectored write through a tcp connection
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		received := make(chan []byte, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				received <- nil
+				return
+			}
+			defer conn.Close()
+			data := make([]byte, len(encoded))
+			io.ReadFull(conn, data)
+			received <- data
+		}()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		assert.Nil(t, err)
+		n, err = msg.WriteTo(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(encoded)), n)
+		assertEncodedMessage(t, msg, <-received)
+		conn.Close()
+		listener.Close()
+	}
+}
+
+func TestDecodePooled(t *testing.T) {
+	for _, size := range []int{0, 100, 64 * 1024, 2 * 1024 * 1024} {
+		msg := buildPoolTestMessage(size)
+		pooled, _, err := DecodePooled(bufio.NewReader(msg.Encode()))
+		assert.Nil(t, err)
+		assertMessageEqual(t, msg, pooled)
+		ReleaseMessage(pooled)
+	}
+
+	// the released messages are reused without the content of the last message
+	msg := buildPoolTestMessage(1024)
+	pooled, _, _ := DecodePooled(bufio.NewReader(msg.Encode()))
+	ReleaseMessage(pooled)
+	msg = buildPoolTestMessage(10)
+	msg.Metadata = core.NewStringMap(0)
+	msg.Header.SetGzip(true)
+	msg.Header.SetRequest(false)
+	pooled, _, err := DecodePooled(bufio.NewReader(msg.Encode()))
+	assert.Nil(t, err)
+	assertMessageEqual(t, msg, pooled)
+	ReleaseMessage(pooled)
+	ReleaseMessage(nil)
+
+	acquired := AcquireMessage()
+	assert.Equal(t, Header{}, *acquired.Header)
+	assert.Equal(t, 0, acquired.Metadata.Len())
+	assert.Nil(t, acquired.Body)
+	ReleaseMessage(acquired)
+
+	// broken messages
+	encoded := buildPoolTestMessage(100).Encode().Bytes()
+	for _, l := range []int{0, 5, HeaderLength + 2, HeaderLength + 10, len(encoded) - 50} {
+		_, _, err = DecodePooled(bufio.NewReader(bytes.NewReader(encoded[:l])))
+		assert.NotNil(t, err)
+	}
+	wrong := append([]byte{}, encoded...)
+	wrong[0] = 0
+	_, _, err = DecodePooled(bufio.NewReader(bytes.NewReader(wrong)))
+	assert.Equal(t, ErrMagicNum, err)
+	wrong = append([]byte{}, encoded...)
+	wrong[3] = 0
+	_, _, err = DecodePooled(bufio.NewReader(bytes.NewReader(wrong)))
+	assert.Equal(t, ErrVersion, err)
+	msg = buildPoolTestMessage(0)
+	msg.Metadata = core.NewStringMap(0)
+	wrong = msg.Encode().Bytes()
+	wrong[HeaderLength+3] = 1
+	wrong = append(wrong[:HeaderLength+4], append([]byte{'k'}, wrong[HeaderLength+4:]...)...)
+	_, _, err = DecodePooled(bufio.NewReader(bytes.NewReader(wrong)))
+	assert.Equal(t, ErrMetadata, err)
+}
+
+func TestClonePooled(t *testing.T) {
+	msg := buildPoolTestMessage(1024)
+	pooled, _, err := DecodePooled(bufio.NewReader(msg.Encode()))
+	assert.Nil(t, err)
+	// the clone is used after the pooled message is released and its buffer is reused
+	clone := pooled.Clone().(*Message)
+	ReleaseMessage(pooled)
+	other := buildPoolTestMessage(1024)
+	for i := range other.Body {
+		other.Body[i] = 'x'
+	}
+	reused, _, err := DecodePooled(bufio.NewReader(other.Encode()))
+	assert.Nil(t, err)
+	assertMessageEqual(t, msg, clone)
+	ReleaseMessage(reused)
+}
+
+func BenchmarkMessageEncode(b *testing.B) {
+	msg := buildPoolTestMessage(4 * 1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ioutil.Discard.Write(msg.Encode().Bytes())
+	}
+}
+
+func BenchmarkMessageWriteTo(b *testing.B) {
+	msg := buildPoolTestMessage(4 * 1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg.WriteTo(ioutil.Discard)
+	}
+}
+
+func BenchmarkMessageDecode(b *testing.B) {
+	encoded := buildPoolTestMessage(4 * 1024).Encode().Bytes()
+	reader := bytes.NewReader(encoded)
+	buf := bufio.NewReader(reader)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reader.Reset(encoded)
+		buf.Reset(reader)
+		DecodeWithTime(buf)
+	}
+}
+
+func BenchmarkMessageDecodePooled(b *testing.B) {
+	encoded := buildPoolTestMessage(4 * 1024).Encode().Bytes()
+	reader := bytes.NewReader(encoded)
+	buf := bufio.NewReader(reader)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reader.Reset(encoded)
+		buf.Reset(reader)
+		msg, _, _ := DecodePooled(buf)
+		ReleaseMessage(msg)
+	}
+}
+
+func BenchmarkMessageDecodePooledConcurrent(b *testing.B) {
+	encoded := buildPoolTestMessage(4 * 1024).Encode().Bytes()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		reader := bytes.NewReader(encoded)
+		buf := bufio.NewReader(reader)
+		for pb.Next() {
+			reader.Reset(encoded)
+			buf.Reset(reader)
+			msg, _, _ := DecodePooled(buf)
+			ReleaseMessage(msg)
+		}
+	})
+}
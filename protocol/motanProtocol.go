@@ -47,6 +47,12 @@ const (
 	MSource        = "M_s"
 	MRequestID     = "M_rid"
 	MTimeout       = "M_tmo"
+	MAuthTimestamp = "M_ats"
+	MAuthSignature = "M_asig"
+	MStreamWindow  = "M_sw"
+	// MCompress is the compressor of the body except gzip, MAcceptCompress is the compressors the sender can decode
+	MCompress       = "M_cmp"
+	MAcceptCompress = "M_acmp"
 )
 
 type Header struct {
@@ -72,6 +78,8 @@ type Message struct {
 	Metadata *motan.StringMap
 	Body     []byte
 	Type     int
+	// the pooled buffer which the body is sliced from, it is put back when the message is released
+	frame *[]byte
 }
 
 //serialize
@@ -134,6 +142,19 @@ func BuildHeartbeat(requestID uint64, msgType int) *Message {
 	return request
 }
 
+// BuildCancel build a oneway message to cancel the request with the requestID, no response for it
+func BuildCancel(requestID uint64) *Message {
+	request := &Message{
+		Header:   BuildHeader(Req, false, defaultSerialize, requestID, Normal),
+		Metadata: motan.NewStringMap(DefaultMetaSize),
+		Body:     make([]byte, 0),
+		Type:     Req,
+	}
+	request.Header.SetCancel(true)
+	request.Header.SetOneWay(true)
+	return request
+}
+
 func (h *Header) SetVersion(version int) error {
 	if version > 31 {
 		return ErrVersion
@@ -157,6 +178,45 @@ func (h *Header) IsHeartbeat() bool {
 	return (h.MsgType & 0x10) == 0x10
 }
 
+// SetCancel set the cancel flag, a cancel message notifies the server that the request with the same request id is canceled
+func (h *Header) SetCancel(isCancel bool) {
+	if isCancel {
+		h.MsgType = h.MsgType | 0x20
+	} else {
+		h.MsgType = h.MsgType & 0xdf
+	}
+}
+
+func (h *Header) IsCancel() bool {
+	return (h.MsgType & 0x20) == 0x20
+}
+
+// SetStream set the stream flag, the message is a frame of a stream. the first request frame opens the stream
+func (h *Header) SetStream(isStream bool) {
+	if isStream {
+		h.MsgType = h.MsgType | 0x40
+	} else {
+		h.MsgType = h.MsgType & 0xbf
+	}
+}
+
+func (h *Header) IsStream() bool {
+	return (h.MsgType & 0x40) == 0x40
+}
+
+// SetEndOfStream set the end-of-stream flag, the sender will not send any frame of the stream after it
+func (h *Header) SetEndOfStream(isEnd bool) {
+	if isEnd {
+		h.MsgType = h.MsgType | 0x80
+	} else {
+		h.MsgType = h.MsgType & 0x7f
+	}
+}
+
+func (h *Header) IsEndOfStream() bool {
+	return (h.MsgType & 0x80) == 0x80
+}
+
 func (h *Header) SetGzip(isgzip bool) {
 	if isgzip {
 		h.MsgType = h.MsgType | 0x08
@@ -252,7 +312,28 @@ func BuildHeader(msgType int, proxy bool, serialize int, requestID uint64, msgSt
 }
 
 func (msg *Message) Encode() (buf *motan.BytesBuffer) {
-	metabuf := motan.NewBytesBuffer(256)
+	buf = motan.NewBytesBuffer(HeaderLength + len(msg.Body) + 256 + 8)
+	msg.encodeHead(buf)
+
+	// encode body
+	if len(msg.Body) > 0 {
+		buf.Write(msg.Body)
+	}
+	return buf
+}
+
+// encodeHead writes the header, the metadata and the size of body into buf, the body itself is not written
+func (msg *Message) encodeHead(buf *motan.BytesBuffer) {
+	// encode header.
+	buf.WriteUint16(MotanMagic)
+	buf.WriteByte(msg.Header.MsgType)
+	buf.WriteByte(msg.Header.VersionStatus)
+	buf.WriteByte(msg.Header.Serialize)
+	buf.WriteUint64(msg.Header.RequestID)
+
+	// encode meta, the size is filled after the metadata is written
+	sizePos := buf.GetWPos()
+	buf.WriteUint32(0)
 	msg.Metadata.Range(func(k, v string) bool {
 		if k == "" || v == "" {
 			return true
@@ -261,46 +342,33 @@ func (msg *Message) Encode() (buf *motan.BytesBuffer) {
 			vlog.Errorf("metadata not correct.k:%s, v:%s", k, v)
 			return true
 		}
-		metabuf.Write([]byte(k))
-		metabuf.WriteByte('\n')
-		metabuf.Write([]byte(v))
-		metabuf.WriteByte('\n')
+		buf.Write([]byte(k))
+		buf.WriteByte('\n')
+		buf.Write([]byte(v))
+		buf.WriteByte('\n')
 		return true
 	})
-
-	if metabuf.Len() > 0 {
-		metabuf.SetWPos(metabuf.GetWPos() - 1)
-	}
-	metasize := metabuf.Len()
-	bodysize := len(msg.Body)
-	buf = motan.NewBytesBuffer(int(HeaderLength + bodysize + metasize + 8))
-	// encode header.
-	buf.WriteUint16(MotanMagic)
-	buf.WriteByte(msg.Header.MsgType)
-	buf.WriteByte(msg.Header.VersionStatus)
-	buf.WriteByte(msg.Header.Serialize)
-	buf.WriteUint64(msg.Header.RequestID)
-
-	// encode meta
-	buf.WriteUint32(uint32(metasize))
+	metasize := buf.GetWPos() - sizePos - 4
 	if metasize > 0 {
-		buf.Write(metabuf.Bytes())
+		metasize--
+		buf.SetWPos(buf.GetWPos() - 1)
 	}
+	binary.BigEndian.PutUint32(buf.Bytes()[sizePos:], uint32(metasize))
 
-	// encode body
-	buf.WriteUint32(uint32(bodysize))
-	if bodysize > 0 {
-		buf.Write(msg.Body)
-	}
-	return buf
+	// encode body size
+	buf.WriteUint32(uint32(len(msg.Body)))
 }
 
+// Clone copies the message with its body, the clone may be used after the message is released to the pool
+// (e.g. the hedged requests and the mirror requests which are sent asynchronously)
 func (msg *Message) Clone() interface{} {
 	newMessage := &Message{
 		Header: msg.Header.Clone(),
-		Body:   msg.Body,
 		Type:   msg.Type,
 	}
+	if msg.Body != nil {
+		newMessage.Body = append([]byte(nil), msg.Body...)
+	}
 	if msg.Metadata != nil {
 		newMessage.Metadata = msg.Metadata.Copy()
 	}
@@ -350,23 +418,9 @@ func DecodeWithTime(buf *bufio.Reader) (msg *Message, start time.Time, err error
 		if err != nil {
 			return nil, start, err
 		}
-		s, e := 0, 0
-		var k string
-		for i := 0; i <= metasize; i++ {
-			if i == metasize || metadata[i] == '\n' {
-				e = i
-				if k == "" {
-					k = string(metadata[s:e])
-				} else {
-					metamap.Store(k, string(metadata[s:e]))
-					k = ""
-				}
-				s = i + 1
-			}
-		}
-		if k != "" {
+		if err = parseMetadata(metadata, metamap); err != nil {
 			vlog.Errorf("decode message fail, metadata not paired. header:%v, meta:%s", header, metadata)
-			return nil, start, ErrMetadata
+			return nil, start, err
 		}
 	}
 
@@ -385,10 +439,32 @@ func DecodeWithTime(buf *bufio.Reader) (msg *Message, start time.Time, err error
 	if err != nil {
 		return nil, start, err
 	}
-	msg = &Message{header, metamap, body, Req}
+	msg = &Message{Header: header, Metadata: metamap, Body: body, Type: Req}
 	return msg, start, err
 }
 
+// parseMetadata parses the '\n' separated keys and values into metamap
+func parseMetadata(metadata []byte, metamap *motan.StringMap) error {
+	s, e := 0, 0
+	var k string
+	for i := 0; i <= len(metadata); i++ {
+		if i == len(metadata) || metadata[i] == '\n' {
+			e = i
+			if k == "" {
+				k = string(metadata[s:e])
+			} else {
+				metamap.Store(k, string(metadata[s:e]))
+				k = ""
+			}
+			s = i + 1
+		}
+	}
+	if k != "" {
+		return ErrMetadata
+	}
+	return nil
+}
+
 func DecodeGzipBody(body []byte) []byte {
 	ret, err := DecodeGzip(body)
 	if err != nil {
@@ -517,9 +593,8 @@ func ConvertToRequest(request *Message, serialize motan.Serialization) (motan.Re
 	rc.Proxy = request.Header.IsProxy()
 	if request.Body != nil && len(request.Body) > 0 {
 		rc.BodySize = len(request.Body)
-		if request.Header.IsGzip() {
-			request.Body = DecodeGzipBody(request.Body)
-			request.Header.SetGzip(false)
+		if err := DecodeMessageCompress(request); err != nil {
+			return nil, err
 		}
 		if !rc.Proxy && serialize == nil {
 			return nil, ErrSerializeNil
@@ -539,7 +614,7 @@ func ConvertToReqMessage(request motan.Request, serialize motan.Serialization) (
 			msg.Header.SetProxy(true)
 			// Make sure group is the requested group
 			msg.Metadata.Store(MGroup, request.GetAttachment(MGroup))
-			EncodeMessageGzip(msg, rc.GzipSize)
+			EncodeMessageCompress(msg, rc.CompressCodec, rc.GzipSize)
 			rc.BodySize = len(msg.Body)
 			return msg, nil
 		}
@@ -576,7 +651,7 @@ func ConvertToReqMessage(request motan.Request, serialize motan.Serialization) (
 	}
 
 	req.Metadata = request.GetAttachments()
-	EncodeMessageGzip(req, rc.GzipSize)
+	EncodeMessageCompress(req, rc.CompressCodec, rc.GzipSize)
 	rc.BodySize = len(req.Body)
 	if rc.Oneway {
 		req.Header.SetOneWay(true)
@@ -645,7 +720,7 @@ func ConvertToResMessage(response motan.Response, serialize motan.Serialization)
 	}
 
 	res.Metadata = response.GetAttachments()
-	EncodeMessageGzip(res, rc.GzipSize)
+	EncodeMessageCompress(res, rc.CompressCodec, rc.GzipSize)
 	rc.BodySize = len(res.Body)
 	if rc.Proxy {
 		res.Header.SetProxy(true)
@@ -667,9 +742,8 @@ func ConvertToResponse(response *Message, serialize motan.Serialization) (motan.
 	}
 	if response.Header.GetStatus() == Normal && len(response.Body) > 0 {
 		rc.BodySize = len(response.Body)
-		if response.Header.IsGzip() {
-			response.Body = DecodeGzipBody(response.Body)
-			response.Header.SetGzip(false)
+		if err := DecodeMessageCompress(response); err != nil {
+			return nil, err
 		}
 		if !rc.Proxy && serialize == nil {
 			return nil, ErrSerializeNil
@@ -90,6 +90,41 @@ func TestMsgType(t *testing.T) {
 		t.Fatalf("header message type test fail. type request")
 	}
 
+	//cancel
+	h = &Header{}
+	b = h.IsCancel()
+	if b {
+		t.Fatalf("default header should not cancel msg")
+	}
+	h.SetCancel(true)
+	b = h.IsCancel()
+	if !b {
+		t.Fatalf("header message type test fail. type cancel")
+	}
+	h.SetCancel(false)
+	if h.IsCancel() {
+		t.Fatalf("header message type test fail. reset type cancel")
+	}
+
+	//stream
+	h = &Header{}
+	if h.IsStream() || h.IsEndOfStream() {
+		t.Fatalf("default header should not stream msg")
+	}
+	h.SetStream(true)
+	h.SetEndOfStream(true)
+	if !h.IsStream() || !h.IsEndOfStream() || h.IsCancel() || h.IsHeartbeat() {
+		t.Fatalf("header message type test fail. type stream")
+	}
+	h.SetEndOfStream(false)
+	if !h.IsStream() || h.IsEndOfStream() {
+		t.Fatalf("header message type test fail. reset type end of stream")
+	}
+
+	cancel, err := Decode(bufio.NewReader(bytes.NewReader(BuildCancel(123).Encode().Bytes())))
+	if err != nil || !cancel.Header.IsCancel() || !cancel.Header.IsOneWay() || cancel.Header.IsHeartbeat() || cancel.Header.RequestID != 123 {
+		t.Fatalf("decode cancel message fail. msg:%+v, err:%v", cancel, err)
+	}
 }
 
 func TestStatus(t *testing.T) {
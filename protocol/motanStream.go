@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// DefaultStreamWindow is the default count of data frames can be sent without window update in each direction of a stream
+const DefaultStreamWindow = 64
+
+var (
+	ErrStreamClosed         = errors.New("stream has been closed")
+	ErrStreamWindowOverflow = errors.New("stream receive window overflow")
+	ErrStreamConnClosed     = errors.New("stream connection has been closed")
+)
+
+// BuildStreamFrame build a data frame of the stream with the requestID
+func BuildStreamFrame(requestID uint64, msgType int, serialize int, body []byte) *Message {
+	frame := &Message{
+		Header:   BuildHeader(msgType, false, serialize, requestID, Normal),
+		Metadata: motan.NewStringMap(DefaultMetaSize),
+		Body:     body,
+		Type:     msgType,
+	}
+	frame.Header.SetStream(true)
+	return frame
+}
+
+// BuildStreamEnd build an end-of-stream frame, a non-empty errmsg makes it an exception frame
+func BuildStreamEnd(requestID uint64, msgType int, errmsg string) *Message {
+	frame := BuildStreamFrame(requestID, msgType, defaultSerialize, nil)
+	frame.Header.SetEndOfStream(true)
+	if errmsg != "" {
+		frame.Header.SetStatus(Exception)
+		frame.Metadata.Store(MExceptionn, errmsg)
+	}
+	return frame
+}
+
+// BuildWindowUpdate build a frame which grants the peer to send increment more data frames
+func BuildWindowUpdate(requestID uint64, msgType int, increment int) *Message {
+	frame := BuildStreamFrame(requestID, msgType, defaultSerialize, nil)
+	frame.Metadata.Store(MStreamWindow, strconv.Itoa(increment))
+	return frame
+}
+
+// GetWindowUpdate returns the window increment if the frame is a window update
+func GetWindowUpdate(frame *Message) (int, bool) {
+	if !frame.Header.IsStream() || frame.Header.IsEndOfStream() {
+		return 0, false
+	}
+	increment, err := strconv.Atoi(frame.Metadata.LoadOrEmpty(MStreamWindow))
+	if err != nil {
+		return 0, false
+	}
+	return increment, true
+}
+
+// GetStreamWindow returns the window of the stream advertised by the opening frame
+func GetStreamWindow(open *Message) int {
+	window, err := strconv.Atoi(open.Metadata.LoadOrEmpty(MStreamWindow))
+	if err != nil || window <= 0 {
+		return DefaultStreamWindow
+	}
+	return window
+}
+
+// SendWindow holds the credits granted by the peer, a data frame can only be sent after acquiring a credit
+type SendWindow struct {
+	lock     sync.Mutex
+	credits  int
+	notifyCh chan struct{}
+}
+
+func NewSendWindow(credits int) *SendWindow {
+	return &SendWindow{credits: credits, notifyCh: make(chan struct{}, 1)}
+}
+
+// Acquire blocks until a credit is available or the done channel is closed
+func (w *SendWindow) Acquire(done <-chan struct{}) error {
+	for {
+		w.lock.Lock()
+		if w.credits > 0 {
+			w.credits--
+			w.lock.Unlock()
+			return nil
+		}
+		w.lock.Unlock()
+		select {
+		case <-w.notifyCh:
+		case <-done:
+			return ErrStreamClosed
+		}
+	}
+}
+
+// Grant adds the credits from a window update
+func (w *SendWindow) Grant(increment int) {
+	w.lock.Lock()
+	w.credits += increment
+	w.lock.Unlock()
+	select {
+	case w.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// RecvWindow counts the consumed data frames, a window update is needed after half of the window is consumed
+type RecvWindow struct {
+	window   int
+	consumed int
+}
+
+func NewRecvWindow(window int) *RecvWindow {
+	return &RecvWindow{window: window}
+}
+
+// Consume records a consumed data frame and returns the increment should be sent to the peer, 0 for no update
+func (w *RecvWindow) Consume() int {
+	w.consumed++
+	if w.consumed*2 < w.window {
+		return 0
+	}
+	increment := w.consumed
+	w.consumed = 0
+	return increment
+}
@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestStreamFrames(t *testing.T) {
+	decode := func(msg *Message) *Message {
+		decoded, err := Decode(bufio.NewReader(bytes.NewReader(msg.Encode().Bytes())))
+		if err != nil {
+			t.Fatalf("decode stream frame fail. err:%v", err)
+		}
+		return decoded
+	}
+	frame := decode(BuildStreamFrame(12, Res, Simple, []byte("data")))
+	if !frame.Header.IsStream() || frame.Header.IsEndOfStream() || frame.Header.RequestID != 12 || string(frame.Body) != "data" {
+		t.Fatalf("decode data frame fail. frame:%+v", frame)
+	}
+	if _, ok := GetWindowUpdate(frame); ok {
+		t.Fatalf("data frame should not be window update")
+	}
+
+	update := decode(BuildWindowUpdate(12, Req, 8))
+	if increment, ok := GetWindowUpdate(update); !ok || increment != 8 {
+		t.Fatalf("decode window update fail. increment:%d", increment)
+	}
+
+	end := decode(BuildStreamEnd(12, Res, ""))
+	if !end.Header.IsEndOfStream() || end.Header.GetStatus() != Normal {
+		t.Fatalf("decode end frame fail. frame:%+v", end)
+	}
+	end = decode(BuildStreamEnd(12, Res, "{}"))
+	if !end.Header.IsEndOfStream() || end.Header.GetStatus() != Exception || end.Metadata.LoadOrEmpty(MExceptionn) != "{}" {
+		t.Fatalf("decode exception end frame fail. frame:%+v", end)
+	}
+
+	open := BuildStreamFrame(12, Req, Simple, nil)
+	if GetStreamWindow(open) != DefaultStreamWindow {
+		t.Fatalf("default stream window not correct")
+	}
+	open.Metadata.Store(MStreamWindow, "3")
+	if GetStreamWindow(open) != 3 {
+		t.Fatalf("stream window not correct")
+	}
+}
+
+func TestStreamWindow(t *testing.T) {
+	done := make(chan struct{})
+	sendWindow := NewSendWindow(2)
+	for i := 0; i < 2; i++ {
+		if err := sendWindow.Acquire(done); err != nil {
+			t.Fatalf("acquire window fail. err:%v", err)
+		}
+	}
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- sendWindow.Acquire(done)
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("acquire should block when the window is exhausted")
+	case <-time.After(50 * time.Millisecond):
+	}
+	sendWindow.Grant(1)
+	if err := <-acquired; err != nil {
+		t.Fatalf("acquire window fail after grant. err:%v", err)
+	}
+	close(done)
+	if err := sendWindow.Acquire(done); err != ErrStreamClosed {
+		t.Fatalf("acquire should fail after done. err:%v", err)
+	}
+
+	recvWindow := NewRecvWindow(4)
+	increments := []int{0, 2, 0, 2}
+	for i, expect := range increments {
+		if increment := recvWindow.Consume(); increment != expect {
+			t.Fatalf("window update not correct. index:%d, increment:%d", i, increment)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// motan v1 is the original protocol of the java motan. The header has the magic, version, flag, request id and body size.
+// The body is a java object serialization stream, the arguments and the value are byte arrays in it
+const (
+	MotanV1Magic   = 0xf0f0
+	V1HeaderLength = 16
+	v1Version      = 0x01
+)
+
+// message flags of motan v1
+const (
+	v1FlagRequest           = 0x00
+	v1FlagResponse          = 0x01
+	v1FlagResponseVoid      = 0x03
+	v1FlagResponseException = 0x05
+)
+
+const (
+	v1HeartbeatInterface = "com.weibo.api.motan.rpc.heartbeat"
+	v1HeartbeatMethod    = "heartbeat"
+	v1VoidParam          = "void"
+	// the value class of responses, java clients deserialize the value without a concrete class
+	v1ValueClass = "java.lang.Object"
+	// exceptions are sent to java clients as runtime exceptions with the error message
+	v1ExceptionClass = "java.lang.RuntimeException"
+	// the exceptions of the java motan framework, others are thrown by the services
+	v1FrameworkExceptionPackage = "com.weibo.api.motan.exception."
+)
+
+var (
+	ErrV1Format    = errors.New("motan v1 message format error")
+	ErrV1Arguments = errors.New("split motan v1 arguments fail, serialization is nil")
+
+	// the attachments of v1 requests which are the metadata with different names in motan2
+	v1Attachments = map[string]string{
+		motan.GroupKey:       MGroup,
+		motan.VersionKey:     MVersion,
+		motan.ModuleKey:      MModule,
+		motan.ApplicationKey: MSource,
+	}
+)
+
+// motanV1Codec converts the motan v1 messages to motan2 messages and the other way round.
+// v1 messages have no serialization number, the configured serialization is used, hessian2 is used if it is not set
+type motanV1Codec struct {
+	serialization motan.Serialization
+	serialize     int
+}
+
+func newMotanV1Codec(serialization motan.Serialization) Codec {
+	c := &motanV1Codec{serialization: serialization, serialize: Hessian}
+	if serialization != nil {
+		c.serialize = serialization.GetSerialNum()
+	}
+	return c
+}
+
+func (c *motanV1Codec) GetName() string {
+	return CodecMotan
+}
+
+func (c *motanV1Codec) Encode(msg *Message) ([]byte, error) {
+	// v1 has no compression
+	if err := DecodeMessageCompress(msg); err != nil {
+		return nil, err
+	}
+	out := newJavaObjectOutput()
+	var flag byte
+	var err error
+	if msg.Header.isRequest() {
+		flag, err = v1FlagRequest, c.encodeRequest(msg, out)
+	} else {
+		flag, err = c.encodeResponse(msg, out)
+	}
+	if err != nil {
+		return nil, err
+	}
+	body := out.Bytes()
+	buf := motan.NewBytesBuffer(V1HeaderLength + len(body))
+	buf.WriteUint16(MotanV1Magic)
+	buf.WriteByte(v1Version)
+	buf.WriteByte(flag)
+	buf.WriteUint64(msg.Header.RequestID)
+	buf.WriteUint32(uint32(len(body)))
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+func (c *motanV1Codec) WriteTo(msg *Message, w io.Writer) (int64, error) {
+	data, err := c.Encode(msg)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+func (c *motanV1Codec) Decode(buf *bufio.Reader) (*Message, time.Time, error) {
+	var temp [V1HeaderLength]byte
+	_, err := io.ReadFull(buf, temp[:])
+	start := time.Now()
+	if err != nil {
+		return nil, start, err
+	}
+	if mn := binary.BigEndian.Uint16(temp[:2]); mn != MotanV1Magic {
+		vlog.Errorf("wrong motan v1 magic num:%d", mn)
+		return nil, start, ErrMagicNum
+	}
+	if temp[2] != v1Version {
+		vlog.Errorf("unsupported motan v1 version number: %d", temp[2])
+		return nil, start, ErrVersion
+	}
+	flag := temp[3]
+	requestID := binary.BigEndian.Uint64(temp[4:])
+	body := make([]byte, binary.BigEndian.Uint32(temp[12:]))
+	if _, err = io.ReadFull(buf, body); err != nil {
+		return nil, start, err
+	}
+	var msg *Message
+	if flag == v1FlagRequest {
+		msg, err = c.decodeRequest(requestID, body)
+	} else {
+		msg, err = c.decodeResponse(flag, requestID, body)
+	}
+	if err != nil {
+		vlog.Errorf("decode motan v1 message fail. rid:%d, flag:%d, err:%v", requestID, flag, err)
+		return nil, start, err
+	}
+	return msg, start, nil
+}
+
+func (c *motanV1Codec) encodeRequest(msg *Message, out *javaObjectOutput) error {
+	if msg.Header.IsHeartbeat() {
+		out.WriteUTF(v1HeartbeatInterface)
+		out.WriteUTF(v1HeartbeatMethod)
+		out.WriteUTF(v1VoidParam)
+		out.WriteInt(0)
+		return nil
+	}
+	methodDesc := msg.Metadata.LoadOrEmpty(MMethodDesc)
+	args, err := c.splitArguments(msg.Body, methodDesc)
+	if err != nil {
+		return err
+	}
+	for _, s := range []string{msg.Metadata.LoadOrEmpty(MPath), msg.Metadata.LoadOrEmpty(MMethod), methodDesc} {
+		if err = out.WriteUTF(s); err != nil {
+			return err
+		}
+	}
+	for _, arg := range args {
+		out.WriteBytes(arg)
+	}
+	attachments := make(map[string]string, msg.Metadata.Len())
+	msg.Metadata.Range(func(k, v string) bool {
+		if k == "" || v == "" || k == MPath || k == MMethod || k == MMethodDesc {
+			return true
+		}
+		for name, key := range v1Attachments {
+			if k == key {
+				k = name
+				break
+			}
+		}
+		attachments[k] = v
+		return true
+	})
+	out.WriteInt(int32(len(attachments)))
+	for k, v := range attachments {
+		if err = out.WriteUTF(k); err != nil {
+			return err
+		}
+		if err = out.WriteUTF(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitArguments splits the body to the arguments, every argument is serialized separately in v1
+func (c *motanV1Codec) splitArguments(body []byte, methodDesc string) ([][]byte, error) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+	// the argument count is unknown without the method description, the body is taken as one argument
+	if methodDesc == "" || !strings.Contains(methodDesc, ",") {
+		if methodDesc == v1VoidParam {
+			return nil, nil
+		}
+		return [][]byte{body}, nil
+	}
+	if c.serialization == nil {
+		return nil, ErrV1Arguments
+	}
+	values, err := c.serialization.DeSerializeMulti(body, nil)
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, 0, len(values))
+	for _, v := range values {
+		arg, err := c.serialization.Serialize(v)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func (c *motanV1Codec) encodeResponse(msg *Message, out *javaObjectOutput) (byte, error) {
+	processTime, _ := strconv.ParseInt(msg.Metadata.LoadOrEmpty(MProcessTime), 10, 64)
+	out.WriteLong(processTime)
+	if msg.Header.GetStatus() == Exception {
+		errmsg := msg.Metadata.LoadOrEmpty(MExceptionn)
+		var exception *motan.Exception
+		if err := json.Unmarshal([]byte(errmsg), &exception); err == nil && exception != nil {
+			errmsg = exception.ErrMsg
+		}
+		out.WriteUTF(v1ExceptionClass)
+		out.WriteBytes(encodeHessianException(v1ExceptionClass, errmsg))
+		return v1FlagResponseException, nil
+	}
+	if msg.Header.IsHeartbeat() || len(msg.Body) == 0 {
+		return v1FlagResponseVoid, nil
+	}
+	out.WriteUTF(v1ValueClass)
+	out.WriteBytes(msg.Body)
+	return v1FlagResponse, nil
+}
+
+func (c *motanV1Codec) decodeRequest(requestID uint64, body []byte) (*Message, error) {
+	in, err := newJavaObjectInput(body)
+	if err != nil {
+		return nil, err
+	}
+	var names [3]string
+	for i := range names {
+		if names[i], err = in.ReadUTF(); err != nil {
+			return nil, err
+		}
+	}
+	msg := &Message{Header: BuildHeader(Req, false, c.serialize, requestID, Normal), Metadata: motan.NewStringMap(DefaultMetaSize), Body: make([]byte, 0)}
+	if names[0] == v1HeartbeatInterface && names[1] == v1HeartbeatMethod {
+		msg.Header.SetHeartbeat(true)
+		return msg, nil
+	}
+	msg.Metadata.Store(MPath, names[0])
+	msg.Metadata.Store(MMethod, names[1])
+	if names[2] != "" {
+		msg.Metadata.Store(MMethodDesc, names[2])
+	}
+	// the arguments are the objects before the attachments, multiple arguments are joined like motan2 SerializeMulti
+	for in.HasObject() {
+		arg, err := in.ReadBytes()
+		if err != nil {
+			return nil, err
+		}
+		msg.Body = append(msg.Body, arg...)
+	}
+	size, err := in.ReadInt()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(size); i++ {
+		k, err := in.ReadUTF()
+		if err != nil {
+			return nil, err
+		}
+		v, err := in.ReadUTF()
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := v1Attachments[k]; ok {
+			k = key
+		}
+		msg.Metadata.Store(k, v)
+	}
+	return msg, nil
+}
+
+func (c *motanV1Codec) decodeResponse(flag byte, requestID uint64, body []byte) (*Message, error) {
+	in, err := newJavaObjectInput(body)
+	if err != nil {
+		return nil, err
+	}
+	processTime, err := in.ReadLong()
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{Header: BuildHeader(Res, false, c.serialize, requestID, Normal), Metadata: motan.NewStringMap(DefaultMetaSize), Body: make([]byte, 0)}
+	msg.Metadata.Store(MProcessTime, strconv.FormatInt(processTime, 10))
+	switch flag {
+	case v1FlagResponseVoid:
+		return msg, nil
+	case v1FlagResponse, v1FlagResponseException:
+	default:
+		return nil, ErrV1Format
+	}
+	className, err := in.ReadUTF()
+	if err != nil {
+		return nil, err
+	}
+	data, err := in.ReadBytes()
+	if err != nil {
+		return nil, err
+	}
+	if flag == v1FlagResponse {
+		if data != nil {
+			msg.Body = data
+		}
+		return msg, nil
+	}
+	errmsg, ok := decodeHessianException(data)
+	if !ok || errmsg == "" {
+		errmsg = "motan v1 remote exception: " + className
+	}
+	errType := motan.BizException
+	if strings.HasPrefix(className, v1FrameworkExceptionPackage) {
+		errType = motan.ServiceException
+	}
+	msg.Header.SetStatus(Exception)
+	msg.Metadata.Store(MExceptionn, ExceptionToJSON(&motan.Exception{ErrCode: 500, ErrMsg: errmsg, ErrType: errType}))
+	return msg, nil
+}
@@ -0,0 +1,482 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"unicode/utf16"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// the subset of the java object serialization stream used by motan v1
+const (
+	javaStreamMagic     = 0xaced
+	javaStreamVersion   = 5
+	javaTCNull          = 0x70
+	javaTCReference     = 0x71
+	javaTCClassDesc     = 0x72
+	javaTCArray         = 0x75
+	javaTCBlockData     = 0x77
+	javaTCEndBlockData  = 0x78
+	javaTCReset         = 0x79
+	javaTCBlockDataLong = 0x7a
+	javaBaseWireHandle  = 0x7e0000
+	javaMaxBlockSize    = 1024
+	javaSCSerializable  = 0x02
+	javaByteArrayClass  = "[B"
+	javaByteArraySUID   = 0xacf317f8060854e0
+)
+
+var errJavaUTFTooLong = errors.New("java utf string too long")
+
+// javaObjectOutput writes primitives, utf strings and byte arrays like java.io.ObjectOutputStream.
+// The primitives are buffered and written as block data before the next object
+type javaObjectOutput struct {
+	buf                 *motan.BytesBuffer
+	block               []byte
+	byteArrayClassWrote bool
+}
+
+func newJavaObjectOutput() *javaObjectOutput {
+	out := &javaObjectOutput{buf: motan.NewBytesBuffer(256), block: make([]byte, 0, 64)}
+	out.buf.WriteUint16(javaStreamMagic)
+	out.buf.WriteUint16(javaStreamVersion)
+	return out
+}
+
+func (o *javaObjectOutput) WriteInt(v int32) {
+	o.block = append(o.block, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (o *javaObjectOutput) WriteLong(v int64) {
+	o.WriteInt(int32(v >> 32))
+	o.WriteInt(int32(v))
+}
+
+// WriteUTF writes the string in modified utf-8 with a 2 bytes length
+func (o *javaObjectOutput) WriteUTF(s string) error {
+	data := appendJavaChars(nil, utf16.Encode([]rune(s)), true)
+	if len(data) > 0xffff {
+		return errJavaUTFTooLong
+	}
+	o.block = append(o.block, byte(len(data)>>8), byte(len(data)))
+	o.block = append(o.block, data...)
+	return nil
+}
+
+// WriteBytes writes a byte array object, nil is written as a null object
+func (o *javaObjectOutput) WriteBytes(b []byte) {
+	o.flushBlock()
+	if b == nil {
+		o.buf.WriteByte(javaTCNull)
+		return
+	}
+	o.buf.WriteByte(javaTCArray)
+	if o.byteArrayClassWrote {
+		// the class description is the first handle of the stream
+		o.buf.WriteByte(javaTCReference)
+		o.buf.WriteUint32(javaBaseWireHandle)
+	} else {
+		o.buf.WriteByte(javaTCClassDesc)
+		o.buf.WriteUint16(uint16(len(javaByteArrayClass)))
+		o.buf.Write([]byte(javaByteArrayClass))
+		o.buf.WriteUint64(javaByteArraySUID)
+		o.buf.WriteByte(javaSCSerializable)
+		o.buf.WriteUint16(0)
+		o.buf.WriteByte(javaTCEndBlockData)
+		o.buf.WriteByte(javaTCNull)
+		o.byteArrayClassWrote = true
+	}
+	o.buf.WriteUint32(uint32(len(b)))
+	o.buf.Write(b)
+}
+
+func (o *javaObjectOutput) Bytes() []byte {
+	o.flushBlock()
+	return o.buf.Bytes()
+}
+
+func (o *javaObjectOutput) flushBlock() {
+	for len(o.block) > 0 {
+		n := len(o.block)
+		if n > javaMaxBlockSize {
+			n = javaMaxBlockSize
+		}
+		if n <= 0xff {
+			o.buf.WriteByte(javaTCBlockData)
+			o.buf.WriteByte(byte(n))
+		} else {
+			o.buf.WriteByte(javaTCBlockDataLong)
+			o.buf.WriteUint32(uint32(n))
+		}
+		o.buf.Write(o.block[:n])
+		o.block = o.block[n:]
+	}
+	o.block = o.block[:0]
+}
+
+// javaObjectInput reads the stream written by javaObjectOutput or java.io.ObjectOutputStream.
+// The primitives may span several blocks, the objects can only be byte arrays or null
+type javaObjectInput struct {
+	data  []byte
+	pos   int
+	block int // the remaining size of current block data
+	// the byte array class description or the byte arrays
+	handles []interface{}
+}
+
+func newJavaObjectInput(data []byte) (*javaObjectInput, error) {
+	if len(data) < 4 || binary.BigEndian.Uint16(data) != javaStreamMagic || binary.BigEndian.Uint16(data[2:]) != javaStreamVersion {
+		return nil, ErrV1Format
+	}
+	return &javaObjectInput{data: data, pos: 4}, nil
+}
+
+func (in *javaObjectInput) ReadInt() (int32, error) {
+	b, err := in.readBlockData(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(b)), nil
+}
+
+func (in *javaObjectInput) ReadLong() (int64, error) {
+	b, err := in.readBlockData(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+func (in *javaObjectInput) ReadUTF() (string, error) {
+	b, err := in.readBlockData(2)
+	if err != nil {
+		return "", err
+	}
+	if b, err = in.readBlockData(int(binary.BigEndian.Uint16(b))); err != nil {
+		return "", err
+	}
+	units, _, err := readJavaChars(b, -1)
+	if err != nil {
+		return "", err
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// HasObject tells whether the next content is an object rather than block data
+func (in *javaObjectInput) HasObject() bool {
+	if in.block > 0 || in.pos >= len(in.data) {
+		return false
+	}
+	tag := in.data[in.pos]
+	return tag != javaTCBlockData && tag != javaTCBlockDataLong
+}
+
+// ReadBytes reads a byte array object, nil is returned for a null object
+func (in *javaObjectInput) ReadBytes() ([]byte, error) {
+	if in.block > 0 {
+		return nil, ErrV1Format
+	}
+	for {
+		tag, err := in.raw(1)
+		if err != nil {
+			return nil, err
+		}
+		switch tag[0] {
+		case javaTCNull:
+			return nil, nil
+		case javaTCReset:
+			in.handles = in.handles[:0]
+		case javaTCReference:
+			h, err := in.readHandle()
+			if err != nil {
+				return nil, err
+			}
+			if b, ok := h.([]byte); ok {
+				return b, nil
+			}
+			return nil, ErrV1Format
+		case javaTCArray:
+			if err = in.readByteArrayClass(); err != nil {
+				return nil, err
+			}
+			size, err := in.raw(4)
+			if err != nil {
+				return nil, err
+			}
+			b, err := in.raw(int(binary.BigEndian.Uint32(size)))
+			if err != nil {
+				return nil, err
+			}
+			in.handles = append(in.handles, b)
+			return b, nil
+		default:
+			return nil, ErrV1Format
+		}
+	}
+}
+
+func (in *javaObjectInput) readByteArrayClass() error {
+	tag, err := in.raw(1)
+	if err != nil {
+		return err
+	}
+	switch tag[0] {
+	case javaTCReference:
+		h, err := in.readHandle()
+		if err != nil {
+			return err
+		}
+		if h != javaByteArrayClass {
+			return ErrV1Format
+		}
+		return nil
+	case javaTCClassDesc:
+		size, err := in.raw(2)
+		if err != nil {
+			return err
+		}
+		name, err := in.raw(int(binary.BigEndian.Uint16(size)))
+		if err != nil || string(name) != javaByteArrayClass {
+			return ErrV1Format
+		}
+		// serial version uid, flags and the field count which is always 0 for arrays
+		desc, err := in.raw(11)
+		if err != nil || binary.BigEndian.Uint16(desc[9:]) != 0 {
+			return ErrV1Format
+		}
+		// no class annotation and super class
+		end, err := in.raw(2)
+		if err != nil || end[0] != javaTCEndBlockData || end[1] != javaTCNull {
+			return ErrV1Format
+		}
+		in.handles = append(in.handles, javaByteArrayClass)
+		return nil
+	default:
+		return ErrV1Format
+	}
+}
+
+func (in *javaObjectInput) readHandle() (interface{}, error) {
+	b, err := in.raw(4)
+	if err != nil {
+		return nil, err
+	}
+	h := int(binary.BigEndian.Uint32(b)) - javaBaseWireHandle
+	if h < 0 || h >= len(in.handles) {
+		return nil, ErrV1Format
+	}
+	return in.handles[h], nil
+}
+
+func (in *javaObjectInput) readBlockData(n int) ([]byte, error) {
+	if in.block >= n {
+		in.block -= n
+		return in.raw(n)
+	}
+	result := make([]byte, 0, n)
+	for len(result) < n {
+		if in.block == 0 {
+			if err := in.nextBlock(); err != nil {
+				return nil, err
+			}
+		}
+		size := n - len(result)
+		if size > in.block {
+			size = in.block
+		}
+		b, err := in.raw(size)
+		if err != nil {
+			return nil, err
+		}
+		in.block -= size
+		result = append(result, b...)
+	}
+	return result, nil
+}
+
+func (in *javaObjectInput) nextBlock() error {
+	for {
+		tag, err := in.raw(1)
+		if err != nil {
+			return err
+		}
+		switch tag[0] {
+		case javaTCBlockData:
+			size, err := in.raw(1)
+			if err != nil {
+				return err
+			}
+			in.block = int(size[0])
+		case javaTCBlockDataLong:
+			size, err := in.raw(4)
+			if err != nil {
+				return err
+			}
+			in.block = int(binary.BigEndian.Uint32(size))
+		case javaTCReset:
+			in.handles = in.handles[:0]
+			continue
+		default:
+			return ErrV1Format
+		}
+		if in.block > 0 {
+			return nil
+		}
+	}
+}
+
+func (in *javaObjectInput) raw(n int) ([]byte, error) {
+	if n < 0 || in.pos+n > len(in.data) {
+		return nil, ErrV1Format
+	}
+	b := in.data[in.pos : in.pos+n]
+	in.pos += n
+	return b, nil
+}
+
+// appendJavaChars encodes the utf16 chars like java, the surrogates are encoded separately.
+// 0 is encoded in 2 bytes in the modified utf-8 of java streams
+func appendJavaChars(dst []byte, units []uint16, modified bool) []byte {
+	for _, u := range units {
+		switch {
+		case u < 0x80 && (u != 0 || !modified):
+			dst = append(dst, byte(u))
+		case u < 0x800:
+			dst = append(dst, byte(0xc0|u>>6), byte(0x80|u&0x3f))
+		default:
+			dst = append(dst, byte(0xe0|u>>12), byte(0x80|(u>>6)&0x3f), byte(0x80|u&0x3f))
+		}
+	}
+	return dst
+}
+
+// readJavaChars decodes count utf16 chars encoded by appendJavaChars, all data is decoded if count is negative.
+// It returns the chars and the size of the decoded data
+func readJavaChars(data []byte, count int) ([]uint16, int, error) {
+	units := make([]uint16, 0, len(data))
+	i := 0
+	for i < len(data) && (count < 0 || len(units) < count) {
+		b := data[i]
+		switch {
+		case b < 0x80:
+			units = append(units, uint16(b))
+			i++
+		case b&0xe0 == 0xc0 && i+1 < len(data):
+			units = append(units, uint16(b&0x1f)<<6|uint16(data[i+1]&0x3f))
+			i += 2
+		case b&0xf0 == 0xe0 && i+2 < len(data):
+			units = append(units, uint16(b&0x0f)<<12|uint16(data[i+1]&0x3f)<<6|uint16(data[i+2]&0x3f))
+			i += 3
+		default:
+			return nil, 0, ErrV1Format
+		}
+	}
+	if count >= 0 && len(units) < count {
+		return nil, 0, ErrV1Format
+	}
+	return units, i, nil
+}
+
+// appendHessianString encodes the string in hessian2, the length is the count of utf16 chars
+func appendHessianString(dst []byte, s string) []byte {
+	units := utf16.Encode([]rune(s))
+	for len(units) > 0x8000 {
+		n := 0x8000
+		// do not split the surrogate pair
+		if utf16.IsSurrogate(rune(units[n-1])) && units[n-1] < 0xdc00 {
+			n--
+		}
+		dst = append(dst, 'R', byte(n>>8), byte(n))
+		dst = appendJavaChars(dst, units[:n], false)
+		units = units[n:]
+	}
+	n := len(units)
+	switch {
+	case n <= 0x1f:
+		dst = append(dst, byte(n))
+	case n <= 0x3ff:
+		dst = append(dst, byte(0x30+n>>8), byte(n))
+	default:
+		dst = append(dst, 'S', byte(n>>8), byte(n))
+	}
+	return appendJavaChars(dst, units, false)
+}
+
+// readHessianString reads a hessian2 string or null, it returns the string and the remaining data
+func readHessianString(data []byte) (string, []byte, error) {
+	var units []uint16
+	for {
+		if len(data) == 0 {
+			return "", nil, ErrV1Format
+		}
+		var n int
+		final := true
+		switch tag := data[0]; {
+		case tag == 'N' && units == nil:
+			return "", data[1:], nil
+		case tag <= 0x1f:
+			n, data = int(tag), data[1:]
+		case tag >= 0x30 && tag <= 0x33 && len(data) >= 2:
+			n, data = int(tag-0x30)<<8|int(data[1]), data[2:]
+		case (tag == 'S' || tag == 'R') && len(data) >= 3:
+			n, data = int(binary.BigEndian.Uint16(data[1:])), data[3:]
+			final = tag == 'S'
+		default:
+			return "", nil, ErrV1Format
+		}
+		chars, size, err := readJavaChars(data, n)
+		if err != nil {
+			return "", nil, err
+		}
+		units, data = append(units, chars...), data[size:]
+		if final {
+			return string(utf16.Decode(units)), data, nil
+		}
+	}
+}
+
+// encodeHessianException encodes an exception object with the message in hessian2, so java clients can get it
+func encodeHessianException(className string, message string) []byte {
+	dst := []byte{'C'}
+	dst = appendHessianString(dst, className)
+	// one field
+	dst = append(dst, 0x91)
+	dst = appendHessianString(dst, "detailMessage")
+	// the object of class definition 0
+	dst = append(dst, 0x60)
+	return appendHessianString(dst, message)
+}
+
+// decodeHessianException gets the message of the exception encoded by encodeHessianException.
+// The exceptions of java services have fields of other types before the message, they are not supported
+func decodeHessianException(data []byte) (string, bool) {
+	if len(data) == 0 || data[0] != 'C' {
+		return "", false
+	}
+	_, data, err := readHessianString(data[1:])
+	if err != nil || len(data) == 0 || data[0] < 0x90 || data[0] > 0xbf {
+		return "", false
+	}
+	fields := make([]string, int(data[0])-0x90)
+	data = data[1:]
+	for i := range fields {
+		if fields[i], data, err = readHessianString(data); err != nil {
+			return "", false
+		}
+	}
+	if len(data) == 0 || data[0] != 0x60 {
+		return "", false
+	}
+	data = data[1:]
+	for _, field := range fields {
+		var value string
+		if value, data, err = readHessianString(data); err != nil {
+			return "", false
+		}
+		if field == "detailMessage" {
+			return value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,203 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+// the bytes written by java.io.ObjectOutputStream with:
+// writeUTF("a"); writeObject(new byte[]{1, 2}); writeObject(new byte[]{3}); writeObject(null); writeInt(0)
+var javaStreamBytes = []byte{
+	0xac, 0xed, 0x00, 0x05,
+	0x77, 0x03, 0x00, 0x01, 'a',
+	0x75, 0x72, 0x00, 0x02, '[', 'B', 0xac, 0xf3, 0x17, 0xf8, 0x06, 0x08, 0x54, 0xe0, 0x02, 0x00, 0x00, 0x78, 0x70,
+	0x00, 0x00, 0x00, 0x02, 0x01, 0x02,
+	0x75, 0x71, 0x00, 0x7e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x03,
+	0x70,
+	0x77, 0x04, 0x00, 0x00, 0x00, 0x00,
+}
+
+func TestJavaObjectStream(t *testing.T) {
+	out := newJavaObjectOutput()
+	assert.Nil(t, out.WriteUTF("a"))
+	out.WriteBytes([]byte{1, 2})
+	out.WriteBytes([]byte{3})
+	out.WriteBytes(nil)
+	out.WriteInt(0)
+	assert.Equal(t, javaStreamBytes, out.Bytes())
+
+	in, err := newJavaObjectInput(javaStreamBytes)
+	assert.Nil(t, err)
+	s, err := in.ReadUTF()
+	assert.Nil(t, err)
+	assert.Equal(t, "a", s)
+	for _, expect := range [][]byte{{1, 2}, {3}, nil} {
+		assert.True(t, in.HasObject())
+		b, err := in.ReadBytes()
+		assert.Nil(t, err)
+		assert.Equal(t, expect, b)
+	}
+	assert.False(t, in.HasObject())
+	i, err := in.ReadInt()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), i)
+	_, err = in.ReadInt()
+	assert.Equal(t, ErrV1Format, err)
+
+	// the primitives span blocks, long strings and the back reference of arrays
+	long := strings.Repeat("motan 协议", 200)
+	out = newJavaObjectOutput()
+	out.WriteLong(-2)
+	assert.Nil(t, out.WriteUTF(long))
+	out.WriteInt(5)
+	data := out.Bytes()
+	assert.Equal(t, byte(javaTCBlockDataLong), data[4])
+	data = append(data, javaTCArray, javaTCReference, 0x00, 0x7e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, javaTCReference, 0x00, 0x7e, 0x00, 0x01)
+	in, _ = newJavaObjectInput(data)
+	l, err := in.ReadLong()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), l)
+	s, err = in.ReadUTF()
+	assert.Nil(t, err)
+	assert.Equal(t, long, s)
+	i, _ = in.ReadInt()
+	assert.Equal(t, int32(5), i)
+	_, err = in.ReadBytes()
+	assert.Equal(t, ErrV1Format, err)
+	assert.NotNil(t, out.WriteUTF(strings.Repeat("a", 0x10000)))
+
+	_, err = newJavaObjectInput([]byte{0xac, 0xed, 0x00, 0x04})
+	assert.Equal(t, ErrV1Format, err)
+}
+
+func TestHessianException(t *testing.T) {
+	for _, message := range []string{"", "error", strings.Repeat("错误", 100), strings.Repeat("e😀", 20000)} {
+		data := encodeHessianException(v1ExceptionClass, message)
+		decoded, ok := decodeHessianException(data)
+		assert.True(t, ok)
+		assert.Equal(t, message, decoded)
+	}
+	// the exceptions of java have complex fields before the message
+	_, ok := decodeHessianException([]byte{'C', 0x04, 'T', 'e', 's', 't', 0x92, 0x01, 'a', 0x01, 'b', 0x60, 'H', 'Z', 0x00})
+	assert.False(t, ok)
+	_, ok = decodeHessianException(nil)
+	assert.False(t, ok)
+}
+
+func encodeDecodeV1(t *testing.T, codec Codec, msg *Message) *Message {
+	data, err := codec.Encode(msg)
+	assert.Nil(t, err)
+	buf := &bytes.Buffer{}
+	n, err := codec.WriteTo(msg, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	decoded, _, err := codec.Decode(bufio.NewReader(bytes.NewReader(data)))
+	assert.Nil(t, err)
+	return decoded
+}
+
+func TestMotanV1Codec(t *testing.T) {
+	codec := NewCodec(CodecMotan, nil)
+	assert.Equal(t, CodecMotan, codec.GetName())
+	assert.Equal(t, CodecMotan2, NewCodec("unknown", nil).GetName())
+
+	// request
+	request := &Message{Header: BuildHeader(Req, false, Simple, 123, Normal), Metadata: core.NewStringMap(0), Body: []byte("args")}
+	request.Metadata.Store(MPath, "com.weibo.test.TestService")
+	request.Metadata.Store(MMethod, "hello")
+	request.Metadata.Store(MMethodDesc, "java.lang.String")
+	request.Metadata.Store(MGroup, "test-group")
+	request.Metadata.Store("key", "value")
+	decoded := encodeDecodeV1(t, codec, request)
+	assert.True(t, decoded.Header.isRequest())
+	assert.Equal(t, uint64(123), decoded.Header.RequestID)
+	assert.Equal(t, Hessian, decoded.Header.GetSerialize())
+	assert.Equal(t, request.Metadata.RawMap(), decoded.Metadata.RawMap())
+	assert.Equal(t, request.Body, decoded.Body)
+	data, _ := codec.Encode(request)
+	assert.True(t, bytes.Contains(data, []byte("group")))
+	assert.False(t, bytes.Contains(data, []byte(MGroup)))
+
+	// the compressed body is sent uncompressed
+	EncodeMessageGzip(request, 1)
+	assert.True(t, request.Header.IsGzip())
+	decoded = encodeDecodeV1(t, codec, request)
+	assert.Equal(t, []byte("args"), decoded.Body)
+
+	// multiple arguments are split by the serialization
+	simple := &serialize.SimpleSerialization{}
+	body, _ := simple.SerializeMulti([]interface{}{"a", "bc"})
+	request.Body = body
+	request.Metadata.Store(MMethodDesc, "java.lang.String,java.lang.String")
+	_, err := codec.Encode(request)
+	assert.Equal(t, ErrV1Arguments, err)
+	decoded = encodeDecodeV1(t, NewCodec(CodecMotan, simple), request)
+	assert.Equal(t, Simple, decoded.Header.GetSerialize())
+	assert.Equal(t, body, decoded.Body)
+
+	// heartbeat
+	decoded = encodeDecodeV1(t, codec, BuildHeartbeat(5, Req))
+	assert.True(t, decoded.Header.IsHeartbeat())
+	assert.Equal(t, uint64(5), decoded.Header.RequestID)
+	decoded = encodeDecodeV1(t, codec, BuildHeartbeat(5, Res))
+	assert.False(t, decoded.Header.isRequest())
+	assert.Equal(t, Normal, decoded.Header.GetStatus())
+	assert.Equal(t, 0, len(decoded.Body))
+
+	// response
+	response := &Message{Header: BuildHeader(Res, false, Simple, 124, Normal), Metadata: core.NewStringMap(0), Body: []byte("value")}
+	response.Metadata.Store(MProcessTime, "12")
+	decoded = encodeDecodeV1(t, codec, response)
+	assert.False(t, decoded.Header.isRequest())
+	assert.Equal(t, uint64(124), decoded.Header.RequestID)
+	assert.Equal(t, "12", decoded.Metadata.LoadOrEmpty(MProcessTime))
+	assert.Equal(t, response.Body, decoded.Body)
+	response.Body = nil
+	decoded = encodeDecodeV1(t, codec, response)
+	assert.Equal(t, Normal, decoded.Header.GetStatus())
+	assert.Equal(t, 0, len(decoded.Body))
+
+	exception := BuildExceptionResponse(125, ExceptionToJSON(&core.Exception{ErrCode: 500, ErrMsg: "service error", ErrType: core.ServiceException}))
+	decoded = encodeDecodeV1(t, codec, exception)
+	assert.Equal(t, Exception, decoded.Header.GetStatus())
+	res, err := ConvertToResponse(decoded, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "service error", res.GetException().ErrMsg)
+
+	// the exceptions of java services
+	out := newJavaObjectOutput()
+	out.WriteLong(1)
+	out.WriteUTF("com.weibo.api.motan.exception.MotanServiceException")
+	out.WriteBytes([]byte{'C', 'H', 'Z'})
+	data = append([]byte{0xf0, 0xf0, v1Version, v1FlagResponseException, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, byte(len(out.Bytes()))}, out.Bytes()...)
+	decoded, _, err = codec.Decode(bufio.NewReader(bytes.NewReader(data)))
+	assert.Nil(t, err)
+	res, _ = ConvertToResponse(decoded, nil)
+	assert.Equal(t, "motan v1 remote exception: com.weibo.api.motan.exception.MotanServiceException", res.GetException().ErrMsg)
+	assert.Equal(t, core.ServiceException, res.GetException().ErrType)
+
+	// broken messages
+	data, _ = codec.Encode(response)
+	for _, l := range []int{0, 5, V1HeaderLength, len(data) - 1} {
+		_, _, err = codec.Decode(bufio.NewReader(bytes.NewReader(data[:l])))
+		assert.NotNil(t, err)
+	}
+	wrong := append([]byte{}, data...)
+	wrong[0] = 0
+	_, _, err = codec.Decode(bufio.NewReader(bytes.NewReader(wrong)))
+	assert.Equal(t, ErrMagicNum, err)
+	wrong = append([]byte{}, data...)
+	wrong[2] = 2
+	_, _, err = codec.Decode(bufio.NewReader(bytes.NewReader(wrong)))
+	assert.Equal(t, ErrVersion, err)
+	wrong = append([]byte{}, data...)
+	wrong[3] = 0xff
+	_, _, err = codec.Decode(bufio.NewReader(bytes.NewReader(wrong)))
+	assert.Equal(t, ErrV1Format, err)
+}
@@ -40,6 +40,8 @@ type HTTPProvider struct {
 	domain            string
 	defaultHTTPMethod string
 	enableRewrite     bool
+	// the active health check of the upstream, it's nil if the health check is disabled
+	healthChecker *motan.HealthChecker
 }
 
 const (
@@ -53,6 +55,8 @@ const (
 	DefaultRequestTimeout = 1 * time.Second
 )
 
+var errUpstreamUnhealthy = errors.New("upstream is unhealthy")
+
 // Initialize http provider
 func (h *HTTPProvider) Initialize() {
 	timeout := h.url.GetTimeDuration(motan.TimeOutKey, time.Millisecond, DefaultRequestTimeout)
@@ -112,10 +116,49 @@ func (h *HTTPProvider) Initialize() {
 		ReadTimeout:               timeout,
 		WriteTimeout:              timeout,
 	}
+	h.initHealthCheck()
+}
+
+// initHealthCheck starts the GET probes to the upstream if the health check is configured
+func (h *HTTPProvider) initHealthCheck() {
+	config := motan.NewHealthCheckConfig(h.url, motan.HealthCheckHTTP)
+	if config == nil || h.proxyAddr == "" {
+		return
+	}
+	if config.Type != motan.HealthCheckHTTP {
+		vlog.Warningf("HTTPProvider only supports the http health check, the health check %s is disabled", config.Type)
+		return
+	}
+	h.healthChecker = motan.NewHealthChecker(h.proxyAddr, config, func(timeout time.Duration) error {
+		req := fasthttp.AcquireRequest()
+		res := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		defer fasthttp.ReleaseResponse(res)
+		req.Header.SetMethod("GET")
+		req.SetRequestURI(config.Path)
+		req.URI().SetScheme(h.proxySchema)
+		req.Header.SetHost(h.domain)
+		if err := h.fastClient.DoTimeout(req, res, timeout); err != nil {
+			return err
+		}
+		return config.CheckStatus(res.StatusCode())
+	}, nil)
+	h.healthChecker.Start()
+}
+
+// doUpstream does the http request to the upstream, it fails fast if the upstream is unhealthy
+func (h *HTTPProvider) doUpstream(httpReq *fasthttp.Request, httpRes *fasthttp.Response) error {
+	if !h.IsAvailable() {
+		return errUpstreamUnhealthy
+	}
+	return h.fastClient.Do(httpReq, httpRes)
 }
 
 // Destroy a HTTPProvider
 func (h *HTTPProvider) Destroy() {
+	if h.healthChecker != nil {
+		h.healthChecker.Stop()
+	}
 }
 
 // SetSerialization for set a motan.SetSerialization to HTTPProvider
@@ -208,6 +251,48 @@ func buildQueryStr(request motan.Request, url *motan.URL, mixVars []string) (res
 	return res, err
 }
 
+// locate returns the matched location and the rewritten path of the request, it returns false if the location is
+// required for the rewrite but not found
+func (h *HTTPProvider) locate(path string, httpReq *fasthttp.Request) (*mhttp.ProxyLocation, string, bool) {
+	location := h.locationMatcher.Locate(path)
+	if !h.enableRewrite {
+		return location, path, true
+	}
+	// Do not check upstream for compatibility
+	if location == nil {
+		return nil, "", false
+	}
+	var query []byte
+	// init query string bytes if needed.
+	if h.locationMatcher.NeedURLQueryString() {
+		query = httpReq.URI().QueryString()
+	}
+	return location, location.DeterminePath(path, query, true), true
+}
+
+// newRequestVariables returns nil if the location has no rules or the rules have been applied by the http proxy server
+func (h *HTTPProvider) newRequestVariables(request motan.Request, location *mhttp.ProxyLocation, httpReq *fasthttp.Request, ip string) *mhttp.RequestVariables {
+	if location == nil || !location.HasRules() || request.GetAttachment(mhttp.LocationRulesApplied) != "" {
+		return nil
+	}
+	return &mhttp.RequestVariables{
+		Request:   httpReq,
+		RemoteIP:  ip,
+		RequestID: strconv.FormatUint(request.GetRequestID(), 10),
+	}
+}
+
+// doRequest does the http request, the responses are cached if the location has the cache config
+func (h *HTTPProvider) doRequest(location *mhttp.ProxyLocation, variables *mhttp.RequestVariables, httpReq *fasthttp.Request, httpRes *fasthttp.Response) error {
+	if variables != nil {
+		if cache := location.ResponseCache(); cache != nil {
+			_, err := cache.Do(httpReq, httpRes, h.doUpstream)
+			return err
+		}
+	}
+	return h.doUpstream(httpReq, httpRes)
+}
+
 // Call for do a motan call through this provider
 func (h *HTTPProvider) Call(request motan.Request) motan.Response {
 	t := time.Now().UnixNano()
@@ -246,40 +331,38 @@ func (h *HTTPProvider) Call(request motan.Request) motan.Response {
 		httpReq.Header.Read(bufio.NewReader(bytes.NewReader(headerBytes)))
 
 		//do rewrite
-		rewritePath := request.GetMethod()
-		if h.enableRewrite {
-			// Do not check upstream for compatibility
-
-			var query []byte
-			// init query string bytes if needed.
-			if h.locationMatcher.NeedURLQueryString() {
-				query = httpReq.URI().QueryString()
+		location, rewritePath, ok := h.locate(request.GetMethod(), httpReq)
+		if !ok {
+			fillExceptionWithCode(resp, http.StatusNotFound, t, errors.New("service not found"))
+			return resp
+		}
+		variables := h.newRequestVariables(request, location, httpReq, ip)
+		if variables == nil || !location.Respond(variables, httpRes) {
+			// sets rewrite
+			httpReq.URI().SetScheme(h.proxySchema)
+			httpReq.URI().SetPath(rewritePath)
+			request.GetAttachments().Range(func(k, v string) bool {
+				if strings.HasPrefix(k, "M_") {
+					httpReq.Header.Add(strings.Replace(k, "M_", "MOTAN-", -1), v)
+				}
+				return true
+			})
+			httpReq.Header.Del("Connection")
+			httpReq.Header.Set("X-Forwarded-For", ip)
+			if len(bodyBytes) != 0 {
+				httpReq.BodyWriter().Write(bodyBytes)
+			}
+			if variables != nil {
+				location.RewriteRequest(variables)
 			}
-			_, path, ok := h.locationMatcher.Pick(request.GetMethod(), query, true)
-			if !ok {
-				fillExceptionWithCode(resp, http.StatusNotFound, t, errors.New("service not found"))
+			err := h.doRequest(location, variables, httpReq, httpRes)
+			if err != nil {
+				fillExceptionWithCode(resp, http.StatusServiceUnavailable, t, err)
 				return resp
 			}
-			rewritePath = path
-		}
-		// sets rewrite
-		httpReq.URI().SetScheme(h.proxySchema)
-		httpReq.URI().SetPath(rewritePath)
-		request.GetAttachments().Range(func(k, v string) bool {
-			if strings.HasPrefix(k, "M_") {
-				httpReq.Header.Add(strings.Replace(k, "M_", "MOTAN-", -1), v)
+			if variables != nil {
+				location.RewriteResponse(variables, httpRes)
 			}
-			return true
-		})
-		httpReq.Header.Del("Connection")
-		httpReq.Header.Set("X-Forwarded-For", ip)
-		if len(bodyBytes) != 0 {
-			httpReq.BodyWriter().Write(bodyBytes)
-		}
-		err := h.fastClient.Do(httpReq, httpRes)
-		if err != nil {
-			fillExceptionWithCode(resp, http.StatusServiceUnavailable, t, err)
-			return resp
 		}
 		headerBuffer := &bytes.Buffer{}
 		httpRes.Header.Del("Connection")
@@ -308,19 +391,10 @@ func (h *HTTPProvider) Call(request motan.Request) motan.Response {
 			fillExceptionWithCode(resp, http.StatusBadRequest, t, err)
 			return resp
 		}
-		rewritePath := request.GetMethod()
-		if h.enableRewrite {
-			var query []byte
-			// init query string bytes if needed.
-			if h.locationMatcher.NeedURLQueryString() {
-				query = httpReq.URI().QueryString()
-			}
-			_, path, ok := h.locationMatcher.Pick(request.GetMethod(), query, true)
-			if !ok {
-				fillExceptionWithCode(resp, http.StatusNotFound, t, errors.New("service not found"))
-				return resp
-			}
-			rewritePath = path
+		location, rewritePath, ok := h.locate(request.GetMethod(), httpReq)
+		if !ok {
+			fillExceptionWithCode(resp, http.StatusNotFound, t, errors.New("service not found"))
+			return resp
 		}
 
 		httpReq.URI().SetScheme(h.proxySchema)
@@ -328,11 +402,20 @@ func (h *HTTPProvider) Call(request motan.Request) motan.Response {
 		if len(httpReq.Header.Host()) == 0 {
 			httpReq.Header.SetHost(h.domain)
 		}
-		httpReq.Header.Set("X-Forwarded-For", ip)
-		err = h.fastClient.Do(httpReq, httpRes)
-		if err != nil {
-			fillExceptionWithCode(resp, http.StatusServiceUnavailable, t, err)
-			return resp
+		variables := h.newRequestVariables(request, location, httpReq, ip)
+		if variables == nil || !location.Respond(variables, httpRes) {
+			httpReq.Header.Set("X-Forwarded-For", ip)
+			if variables != nil {
+				location.RewriteRequest(variables)
+			}
+			err = h.doRequest(location, variables, httpReq, httpRes)
+			if err != nil {
+				fillExceptionWithCode(resp, http.StatusServiceUnavailable, t, err)
+				return resp
+			}
+			if variables != nil {
+				location.RewriteResponse(variables, httpRes)
+			}
 		}
 		mhttp.FasthttpResponseToMotanResponse(resp, httpRes)
 		resp.ProcessTime = (time.Now().UnixNano() - t) / 1e6
@@ -451,8 +534,15 @@ func (h *HTTPProvider) SetMixVars(mixVars []string) {
 
 // IsAvailable to check if this provider is sitll working well
 func (h *HTTPProvider) IsAvailable() bool {
-	//TODO Provider 是否可用
-	return true
+	return h.healthChecker == nil || h.healthChecker.IsHealthy()
+}
+
+// GetHealthStatus returns the health check status of the upstream, it's nil if the health check is disabled
+func (h *HTTPProvider) GetHealthStatus() *motan.HealthStatus {
+	if h.healthChecker == nil {
+		return nil
+	}
+	return h.healthChecker.GetHealthStatus()
 }
 
 // SetService to set services to this provider that wich can handle
@@ -1,6 +1,7 @@
 package provider
 
 import (
+	"errors"
 	"reflect"
 
 	motan "github.com/weibocom/motan-go/core"
@@ -13,6 +14,7 @@ const (
 	HTTP    = "http"
 	HTTPX   = "httpx"
 	MOTAN2  = "motan2"
+	MOTAN   = "motan"
 	Mock    = "mockProvider"
 	Default = "default"
 )
@@ -35,6 +37,10 @@ func RegistDefaultProvider(extFactory motan.ExtensionFactory) {
 		return &MotanProvider{url: url, extFactory: extFactory}
 	})
 
+	extFactory.RegistExtProvider(MOTAN, func(url *motan.URL) motan.Provider {
+		return &MotanProvider{url: url, extFactory: extFactory}
+	})
+
 	extFactory.RegistExtProvider(Mock, func(url *motan.URL) motan.Provider {
 		return &MockProvider{URL: url}
 	})
@@ -122,6 +128,47 @@ func (d *DefaultProvider) Call(request motan.Request) (res motan.Response) {
 	return mres
 }
 
+var (
+	serverStreamType = reflect.TypeOf((*motan.ServerStream)(nil)).Elem()
+	errorType        = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// CallStream calls a streaming method of the service.
+// A streaming method takes motan.ServerStream as the last argument and returns an error, such as: func (s *Service) Subscribe(topic string, stream motan.ServerStream) error
+func (d *DefaultProvider) CallStream(request motan.Request, stream motan.ServerStream) error {
+	m, exit := d.methods[motan.FirstUpper(request.GetMethod())]
+	if !exit {
+		vlog.Errorf("method not found in provider. %s", motan.GetReqInfo(request))
+		return errors.New("method " + request.GetMethod() + " is not found in provider.")
+	}
+	mt := m.Type()
+	inNum := mt.NumIn()
+	if inNum == 0 || mt.In(inNum-1) != serverStreamType || mt.NumOut() != 1 || mt.Out(0) != errorType {
+		return errors.New("method " + request.GetMethod() + " is not a stream method.")
+	}
+	vs := make([]reflect.Value, 0, inNum)
+	if inNum > 1 {
+		values := make([]interface{}, 0, inNum-1)
+		for i := 0; i < inNum-1; i++ {
+			values = append(values, mt.In(i))
+		}
+		if err := request.ProcessDeserializable(values); err != nil {
+			return errors.New("deserialize arguments fail." + err.Error())
+		}
+		for _, arg := range request.GetArguments() {
+			vs = append(vs, reflect.ValueOf(arg))
+		}
+	}
+	if len(vs) != inNum-1 {
+		return errors.New("arguments count of method " + request.GetMethod() + " not match.")
+	}
+	vs = append(vs, reflect.ValueOf(stream))
+	if err, _ := m.Call(vs)[0].Interface().(error); err != nil {
+		return err
+	}
+	return nil
+}
+
 type MockProvider struct {
 	URL          *motan.URL
 	MockResponse motan.Response
@@ -8,6 +8,12 @@ import (
 	"github.com/weibocom/motan-go/log"
 )
 
+const (
+	// DirectCommandKey is the static command of the direct registry, it's processed like the service commands of the
+	// other registries, such as a traffic control command which merges the groups with weights
+	DirectCommandKey = "command"
+)
+
 type DirectRegistry struct {
 	url  *motan.URL
 	urls []*motan.URL
@@ -35,8 +41,13 @@ func (d *DirectRegistry) Discover(url *motan.URL) []*motan.URL {
 	if d.urls == nil {
 		d.urls = parseURLs(d.url)
 	}
-	result := make([]*motan.URL, 0, len(d.urls))
-	for _, u := range d.urls {
+	urls := d.urls
+	// the servers of a group are in the address.{group}, such as the groups merged by the command
+	if address := d.url.GetParam(motan.AddressKey+"."+url.Group, ""); url.Group != "" && address != "" {
+		urls = parseAddress(address)
+	}
+	result := make([]*motan.URL, 0, len(urls))
+	for _, u := range urls {
 		newURL := *url
 		newURL.Host = u.Host
 		newURL.Port = u.Port
@@ -60,23 +71,45 @@ func (d *DirectRegistry) GetRegisteredServices() []*motan.URL {
 	return nil
 }
 func (d *DirectRegistry) StartSnapshot(conf *motan.SnapshotConf) {}
+
+// SubscribeCommand does nothing, the command of the direct registry never changes
+func (d *DirectRegistry) SubscribeCommand(url *motan.URL, listener motan.CommandNotifyListener) {
+}
+
+func (d *DirectRegistry) UnSubscribeCommand(url *motan.URL, listener motan.CommandNotifyListener) {
+}
+
+func (d *DirectRegistry) DiscoverCommand(url *motan.URL) string {
+	return d.url.GetParam(DirectCommandKey, "")
+}
+
 func parseURLs(url *motan.URL) []*motan.URL {
 	urls := make([]*motan.URL, 0)
 	if len(url.Host) > 0 && url.Port > 0 {
 		urls = append(urls, url)
 	} else if address, exist := url.Parameters[motan.AddressKey]; exist {
-		for _, add := range strings.Split(address, ",") {
-			hostport := motan.TrimSplit(add, ":")
-			if len(hostport) == 2 {
-				port, err := strconv.Atoi(hostport[1])
-				if err == nil {
-					u := &motan.URL{Host: hostport[0], Port: port}
-					urls = append(urls, u)
-				}
-			}
-		}
+		urls = parseAddress(address)
 	} else {
 		vlog.Warningf("direct registry parse fail.url:L %+v", url)
 	}
 	return urls
 }
+
+func parseAddress(address string) []*motan.URL {
+	urls := make([]*motan.URL, 0)
+	for _, add := range strings.Split(address, ",") {
+		if add = strings.TrimSpace(add); strings.HasPrefix(add, motan.UnixSockProtocolFlag) {
+			urls = append(urls, &motan.URL{Host: add})
+			continue
+		}
+		hostport := motan.TrimSplit(add, ":")
+		if len(hostport) == 2 {
+			port, err := strconv.Atoi(hostport[1])
+			if err == nil {
+				u := &motan.URL{Host: hostport[0], Port: port}
+				urls = append(urls, u)
+			}
+		}
+	}
+	return urls
+}
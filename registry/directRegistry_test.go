@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/weibocom/motan-go/cluster"
 	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/ha"
+	"github.com/weibocom/motan-go/lb"
 )
 
 func TestGetDirectRegistey(t *testing.T) {
@@ -66,3 +69,65 @@ func TestMultiAddress(t *testing.T) {
 		}
 	}
 }
+
+func TestUnixSockAddress(t *testing.T) {
+	params := map[string]string{motan.AddressKey: "127.0.0.1:8002, unix:///run/motan/agent.sock"}
+	registry := &DirectRegistry{url: &motan.URL{Parameters: params}}
+	urls := registry.Discover(&motan.URL{Protocol: "motan2"})
+	if len(urls) != 2 {
+		t.Fatalf("discover size should be 2. size: %d", len(urls))
+	}
+	if urls[1].Host != "unix:///run/motan/agent.sock" || urls[1].GetAddressStr() != "unix:///run/motan/agent.sock" || motan.GetUnixSockAddress(urls[1]) != "/run/motan/agent.sock" {
+		t.Fatalf("discover unix sock url not correct. url: %+v", urls[1])
+	}
+	if motan.GetUnixSockAddress(urls[0]) != "" {
+		t.Fatalf("tcp url should not use unix sock. url: %+v", urls[0])
+	}
+}
+
+func TestGroupAddressCommand(t *testing.T) {
+	command := `{"clientCommandList":[{"index":0,"commandType":0,"pattern":"*","mergeGroups":["backend-weight5:5","backend-weight1:1"]}]}`
+	regURL := &motan.URL{Protocol: "direct", Parameters: map[string]string{
+		motan.AddressKey:                      "10.0.0.1:8080,10.0.0.2:8080",
+		motan.AddressKey + ".backend-weight5": "10.0.0.1:8080",
+		motan.AddressKey + ".backend-weight1": "10.0.0.2:8080",
+		motan.URLConfKey:                      "upstream-backend",
+		DirectCommandKey:                      command,
+	}}
+	registry := &DirectRegistry{url: regURL}
+	if urls := registry.Discover(&motan.URL{Protocol: "test", Group: "api.example.com"}); len(urls) != 2 {
+		t.Fatalf("discover size of the default group should be 2. size: %d", len(urls))
+	}
+	urls := registry.Discover(&motan.URL{Protocol: "test", Group: "backend-weight1"})
+	if len(urls) != 1 || urls[0].Host != "10.0.0.2" || urls[0].Group != "backend-weight1" {
+		t.Fatalf("discover group address not correct. urls: %+v", urls)
+	}
+	if registry.DiscoverCommand(&motan.URL{}) != command {
+		t.Fatalf("discover command not correct. command: %s", registry.DiscoverCommand(&motan.URL{}))
+	}
+
+	// the groups are merged with the weights by the cluster
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ha.RegistDefaultHa(ext)
+	lb.RegistDefaultLb(ext)
+	RegistDefaultRegistry(ext)
+	ext.RegistExtEndpoint("test", func(url *motan.URL) motan.EndPoint {
+		return &motan.TestEndPoint{URL: url}
+	})
+	referURL := &motan.URL{Protocol: "test", Path: "test.domain", Group: "api.example.com", Parameters: map[string]string{
+		motan.RegistryKey: "upstream-backend",
+		motan.Hakey:       "failover",
+		motan.Lbkey:       "roundrobin",
+	}}
+	context := &motan.Context{RegistryURLs: map[string]*motan.URL{"upstream-backend": regURL}}
+	cls := cluster.NewCluster(context, ext, referURL, false)
+	defer cls.Destroy()
+	counts := make(map[string]int)
+	for i := 0; i < 600; i++ {
+		counts[cls.LoadBalance.Select(&motan.MotanRequest{}).GetURL().Group]++
+	}
+	if len(cls.GetRefers()) != 2 || counts["backend-weight5"] != 500 || counts["backend-weight1"] != 100 {
+		t.Fatalf("weighted groups not correct. refers: %d, counts: %v", len(cls.GetRefers()), counts)
+	}
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/endpoint"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/registry"
+	"github.com/weibocom/motan-go/serialize"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// GrpcServer accepts the raw grpc calls and dispatches them to the message handler like motan2 requests.
+// The service and method are parsed from the grpc path `/{service}/{method}`, the messages are passed through as
+// grpc-pb serialized bodies, so the providers of pb services and the agent proxies are reachable from grpc clients
+type GrpcServer struct {
+	URL        *motan.URL
+	handler    motan.MessageHandler
+	listener   net.Listener
+	server     *grpc.Server
+	extFactory motan.ExtensionFactory
+	proxy      bool
+}
+
+func (g *GrpcServer) Open(block bool, proxy bool, handler motan.MessageHandler, extFactory motan.ExtensionFactory) error {
+	var lis net.Listener
+	if unixSockAddr := g.URL.GetParam(motan.UnixSockKey, ""); unixSockAddr != "" {
+		listener, err := motan.ListenUnixSock(unixSockAddr)
+		if err != nil {
+			vlog.Errorf("listenUnixSock fail. err:%v", err)
+			return err
+		}
+		lis = listener
+	} else {
+		addr := ":" + strconv.Itoa(int(g.URL.Port))
+		if registry.IsAgent(g.URL) {
+			addr = g.URL.Host + addr
+		}
+		lisTmp, err := net.Listen("tcp", addr)
+		if err != nil {
+			vlog.Errorf("listen port:%d fail. err: %v", g.URL.Port, err)
+			return err
+		}
+		lis = lisTmp
+	}
+	g.listener = lis
+	g.handler = handler
+	g.extFactory = extFactory
+	g.proxy = proxy
+	options := []grpc.ServerOption{grpc.CustomCodec(rawCodec{}), grpc.UnknownServiceHandler(g.handleStream)}
+	if motan.IsTLSEnabled(g.URL) {
+		tlsConfig, err := motan.BuildServerTLSConfig(g.URL)
+		if err != nil {
+			vlog.Errorf("build tls config fail. url:%s, err: %v", g.URL.GetIdentity(), err)
+			lis.Close()
+			return err
+		}
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if keepaliveTime := g.URL.GetTimeDuration(endpoint.GrpcKeepaliveTimeKey, time.Millisecond, 0); keepaliveTime > 0 {
+		options = append(options, grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    keepaliveTime,
+			Timeout: g.URL.GetTimeDuration(endpoint.GrpcKeepaliveTimeoutKey, time.Millisecond, endpoint.DefaultGrpcKeepaliveTimeout),
+		}))
+	}
+	g.server = grpc.NewServer(options...)
+	vlog.Infof("grpc server is started. port:%d, tls:%t", g.URL.Port, motan.IsTLSEnabled(g.URL))
+	if block {
+		return g.server.Serve(lis)
+	}
+	go g.server.Serve(lis)
+	return nil
+}
+
+func (g *GrpcServer) GetMessageHandler() motan.MessageHandler {
+	return g.handler
+}
+
+func (g *GrpcServer) SetMessageHandler(mh motan.MessageHandler) {
+	g.handler = mh
+}
+
+func (g *GrpcServer) GetURL() *motan.URL {
+	return g.URL
+}
+
+func (g *GrpcServer) SetURL(url *motan.URL) {
+	g.URL = url
+}
+
+func (g *GrpcServer) GetName() string {
+	return Grpc
+}
+
+func (g *GrpcServer) Destroy() {
+	if g.server != nil {
+		g.server.Stop()
+		vlog.Infof("grpc server destroy success.url %v", g.URL)
+	}
+}
+
+// handleStream handles all the grpc calls, only unary calls are supported now
+func (g *GrpcServer) handleStream(srv interface{}, stream grpc.ServerStream) error {
+	start := time.Now()
+	fullMethod, _ := grpc.MethodFromServerStream(stream)
+	service, method, ok := parseGrpcMethod(fullMethod)
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "malformed method name: %q", fullMethod)
+	}
+	var body []byte
+	if err := stream.RecvMsg(&body); err != nil {
+		return err
+	}
+	request := &mpro.Message{
+		Header:   mpro.BuildHeader(mpro.Req, g.proxy, serialize.GrpcPbNumber, endpoint.GenerateRequestID(), mpro.Normal),
+		Metadata: motan.NewStringMap(mpro.DefaultMetaSize),
+		Body:     body,
+	}
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	for k, v := range md {
+		if len(v) > 0 && !endpoint.IsGrpcReservedHeader(k) {
+			request.Metadata.Store(endpoint.GrpcMetadataKey(k), v[len(v)-1])
+		}
+	}
+	request.Metadata.Store(mpro.MPath, service)
+	request.Metadata.Store(mpro.MMethod, method)
+	if ip := getGrpcRemoteIP(stream); ip != "" {
+		request.Metadata.Store(motan.HostKey, ip)
+	}
+
+	serialization := g.extFactory.GetSerialization("", serialize.GrpcPbNumber)
+	req, err := mpro.ConvertToRequest(request, serialization)
+	if err != nil {
+		vlog.Errorf("grpc server convert to motan request fail. service: %s, method:%s, err:%s", service, method, err.Error())
+		return status.Error(codes.Internal, "convert request fail. err:"+err.Error())
+	}
+	reqCtx := req.GetRPCContext(true)
+	reqCtx.ExtFactory = g.extFactory
+	reqCtx.RequestReceiveTime = start
+	// the context of the stream is done when the client cancels the call or the call is finished
+	cancelCh := make(chan struct{})
+	go func() {
+		<-stream.Context().Done()
+		close(cancelCh)
+	}()
+	reqCtx.CancelCh = cancelCh
+	callStart := time.Now()
+	res := g.handler.Call(req)
+	if res == nil {
+		return status.Error(codes.Internal, "handler call return nil")
+	}
+	if res.GetAttachment(mpro.MProcessTime) == "" {
+		res.SetAttachment(mpro.MProcessTime, strconv.FormatInt(int64(time.Now().Sub(callStart)/1e6), 10))
+	}
+	defer res.GetRPCContext(true).OnFinish()
+	res.GetRPCContext(true).Proxy = g.proxy
+	resMsg, err := mpro.ConvertToResMessage(res, serialization)
+	if err == nil {
+		err = mpro.DecodeMessageCompress(resMsg)
+	}
+	if err != nil {
+		vlog.Errorf("grpc server convert response fail. service: %s, method:%s, err:%s", service, method, err.Error())
+		return status.Error(codes.Internal, "convert response fail. err:"+err.Error())
+	}
+	if resMsg.Header.GetStatus() == mpro.Exception {
+		errmsg := resMsg.Metadata.LoadOrEmpty(mpro.MExceptionn)
+		code := codes.Internal
+		if e := res.GetException(); e != nil {
+			errmsg = e.ErrMsg
+			if c, ok := grpcExceptionCodes[e.ErrType]; ok {
+				code = c
+			}
+		}
+		return status.Error(code, errmsg)
+	}
+	// the attachments of the response are sent as the headers
+	if resMsg.Metadata != nil && resMsg.Metadata.Len() > 0 {
+		stream.SetHeader(grpcResponseHeader(resMsg.Metadata))
+	}
+	body = resMsg.Body
+	if body == nil {
+		body = []byte{}
+	}
+	err = stream.SendMsg(body)
+	reqCtx.ResponseSendTime = time.Now()
+	return err
+}
+
+// grpcResponseHeader converts the attachments to the grpc headers. The headers used by the grpc transport and the
+// illegal headers are skipped, the values of them must be printable ASCII except the binary headers('-bin' suffix)
+func grpcResponseHeader(attachments *motan.StringMap) metadata.MD {
+	md := metadata.MD{}
+	attachments.Range(func(k, v string) bool {
+		key := strings.ToLower(k)
+		if endpoint.IsGrpcReservedHeader(key) || !isGrpcHeaderKey(key) || (!strings.HasSuffix(key, "-bin") && !isGrpcHeaderValue(v)) {
+			vlog.Warningf("grpc server skips the illegal response header: %s", k)
+			return true
+		}
+		md[key] = append(md[key], v)
+		return true
+	})
+	return md
+}
+
+func isGrpcHeaderKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func isGrpcHeaderValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// the grpc status codes of the exception types, the endpoints map them back to the same exception types
+var grpcExceptionCodes = map[int]codes.Code{
+	motan.FrameworkException: codes.Unavailable,
+	motan.ServiceException:   codes.Internal,
+	motan.BizException:       codes.Unknown,
+}
+
+// parseGrpcMethod splits the grpc path `/{service}/{method}` to the service and the method
+func parseGrpcMethod(fullMethod string) (service string, method string, ok bool) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	pos := strings.LastIndex(fullMethod, "/")
+	if pos <= 0 || pos == len(fullMethod)-1 {
+		return "", "", false
+	}
+	return fullMethod[:pos], fullMethod[pos+1:], true
+}
+
+func getGrpcRemoteIP(stream grpc.ServerStream) string {
+	p, ok := peer.FromContext(stream.Context())
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if ta, ok := p.Addr.(*net.TCPAddr); ok {
+		return ta.IP.String()
+	}
+	return getRemoteIP(p.Addr.String())
+}
+
+// rawCodec passes the messages through without serialization, the bodies are serialized by the motan serializations
+type rawCodec struct{}
+
+var errRawCodecType = errors.New("grpc raw codec only supports []byte")
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case *[]byte:
+		return *b, nil
+	}
+	return nil, errRawCodecType
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return errRawCodecType
+	}
+	// the data is reused by the transport after the call returns
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) String() string {
+	return "raw"
+}
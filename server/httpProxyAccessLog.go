@@ -0,0 +1,100 @@
+package server
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	mhttp "github.com/weibocom/motan-go/http"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+)
+
+const (
+	// HTTPProxyAccessLogFormatKey is the nginx style format of the access logs, such as `$host $request_uri $status`
+	HTTPProxyAccessLogFormatKey = "httpProxyAccessLogFormat"
+	// HTTPProxyAccessLogSampleRateKey is the sample rate in [0, 1] of the access logs, the failed requests are always logged
+	HTTPProxyAccessLogSampleRateKey = "httpProxyAccessLogSampleRate"
+	DefaultAccessLogSampleRate      = 1.0
+)
+
+// the routes of the http proxy requests
+const (
+	HTTPProxyRouteRPC     = "rpc"
+	HTTPProxyRouteDirect  = "direct"
+	HTTPProxyRouteUpgrade = "upgrade"
+	// HTTPProxyRouteReturn means the request is responded by the location rules, such as the returns and the cors
+	HTTPProxyRouteReturn = "return"
+	// HTTPProxyRouteCache means the request is responded by the response cache of the location
+	HTTPProxyRouteCache = "cache"
+)
+
+const (
+	httpProxyMetricsRole       = "motan-http-proxy"
+	httpProxyDirectLocation    = "direct"
+	httpProxyTotalCount        = ".total_count"
+	httpProxyStatusCountSuffix = "xx_count"
+)
+
+// httpAccess records the request line before the request is rewritten by the proxy
+type httpAccess struct {
+	start   time.Time
+	method  string
+	host    string
+	uri     string
+	reqSize int
+}
+
+func newHTTPAccess(ctx *fasthttp.RequestCtx) *httpAccess {
+	return &httpAccess{
+		start:   time.Now(),
+		method:  string(ctx.Method()),
+		host:    string(ctx.Request.Host()),
+		uri:     string(ctx.Request.RequestURI()),
+		reqSize: len(ctx.Request.Body()),
+	}
+}
+
+// logAccess writes the access log and the metrics of the proxied request, the metrics are grouped by the configured
+// domain and the location, the location of the rpc requests is the service, the direct requests are in the `direct`
+// location. The requests of the unknown hosts are in the `direct` domain, so the clients can not create metrics freely
+func (s *HTTPProxyServer) logAccess(ctx *fasthttp.RequestCtx, access *httpAccess, route string, upstream string, domain string, location string) {
+	totalTime := time.Now().Sub(access.start).Nanoseconds() / 1e6
+	status := ctx.Response.StatusCode()
+	group := metrics.Escape(domain)
+	service := metrics.Escape(location)
+	key := httpProxyMetricsRole + ":" + route
+	metrics.AddCounter(group, service, key+httpProxyTotalCount, 1)
+	metrics.AddCounter(group, service, key+"."+strconv.Itoa(status/100)+httpProxyStatusCountSuffix, 1)
+	metrics.AddCounter(group, service, key+metrics.ElapseTimeSuffix(totalTime), 1)
+	metrics.AddHistograms(group, service, key, totalTime)
+
+	if status < fasthttp.StatusInternalServerError && s.accessLogSampleRate < 1 && rand.Float64() >= s.accessLogSampleRate {
+		return
+	}
+	vlog.AccessLog(&vlog.AccessLogEntity{
+		FilterName: "httpProxy",
+		HTTP: &vlog.HTTPAccessLogEntity{
+			RemoteAddress: ctx.RemoteAddr().String(),
+			Method:        access.method,
+			Host:          access.host,
+			URI:           access.uri,
+			Status:        status,
+			ReqSize:       access.reqSize,
+			ResSize:       len(ctx.Response.Body()),
+			Upstream:      upstream,
+			Route:         route,
+			TotalTime:     totalTime,
+			Format:        s.accessLogFormat,
+		},
+	})
+}
+
+// locationName returns the service of the location for the metrics, the locations without upstream are direct
+func locationName(location *mhttp.ProxyLocation) string {
+	if location.Upstream == "" {
+		return httpProxyDirectLocation
+	}
+	return location.Upstream
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// http proxy server types
+const (
+	HTTPProxyServerFastHTTP = "fasthttp"
+	// HTTPProxyServerHTTP2 serves h2c and HTTP/1.1 on the plain listener, h2 is negotiated by ALPN if tls is enabled
+	HTTPProxyServerHTTP2 = "http2"
+)
+
+// the hop-by-hop headers can not be forwarded, and they are forbidden in HTTP/2
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Proxy-Connection":    true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Http2-Settings":      true,
+	"Content-Length":      true,
+}
+
+// serveHTTP2 serves the requests by net/http with HTTP/2 support, the requests are converted to fasthttp requests and
+// handled by the same handler of the fasthttp server, so the routing and the rpc proxy are the same
+func (s *HTTPProxyServer) serveHTTP2(listener net.Listener, block bool) error {
+	h2Server := &http2.Server{IdleTimeout: s.url.GetTimeDuration(HTTPProxyKeepaliveTimeoutKey, time.Millisecond, DefaultKeepaliveTimeout)}
+	s.h2Server = &http.Server{Handler: http.HandlerFunc(s.serveHTTP)}
+	if core.IsTLSEnabled(s.url) {
+		tlsConfig, err := core.BuildServerTLSConfig(s.url)
+		if err != nil {
+			vlog.Errorf("build tls config fail. url:%s, err: %v", s.url.GetIdentity(), err)
+			listener.Close()
+			return err
+		}
+		tlsConfig.NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+		s.h2Server.TLSConfig = tlsConfig
+		if err = http2.ConfigureServer(s.h2Server, h2Server); err != nil {
+			listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	} else {
+		s.h2Server.Handler = h2c.NewHandler(s.h2Server.Handler, h2Server)
+	}
+	vlog.Infof("http2 proxy server is started. addr:%s, tls:%t", listener.Addr().String(), s.h2Server.TLSConfig != nil)
+	if block {
+		return s.h2Server.Serve(listener)
+	}
+	go s.h2Server.Serve(listener)
+	return nil
+}
+
+func (s *HTTPProxyServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		s.serveConnect(w, r)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(s.httpServer.MaxRequestBodySize)+1))
+	if err != nil {
+		http.Error(w, "read request body fail: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > s.httpServer.MaxRequestBodySize {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.SetMethod(r.Method)
+	req.SetRequestURI(r.RequestURI)
+	// the upgrade requests of HTTP/1.1 such as websocket keep the upgrade headers
+	upgrade := r.ProtoMajor == 1 && r.Header.Get("Upgrade") != ""
+	for k, vs := range r.Header {
+		if hopByHopHeaders[k] && !(upgrade && (k == "Connection" || k == "Upgrade")) {
+			continue
+		}
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.SetHost(r.Host)
+	req.SetBody(body)
+
+	ctx := &fasthttp.RequestCtx{}
+	var remoteAddr net.Addr
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		remoteAddr = addr
+	}
+	ctx.Init(req, remoteAddr, nil)
+	s.httpHandler(ctx)
+	if upgraded, ok := ctx.UserValue(upgradeConnKey).(*upgradedConn); ok {
+		s.serveUpgraded(w, &ctx.Response, upgraded)
+		return
+	}
+
+	header := w.Header()
+	ctx.Response.Header.VisitAll(func(key, value []byte) {
+		k := http.CanonicalHeaderKey(string(key))
+		if !hopByHopHeaders[k] {
+			header.Add(k, string(value))
+		}
+	})
+	w.WriteHeader(ctx.Response.StatusCode())
+	if r.Method != http.MethodHead {
+		w.Write(ctx.Response.Body())
+	}
+}
+
+// serveUpgraded hijacks the HTTP/1.1 connection, and pipes it with the backend connection which has switched the protocol
+func (s *HTTPProxyServer) serveUpgraded(w http.ResponseWriter, res *fasthttp.Response, upgraded *upgradedConn) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upgraded.conn.Close()
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		upgraded.conn.Close()
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if _, err = conn.Write(responseHeaderBytes(res)); err != nil {
+		conn.Close()
+		upgraded.conn.Close()
+		return
+	}
+	s.pipeUpgraded(conn, buf, upgraded)
+}
+
+// serveConnect tunnels the https proxy requests. HTTP/1.1 connections are hijacked, HTTP/2 streams are used as tunnels
+func (s *HTTPProxyServer) serveConnect(w http.ResponseWriter, r *http.Request) {
+	backendConn, err := net.DialTimeout("tcp", r.Host, s.proxyTimeout)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer backendConn.Close()
+	var src io.Reader
+	var dst io.Writer
+	var clientCloser io.Closer
+	if hijacker, ok := w.(http.Hijacker); ok && r.ProtoMajor == 1 {
+		conn, buf, err := hijacker.Hijack()
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+		src, dst, clientCloser = buf, conn, conn
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		src, dst, clientCloser = r.Body, &flushWriter{w: w}, r.Body
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		io.Copy(backendConn, src)
+		if c, ok := backendConn.(*net.TCPConn); ok {
+			c.CloseWrite()
+		}
+	}()
+	io.Copy(dst, backendConn)
+	// the backend is closed, stop reading from the client
+	clientCloser.Close()
+	wg.Wait()
+}
+
+// flushWriter flushes every write, so the tunneled data over HTTP/2 streams is not buffered
+type flushWriter struct {
+	w io.Writer
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+func isHTTP2ProxyServer(url *core.URL) bool {
+	return strings.EqualFold(url.GetParam(HTTPProxyServerTypeKey, HTTPProxyServerFastHTTP), HTTPProxyServerHTTP2)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/weibocom/motan-go/core"
+)
+
+// RPCMethodSchema describes the types of the arguments and the reply of a method, the json arguments of /proxy_to_rpc
+// are converted to these types before the call, and the reply is deserialized to the reply type.
+// The protobuf messages are converted by jsonpb, so the services using grpc-pb serialization can be called by json
+type RPCMethodSchema struct {
+	ArgTypes  []reflect.Type
+	ReplyType reflect.Type
+}
+
+var (
+	rpcSchemas     = make(map[string]*RPCMethodSchema)
+	rpcSchemasLock sync.RWMutex
+
+	errArgumentCount = errors.New("argument count not match the schema")
+)
+
+// RegisterRPCMethodSchema registers the schema of a method for the json to rpc gateway
+func RegisterRPCMethodSchema(service string, method string, schema *RPCMethodSchema) {
+	rpcSchemasLock.Lock()
+	defer rpcSchemasLock.Unlock()
+	rpcSchemas[service+"/"+core.FirstUpper(method)] = schema
+}
+
+// RegisterRPCSchema registers the schemas of all exported methods of a service definition. The definition is an
+// implement of the service or a nil pointer of the service interface, such as: (*HelloService)(nil)
+func RegisterRPCSchema(service string, definition interface{}) {
+	t := reflect.TypeOf(definition)
+	// the methods of interface types have no receiver
+	receiver := 1
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Interface {
+		t = t.Elem()
+		receiver = 0
+	}
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		schema := &RPCMethodSchema{}
+		for j := receiver; j < m.Type.NumIn(); j++ {
+			schema.ArgTypes = append(schema.ArgTypes, m.Type.In(j))
+		}
+		if m.Type.NumOut() > 0 {
+			schema.ReplyType = m.Type.Out(0)
+		}
+		RegisterRPCMethodSchema(service, m.Name, schema)
+	}
+}
+
+func getRPCMethodSchema(service string, method string) *RPCMethodSchema {
+	rpcSchemasLock.RLock()
+	defer rpcSchemasLock.RUnlock()
+	return rpcSchemas[service+"/"+core.FirstUpper(method)]
+}
+
+// decodeJSONArguments converts the json argument list to the motan arguments. Without schema the numbers are
+// converted to int64 or float64, the objects and arrays are converted to maps and slices
+func decodeJSONArguments(body []byte, schema *RPCMethodSchema) ([]interface{}, error) {
+	var raws []json.RawMessage
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &raws); err != nil {
+			return nil, err
+		}
+	}
+	args := make([]interface{}, 0, len(raws))
+	if schema != nil {
+		if len(raws) != len(schema.ArgTypes) {
+			return nil, errArgumentCount
+		}
+		for i, raw := range raws {
+			arg, err := decodeJSONValue(raw, schema.ArgTypes[i])
+			if err != nil {
+				return nil, fmt.Errorf("decode argument %d fail: %v", i, err)
+			}
+			args = append(args, arg)
+		}
+		return args, nil
+	}
+	for _, raw := range raws {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		var v interface{}
+		if err := decoder.Decode(&v); err != nil {
+			return nil, err
+		}
+		args = append(args, normalizeJSONNumber(v))
+	}
+	return args, nil
+}
+
+func decodeJSONValue(raw json.RawMessage, t reflect.Type) (interface{}, error) {
+	if t.Kind() == reflect.Ptr {
+		v := reflect.New(t.Elem())
+		if message, ok := v.Interface().(proto.Message); ok {
+			return message, jsonpb.Unmarshal(bytes.NewReader(raw), message)
+		}
+		return v.Interface(), json.Unmarshal(raw, v.Interface())
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal(raw, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+func normalizeJSONNumber(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = normalizeJSONNumber(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = normalizeJSONNumber(item)
+		}
+	}
+	return v
+}
+
+// newReplyValue creates the value to deserialize the reply, the pointer types are created as the pointed values
+func newReplyValue(schema *RPCMethodSchema) interface{} {
+	if schema == nil || schema.ReplyType == nil {
+		return nil
+	}
+	if schema.ReplyType.Kind() == reflect.Ptr {
+		return reflect.New(schema.ReplyType.Elem()).Interface()
+	}
+	return reflect.New(schema.ReplyType).Interface()
+}
+
+// encodeJSONReply renders the reply as json, the maps with non-string keys are converted to maps with string keys
+func encodeJSONReply(v interface{}) ([]byte, error) {
+	if rv, ok := v.(reflect.Value); ok {
+		v = rv.Interface()
+	}
+	if message, ok := v.(proto.Message); ok {
+		buf := &bytes.Buffer{}
+		err := (&jsonpb.Marshaler{OrigName: true}).Marshal(buf, message)
+		return buf.Bytes(), err
+	}
+	return json.Marshal(jsonCompatible(v))
+}
+
+func jsonCompatible(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[fmt.Sprint(k)] = jsonCompatible(item)
+		}
+		return m
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = jsonCompatible(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = jsonCompatible(item)
+		}
+	}
+	return v
+}
+
+// rpcExceptionStatus maps the exceptions of rpc calls to the http status codes by the error causes, codes and types
+func rpcExceptionStatus(response core.Response) int {
+	switch core.GetErrorCause(response) {
+	case core.ErrorCauseNoCluster:
+		return http.StatusNotFound
+	case core.ErrorCauseTimeout:
+		return http.StatusGatewayTimeout
+	}
+	e := response.GetException()
+	switch {
+	case e.ErrCode == core.ENoEndpoints || e.ErrCode == core.ENoChannel:
+		return http.StatusServiceUnavailable
+	case e.ErrType == core.BizException:
+		return http.StatusInternalServerError
+	case e.ErrType == core.FrameworkException:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
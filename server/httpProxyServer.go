@@ -3,6 +3,7 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math"
@@ -37,6 +38,7 @@ const (
 	HTTPProxyTimeoutKey            = "httpProxyTimeout"
 	HTTPProxyMaxRequestBodySizeKey = "httpProxyMaxRequestBodySize"
 	HTTPProxyEnableKey             = "httpProxyEnable"
+	HTTPProxyServerTypeKey         = "httpProxyServerType"
 )
 
 type HTTPClusterGetter interface {
@@ -49,10 +51,17 @@ type HTTPProxyServer struct {
 	messageHandler core.MessageHandler
 	httpHandler    fasthttp.RequestHandler
 	httpServer     *fasthttp.Server
+	h2Server       *http.Server
 	httpClient     *fasthttp.Client
 	deny           []string
 	keepalive      bool
 	defaultDomain  string
+	proxyTimeout   time.Duration
+	// the access logs of the proxied requests
+	accessLogFormat     *vlog.HTTPAccessLogFormat
+	accessLogSampleRate float64
+	// the upgraded connections are closed if no data is transferred in the timeout
+	upgradeIdleTimeout time.Duration
 }
 
 func NewHTTPProxyServer(url *core.URL) *HTTPProxyServer {
@@ -70,6 +79,13 @@ func (s *HTTPProxyServer) Open(block bool, proxy bool, clusterGetter HTTPCluster
 	s.deny = append(s.deny, "localhost:"+s.url.GetPortStr())
 	s.deny = append(s.deny, core.GetLocalIP()+":"+s.url.GetPortStr())
 	proxyTimeout := s.url.GetTimeDuration(HTTPProxyTimeoutKey, time.Millisecond, DefaultTimeout)
+	s.proxyTimeout = proxyTimeout
+	s.upgradeIdleTimeout = s.url.GetTimeDuration(HTTPProxyUpgradeIdleTimeoutKey, time.Millisecond, DefaultUpgradeIdleTimeout)
+	s.accessLogFormat = vlog.ParseHTTPAccessLogFormat(s.url.GetParam(HTTPProxyAccessLogFormatKey, vlog.DefaultHTTPAccessLogFormat))
+	s.accessLogSampleRate = DefaultAccessLogSampleRate
+	if rate, err := strconv.ParseFloat(s.url.GetParam(HTTPProxyAccessLogSampleRateKey, ""), 64); err == nil {
+		s.accessLogSampleRate = rate
+	}
 	keepaliveTimeout := s.url.GetTimeDuration(HTTPProxyKeepaliveTimeoutKey, time.Millisecond, DefaultKeepaliveTimeout)
 	s.httpClient = &fasthttp.Client{
 		Name: "motan",
@@ -124,6 +140,7 @@ func (s *HTTPProxyServer) Open(block bool, proxy bool, clusterGetter HTTPCluster
 			return
 		}
 
+		access := newHTTPAccess(ctx)
 		hostAndPort := string(httpReq.Header.Host())
 		if strings.Index(hostAndPort, ":") == -1 {
 			hostAndPort += ":80"
@@ -136,9 +153,65 @@ func (s *HTTPProxyServer) Open(block bool, proxy bool, clusterGetter HTTPCluster
 			hostAndPort = s.defaultDomain + ":80"
 			httpCluster = s.clusterGetter.GetHTTPCluster(s.defaultDomain)
 		}
+		// the metrics are grouped by the configured domain, the requests of unknown hosts are in the direct group
+		domain := httpProxyDirectLocation
+		if httpCluster != nil {
+			domain = httpCluster.GetURL().GetParam(mhttp.DomainKey, domain)
+		}
+		upgrade := isUpgradeRequest(&httpReq.Header)
+		// the location rules are applied by the proxy server, the upgrade requests are tunneled without the rules
+		var location *mhttp.ProxyLocation
+		var variables *mhttp.RequestVariables
+		if httpCluster != nil && !upgrade {
+			if location = httpCluster.Locate(string(ctx.Path())); location != nil && location.HasRules() {
+				variables = newRequestVariables(ctx)
+				if location.Respond(variables, &ctx.Response) {
+					ctx.Response.Header.SetServer(HTTPProxyServerName)
+					s.logAccess(ctx, access, HTTPProxyRouteReturn, "", domain, locationName(location))
+					return
+				}
+				location.RewriteRequest(variables)
+			}
+		}
 		if httpCluster != nil {
 			if service, ok := httpCluster.CanServe(string(ctx.Path())); ok {
-				s.doHTTPRpcProxy(ctx, httpCluster, service)
+				if upgrade {
+					// the upgraded connections can not be proxied by rpc, they are tunneled to the http port of the endpoint
+					addr, err := httpCluster.SelectUpgradeAddress(&core.MotanRequest{ServiceName: service, Method: string(ctx.Path())})
+					if err != nil {
+						vlog.Errorf("Upgrade request %s of service %s failed: %s", string(requestPath), service, err.Error())
+						ctx.Response.Header.SetServer(HTTPProxyServerName)
+						ctx.Response.SetStatusCode(fasthttp.StatusBadGateway)
+						return
+					}
+					s.doUpgradeProxy(ctx, addr)
+					s.logAccess(ctx, access, HTTPProxyRouteUpgrade, addr, domain, service)
+					return
+				}
+				route, upstream := HTTPProxyRouteRPC, service
+				if cache := responseCache(location, variables); cache != nil {
+					status, _ := cache.Do(&ctx.Request, &ctx.Response, func(req *fasthttp.Request, res *fasthttp.Response) error {
+						fetchRoute, fetchUpstream := s.doHTTPRpcProxy(req, res, httpCluster, service, true)
+						// the stale responses are revalidated with the copied requests in the background
+						if req == &ctx.Request {
+							route, upstream = fetchRoute, fetchUpstream
+						}
+						return nil
+					})
+					if status == mhttp.CacheStatusHit || status == mhttp.CacheStatusStale {
+						route, upstream = HTTPProxyRouteCache, service
+					}
+				} else {
+					route, upstream = s.doHTTPRpcProxy(&ctx.Request, &ctx.Response, httpCluster, service, variables != nil)
+				}
+				if variables != nil {
+					location.RewriteResponse(variables, &ctx.Response)
+				}
+				if route == HTTPProxyRouteDirect {
+					s.logAccess(ctx, access, route, upstream, domain, httpProxyDirectLocation)
+				} else {
+					s.logAccess(ctx, access, route, upstream, domain, service)
+				}
 				return
 			}
 		}
@@ -152,7 +225,16 @@ func (s *HTTPProxyServer) Open(block bool, proxy bool, clusterGetter HTTPCluster
 			}
 		}
 		// TODO: if the request is form this server itself, we should reject it
-		s.doHTTPProxy(ctx)
+		if upgrade {
+			s.doUpgradeProxy(ctx, hostAndPort)
+			s.logAccess(ctx, access, HTTPProxyRouteUpgrade, hostAndPort, domain, httpProxyDirectLocation)
+			return
+		}
+		s.doHTTPProxy(&ctx.Request, &ctx.Response)
+		if variables != nil {
+			location.RewriteResponse(variables, &ctx.Response)
+		}
+		s.logAccess(ctx, access, HTTPProxyRouteDirect, hostAndPort, domain, httpProxyDirectLocation)
 	}
 
 	maxRequestBodySize := s.url.GetPositiveIntValue(HTTPProxyMaxRequestBodySizeKey, DefaultMaxRequestBodySize)
@@ -175,6 +257,9 @@ func (s *HTTPProxyServer) Open(block bool, proxy bool, clusterGetter HTTPCluster
 			vlog.Errorf("listenUnixSock fail. err:%v", err)
 			return err
 		}
+		if isHTTP2ProxyServer(s.url) {
+			return s.serveHTTP2(listener, block)
+		}
 
 		if block {
 			s.httpServer.Serve(listener)
@@ -186,6 +271,14 @@ func (s *HTTPProxyServer) Open(block bool, proxy bool, clusterGetter HTTPCluster
 		return nil
 	}
 
+	if isHTTP2ProxyServer(s.url) {
+		listener, err := net.Listen("tcp", s.url.Host+":"+s.url.GetPortStr())
+		if err != nil {
+			vlog.Errorf("listen http proxy port fail. err:%v", err)
+			return err
+		}
+		return s.serveHTTP2(listener, block)
+	}
 	if block {
 		s.httpServer.ListenAndServe(s.url.Host + ":" + s.url.GetPortStr())
 	} else {
@@ -209,6 +302,9 @@ func (s *HTTPProxyServer) GetName() string {
 }
 
 func (s *HTTPProxyServer) Destroy() {
+	if s.h2Server != nil {
+		s.h2Server.Close()
+	}
 }
 
 func (s *HTTPProxyServer) GetHTTPClient() *fasthttp.Client {
@@ -227,13 +323,29 @@ func (s *HTTPProxyServer) doRPC(ctx *fasthttp.RequestCtx) {
 	}
 	group := string(ctx.FormValue("group"))
 	argType := string(ctx.FormValue("arg_type"))
-	argStr := string(ctx.FormValue("arg"))
-	if argType != "string" {
+	if argType == "" && bytes.HasPrefix(request.Header.ContentType(), []byte("application/json")) {
+		argType = "json"
+	}
+	var motanArgs []interface{}
+	var schema *RPCMethodSchema
+	switch argType {
+	case "string":
+		motanArgs = []interface{}{string(ctx.FormValue("arg"))}
+	case "json":
+		// the body is the json list of the arguments, they are converted to the types of the schema if registered
+		schema = getRPCMethodSchema(service, method)
+		args, err := decodeJSONArguments(request.Body(), schema)
+		if err != nil {
+			ctx.SetStatusCode(http.StatusBadRequest)
+			ctx.SetBodyString("decode json arguments fail: " + err.Error())
+			return
+		}
+		motanArgs = args
+	default:
 		ctx.SetStatusCode(http.StatusBadRequest)
 		ctx.SetBodyString(fmt.Sprintf("arg_type %s not support now", argType))
 		return
 	}
-	motanArgs := []interface{}{argStr}
 	motanRequest := &core.MotanRequest{
 		RequestID:   endpoint.GenerateRequestID(),
 		ServiceName: service,
@@ -253,10 +365,36 @@ func (s *HTTPProxyServer) doRPC(ctx *fasthttp.RequestCtx) {
 	motanResponse := s.messageHandler.Call(motanRequest)
 	responseException := motanResponse.GetException()
 	if responseException != nil {
-		ctx.SetStatusCode(http.StatusBadGateway)
+		if argType == "json" {
+			body, _ := json.Marshal(responseException)
+			ctx.SetStatusCode(rpcExceptionStatus(motanResponse))
+			response.Header.Set("Content-Type", "application/json")
+			ctx.SetBody(body)
+			return
+		}
+		ctx.SetStatusCode(rpcExceptionStatus(motanResponse))
 		ctx.SetBodyString(responseException.ErrMsg)
 		return
 	}
+	motanResponse.GetAttachments().Range(func(k, v string) bool {
+		response.Header.Set(k, v)
+		return true
+	})
+	if argType == "json" {
+		err := motanResponse.ProcessDeserializable(newReplyValue(schema))
+		var body []byte
+		if err == nil {
+			body, err = encodeJSONReply(motanResponse.GetValue())
+		}
+		if err != nil {
+			ctx.SetStatusCode(http.StatusBadGateway)
+			ctx.SetBodyString("get response body failed: " + err.Error())
+			return
+		}
+		response.Header.Set("Content-Type", "application/json")
+		ctx.SetBody(body)
+		return
+	}
 	var responseBody []byte
 	err := motanResponse.ProcessDeserializable(&responseBody)
 	if err != nil {
@@ -264,19 +402,12 @@ func (s *HTTPProxyServer) doRPC(ctx *fasthttp.RequestCtx) {
 		ctx.SetBodyString("get response body failed: " + err.Error())
 		return
 	}
-	motanResponse.GetAttachments().Range(func(k, v string) bool {
-		response.Header.Set(k, v)
-		return true
-	})
 	response.Header.Set("Content-Type", "text/plain")
 	ctx.SetBody(motanResponse.GetValue().([]byte))
 	return
 }
 
-func (s *HTTPProxyServer) doHTTPProxy(ctx *fasthttp.RequestCtx) {
-	httpReq := &ctx.Request
-	httpRes := &ctx.Response
-	start := time.Now()
+func (s *HTTPProxyServer) doHTTPProxy(httpReq *fasthttp.Request, httpRes *fasthttp.Response) {
 	if s.keepalive {
 		httpReq.Header.Del("Connection")
 	}
@@ -289,56 +420,87 @@ func (s *HTTPProxyServer) doHTTPProxy(ctx *fasthttp.RequestCtx) {
 		httpRes.Header.SetServer(HTTPProxyServerName)
 		httpRes.Header.SetStatusCode(fasthttp.StatusBadGateway)
 	}
-	// TODO: configurable
-	vlog.Infof("http-proxy %s %s %d %d", httpReq.Host(), httpReq.RequestURI(), httpRes.Header.StatusCode(), time.Now().Sub(start)/1e6)
 }
 
-func (s *HTTPProxyServer) doHTTPRpcProxy(ctx *fasthttp.RequestCtx, httpCluster *cluster.HTTPCluster, service string) {
+// responseCache returns the response cache if the location rules are applied by the proxy server
+func responseCache(location *mhttp.ProxyLocation, variables *mhttp.RequestVariables) *mhttp.ResponseCache {
+	if variables == nil {
+		return nil
+	}
+	return location.ResponseCache()
+}
+
+func newRequestVariables(ctx *fasthttp.RequestCtx) *mhttp.RequestVariables {
+	requestID := string(ctx.Request.Header.Peek("X-Request-Id"))
+	if requestID == "" {
+		requestID = strconv.FormatUint(endpoint.GenerateRequestID(), 10)
+	}
+	return &mhttp.RequestVariables{
+		Request:   &ctx.Request,
+		RemoteIP:  ctx.RemoteIP().String(),
+		RequestID: requestID,
+	}
+}
+
+// doHTTPRpcProxy proxies the request to the motan service, it returns the route and the upstream address of the
+// request. The route is direct if the service has no endpoints and the request is proxied by http directly
+func (s *HTTPProxyServer) doHTTPRpcProxy(httpReq *fasthttp.Request, httpRes *fasthttp.Response, httpCluster *cluster.HTTPCluster, service string, rulesApplied bool) (string, string) {
 	motanRequest := &core.MotanRequest{}
 	motanRequest.ServiceName = service
-	motanRequest.Method = string(ctx.Path())
+	motanRequest.Method = string(httpReq.URI().Path())
 	motanRequest.SetAttachment(mhttp.Proxy, "true")
 	motanRequest.SetAttachment(protocol.MPath, service)
+	if rulesApplied {
+		// the provider should not apply the location rules again
+		motanRequest.SetAttachment(mhttp.LocationRulesApplied, "true")
+	}
 
 	headerBuffer := &bytes.Buffer{}
 	// server do the url rewrite
-	requestURI := ctx.Request.URI()
-	ctx.Request.SetRequestURIBytes(requestURI.RequestURI())
-	ctx.Request.Header.WriteTo(headerBuffer)
+	requestURI := httpReq.URI()
+	httpReq.SetRequestURIBytes(requestURI.RequestURI())
+	httpReq.Header.WriteTo(headerBuffer)
 	headerBytes := headerBuffer.Bytes()
 	// no need to copy body, because we hold it until request finished
-	bodyBytes := ctx.Request.Body()
+	bodyBytes := httpReq.Body()
 	motanRequest.SetArguments([]interface{}{headerBytes, bodyBytes})
 	var reply []interface{}
 	motanRequest.GetRPCContext(true).Reply = &reply
 	motanResponse := httpCluster.Call(motanRequest)
+	upstream := service
+	if resCtx := motanResponse.GetRPCContext(false); resCtx != nil && resCtx.Meta != nil {
+		if addr := resCtx.Meta.LoadOrEmpty(core.MetaUpstreamAddress); addr != "" {
+			upstream = addr
+		}
+	}
 	// exception is a motan internal exception
 	if exception := motanResponse.GetException(); exception != nil {
 		if exception.ErrCode == core.ENoEndpoints {
 			vlog.Warningf("Http rpc proxy to [%s, %s] has no endpoints, try http proxy", string(requestURI.Path()), service)
-			s.doHTTPProxy(ctx)
-			return
+			s.doHTTPProxy(httpReq, httpRes)
+			return HTTPProxyRouteDirect, string(httpReq.Host())
 		}
 		vlog.Errorf("Http rpc proxy call failed: %s", exception.ErrMsg)
-		ctx.Response.Header.SetServer(HTTPProxyServerName)
-		ctx.Response.SetStatusCode(fasthttp.StatusBadGateway)
-		ctx.Response.SetBodyString("err_msg: " + exception.ErrMsg)
-		return
+		httpRes.Header.SetServer(HTTPProxyServerName)
+		httpRes.SetStatusCode(fasthttp.StatusBadGateway)
+		httpRes.SetBodyString("err_msg: " + exception.ErrMsg)
+		return HTTPProxyRouteRPC, upstream
 	}
 	// we need process deserialize here, maybe the httpCluster should initialize without proxy as a normal client
 	// and the serialization proceed by cluster itself
 	err := motanResponse.ProcessDeserializable(&reply)
 	if err != nil {
 		vlog.Errorf("Deserialize rpc response failed: %s", err.Error())
-		ctx.Response.Header.SetServer(HTTPProxyServerName)
-		ctx.Response.SetStatusCode(fasthttp.StatusBadGateway)
-		ctx.Response.SetBodyString("err_msg: " + err.Error())
-		return
+		httpRes.Header.SetServer(HTTPProxyServerName)
+		httpRes.SetStatusCode(fasthttp.StatusBadGateway)
+		httpRes.SetBodyString("err_msg: " + err.Error())
+		return HTTPProxyRouteRPC, upstream
 	}
 	if reply[0] != nil {
-		ctx.Response.Header.Read(bufio.NewReader(bytes.NewReader(reply[0].([]byte))))
+		httpRes.Header.Read(bufio.NewReader(bytes.NewReader(reply[0].([]byte))))
 	}
 	if reply[1] != nil {
-		ctx.Response.BodyWriter().Write(reply[1].([]byte))
+		httpRes.BodyWriter().Write(reply[1].([]byte))
 	}
+	return HTTPProxyRouteRPC, upstream
 }
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+)
+
+const (
+	HTTPProxyUpgradeIdleTimeoutKey = "httpProxyUpgradeIdleTimeout"
+	DefaultUpgradeIdleTimeout      = 10 * time.Minute
+	upgradeConnKey                 = "httpProxyUpgradeConn"
+)
+
+var (
+	upgradeConnections     int64
+	upgradeConnectionsOnce sync.Once
+)
+
+// upgradedConn is the backend connection which has switched the protocol, the reader may have buffered data
+type upgradedConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func isUpgradeRequest(header *fasthttp.RequestHeader) bool {
+	return header.ConnectionUpgrade() && len(header.Peek("Upgrade")) > 0
+}
+
+// doUpgradeProxy sends the upgrade request such as websocket to the backend, and pipes the connections in both
+// directions after the backend switches the protocol. The response is returned as a normal response if the backend
+// does not switch the protocol
+func (s *HTTPProxyServer) doUpgradeProxy(ctx *fasthttp.RequestCtx, addr string) {
+	upgradeConnectionsOnce.Do(func() {
+		metrics.RegisterStatusSampleFunc("motan_http_proxy_upgrade_connection_count", func() int64 {
+			return atomic.LoadInt64(&upgradeConnections)
+		})
+	})
+	httpReq := &ctx.Request
+	httpRes := &ctx.Response
+	backendConn, err := net.DialTimeout("tcp", addr, s.proxyTimeout)
+	if err != nil {
+		vlog.Errorf("Upgrade request %s to %s failed: %s", string(httpReq.RequestURI()), addr, err.Error())
+		httpRes.Header.SetServer(HTTPProxyServerName)
+		httpRes.SetStatusCode(fasthttp.StatusBadGateway)
+		return
+	}
+	// send the request in origin form, the absolute form is only for proxies
+	httpReq.SetRequestURIBytes(httpReq.URI().RequestURI())
+	httpReq.Header.Del("Proxy-Connection")
+	backendConn.SetDeadline(time.Now().Add(s.proxyTimeout))
+	w := bufio.NewWriter(backendConn)
+	reader := bufio.NewReader(backendConn)
+	if err = httpReq.Write(w); err == nil {
+		if err = w.Flush(); err == nil {
+			err = httpRes.Read(reader)
+		}
+	}
+	if err != nil {
+		backendConn.Close()
+		vlog.Errorf("Upgrade request %s to %s failed: %s", string(httpReq.RequestURI()), addr, err.Error())
+		httpRes.Reset()
+		httpRes.Header.SetServer(HTTPProxyServerName)
+		httpRes.SetStatusCode(fasthttp.StatusBadGateway)
+		return
+	}
+	if httpRes.StatusCode() != fasthttp.StatusSwitchingProtocols {
+		backendConn.Close()
+		return
+	}
+	backendConn.SetDeadline(time.Time{})
+	upgraded := &upgradedConn{conn: backendConn, reader: reader}
+	// the net/http server takes the connection from the user value, the fasthttp server hijacks the connection
+	ctx.SetUserValue(upgradeConnKey, upgraded)
+	ctx.Hijack(func(c net.Conn) {
+		s.pipeUpgraded(c, c, upgraded)
+	})
+}
+
+// pipeUpgraded copies the data in both directions until any side is closed or the connection is idle for a while
+func (s *HTTPProxyServer) pipeUpgraded(client net.Conn, clientReader io.Reader, backend *upgradedConn) {
+	atomic.AddInt64(&upgradeConnections, 1)
+	defer atomic.AddInt64(&upgradeConnections, -1)
+	closeOnce := sync.Once{}
+	closeAll := func() {
+		closeOnce.Do(func() {
+			client.Close()
+			backend.conn.Close()
+		})
+	}
+	defer closeAll()
+	idleTimer := time.AfterFunc(s.upgradeIdleTimeout, closeAll)
+	defer idleTimer.Stop()
+	transport := func(dst io.Writer, src io.Reader) {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				idleTimer.Reset(s.upgradeIdleTimeout)
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		transport(backend.conn, clientReader)
+		closeAll()
+	}()
+	transport(client, backend.reader)
+	closeAll()
+	wg.Wait()
+}
+
+// responseHeaderBytes returns the header of the switching protocols response for the hijacked net/http connections
+func responseHeaderBytes(res *fasthttp.Response) []byte {
+	buf := &bytes.Buffer{}
+	w := bufio.NewWriter(buf)
+	res.Header.Write(w)
+	w.Flush()
+	return buf.Bytes()
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// serverStreams keeps the processing streams in a connection
+type serverStreams struct {
+	lock    sync.Mutex
+	streams map[uint64]*serverStream
+}
+
+func (s *serverStreams) add(stream *serverStream) {
+	s.lock.Lock()
+	s.streams[stream.requestID] = stream
+	s.lock.Unlock()
+}
+
+func (s *serverStreams) get(requestID uint64) *serverStream {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.streams[requestID]
+}
+
+func (s *serverStreams) remove(requestID uint64) {
+	s.lock.Lock()
+	delete(s.streams, requestID)
+	s.lock.Unlock()
+}
+
+// closeAll is called when the connection is closed, the streams return the err after they are canceled
+func (s *serverStreams) closeAll(err error) {
+	s.lock.Lock()
+	streams := s.streams
+	s.streams = make(map[uint64]*serverStream)
+	s.lock.Unlock()
+	for _, stream := range streams {
+		stream.setErr(err)
+	}
+}
+
+// serverStream implements motan.ServerStream, the frames are written to the connection directly like the unary responses
+type serverStream struct {
+	conn          net.Conn
+	requestID     uint64
+	serialization motan.Serialization
+	recvCh        chan *mpro.Message
+	recvClosed    bool
+	sendWindow    *mpro.SendWindow
+	recvWindow    *mpro.RecvWindow
+	done          chan struct{}
+	canceler      *requestCanceler
+	errLock       sync.Mutex
+	err           error
+	// the received frames are pooled messages, they are released after handled
+	pooled bool
+}
+
+func newServerStream(conn net.Conn, open *mpro.Message, serialization motan.Serialization, canceler *requestCanceler, pooled bool) *serverStream {
+	window := mpro.GetStreamWindow(open)
+	return &serverStream{
+		conn:          conn,
+		requestID:     open.Header.RequestID,
+		serialization: serialization,
+		recvCh:        make(chan *mpro.Message, window+1),
+		sendWindow:    mpro.NewSendWindow(window),
+		recvWindow:    mpro.NewRecvWindow(window),
+		done:          canceler.register(open.Header.RequestID),
+		canceler:      canceler,
+		pooled:        pooled,
+	}
+}
+
+func (s *serverStream) Send(v interface{}) error {
+	if s.serialization == nil {
+		return mpro.ErrSerializeNil
+	}
+	body, err := s.serialization.Serialize(v)
+	if err != nil {
+		return err
+	}
+	if err = s.sendWindow.Acquire(s.done); err != nil {
+		return s.closedErr(err)
+	}
+	return s.write(mpro.BuildStreamFrame(s.requestID, mpro.Res, s.serialization.GetSerialNum(), body))
+}
+
+func (s *serverStream) Recv(v interface{}) error {
+	if s.recvClosed {
+		return io.EOF
+	}
+	select {
+	case msg := <-s.recvCh:
+		defer s.release(msg)
+		if msg.Header.IsEndOfStream() {
+			s.recvClosed = true
+			return io.EOF
+		}
+		if s.serialization == nil {
+			return mpro.ErrSerializeNil
+		}
+		if _, err := s.serialization.DeSerialize(msg.Body, v); err != nil {
+			return err
+		}
+		if increment := s.recvWindow.Consume(); increment > 0 {
+			s.write(mpro.BuildWindowUpdate(s.requestID, mpro.Res, increment))
+		}
+		return nil
+	case <-s.done:
+		return s.closedErr(mpro.ErrStreamClosed)
+	}
+}
+
+func (s *serverStream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *serverStream) isCanceled() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleFrame is called by the read loop of the connection, so it never blocks
+func (s *serverStream) handleFrame(msg *mpro.Message) {
+	if increment, ok := mpro.GetWindowUpdate(msg); ok && len(msg.Body) == 0 {
+		s.sendWindow.Grant(increment)
+		s.release(msg)
+		return
+	}
+	select {
+	case s.recvCh <- msg:
+	default:
+		vlog.Warningf("stream receive window overflow, stream will be canceled. rid:%d, conn:%s", s.requestID, s.conn.RemoteAddr().String())
+		s.setErr(mpro.ErrStreamWindowOverflow)
+		s.canceler.cancel(s.requestID)
+		s.release(msg)
+	}
+}
+
+func (s *serverStream) setErr(err error) {
+	s.errLock.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.errLock.Unlock()
+}
+
+func (s *serverStream) closedErr(err error) error {
+	s.errLock.Lock()
+	defer s.errLock.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
+
+func (s *serverStream) release(msg *mpro.Message) {
+	if s.pooled {
+		mpro.ReleaseMessage(msg)
+	}
+}
+
+func (s *serverStream) write(msg *mpro.Message) error {
+	s.conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
+	_, err := msg.WriteTo(s.conn)
+	if err != nil {
+		vlog.Errorf("connection will close. conn: %s, err:%s", s.conn.RemoteAddr().String(), err.Error())
+		s.conn.Close()
+	}
+	return err
+}
+
+func (m *MotanServer) processStream(start time.Time, request *mpro.Message, stream *serverStream, peerIdentity string, streams *serverStreams) {
+	defer motan.HandlePanic(nil)
+	defer stream.release(request)
+	defer stream.canceler.remove(stream.requestID)
+	defer streams.remove(stream.requestID)
+	req, err := mpro.ConvertToRequest(request, stream.serialization)
+	if err == nil {
+		reqCtx := req.GetRPCContext(true)
+		reqCtx.ExtFactory = m.extFactory
+		reqCtx.RequestReceiveTime = start
+		reqCtx.PeerIdentity = peerIdentity
+		reqCtx.CancelCh = stream.done
+		if sh, ok := m.handler.(motan.StreamMessageHandler); ok {
+			err = sh.CallStream(req, stream)
+		} else {
+			err = motan.ErrStreamNotSupported
+		}
+	}
+	if stream.isCanceled() {
+		// the client does not wait for the end of a canceled stream
+		return
+	}
+	errmsg := ""
+	if err != nil {
+		errmsg = mpro.ExceptionToJSON(&motan.Exception{ErrCode: 500, ErrMsg: err.Error(), ErrType: motan.ServiceException})
+	}
+	stream.write(mpro.BuildStreamEnd(stream.requestID, mpro.Res, errmsg))
+}
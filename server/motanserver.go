@@ -2,6 +2,7 @@ package server
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"net"
 	"strconv"
@@ -19,6 +20,8 @@ import (
 
 var currentConnections int64
 
+var tlsHandshakeTimeout = 5 * time.Second
+
 var motanServerOnce sync.Once
 
 func incrConnections() {
@@ -40,6 +43,12 @@ type MotanServer struct {
 	extFactory  motan.ExtensionFactory
 	proxy       bool
 	isDestroyed chan bool
+	tlsConfig   *tls.Config
+	// the codec of the protocol, motan2 or the legacy motan v1
+	codec mpro.Codec
+	// the request messages are decoded into pooled objects and released after the response is sent.
+	// it should be enabled only if no handler or filter keeps the request after the call returns
+	messagePool bool
 }
 
 func (m *MotanServer) Open(block bool, proxy bool, handler motan.MessageHandler, extFactory motan.ExtensionFactory) error {
@@ -70,11 +79,30 @@ func (m *MotanServer) Open(block bool, proxy bool, handler motan.MessageHandler,
 		lis = lisTmp
 	}
 
+	if motan.IsTLSEnabled(m.URL) {
+		tlsConfig, err := motan.BuildServerTLSConfig(m.URL)
+		if err != nil {
+			vlog.Errorf("build tls config fail. url:%s, err: %v", m.URL.GetIdentity(), err)
+			lis.Close()
+			return err
+		}
+		m.tlsConfig = tlsConfig
+	}
+
 	m.listener = lis
 	m.handler = handler
 	m.extFactory = extFactory
 	m.proxy = proxy
-	vlog.Infof("motan server is started. port:%d", m.URL.Port)
+	// motan v1 messages have no serialization number, hessian2 is used like java if the serialization is not configured
+	var serialization motan.Serialization
+	if m.URL.GetParam(motan.SerializationKey, "") != "" {
+		serialization = motan.GetSerialization(m.URL, extFactory)
+	}
+	m.codec = mpro.NewCodec(m.URL.Protocol, serialization)
+	// only motan2 messages can be decoded into pooled objects
+	m.messagePool, _ = strconv.ParseBool(m.URL.GetParam(motan.MessagePoolKey, ""))
+	m.messagePool = m.messagePool && m.codec.GetName() == mpro.CodecMotan2
+	vlog.Infof("motan server is started. port:%d, tls:%t", m.URL.Port, m.tlsConfig != nil)
 	if block {
 		m.run()
 	} else {
@@ -100,6 +128,9 @@ func (m *MotanServer) SetURL(url *motan.URL) {
 }
 
 func (m *MotanServer) GetName() string {
+	if m.codec != nil {
+		return m.codec.GetName()
+	}
 	return "motan2"
 }
 
@@ -129,6 +160,9 @@ func (m *MotanServer) run() {
 				c.SetNoDelay(true)
 				c.SetKeepAlive(true)
 			}
+			if m.tlsConfig != nil {
+				conn = tls.Server(conn, m.tlsConfig)
+			}
 			go m.handleConn(conn)
 		}
 	}
@@ -139,7 +173,20 @@ func (m *MotanServer) handleConn(conn net.Conn) {
 	defer decrConnections()
 	defer conn.Close()
 	defer motan.HandlePanic(nil)
+
+	var peerIdentity string
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			vlog.Warningf("tls handshake fail! con:%s, err:%s.", conn.RemoteAddr().String(), err.Error())
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+		peerIdentity = motan.GetPeerIdentity(tlsConn.ConnectionState())
+	}
 	buf := bufio.NewReader(conn)
+	canceler := &requestCanceler{channels: make(map[uint64]chan struct{})}
+	streams := &serverStreams{streams: make(map[uint64]*serverStream)}
 
 	var ip string
 	if ta, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
@@ -149,7 +196,14 @@ func (m *MotanServer) handleConn(conn net.Conn) {
 	}
 
 	for {
-		request, t, err := mpro.DecodeWithTime(buf)
+		var request *mpro.Message
+		var t time.Time
+		var err error
+		if m.messagePool {
+			request, t, err = mpro.DecodePooled(buf)
+		} else {
+			request, t, err = m.codec.Decode(buf)
+		}
 		if err != nil {
 			if err.Error() != "EOF" {
 				vlog.Warningf("decode motan message fail! con:%s, err:%s.", conn.RemoteAddr().String(), err.Error())
@@ -157,9 +211,30 @@ func (m *MotanServer) handleConn(conn net.Conn) {
 			break
 		}
 
+		if request.Header.IsCancel() {
+			canceler.cancel(request.Header.RequestID)
+			m.releaseMessage(request)
+			continue
+		}
 		request.Metadata.Store(motan.HostKey, ip)
+		if request.Header.IsStream() {
+			if stream := streams.get(request.Header.RequestID); stream != nil {
+				stream.handleFrame(request)
+			} else if request.Metadata.LoadOrEmpty(mpro.MPath) != "" {
+				// only the opening frame has the service path, frames of finished streams are dropped
+				stream = newServerStream(conn, request, m.extFactory.GetSerialization("", request.Header.GetSerialize()), canceler, m.messagePool)
+				streams.add(stream)
+				go m.processStream(t, request, stream, peerIdentity, streams)
+			} else {
+				m.releaseMessage(request)
+			}
+			continue
+		}
 		var trace *motan.TraceContext
+		var cancelCh chan struct{}
 		if !request.Header.IsHeartbeat() {
+			// register before processing, so the cancel message followed can always find the request
+			cancelCh = canceler.register(request.Header.RequestID)
 			trace = motan.TracePolicy(request.Header.RequestID, request.Metadata)
 			if trace != nil {
 				trace.Addr = ip
@@ -167,14 +242,61 @@ func (m *MotanServer) handleConn(conn net.Conn) {
 				trace.PutReqSpan(&motan.Span{Name: motan.Decode, Time: time.Now()})
 			}
 		}
-		go m.processReq(t, request, trace, conn)
+		go m.processReq(t, request, trace, conn, peerIdentity, canceler, cancelCh)
+	}
+	// nothing can be received or responded after the connection is closed, the processing requests and streams are canceled
+	streams.closeAll(mpro.ErrStreamConnClosed)
+	canceler.cancelAll()
+}
+
+// requestCanceler keeps the cancel channels of processing requests in a connection
+type requestCanceler struct {
+	lock     sync.Mutex
+	channels map[uint64]chan struct{}
+}
+
+func (r *requestCanceler) register(requestID uint64) chan struct{} {
+	ch := make(chan struct{})
+	r.lock.Lock()
+	r.channels[requestID] = ch
+	r.lock.Unlock()
+	return ch
+}
+
+func (r *requestCanceler) remove(requestID uint64) {
+	r.lock.Lock()
+	delete(r.channels, requestID)
+	r.lock.Unlock()
+}
+
+func (r *requestCanceler) cancel(requestID uint64) {
+	r.lock.Lock()
+	ch := r.channels[requestID]
+	delete(r.channels, requestID)
+	r.lock.Unlock()
+	if ch != nil {
+		close(ch)
 	}
 }
 
-func (m *MotanServer) processReq(start time.Time, request *mpro.Message, tc *motan.TraceContext, conn net.Conn) {
+func (r *requestCanceler) cancelAll() {
+	r.lock.Lock()
+	channels := r.channels
+	r.channels = make(map[uint64]chan struct{})
+	r.lock.Unlock()
+	for _, ch := range channels {
+		close(ch)
+	}
+}
+
+func (m *MotanServer) processReq(start time.Time, request *mpro.Message, tc *motan.TraceContext, conn net.Conn, peerIdentity string, canceler *requestCanceler, cancelCh chan struct{}) {
 	defer motan.HandlePanic(nil)
+	// released after all the other deferred functions which may use the request
+	defer m.releaseMessage(request)
+	if cancelCh != nil {
+		defer canceler.remove(request.Header.RequestID)
+	}
 	request.Header.SetProxy(m.proxy)
-	// TODO request , response reuse
 	var mres motan.Response
 	var mreq motan.Request
 	var res *mpro.Message
@@ -192,10 +314,16 @@ func (m *MotanServer) processReq(start time.Time, request *mpro.Message, tc *mot
 			reqCtx := req.GetRPCContext(true)
 			reqCtx.ExtFactory = m.extFactory
 			reqCtx.RequestReceiveTime = start
+			reqCtx.PeerIdentity = peerIdentity
+			reqCtx.CancelCh = cancelCh
 			if tc != nil {
 				tc.PutReqSpan(&motan.Span{Name: motan.Convert, Time: time.Now()})
 				req.GetRPCContext(true).Tc = tc
 			}
+			if reqCtx.IsCanceled() {
+				// the request is canceled before processing, the client does not wait for the response
+				return
+			}
 			callStart := time.Now()
 			mres = m.handler.Call(req)
 			if tc != nil {
@@ -223,16 +351,19 @@ func (m *MotanServer) processReq(start time.Time, request *mpro.Message, tc *mot
 	}
 	// recover the communication identifier
 	res.Header.RequestID = lastRequestID
-	resBuf := res.Encode()
 	if tc != nil {
 		tc.PutResSpan(&motan.Span{Name: motan.Encode, Time: time.Now()})
 	}
 
-	conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
-	_, err := conn.Write(resBuf.Bytes())
-	if err != nil {
-		vlog.Errorf("connection will close. conn: %s, err:%s", conn.RemoteAddr().String(), err.Error())
-		conn.Close()
+	// the response of canceled request will be discarded by the client
+	if mreq == nil || !mreq.GetRPCContext(true).IsCanceled() {
+		conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
+		// the response is encoded while writing, the body is not copied by motan2
+		_, err := m.codec.WriteTo(res, conn)
+		if err != nil {
+			vlog.Errorf("connection will close. conn: %s, err:%s", conn.RemoteAddr().String(), err.Error())
+			conn.Close()
+		}
 	}
 	resSendTime := time.Now()
 	if mreq != nil {
@@ -248,6 +379,13 @@ func (m *MotanServer) processReq(start time.Time, request *mpro.Message, tc *mot
 	}
 }
 
+// releaseMessage puts the request message back to the pool if the server decodes messages into pooled objects
+func (m *MotanServer) releaseMessage(msg *mpro.Message) {
+	if m.messagePool {
+		mpro.ReleaseMessage(msg)
+	}
+}
+
 func getRemoteIP(address string) string {
 	var ip string
 	index := strings.Index(address, ":")
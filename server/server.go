@@ -7,11 +7,14 @@ import (
 
 	motan "github.com/weibocom/motan-go/core"
 	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
 )
 
 const (
 	Motan2 = "motan2"
+	Motan  = "motan"
 	CGI    = "cgi"
+	Grpc   = "grpc"
 )
 
 const (
@@ -22,9 +25,15 @@ func RegistDefaultServers(extFactory motan.ExtensionFactory) {
 	extFactory.RegistExtServer(Motan2, func(url *motan.URL) motan.Server {
 		return &MotanServer{URL: url}
 	})
+	extFactory.RegistExtServer(Motan, func(url *motan.URL) motan.Server {
+		return &MotanServer{URL: url}
+	})
 	extFactory.RegistExtServer(CGI, func(url *motan.URL) motan.Server {
 		return &MotanServer{URL: url}
 	})
+	extFactory.RegistExtServer(Grpc, func(url *motan.URL) motan.Server {
+		return &GrpcServer{URL: url}
+	})
 }
 
 func RegistDefaultMessageHandlers(extFactory motan.ExtensionFactory) {
@@ -170,12 +179,30 @@ func (d *DefaultMessageHandler) Call(request motan.Request) (res motan.Response)
 	if p != nil {
 		res = p.Call(request)
 		res.GetRPCContext(true).GzipSize = int(p.GetURL().GetIntValue(motan.GzipSizeKey, 0))
+		mpro.NegotiateResponseCompress(request, res, p.GetURL().GetParam(motan.CompressKey, ""))
 		return res
 	}
 	vlog.Errorf("not found provider for %s", motan.GetReqInfo(request))
 	return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "not found provider for " + request.GetServiceName(), ErrType: motan.ServiceException})
 }
 
+// CallStream dispatches the streaming call to the StreamProvider of the service
+func (d *DefaultMessageHandler) CallStream(request motan.Request, stream motan.ServerStream) (err error) {
+	defer motan.HandlePanic(func() {
+		err = errors.New("provider call stream panic")
+		vlog.Errorf("provider call stream panic. req:%s", motan.GetReqInfo(request))
+	})
+	p := d.providers[request.GetServiceName()]
+	if p == nil {
+		vlog.Errorf("not found provider for %s", motan.GetReqInfo(request))
+		return errors.New("not found provider for " + request.GetServiceName())
+	}
+	if sp, ok := p.(motan.StreamProvider); ok {
+		return sp.CallStream(request, stream)
+	}
+	return motan.ErrStreamNotSupported
+}
+
 type FilterProviderWrapper struct {
 	provider motan.Provider
 	filter   motan.EndPointFilter
@@ -209,6 +236,18 @@ func (f *FilterProviderWrapper) Call(request motan.Request) (res motan.Response)
 	return f.filter.Filter(f.provider, request)
 }
 
+// CallStream calls the wrapped provider after the stream checks of the filters
+func (f *FilterProviderWrapper) CallStream(request motan.Request, stream motan.ServerStream) error {
+	sp, ok := f.provider.(motan.StreamProvider)
+	if !ok {
+		return motan.ErrStreamNotSupported
+	}
+	if e := motan.FilterStream(f.filter, f.provider, request); e != nil {
+		return errors.New(e.ErrMsg)
+	}
+	return sp.CallStream(request, stream)
+}
+
 func WrapWithFilter(provider motan.Provider, extFactory motan.ExtensionFactory, context *motan.Context) motan.Provider {
 	var lastf motan.EndPointFilter
 	lastf = motan.GetLastEndPointFilter()
@@ -1,11 +1,28 @@
 package motan
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/tls"
 	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
 	assert2 "github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/cluster"
 	"github.com/weibocom/motan-go/config"
 	motan "github.com/weibocom/motan-go/core"
+	mpro "github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/serialize"
+	mserver "github.com/weibocom/motan-go/server"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc/codes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 )
@@ -74,3 +91,456 @@ type HelloService struct{}
 func (m *HelloService) Hello(name string) string {
 	return fmt.Sprintf("Hello %s from motan server", name)
 }
+
+func TestServerCompressNegotiation(t *testing.T) {
+	assert := assert2.New(t)
+	cfgText := `
+motan-server:
+  log_dir: "stdout"
+  application: "app-golang"
+motan-registry:
+  direct:
+    protocol: direct
+motan-service:
+  compress-motan2:
+    path: compressService
+    group: bj
+    protocol: motan2
+    registry: direct
+    serialization: simple
+    ref : "compressServiceID"
+    export: "motan2:64540"
+    compress: snappy
+    mingzSize: 100
+    messagePool: true
+`
+	conf, err := config.NewConfigFromReader(bytes.NewReader([]byte(cfgText)))
+	assert.Nil(err)
+	mscontext := NewMotanServerContextFromConfig(conf)
+	assert.Nil(mscontext.RegisterService(&HelloService{}, "compressServiceID"))
+	mscontext.Start(GetDefaultExtFactory())
+	mscontext.ServicesAvailable()
+
+	name := strings.Repeat("motan", 100)
+	call := func(ep motan.EndPoint) motan.Response {
+		request := newRequest("compressService", "hello", name)
+		request.Attachment = motan.NewStringMap(motan.DefaultAttachmentSize)
+		resp := ep.Call(request)
+		assert.Nil(resp.GetException())
+		assert.Equal("Hello "+name+" from motan server", resp.GetValue())
+		return resp
+	}
+	clientExt := GetDefaultExtFactory()
+	for _, params := range []string{"compress=snappy&mingzSize=100", "mingzSize=100", ""} {
+		u := motan.FromExtInfo("motan2://127.0.0.1:64540/compressService?serialization=simple&" + params)
+		ep := clientExt.GetEndPoint(u)
+		ep.SetSerialization(motan.GetSerialization(u, clientExt))
+		motan.Initialize(ep)
+		// the first call learns the compressors of the server
+		resp := call(ep)
+		if u.GetParam(motan.CompressKey, "") == "" {
+			assert.Equal("", resp.GetAttachment(mpro.MAcceptCompress))
+		} else {
+			assert.Equal(mpro.AcceptCompressors(), resp.GetAttachment(mpro.MAcceptCompress))
+		}
+		// the compressors are not advertised any more after they are learned
+		resp = call(ep)
+		assert.Equal("", resp.GetAttachment(mpro.MAcceptCompress))
+		ep.Destroy()
+	}
+}
+
+func TestServerMotanV1(t *testing.T) {
+	assert := assert2.New(t)
+	cfgText := `
+motan-server:
+  log_dir: "stdout"
+  application: "app-golang"
+motan-registry:
+  direct:
+    protocol: direct
+motan-service:
+  hello-motan:
+    path: v1Service
+    group: bj
+    protocol: motan
+    registry: direct
+    serialization: simple
+    ref : "v1ServiceID"
+    export: "motan:64541"
+`
+	conf, err := config.NewConfigFromReader(bytes.NewReader([]byte(cfgText)))
+	assert.Nil(err)
+	mscontext := NewMotanServerContextFromConfig(conf)
+	assert.Nil(mscontext.RegisterService(&HelloService{}, "v1ServiceID"))
+	mscontext.Start(GetDefaultExtFactory())
+	mscontext.ServicesAvailable()
+
+	clientExt := GetDefaultExtFactory()
+	u := motan.FromExtInfo("motan://127.0.0.1:64541/v1Service?serialization=simple")
+	ep := clientExt.GetEndPoint(u)
+	assert.NotNil(ep)
+	ep.SetSerialization(motan.GetSerialization(u, clientExt))
+	motan.Initialize(ep)
+	defer ep.Destroy()
+
+	request := newRequest("v1Service", "hello", "Ray")
+	request.Attachment = motan.NewStringMap(motan.DefaultAttachmentSize)
+	request.Attachment.Store(mpro.MMethodDesc, "java.lang.String")
+	resp := ep.Call(request)
+	assert.Nil(resp.GetException())
+	assert.Equal("Hello Ray from motan server", resp.GetValue())
+
+	request = newRequest("v1Service", "notExist", "Ray")
+	request.Attachment = motan.NewStringMap(motan.DefaultAttachmentSize)
+	resp = ep.Call(request)
+	assert.NotNil(resp.GetException())
+}
+
+type GrpcHelloService struct{}
+
+func (m *GrpcHelloService) Hello(name *wrappers.StringValue) *wrappers.StringValue {
+	return &wrappers.StringValue{Value: "Hello " + name.Value + " from grpc server"}
+}
+
+func (m *GrpcHelloService) Sleep(name *wrappers.StringValue) *wrappers.StringValue {
+	time.Sleep(200 * time.Millisecond)
+	return name
+}
+
+func TestServerGrpc(t *testing.T) {
+	assert := assert2.New(t)
+	cfgText := `
+motan-server:
+  log_dir: "stdout"
+  application: "app-golang"
+motan-registry:
+  direct:
+    protocol: direct
+motan-service:
+  hello-grpc:
+    path: motan.test.GreeterService
+    group: bj
+    protocol: grpc
+    registry: direct
+    serialization: grpc-pb
+    ref : "grpcServiceID"
+    export: "grpc:64542"
+`
+	conf, err := config.NewConfigFromReader(bytes.NewReader([]byte(cfgText)))
+	assert.Nil(err)
+	mscontext := NewMotanServerContextFromConfig(conf)
+	assert.Nil(mscontext.RegisterService(&GrpcHelloService{}, "grpcServiceID"))
+	mscontext.Start(GetDefaultExtFactory())
+	mscontext.ServicesAvailable()
+
+	clientExt := GetDefaultExtFactory()
+	ep := clientExt.GetEndPoint(motan.FromExtInfo("grpc://127.0.0.1:64542/motan.test.GreeterService?serialization=grpc-pb&requestTimeout=50&grpcKeepaliveTime=10000"))
+	assert.NotNil(ep)
+	motan.Initialize(ep)
+	defer ep.Destroy()
+
+	body, _ := proto.Marshal(&wrappers.StringValue{Value: "Ray"})
+	request := newRequest("motan.test.GreeterService", "hello", body)
+	request.Attachment = motan.NewStringMap(motan.DefaultAttachmentSize)
+	request.Attachment.Store("key", "value")
+	resp := ep.Call(request)
+	assert.Nil(resp.GetException())
+	reply := &wrappers.StringValue{}
+	assert.Nil(proto.Unmarshal(resp.GetValue().([]byte), reply))
+	assert.Equal("Hello Ray from grpc server", reply.Value)
+	// the headers are the attachments of the response
+	assert.NotEqual("", resp.GetAttachment(mpro.MProcessTime))
+
+	request = newRequest("motan.test.GreeterService", "notExist", body)
+	request.Attachment = motan.NewStringMap(motan.DefaultAttachmentSize)
+	resp = ep.Call(request)
+	assert.NotNil(resp.GetException())
+	assert.Contains(resp.GetException().ErrMsg, "notExist")
+	assert.Equal(motan.ServiceException, resp.GetException().ErrType)
+
+	request = newRequest("motan.test.GreeterService", "sleep", body)
+	request.Attachment = motan.NewStringMap(motan.DefaultAttachmentSize)
+	start := time.Now()
+	resp = ep.Call(request)
+	assert.True(time.Since(start) < 200*time.Millisecond)
+	assert.NotNil(resp.GetException())
+	assert.Equal(motan.FrameworkException, resp.GetException().ErrType)
+}
+
+// grpcGroupProvider replies the group of the request
+type grpcGroupProvider struct {
+	*motan.TestProvider
+}
+
+func (p *grpcGroupProvider) Call(request motan.Request) motan.Response {
+	if request.GetMethod() == "unknownError" {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "unknown error", ErrType: 99})
+	}
+	body, _ := proto.Marshal(&wrappers.StringValue{Value: request.GetAttachment(mpro.MGroup)})
+	res := &motan.MotanResponse{RequestID: request.GetRequestID(), Value: body}
+	res.GetRPCContext(true).Serialized = true
+	res.GetRPCContext(true).SerializeNum = serialize.GrpcPbNumber
+	res.SetAttachment("x-user", "ray")
+	res.SetAttachment("x-name", "雷")
+	res.SetAttachment("content-type", "text/plain")
+	res.SetAttachment("x user", "illegal")
+	return res
+}
+
+func TestServerAgentGrpc(t *testing.T) {
+	assert := assert2.New(t)
+	handler := &serverAgentMessageHandler{}
+	motan.Initialize(handler)
+	handler.AddProvider(&grpcGroupProvider{TestProvider: &motan.TestProvider{URL: &motan.URL{Path: "motan.test.GreeterService", Group: "bj"}}})
+	server := &mserver.GrpcServer{URL: &motan.URL{Protocol: "grpc", Port: 64545, Parameters: map[string]string{}}}
+	assert.Nil(server.Open(false, true, handler, GetDefaultExtFactory()))
+	defer server.Destroy()
+
+	ep := GetDefaultExtFactory().GetEndPoint(motan.FromExtInfo("grpc://127.0.0.1:64545/motan.test.GreeterService?serialization=grpc-pb&requestTimeout=500"))
+	motan.Initialize(ep)
+	defer ep.Destroy()
+	body, _ := proto.Marshal(&wrappers.StringValue{Value: "Ray"})
+	// the group of the metadata is lowercased by grpc, the agent handler finds the provider by the group
+	request := newRequest("motan.test.GreeterService", "hello", body)
+	request.Attachment = motan.NewStringMap(motan.DefaultAttachmentSize)
+	request.Attachment.Store(mpro.MGroup, "bj")
+	resp := ep.Call(request)
+	assert.Nil(resp.GetException())
+	reply := &wrappers.StringValue{}
+	assert.Nil(proto.Unmarshal(resp.GetValue().([]byte), reply))
+	assert.Equal("bj", reply.Value)
+	// the illegal headers are skipped
+	assert.Equal("ray", resp.GetAttachment("x-user"))
+	assert.Equal("", resp.GetAttachment("x-name"))
+	assert.Equal("", resp.GetAttachment("x user"))
+	assert.NotEqual("", resp.GetAttachment(mpro.MProcessTime))
+
+	request = newRequest("motan.test.GreeterService", "hello", body)
+	request.Attachment = motan.NewStringMap(motan.DefaultAttachmentSize)
+	request.Attachment.Store(mpro.MGroup, "sh")
+	resp = ep.Call(request)
+	assert.NotNil(resp.GetException())
+	assert.Contains(resp.GetException().ErrMsg, "not found provider")
+
+	// the exceptions of unknown types are internal errors
+	request = newRequest("motan.test.GreeterService", "unknownError", body)
+	request.Attachment = motan.NewStringMap(motan.DefaultAttachmentSize)
+	request.Attachment.Store(mpro.MGroup, "bj")
+	resp = ep.Call(request)
+	assert.NotNil(resp.GetException())
+	assert.Equal(int(codes.Internal), resp.GetException().ErrCode)
+	assert.Equal("unknown error", resp.GetException().ErrMsg)
+}
+
+type echoMessageHandler struct{}
+
+func (e *echoMessageHandler) Call(request motan.Request) motan.Response {
+	return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: []byte(request.GetMethod() + ":" + request.GetArguments()[0].(string))}
+}
+
+func (e *echoMessageHandler) AddProvider(p motan.Provider) error { return nil }
+
+func (e *echoMessageHandler) RmProvider(p motan.Provider) {}
+
+func (e *echoMessageHandler) GetProvider(serviceName string) motan.Provider { return nil }
+
+// rpcJSONMessageHandler serializes the arguments back as the response like the remote providers
+type rpcJSONMessageHandler struct{}
+
+func (r *rpcJSONMessageHandler) Call(request motan.Request) motan.Response {
+	switch request.GetMethod() {
+	case "fail":
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "biz fail", ErrType: motan.BizException})
+	case "timeout":
+		response := motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 400, ErrMsg: "channel call error:request timeout", ErrType: motan.ServiceException})
+		motan.SetErrorCause(response, motan.ErrorCauseTimeout)
+		return response
+	case "notFound":
+		response := motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 400, ErrMsg: "cluster not found", ErrType: motan.ServiceException})
+		motan.SetErrorCause(response, motan.ErrorCauseNoCluster)
+		return response
+	case "unavailable":
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "unavailable", ErrType: motan.FrameworkException})
+	case "hello":
+		name := request.GetArguments()[0].(*wrappers.StringValue)
+		body, _ := proto.Marshal(&wrappers.StringValue{Value: "hello " + name.Value})
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: &motan.DeserializableValue{Serialization: &serialize.GrpcPbSerialization{}, Body: body}}
+	}
+	serialization := &serialize.SimpleSerialization{}
+	body, _ := serialization.Serialize(request.GetArguments())
+	return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: &motan.DeserializableValue{Serialization: serialization, Body: body}}
+}
+
+func (r *rpcJSONMessageHandler) AddProvider(p motan.Provider) error { return nil }
+
+func (r *rpcJSONMessageHandler) RmProvider(p motan.Provider) {}
+
+func (r *rpcJSONMessageHandler) GetProvider(serviceName string) motan.Provider { return nil }
+
+func TestHTTPProxyRPCJSON(t *testing.T) {
+	assert := assert2.New(t)
+	u := &motan.URL{Host: "127.0.0.1", Port: 64544, Parameters: map[string]string{mserver.HTTPProxyEnableKey: "true"}}
+	proxyServer := mserver.NewHTTPProxyServer(u)
+	assert.Nil(proxyServer.Open(false, true, &emptyClusterGetter{}, &rpcJSONMessageHandler{}))
+	defer proxyServer.Destroy()
+	time.Sleep(100 * time.Millisecond)
+	mserver.RegisterRPCSchema("jsonHelloService", &GrpcHelloService{})
+
+	post := func(path string, body string) (int, string, string) {
+		resp, err := http.Post("http://127.0.0.1:64544"+path, "application/json", strings.NewReader(body))
+		assert.Nil(err)
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(b)
+	}
+	// without schema
+	code, contentType, body := post("/proxy_to_rpc?service=s&method=echo", `["a", 1, 1.5, true, {"k": "v"}, ["x"]]`)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("application/json", contentType)
+	assert.JSONEq(`["a", 1, 1.5, true, {"k": "v"}, ["x"]]`, body)
+
+	// with the schema of protobuf arguments, the wrappers are converted from the json values by jsonpb
+	code, _, body = post("/proxy_to_rpc?service=jsonHelloService&method=hello&arg_type=json", `["json"]`)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(`"hello json"`, body)
+	code, _, _ = post("/proxy_to_rpc?service=jsonHelloService&method=hello", `["a", "b"]`)
+	assert.Equal(http.StatusBadRequest, code)
+	code, _, _ = post("/proxy_to_rpc?service=s&method=echo", `{"not": "list"}`)
+	assert.Equal(http.StatusBadRequest, code)
+
+	// exceptions
+	code, _, body = post("/proxy_to_rpc?service=s&method=fail", `[]`)
+	assert.Equal(http.StatusInternalServerError, code)
+	assert.Contains(body, "biz fail")
+	code, _, _ = post("/proxy_to_rpc?service=s&method=timeout", `[]`)
+	assert.Equal(http.StatusGatewayTimeout, code)
+	code, _, _ = post("/proxy_to_rpc?service=s&method=notFound", `[]`)
+	assert.Equal(http.StatusNotFound, code)
+	code, _, _ = post("/proxy_to_rpc?service=s&method=unavailable", `[]`)
+	assert.Equal(http.StatusServiceUnavailable, code)
+	// the exceptions of the string arguments are mapped too
+	for method, status := range map[string]int{"fail": http.StatusInternalServerError, "timeout": http.StatusGatewayTimeout, "notFound": http.StatusNotFound} {
+		code, _, body = post("/proxy_to_rpc?service=s&method="+method+"&arg_type=string&arg=a", ``)
+		assert.Equal(status, code, method)
+		assert.NotEmpty(body)
+	}
+}
+
+type emptyClusterGetter struct{}
+
+func (e *emptyClusterGetter) GetHTTPCluster(host string) *cluster.HTTPCluster { return nil }
+
+func TestHTTPProxyServerHTTP2(t *testing.T) {
+	assert := assert2.New(t)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", r.Header.Get("X-Test"))
+		w.Write([]byte(r.URL.String()))
+	}))
+	defer backend.Close()
+	backendAddr := backend.Listener.Addr().String()
+
+	u := &motan.URL{Host: "127.0.0.1", Port: 64543, Parameters: map[string]string{
+		mserver.HTTPProxyEnableKey:             "true",
+		mserver.HTTPProxyServerTypeKey:         mserver.HTTPProxyServerHTTP2,
+		mserver.HTTPProxyUpgradeIdleTimeoutKey: "300",
+	}}
+	proxyServer := mserver.NewHTTPProxyServer(u)
+	assert.Nil(proxyServer.Open(false, true, &emptyClusterGetter{}, &echoMessageHandler{}))
+	defer proxyServer.Destroy()
+	time.Sleep(100 * time.Millisecond)
+
+	// h2c with prior knowledge
+	h2cClient := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	request, _ := http.NewRequest("GET", "http://127.0.0.1:64543/test/path?a=b", nil)
+	request.Host = backendAddr
+	request.Header.Set("X-Test", "h2c")
+	resp, err := h2cClient.Do(request)
+	assert.Nil(err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(2, resp.ProtoMajor)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal("/test/path?a=b", string(body))
+	assert.Equal("h2c", resp.Header.Get("X-Backend"))
+
+	// the same rpc endpoint
+	resp, err = h2cClient.Get("http://127.0.0.1:64543/proxy_to_rpc?service=s&method=m&arg_type=string&arg=hello")
+	assert.Nil(err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal("m:hello", string(body))
+
+	// HTTP/1.1 forward proxy and https tunnel
+	proxyURL, _ := url.Parse("http://127.0.0.1:64543")
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err = client.Get("http://" + backendAddr + "/http1")
+	assert.Nil(err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(1, resp.ProtoMajor)
+	assert.Equal("/http1", string(body))
+
+	tlsBackend := httptest.NewTLSServer(backend.Config.Handler)
+	defer tlsBackend.Close()
+	client = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err = client.Get(tlsBackend.URL + "/tunnel")
+	assert.Nil(err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal("/tunnel", string(body))
+
+	// the upgraded connection is closed after idle timeout
+	upgradeBackend := newUpgradeEchoServer()
+	defer upgradeBackend.Close()
+	conn, reader := assertUpgradeEcho(t, "127.0.0.1:64543", upgradeBackend.Listener.Addr().String())
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	_, err = reader.ReadByte()
+	assert.NotNil(err)
+	assert.True(time.Since(start) < time.Second)
+}
+
+// newUpgradeEchoServer switches to the echo protocol for upgrade requests
+func newUpgradeEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.Write([]byte("not upgraded"))
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n"))
+		io.Copy(conn, buf)
+	}))
+}
+
+// assertUpgradeEcho upgrades a connection through the proxy, and checks the echo of the backend
+func assertUpgradeEcho(t *testing.T, proxyAddr string, backendAddr string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", proxyAddr)
+	assert2.Nil(t, err)
+	conn.Write([]byte("GET http://" + backendAddr + "/ws HTTP/1.1\r\nHost: " + backendAddr + "\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	assert2.Nil(t, err)
+	assert2.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert2.Equal(t, "echo", resp.Header.Get("Upgrade"))
+	for _, message := range []string{"ping", "pong"} {
+		conn.Write([]byte(message))
+		data := make([]byte, len(message))
+		_, err = io.ReadFull(reader, data)
+		assert2.Nil(t, err)
+		assert2.Equal(t, message, string(data))
+	}
+	return conn, reader
+}
@@ -0,0 +1,862 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	issueWarning = "warning"
+	issueError   = "error"
+
+	upstreamRegistryPrefix = "upstream-"
+	defaultUpstreamPort    = "80"
+	// the weights of the groups merged by the traffic control command are 1-100
+	maxGroupWeight = 100
+)
+
+// Issue is an item of the validation report, the error issues mean the generated configuration is incomplete
+type Issue struct {
+	Line    int
+	Level   string
+	Message string
+}
+
+// Report collects the unsupported or approximately converted directives
+type Report struct {
+	Issues []Issue
+}
+
+func (r *Report) add(level string, line int, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, Issue{Line: line, Level: level, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) warnf(line int, format string, args ...interface{}) {
+	r.add(issueWarning, line, format, args...)
+}
+
+func (r *Report) errorf(line int, format string, args ...interface{}) {
+	r.add(issueError, line, format, args...)
+}
+
+func (r *Report) HasError() bool {
+	for _, issue := range r.Issues {
+		if issue.Level == issueError {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) String() string {
+	issues := append([]Issue{}, r.Issues...)
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Line < issues[j].Line
+	})
+	buf := &bytes.Buffer{}
+	for _, issue := range issues {
+		fmt.Fprintf(buf, "line %d: %s: %s\n", issue.Line, issue.Level, issue.Message)
+	}
+	return buf.String()
+}
+
+// location is the http-locations item, it's the yaml form of http.ProxyLocation
+type location struct {
+	Match           string   `yaml:"match"`
+	Type            string   `yaml:"type"`
+	Upstream        string   `yaml:"upstream,omitempty"`
+	RewriteRules    []string `yaml:"rewriteRules,omitempty"`
+	RequestHeaders  []string `yaml:"requestHeaders,omitempty"`
+	ResponseHeaders []string `yaml:"responseHeaders,omitempty"`
+	Return          string   `yaml:"return,omitempty"`
+}
+
+// mapConfig is the http-maps item converted from the nginx map block, the entries are matched in order
+type mapConfig struct {
+	Source  string     `yaml:"source"`
+	Default string     `yaml:"default,omitempty"`
+	Entries []mapEntry `yaml:"entries,omitempty"`
+}
+
+type mapEntry struct {
+	Match string `yaml:"match"`
+	Type  string `yaml:"type"`
+	Value string `yaml:"value"`
+}
+
+// registryConfig is the direct registry of an upstream. The servers with different weights are in the groups of
+// address.{group}, which are merged with the group weights by the command
+type registryConfig struct {
+	Protocol string            `yaml:"protocol"`
+	Address  string            `yaml:"address"`
+	Groups   map[string]string `yaml:",inline"`
+	Command  string            `yaml:"command,omitempty"`
+}
+
+// command is the json form of cluster.Command, it has a traffic control command merging the weighted groups
+type command struct {
+	ClientCommandList []clientCommand `json:"clientCommandList"`
+}
+
+type clientCommand struct {
+	Index       int      `json:"index"`
+	CommandType int      `json:"commandType"`
+	Pattern     string   `json:"pattern"`
+	MergeGroups []string `json:"mergeGroups"`
+	Remark      string   `json:"remark,omitempty"`
+}
+
+type clientConfig struct {
+	Registry       string `yaml:"registry"`
+	Domain         string `yaml:"domain"`
+	Group          string `yaml:"group"`
+	Preload        string `yaml:"preload"`
+	Protocol       string `yaml:"protocol"`
+	Serialization  string `yaml:"serialization"`
+	RequestTimeout int64  `yaml:"requestTimeout,omitempty"`
+}
+
+type upstream struct {
+	name     string
+	line     int
+	servers  []string
+	weights  []int
+	backups  []string
+	implicit bool
+}
+
+// headerScope holds the header directives which are inherited from the outer level if the inner level has none
+type headerScope struct {
+	requestHeaders  []string
+	responseHeaders []string
+	hideHeaders     []string
+}
+
+// Importer converts the nginx configuration to the http-locations, http-client, motan-registry and http-maps
+// sections of the agent configuration
+type Importer struct {
+	// domain overrides the server_name of the server blocks, it's required if the locations are not in server blocks
+	domain string
+	report *Report
+
+	locations      map[string][]*location
+	domainTimeouts map[string]int64
+	upstreams      map[string]*upstream
+	maps           map[string]*mapConfig
+	// the upstreams used by the domains in order
+	domainUpstreams map[string][]string
+	// the line of the first block which defines the locations of a domain
+	domainLines map[string]int
+}
+
+func NewImporter(domain string) *Importer {
+	return &Importer{
+		domain:          domain,
+		report:          &Report{},
+		locations:       make(map[string][]*location),
+		domainTimeouts:  make(map[string]int64),
+		upstreams:       make(map[string]*upstream),
+		maps:            make(map[string]*mapConfig),
+		domainUpstreams: make(map[string][]string),
+		domainLines:     make(map[string]int),
+	}
+}
+
+func (im *Importer) Report() *Report {
+	return im.report
+}
+
+// Import converts the directives, the unsupported directives are recorded in the report
+func (im *Importer) Import(ds []*Directive) ([]byte, error) {
+	// the upstreams and the maps can be declared after the servers which use them
+	im.collectDeclarations(ds)
+	im.visitBlock(ds, &headerScope{})
+	return im.marshal()
+}
+
+func (im *Importer) collectDeclarations(ds []*Directive) {
+	for _, d := range ds {
+		switch d.name {
+		case "upstream":
+			im.visitUpstream(d)
+		case "map":
+			im.visitMap(d)
+		case "server", "location":
+		default:
+			if d.directives != nil {
+				im.collectDeclarations(d.directives)
+			}
+		}
+	}
+}
+
+// visitBlock visits the global, http or other blocks which contain the server blocks or the locations without server
+func (im *Importer) visitBlock(ds []*Directive, scope *headerScope) {
+	scope = im.inheritHeaders(ds, scope)
+	for _, d := range ds {
+		switch d.name {
+		case "server":
+			im.visitServer(d, scope)
+		case "location":
+			if im.domain == "" {
+				im.report.errorf(d.line, "location %s is not in a server block, use -d to specify the domain", strings.Join(d.args, " "))
+				continue
+			}
+			im.addLocations([]string{im.domain}, im.visitLocation(d, scope), d.line)
+		case "upstream", "map":
+		default:
+			if d.directives != nil {
+				im.visitBlock(d.directives, scope)
+			}
+		}
+	}
+}
+
+func (im *Importer) visitServer(server *Directive, scope *headerScope) {
+	domains := im.serverDomains(server)
+	if len(domains) == 0 {
+		im.report.errorf(server.line, "server has no usable server_name, use -d to specify the domain")
+		return
+	}
+	scope = im.inheritHeaders(server.directives, scope)
+	var locations []*location
+	var serverReturn string
+	for _, d := range server.directives {
+		switch d.name {
+		case "location":
+			locations = append(locations, im.visitLocation(d, scope)...)
+		case "return":
+			serverReturn = im.convertReturn(d)
+		case "proxy_read_timeout":
+			if timeout, ok := im.parseTimeout(d); ok {
+				for _, domain := range domains {
+					im.domainTimeouts[domain] = timeout
+				}
+			}
+		case "server_name", "listen", "proxy_set_header", "add_header", "proxy_hide_header",
+			"access_log", "error_log", "root", "index", "charset":
+		default:
+			im.report.warnf(d.line, "directive %s is not supported in server, ignored", d.name)
+		}
+	}
+	// the return of the server is executed before the locations are matched
+	if serverReturn != "" {
+		hasRoot := false
+		for _, l := range locations {
+			l.Return = serverReturn
+			hasRoot = hasRoot || (l.Type == "start" && l.Match == "/")
+		}
+		if !hasRoot {
+			locations = append(locations, &location{Match: "/", Type: "start", Return: serverReturn})
+		}
+	}
+	im.addLocations(domains, locations, server.line)
+}
+
+func (im *Importer) serverDomains(server *Directive) []string {
+	if im.domain != "" {
+		return []string{im.domain}
+	}
+	var domains []string
+	for _, d := range server.directives {
+		if d.name != "server_name" {
+			continue
+		}
+		for _, name := range d.args {
+			if name == "" || name == "_" || name == "localhost" {
+				continue
+			}
+			if strings.ContainsAny(name, "*~") || strings.HasPrefix(name, ".") {
+				im.report.warnf(d.line, "wildcard or regexp server_name %s is not supported, ignored", name)
+				continue
+			}
+			domains = append(domains, name)
+		}
+	}
+	return domains
+}
+
+func (im *Importer) addLocations(domains []string, locations []*location, line int) {
+	for _, domain := range domains {
+		if _, ok := im.domainLines[domain]; !ok {
+			im.domainLines[domain] = line
+		}
+		im.locations[domain] = append(im.locations[domain], locations...)
+		for _, l := range locations {
+			if l.Upstream == "" {
+				continue
+			}
+			used := false
+			for _, u := range im.domainUpstreams[domain] {
+				used = used || u == l.Upstream
+			}
+			if !used {
+				im.domainUpstreams[domain] = append(im.domainUpstreams[domain], l.Upstream)
+			}
+		}
+	}
+}
+
+// inheritHeaders returns the header scope of the block, like nginx the header directives of the outer level are
+// inherited only if the block has no directives of the same kind
+func (im *Importer) inheritHeaders(ds []*Directive, parent *headerScope) *headerScope {
+	scope := &headerScope{}
+	var requestHeaders, responseHeaders, hideHeaders bool
+	for _, d := range ds {
+		switch d.name {
+		case "proxy_set_header":
+			requestHeaders = true
+			if len(d.args) < 1 || len(d.args) > 2 {
+				im.report.errorf(d.line, "invalid proxy_set_header %s", strings.Join(d.args, " "))
+				continue
+			}
+			value := ""
+			if len(d.args) == 2 {
+				value = d.args[1]
+			}
+			// the header with empty value is not passed to the upstream
+			if value == "" {
+				scope.requestHeaders = append(scope.requestHeaders, "remove "+d.args[0])
+				continue
+			}
+			scope.requestHeaders = append(scope.requestHeaders, "set "+d.args[0]+" "+im.convertVariables(d.line, value))
+		case "add_header":
+			responseHeaders = true
+			if len(d.args) < 2 || len(d.args) > 3 || (len(d.args) == 3 && d.args[2] != "always") {
+				im.report.errorf(d.line, "invalid add_header %s", strings.Join(d.args, " "))
+				continue
+			}
+			scope.responseHeaders = append(scope.responseHeaders, "add "+d.args[0]+" "+im.convertVariables(d.line, d.args[1]))
+		case "proxy_hide_header":
+			hideHeaders = true
+			if len(d.args) != 1 {
+				im.report.errorf(d.line, "invalid proxy_hide_header %s", strings.Join(d.args, " "))
+				continue
+			}
+			scope.hideHeaders = append(scope.hideHeaders, "remove "+d.args[0])
+		}
+	}
+	if !requestHeaders {
+		scope.requestHeaders = parent.requestHeaders
+	}
+	if !responseHeaders {
+		scope.responseHeaders = parent.responseHeaders
+	}
+	if !hideHeaders {
+		scope.hideHeaders = parent.hideHeaders
+	}
+	return scope
+}
+
+func (im *Importer) visitLocation(d *Directive, parent *headerScope) []*location {
+	pl, ok := im.parseLocationMatch(d)
+	if !ok {
+		return nil
+	}
+	scope := im.inheritHeaders(d.directives, parent)
+	pl.RequestHeaders = scope.requestHeaders
+	pl.ResponseHeaders = append(append([]string{}, scope.hideHeaders...), scope.responseHeaders...)
+	if len(pl.ResponseHeaders) == 0 {
+		pl.ResponseHeaders = nil
+	}
+	var nested []*location
+	for _, sub := range d.directives {
+		switch sub.name {
+		case "proxy_pass":
+			im.convertProxyPass(sub, pl)
+		case "rewrite":
+			if rule, ok := im.convertRewrite(sub, "start /"); ok {
+				pl.RewriteRules = append(pl.RewriteRules, rule)
+			}
+		case "if":
+			im.convertIf(sub, pl)
+		case "return":
+			pl.Return = im.convertReturn(sub)
+		case "location":
+			// the nested locations are flattened, they are matched in the same level as the outer location
+			im.report.warnf(sub.line, "nested location %s is flattened", strings.Join(sub.args, " "))
+			nested = append(nested, im.visitLocation(sub, scope)...)
+		case "proxy_set_header", "add_header", "proxy_hide_header":
+		default:
+			im.report.warnf(sub.line, "directive %s is not supported in location, ignored", sub.name)
+		}
+	}
+	// has no upstream ignore it
+	if pl.Upstream == "" && pl.Return == "" {
+		im.report.warnf(d.line, "location %s has no proxy_pass or return, ignored", strings.Join(d.args, " "))
+		return nested
+	}
+	return append([]*location{pl}, nested...)
+}
+
+func (im *Importer) parseLocationMatch(d *Directive) (*location, bool) {
+	pl := &location{}
+	args := d.args
+	if len(args) == 1 {
+		// the modifier may be not separated with the uri such as `=/path`
+		arg := args[0]
+		switch {
+		case strings.HasPrefix(arg, "^~"):
+			args = []string{"^~", arg[2:]}
+		case strings.HasPrefix(arg, "~*"):
+			args = []string{"~*", arg[2:]}
+		case strings.HasPrefix(arg, "~"), strings.HasPrefix(arg, "="):
+			args = []string{arg[:1], arg[1:]}
+		}
+	}
+	switch len(args) {
+	case 1:
+		if strings.HasPrefix(args[0], "@") {
+			im.report.warnf(d.line, "named location %s is not supported, ignored", args[0])
+			return nil, false
+		}
+		pl.Type = "start"
+		pl.Match = args[0]
+	case 2:
+		pl.Match = args[1]
+		switch args[0] {
+		case "=":
+			pl.Type = "exact"
+		case "~":
+			pl.Type = "regexp"
+		case "~*":
+			pl.Type = "iregexp"
+		case "^~":
+			im.report.warnf(d.line, "location modifier ^~ is converted to prefix match, the regexp locations may take precedence")
+			pl.Type = "start"
+		default:
+			im.report.errorf(d.line, "unsupported location modifier %s", args[0])
+			return nil, false
+		}
+	default:
+		im.report.errorf(d.line, "illegal argument count for location %s", strings.Join(d.args, " "))
+		return nil, false
+	}
+	if pl.Match == "" {
+		im.report.errorf(d.line, "location %s has empty uri", strings.Join(d.args, " "))
+		return nil, false
+	}
+	if pl.Type == "regexp" || pl.Type == "iregexp" {
+		if _, err := regexp.Compile(pl.Match); err != nil {
+			im.report.errorf(d.line, "location regexp %s can not be compiled: %v", pl.Match, err)
+			return nil, false
+		}
+	}
+	return pl, true
+}
+
+func (im *Importer) convertProxyPass(d *Directive, pl *location) {
+	if len(d.args) != 1 {
+		im.report.errorf(d.line, "invalid proxy_pass %s", strings.Join(d.args, " "))
+		return
+	}
+	target := d.args[0]
+	switch {
+	case strings.HasPrefix(target, "http://"):
+		target = target[len("http://"):]
+	case strings.HasPrefix(target, "https://"):
+		im.report.warnf(d.line, "https upstream %s is proxied by http", d.args[0])
+		target = target[len("https://"):]
+	default:
+		im.report.errorf(d.line, "unsupported proxy_pass %s", d.args[0])
+		return
+	}
+	host, uri := target, ""
+	if pos := strings.IndexByte(target, '/'); pos >= 0 {
+		host, uri = target[:pos], target[pos:]
+	}
+	if strings.Contains(host, "$") {
+		im.report.errorf(d.line, "proxy_pass with variables %s is not supported", d.args[0])
+		return
+	}
+	name := host
+	if _, ok := im.upstreams[host]; !ok {
+		// the host of the proxy_pass is an implicit upstream with one server
+		address := host
+		if _, _, ok := splitHostPort(host); !ok {
+			address = host + ":" + defaultUpstreamPort
+		}
+		name, _, _ = splitHostPort(address)
+		if u, ok := im.upstreams[name]; !ok || u.implicit {
+			im.upstreams[name] = &upstream{name: name, line: d.line, servers: []string{address}, weights: []int{1}, implicit: true}
+		}
+		im.report.warnf(d.line, "proxy_pass to %s without upstream block, an upstream %s is created", host, name)
+	}
+	pl.Upstream = name
+	// the uri of the proxy_pass replaces the matched prefix of the location
+	if uri != "" {
+		if pl.Type != "start" {
+			im.report.warnf(d.line, "the uri %s of proxy_pass in %s location is ignored", uri, pl.Type)
+			return
+		}
+		pl.RewriteRules = append(pl.RewriteRules, "start "+pl.Match+" ^"+regexp.QuoteMeta(pl.Match)+"(.*) "+uri+"$1")
+	}
+}
+
+// convertRewrite converts the rewrite directive to the rewrite rule with the condition, the flags are ignored
+func (im *Importer) convertRewrite(d *Directive, condition string) (string, bool) {
+	if len(d.args) < 2 || len(d.args) > 3 {
+		im.report.errorf(d.line, "invalid rewrite %s", strings.Join(d.args, " "))
+		return "", false
+	}
+	if len(d.args) == 3 && (d.args[2] == "redirect" || d.args[2] == "permanent") {
+		im.report.warnf(d.line, "rewrite flag %s is converted to internal rewrite", d.args[2])
+	}
+	if _, err := regexp.Compile(d.args[0]); err != nil {
+		im.report.errorf(d.line, "rewrite regexp %s can not be compiled: %v", d.args[0], err)
+		return "", false
+	}
+	return condition + " " + d.args[0] + " " + im.convertVariables(d.line, d.args[1]), true
+}
+
+// convertIf converts the `if ($request_uri ~ regexp) { rewrite ...; }` to the conditional rewrite rules
+func (im *Importer) convertIf(d *Directive, pl *location) {
+	condition := strings.TrimSpace(strings.Join(d.args, " "))
+	if !strings.HasPrefix(condition, "(") || !strings.HasSuffix(condition, ")") {
+		im.report.errorf(d.line, "invalid condition %s", condition)
+		return
+	}
+	args := strings.Fields(strings.TrimSpace(condition[1 : len(condition)-1]))
+	if len(args) != 3 {
+		im.report.errorf(d.line, "unsupported condition %s", condition)
+		return
+	}
+	if args[0] != "$request_uri" && args[0] != "$uri" {
+		im.report.errorf(d.line, "unsupported condition %s, only $request_uri and $uri are supported", condition)
+		return
+	}
+	conditionType := ""
+	switch args[1] {
+	case "~":
+		conditionType = "regexp"
+	case "~*":
+		conditionType = "iregexp"
+	case "!~":
+		conditionType = "!regexp"
+	case "!~*":
+		conditionType = "!iregexp"
+	case "=":
+		conditionType = "exact"
+	case "!=":
+		conditionType = "!exact"
+	default:
+		im.report.errorf(d.line, "unsupported condition operator %s", args[1])
+		return
+	}
+	for _, sub := range d.directives {
+		if sub.name != "rewrite" {
+			im.report.errorf(sub.line, "directive %s in if is not supported", sub.name)
+			continue
+		}
+		if rule, ok := im.convertRewrite(sub, conditionType+" "+args[2]); ok {
+			pl.RewriteRules = append(pl.RewriteRules, rule)
+		}
+	}
+}
+
+// convertReturn converts the return directive to `code [text or url]`
+func (im *Importer) convertReturn(d *Directive) string {
+	switch len(d.args) {
+	case 1:
+		if code, err := strconv.Atoi(d.args[0]); err == nil {
+			return strconv.Itoa(code)
+		}
+		// return url is the temporary redirect
+		return "302 " + im.convertVariables(d.line, d.args[0])
+	case 2:
+		code, err := strconv.Atoi(d.args[0])
+		if err != nil {
+			break
+		}
+		return strconv.Itoa(code) + " " + im.convertVariables(d.line, d.args[1])
+	}
+	im.report.errorf(d.line, "invalid return %s", strings.Join(d.args, " "))
+	return ""
+}
+
+func (im *Importer) visitUpstream(d *Directive) {
+	if len(d.args) != 1 {
+		im.report.errorf(d.line, "invalid upstream %s", strings.Join(d.args, " "))
+		return
+	}
+	u := &upstream{name: d.args[0], line: d.line}
+	for _, sub := range d.directives {
+		if sub.name != "server" {
+			im.report.warnf(sub.line, "directive %s in upstream %s is not supported, ignored", sub.name, u.name)
+			continue
+		}
+		if len(sub.args) == 0 {
+			im.report.errorf(sub.line, "invalid server in upstream %s", u.name)
+			continue
+		}
+		address := sub.args[0]
+		if _, _, ok := splitHostPort(address); !ok && !strings.HasPrefix(address, "unix:") {
+			address += ":" + defaultUpstreamPort
+		}
+		weight, backup, down := 1, false, false
+		for _, param := range sub.args[1:] {
+			switch {
+			case strings.HasPrefix(param, "weight="):
+				w, err := strconv.Atoi(param[len("weight="):])
+				if err != nil || w <= 0 {
+					im.report.errorf(sub.line, "invalid server parameter %s in upstream %s", param, u.name)
+					continue
+				}
+				weight = w
+			case param == "backup":
+				backup = true
+			case param == "down":
+				down = true
+			default:
+				im.report.warnf(sub.line, "server parameter %s in upstream %s is not supported, ignored", param, u.name)
+			}
+		}
+		if down {
+			continue
+		}
+		if backup {
+			u.backups = append(u.backups, address)
+			continue
+		}
+		u.servers = append(u.servers, address)
+		u.weights = append(u.weights, weight)
+	}
+	if len(u.servers) == 0 {
+		im.report.errorf(d.line, "upstream %s has no available server", u.name)
+	}
+	im.upstreams[u.name] = u
+}
+
+func (im *Importer) visitMap(d *Directive) {
+	if len(d.args) != 2 || !strings.HasPrefix(d.args[1], "$") {
+		im.report.errorf(d.line, "invalid map %s", strings.Join(d.args, " "))
+		return
+	}
+	m := &mapConfig{Source: im.convertVariables(d.line, d.args[0])}
+	for _, sub := range d.directives {
+		if len(sub.args) != 1 {
+			im.report.warnf(sub.line, "map parameter %s is not supported, ignored", sub.name)
+			continue
+		}
+		value := im.convertVariables(sub.line, sub.args[0])
+		switch {
+		case sub.name == "default":
+			m.Default = value
+		case strings.HasPrefix(sub.name, "~*"):
+			m.Entries = append(m.Entries, mapEntry{Match: sub.name[2:], Type: "iregexp", Value: value})
+		case strings.HasPrefix(sub.name, "~"):
+			m.Entries = append(m.Entries, mapEntry{Match: sub.name[1:], Type: "regexp", Value: value})
+		default:
+			m.Entries = append(m.Entries, mapEntry{Match: strings.TrimPrefix(sub.name, "\\"), Type: "exact", Value: value})
+		}
+	}
+	im.maps[d.args[1][1:]] = m
+}
+
+func (im *Importer) parseTimeout(d *Directive) (int64, bool) {
+	if len(d.args) != 1 {
+		im.report.errorf(d.line, "invalid %s %s", d.name, strings.Join(d.args, " "))
+		return 0, false
+	}
+	value := d.args[0]
+	// the nginx time without unit is in seconds
+	if _, err := strconv.Atoi(value); err == nil {
+		value += "s"
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		im.report.errorf(d.line, "invalid %s %s", d.name, d.args[0])
+		return 0, false
+	}
+	return int64(duration / time.Millisecond), true
+}
+
+var nginxVariablePattern = regexp.MustCompile(`\$(\{[a-zA-Z0-9_]+\}|[a-zA-Z0-9_]+)`)
+
+// the nginx variables supported by the agent, the others are reported
+var nginxVariables = map[string]string{
+	"remote_addr":               "remote_ip",
+	"request_id":                "request_id",
+	"host":                      "host",
+	"uri":                       "uri",
+	"request_uri":               "request_uri",
+	"scheme":                    "scheme",
+	"proxy_add_x_forwarded_for": "proxy_add_x_forwarded_for",
+}
+
+// convertVariables converts the nginx variables to the variables of the agent, such as $arg_foo to $query_foo
+func (im *Importer) convertVariables(line int, s string) string {
+	return nginxVariablePattern.ReplaceAllStringFunc(s, func(v string) string {
+		name := strings.Trim(v[1:], "{}")
+		if _, err := strconv.Atoi(name); err == nil {
+			// the regexp group references
+			return v
+		}
+		if converted, ok := nginxVariables[name]; ok {
+			return "$" + converted
+		}
+		if strings.HasPrefix(name, "arg_") {
+			return "$query_" + name[len("arg_"):]
+		}
+		if strings.HasPrefix(name, "http_") {
+			return v
+		}
+		if _, ok := im.maps[name]; ok {
+			return v
+		}
+		im.report.warnf(line, "variable %s is not supported", v)
+		return v
+	})
+}
+
+func (im *Importer) marshal() ([]byte, error) {
+	config := make(map[string]interface{})
+	if len(im.locations) > 0 {
+		config["http-locations"] = im.locations
+	}
+	if len(im.maps) > 0 {
+		config["http-maps"] = im.maps
+	}
+	registries := make(map[string]*registryConfig)
+	for name, u := range im.upstreams {
+		if len(u.servers) == 0 {
+			continue
+		}
+		registries[upstreamRegistryPrefix+name] = im.newRegistryConfig(u)
+	}
+	if len(registries) > 0 {
+		config["motan-registry"] = registries
+	}
+	clients := make(map[string]*clientConfig)
+	domains := make([]string, 0, len(im.domainUpstreams))
+	for domain := range im.domainUpstreams {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		upstreams := im.domainUpstreams[domain]
+		// the http-client of a domain has one registry, it's the registry of the first upstream
+		if len(upstreams) > 1 {
+			im.report.warnf(im.upstreams[upstreams[0]].line, "domain %s uses upstreams %s, the http-client uses the registry of %s, the others should be discoverable in it",
+				domain, strings.Join(upstreams, ","), upstreams[0])
+		}
+		im.report.warnf(im.upstreams[upstreams[0]].line, "http-client %s calls the upstream servers by motan2 with simple serialization, the servers should be motan agents, plain http servers are not reachable",
+			domain)
+		clients[domain] = &clientConfig{
+			Registry:       upstreamRegistryPrefix + upstreams[0],
+			Domain:         domain,
+			Group:          domain,
+			Preload:        strings.Join(upstreams, ","),
+			Protocol:       "motan2",
+			Serialization:  "simple",
+			RequestTimeout: im.domainTimeouts[domain],
+		}
+	}
+	if len(clients) > 0 {
+		config["http-client"] = clients
+	}
+	// the http proxy uses the locations of a domain only if the domain has a http-client
+	domains = domains[:0]
+	for domain := range im.locations {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		if _, ok := clients[domain]; !ok {
+			im.report.errorf(im.domainLines[domain], "domain %s has no proxy_pass, its locations are not used without a http-client", domain)
+		}
+	}
+	return yaml.Marshal(config)
+}
+
+// newRegistryConfig creates the direct registry of the upstream. The servers with the same weight are in a group, the
+// weight of a group is the sum of its servers, so the servers get the same shares as nginx. The backup servers are in
+// a separate group, it's used only if a command merges it
+func (im *Importer) newRegistryConfig(u *upstream) *registryConfig {
+	r := &registryConfig{Protocol: "direct", Address: strings.Join(u.servers, ","), Groups: make(map[string]string)}
+	var groups []string
+	groupServers := make(map[string][]string)
+	groupWeights := make(map[string]int)
+	for i, server := range u.servers {
+		group := u.name + "-weight" + strconv.Itoa(u.weights[i])
+		if _, ok := groupWeights[group]; !ok {
+			groups = append(groups, group)
+		}
+		groupServers[group] = append(groupServers[group], server)
+		groupWeights[group] += u.weights[i]
+	}
+	if len(groups) > 1 {
+		for _, group := range groups {
+			r.Groups["address."+group] = strings.Join(groupServers[group], ",")
+		}
+		mergeGroups := im.mergeGroups(u, groups, groupWeights)
+		cmd, _ := json.Marshal(&command{ClientCommandList: []clientCommand{{
+			Pattern:     "*",
+			MergeGroups: mergeGroups,
+			Remark:      "weights of upstream " + u.name,
+		}}})
+		r.Command = string(cmd)
+		im.report.warnf(u.line, "the weighted servers of upstream %s are merged by the command with groups %s, the servers should export the services in these groups", u.name, strings.Join(mergeGroups, ","))
+	}
+	if len(u.backups) > 0 {
+		group := u.name + "-backup"
+		r.Groups["address."+group] = strings.Join(u.backups, ",")
+		im.report.warnf(u.line, "the backup servers of upstream %s are in group %s, they are used only if a command merges the group", u.name, group)
+	}
+	return r
+}
+
+// mergeGroups returns the merge groups of the command with the weights reduced to 1-100
+func (im *Importer) mergeGroups(u *upstream, groups []string, groupWeights map[string]int) []string {
+	gcd, max := 0, 0
+	for _, group := range groups {
+		gcd = greatestCommonDivisor(gcd, groupWeights[group])
+	}
+	for _, group := range groups {
+		groupWeights[group] /= gcd
+		if groupWeights[group] > max {
+			max = groupWeights[group]
+		}
+	}
+	mergeGroups := make([]string, 0, len(groups))
+	for _, group := range groups {
+		weight := groupWeights[group]
+		if max > maxGroupWeight {
+			weight = weight * maxGroupWeight / max
+			if weight < 1 {
+				weight = 1
+			}
+		}
+		mergeGroups = append(mergeGroups, group+":"+strconv.Itoa(weight))
+	}
+	if max > maxGroupWeight {
+		im.report.warnf(u.line, "the group weights of upstream %s are scaled to %d at most, the shares of the servers are approximate", u.name, maxGroupWeight)
+	}
+	return mergeGroups
+}
+
+func greatestCommonDivisor(a int, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func splitHostPort(address string) (string, string, bool) {
+	pos := strings.LastIndexByte(address, ':')
+	if pos <= 0 {
+		return address, "", false
+	}
+	if _, err := strconv.Atoi(address[pos+1:]); err != nil {
+		return address, "", false
+	}
+	return address[:pos], address[pos+1:], true
+}
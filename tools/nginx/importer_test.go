@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// the domains specified by -d, the server_name is used for the others
+var importDomains = map[string]string{
+	"locations": "test.domain",
+}
+
+func TestImportGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.conf")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, files)
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".conf")
+		t.Run(name, func(t *testing.T) {
+			directives, err := parseFile(file)
+			assert.Nil(t, err)
+			importer := NewImporter(importDomains[name])
+			out, err := importer.Import(directives)
+			assert.Nil(t, err)
+			actual := string(out) + "\n# report\n" + importer.Report().String()
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				assert.Nil(t, ioutil.WriteFile(golden, []byte(actual), 0644))
+			}
+			expected, err := ioutil.ReadFile(golden)
+			assert.Nil(t, err)
+			assert.Equal(t, string(expected), actual)
+		})
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	directives, err := NewParser(strings.NewReader("location / {\n  proxy_set_header X-Empty \"\";\n  add_header 'X-Quoted' 'a b';\n}\n")).Parse()
+	assert.Nil(t, err)
+	assert.Len(t, directives, 1)
+	location := directives[0]
+	assert.Equal(t, "location", location.name)
+	assert.Equal(t, 1, location.line)
+	assert.Len(t, location.directives, 2)
+	assert.Equal(t, []string{"X-Empty", ""}, location.directives[0].args)
+	assert.Equal(t, 2, location.directives[0].line)
+	assert.Equal(t, []string{"X-Quoted", "a b"}, location.directives[1].args)
+
+	_, err = NewParser(strings.NewReader("location / {\n  proxy_pass http://test;\n")).Parse()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+const usage = `usage: nginx <command> [options]
+
+commands:
+  import   convert the nginx configuration to the agent configuration, the validation report is written to stderr
+  parse    print the parsed directives of the nginx configuration
+`
+
+func main() {
+	args := os.Args[1:]
+	command := "import"
+	// the options without command are the options of import
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "import":
+		os.Exit(runImport(args))
+	case "parse":
+		os.Exit(runParse(args))
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	confFile := flags.String("f", "", "file for parse")
+	domain := flags.String("d", "", "domain for configuration file, the server_name is used if it's empty")
+	output := flags.String("o", "", "output file, default is stdout")
+	strict := flags.Bool("strict", false, "exit with failure if the report has errors")
+	flags.BoolVar(&verbose, "v", false, "verbose output")
+	flags.Parse(args)
+	if *confFile == "" {
+		fmt.Fprintln(os.Stderr, "use -f to specify the configuration file to parse")
+		return 1
+	}
+	directives, err := parseFile(*confFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "parse %s failed: %v\n", *confFile, err)
+		return 1
+	}
+	importer := NewImporter(*domain)
+	out, err := importer.Import(directives)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import %s failed: %v\n", *confFile, err)
+		return 1
+	}
+	fmt.Fprint(os.Stderr, importer.Report().String())
+	if *output != "" {
+		if err = ioutil.WriteFile(*output, out, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "write %s failed: %v\n", *output, err)
+			return 1
+		}
+	} else {
+		fmt.Print(string(out))
+	}
+	if *strict && importer.Report().HasError() {
+		return 1
+	}
+	return 0
+}
+
+func runParse(args []string) int {
+	flags := flag.NewFlagSet("parse", flag.ExitOnError)
+	confFile := flags.String("f", "", "file for parse")
+	flags.BoolVar(&verbose, "v", false, "verbose output")
+	flags.Parse(args)
+	if *confFile == "" {
+		fmt.Fprintln(os.Stderr, "use -f to specify the configuration file to parse")
+		return 1
+	}
+	directives, err := parseFile(*confFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "parse %s failed: %v\n", *confFile, err)
+		return 1
+	}
+	fmt.Print(printDirectives(directives, 0))
+	return 0
+}
+
+func parseFile(file string) ([]*Directive, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewParser(bytes.NewReader(content)).Parse()
+}
@@ -4,16 +4,11 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
-	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
-
-	"github.com/weibocom/motan-go/http"
-	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -37,6 +32,8 @@ type Directive struct {
 	name       string
 	args       []string
 	directives []*Directive
+	// the line number of the directive, it's used by the validation report
+	line int
 }
 
 type Parser struct {
@@ -54,17 +51,7 @@ func newToken(kind int, text string) token {
 	return token{kind, text}
 }
 
-var (
-	verbose  bool
-	confFile string
-	domain   string
-)
-
-func init() {
-	flag.BoolVar(&verbose, "v", false, "verbose output")
-	flag.StringVar(&confFile, "f", "", "file for parse")
-	flag.StringVar(&domain, "d", "", "domain for configuration file")
-}
+var verbose bool
 
 func NewParser(reader io.Reader) *Parser {
 	return &Parser{reader: bufio.NewReader(reader)}
@@ -154,6 +141,9 @@ func (p *Parser) readToken() token {
 			case '"':
 				doubleQuoted = true
 				lastIsSpace = false
+			case '\'':
+				singleQuoted = true
+				lastIsSpace = false
 			default:
 				lastIsSpace = false
 				buf.WriteByte(ch)
@@ -261,6 +251,7 @@ func (p *Parser) parseFromStat(depth int) ([]*Directive, error) {
 		if token.kind == BlockStart {
 			// we should reset args
 			directiveParsing.name = p.args[0]
+			directiveParsing.line = p.line + 1
 			if len(p.args) > 1 {
 				directiveParsing.args = p.args[1:]
 			}
@@ -277,6 +268,7 @@ func (p *Parser) parseFromStat(depth int) ([]*Directive, error) {
 		if token.kind == Ok {
 			// ok
 			directiveParsing.name = p.args[0]
+			directiveParsing.line = p.line + 1
 			if len(p.args) > 1 {
 				directiveParsing.args = p.args[1:]
 			}
@@ -287,139 +279,6 @@ func (p *Parser) parseFromStat(depth int) ([]*Directive, error) {
 	}
 }
 
-func visitDirectives(context string, proxyLocations []*http.ProxyLocation, ds []*Directive) []*http.ProxyLocation {
-	for _, d := range ds {
-		if d.name == "location" {
-			proxyLocations = visitLocation(context, proxyLocations, d)
-			continue
-		}
-		if d.directives != nil {
-			proxyLocations = visitDirectives(d.name, proxyLocations, d.directives)
-		}
-	}
-	return proxyLocations
-}
-
-func visitLocation(context string, proxyLocations []*http.ProxyLocation, d *Directive) []*http.ProxyLocation {
-	pl := new(http.ProxyLocation)
-	argc := len(d.args)
-	if argc == 0 {
-		panic(fmt.Sprintf("illegal argument count for location %v", d))
-	}
-	if argc == 2 {
-		arg0 := d.args[0]
-		arg1 := d.args[1]
-		switch arg0 {
-		case "=":
-			pl.Type = "exact"
-			pl.Match = arg1
-		case "~":
-			pl.Type = "regexp"
-			pl.Match = arg1
-		case "~*":
-			pl.Type = "iregexp"
-			pl.Match = arg1
-		default:
-			panic("unsupported match type " + arg0)
-		}
-	} else {
-		// only one parameter
-		arg0 := d.args[0]
-		if arg0[0] == '=' {
-			pl.Type = "exact"
-			pl.Match = arg0[1:]
-		} else if arg0[0] == '^' && arg0[1] == '~' {
-			panic("unsupported match type ^~")
-		} else if arg0[0] == '~' {
-			if arg0[1] == '*' {
-				pl.Type = "iregexp"
-				pl.Match = arg0[2:]
-			} else {
-				pl.Type = "regexp"
-				pl.Match = arg0[1:]
-			}
-		} else {
-			pl.Type = "start"
-			pl.Match = arg0
-		}
-	}
-	for _, subDirective := range d.directives {
-		if subDirective.name == "proxy_pass" {
-			const httpPrefixLen = len("http://")
-			pl.Upstream = subDirective.args[0][httpPrefixLen:]
-		}
-		if subDirective.name == "if" {
-			parenthesesRemovedArgs := make([]string, 0, len(subDirective.args))
-			for i, arg := range subDirective.args {
-				// first one remove ahead '('
-				argToBeAppend := arg
-				if i == 0 {
-					if len(arg) < 1 || arg[0] != '(' {
-						panic("invalid condition " + strings.Join(subDirective.args, " "))
-					}
-					if arg != "(" {
-						argToBeAppend = arg[1:]
-					} else {
-						continue
-					}
-				} else if i == len(subDirective.args)-1 {
-					// last one remove end ')'
-					if arg != ")" && strings.HasSuffix(arg, ")") {
-						argToBeAppend = arg[:len(arg)-1]
-					} else {
-						continue
-					}
-				}
-				parenthesesRemovedArgs = append(parenthesesRemovedArgs, argToBeAppend)
-			}
-			if len(parenthesesRemovedArgs) != 3 {
-				fmt.Fprintf(os.Stderr, "invalid condition "+strings.Join(subDirective.args, " "))
-				continue
-			}
-			// $request_uri !~ /2/.*
-			if parenthesesRemovedArgs[0] != "$request_uri" {
-				fmt.Fprintf(os.Stderr, "unsupported condition "+strings.Join(subDirective.args, " "))
-				continue
-			}
-			conditionType := ""
-			switch parenthesesRemovedArgs[1] {
-			case "~":
-				conditionType = "regexp"
-			case "~*":
-				conditionType = "iregexp"
-			case "!~":
-				conditionType = "!regexp"
-			case "!~*":
-				conditionType = "!iregexp"
-			default:
-				panic("unsupported write condition type")
-			}
-			conditionRegexp := parenthesesRemovedArgs[2]
-			matchStr := ""
-			replacement := ""
-			for _, ifSubDirective := range subDirective.directives {
-				if ifSubDirective.name == "rewrite" {
-					matchStr = ifSubDirective.args[0]
-					replacement = ifSubDirective.args[1]
-				}
-			}
-			// has rewrite
-			if matchStr != "" {
-				pl.RewriteRules = append(pl.RewriteRules, conditionType+" "+conditionRegexp+" "+matchStr+" "+replacement)
-			}
-		}
-		if subDirective.name == "rewrite" {
-			pl.RewriteRules = append(pl.RewriteRules, "start / "+subDirective.args[0]+" "+subDirective.args[1])
-		}
-	}
-	// has no upstream ignore it
-	if pl.Upstream == "" {
-		fmt.Fprintf(os.Stderr, "ignore location "+printDirectives([]*Directive{d}, 0))
-		return proxyLocations
-	}
-	return append(proxyLocations, pl)
-}
-
 func printDirectives(ds []*Directive, depth int) string {
 	prefix := ""
 	for i := 0; i < depth; i++ {
@@ -434,36 +293,3 @@ func printDirectives(ds []*Directive, depth int) string {
 	}
 	return resultStr
 }
-
-func main() {
-	if !flag.Parsed() {
-		flag.Parse()
-	}
-	if confFile == "" {
-		fmt.Println("use -f to specify the configuration file to parse")
-		os.Exit(1)
-	}
-	if domain == "" {
-		fmt.Println("use -d to specify the domain for configuration file")
-		os.Exit(1)
-	}
-	f, err := os.Open(confFile)
-	if err != nil {
-		panic(err.Error())
-	}
-	content, err := ioutil.ReadAll(f)
-	if err != nil {
-		panic(err.Error())
-	}
-	parser := NewParser(bytes.NewReader(content))
-	f.Close()
-	directives, err := parser.Parse()
-	if err != nil {
-		panic(err.Error())
-	}
-	locations := visitDirectives("global", nil, directives)
-	yamlMap := make(map[string]interface{})
-	yamlMap["http-locations"] = map[string]interface{}{domain: locations}
-	out, _ := yaml.Marshal(yamlMap)
-	fmt.Println(string(out))
-}